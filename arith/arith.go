@@ -0,0 +1,226 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package arith implements the arithmetic of Lua 5.4 on integers and
+// floats: floor division and modulo, shifts, exact conversions between
+// the two number types, comparisons that mix them, and the conversion
+// of numbers to strings.
+//
+// The functions are shared by the compiler, which folds constant
+// expressions, and by the evaluators, so that both give the same
+// answers.
+package arith
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FloorDiv returns the integer division of a by b, rounded towards
+// minus infinity. The caller must check that b is not zero.
+// MinInteger // -1 wraps around to MinInteger.
+func FloorDiv(a, b int64) int64 {
+	if b == -1 {
+		return int64(-uint64(a)) // avoid the overflow trap of a / -1
+	}
+	q := a / b
+	if (a^b) < 0 && a%b != 0 {
+		q-- // the signs differ and there is a remainder; round down
+	}
+	return q
+}
+
+// Mod returns the remainder of the floor division of a by b, which has
+// the sign of b. The caller must check that b is not zero.
+func Mod(a, b int64) int64 {
+	if b == -1 {
+		return 0 // avoid the overflow trap of MinInteger % -1
+	}
+	m := a % b
+	if m != 0 && (m^b) < 0 {
+		m += b
+	}
+	return m
+}
+
+// FloatFloorDiv returns the floor of a / b.
+func FloatFloorDiv(a, b float64) float64 {
+	return math.Floor(a / b)
+}
+
+// FloatMod returns the remainder of the floor division of a by b,
+// which has the sign of b.
+func FloatMod(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if m > 0 && b < 0 || m < 0 && b > 0 {
+		m += b
+	}
+	return m
+}
+
+// Pow returns a raised to the power b.
+func Pow(a, b float64) float64 {
+	if b == 2 {
+		return a * a
+	}
+	return math.Pow(a, b)
+}
+
+// ShiftLeft shifts a left by b bits. A negative b shifts right.
+// Both shifts are logical, and shifting by 64 or more bits gives zero.
+func ShiftLeft(a, b int64) int64 {
+	switch {
+	case b <= -64 || b >= 64:
+		return 0
+	case b < 0:
+		return int64(uint64(a) >> uint(-b))
+	}
+	return int64(uint64(a) << uint(b))
+}
+
+// ShiftRight shifts a right by b bits. A negative b shifts left.
+func ShiftRight(a, b int64) int64 {
+	return ShiftLeft(a, int64(-uint64(b)))
+}
+
+// FloatToInteger converts f to an integer if it has an exact integer
+// representation.
+func FloatToInteger(f float64) (int64, bool) {
+	// -2^63 is exact in a float; 2^63 is the first value out of range
+	if f >= -(1<<63) && f < 1<<63 && f == math.Floor(f) {
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// FloatToIntegerFloor converts f to an integer by rounding it towards
+// minus infinity, and reports whether the result is in range.
+func FloatToIntegerFloor(f float64) (int64, bool) {
+	return FloatToInteger(math.Floor(f))
+}
+
+// EqualIntFloat reports whether the integer i and the float f denote
+// the same number.
+func EqualIntFloat(i int64, f float64) bool {
+	fi, ok := FloatToInteger(f)
+	return ok && fi == i
+}
+
+// LessIntFloat reports whether i < f, exactly.
+func LessIntFloat(i int64, f float64) bool {
+	if math.IsNaN(f) {
+		return false
+	}
+	if fitsFloat(i) {
+		return float64(i) < f
+	}
+	// i < f <=> i < ceil(f)
+	if c, ok := FloatToInteger(math.Ceil(f)); ok {
+		return i < c
+	}
+	return f > 0 // f is out of the integer range
+}
+
+// LessEqualIntFloat reports whether i <= f, exactly.
+func LessEqualIntFloat(i int64, f float64) bool {
+	if math.IsNaN(f) {
+		return false
+	}
+	if fitsFloat(i) {
+		return float64(i) <= f
+	}
+	// i <= f <=> i <= floor(f)
+	if fl, ok := FloatToIntegerFloor(f); ok {
+		return i <= fl
+	}
+	return f > 0
+}
+
+// LessFloatInt reports whether f < i, exactly.
+func LessFloatInt(f float64, i int64) bool {
+	if math.IsNaN(f) {
+		return false
+	}
+	if fitsFloat(i) {
+		return f < float64(i)
+	}
+	// f < i <=> floor(f) < i
+	if fl, ok := FloatToIntegerFloor(f); ok {
+		return fl < i
+	}
+	return f < 0
+}
+
+// LessEqualFloatInt reports whether f <= i, exactly.
+func LessEqualFloatInt(f float64, i int64) bool {
+	if math.IsNaN(f) {
+		return false
+	}
+	if fitsFloat(i) {
+		return f <= float64(i)
+	}
+	// f <= i <=> ceil(f) <= i
+	if c, ok := FloatToInteger(math.Ceil(f)); ok {
+		return c <= i
+	}
+	return f < 0
+}
+
+// fitsFloat reports whether i converts to a float without rounding,
+// judged conservatively by its magnitude.
+func fitsFloat(i int64) bool {
+	const maxExact = 1 << 53
+	return -maxExact <= i && i <= maxExact
+}
+
+// FormatInt returns the string form of an integer.
+func FormatInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+// FormatFloat returns the string form of a float, as Lua's tostring
+// does: 14 significant digits, with ".0" added to values that would
+// otherwise read as integers.
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if f == 0 && math.Signbit(f) {
+		s = "-0"
+	}
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
+}
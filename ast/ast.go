@@ -0,0 +1,382 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package ast declares the types used to represent the syntax tree
+// of a Lua 5.4 chunk.
+//
+// The tree is produced by lowering the parse tree built by package
+// syntax. Statements implement Stat and expressions implement Expr;
+// both carry the source span they were parsed from.
+package ast
+
+import "github.com/mdhender/glua/lexer"
+
+// Node is implemented by every node in the tree.
+type Node interface {
+	Pos() lexer.Pos // position of the first token of the node
+	End() lexer.Pos // position just past the last token of the node
+}
+
+// Stat is implemented by every statement node.
+type Stat interface {
+	Node
+	statNode()
+}
+
+// Expr is implemented by every expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Span records the extent of a node in the source.
+// It is embedded in every node to implement the Node interface.
+type Span struct {
+	From lexer.Pos
+	To   lexer.Pos
+}
+
+// Pos returns the position of the first token of the node.
+func (s Span) Pos() lexer.Pos {
+	return s.From
+}
+
+// End returns the position just past the last token of the node.
+func (s Span) End() lexer.Pos {
+	return s.To
+}
+
+// Chunk is the root of the tree.
+type Chunk struct {
+	Span
+	Name   string // name of the chunk, for error messages
+	Block  *Block
+	Trivia map[Node]*Trivia // trivia of each node that has any; nil unless parsed with trivia
+}
+
+// Trivia is the comments and blank lines attached to a node.
+//
+// Leading trivia comes before the node's first token and trailing
+// trivia follows its last token on the same line. Trivia next to a
+// token that does not start or end a node, such as a keyword or a
+// comma, is inner trivia of the innermost node containing the token.
+type Trivia struct {
+	Leading  []lexer.Trivia
+	Trailing []lexer.Trivia
+	Inner    []lexer.Trivia
+}
+
+// Block is a sequence of statements.
+// A ReturnStat may only appear as the last statement.
+type Block struct {
+	Span
+	Stats []Stat
+}
+
+// Ident is a name, either where it is declared or where it is used.
+// As an expression, it is a reference to a variable.
+type Ident struct {
+	Span
+	Name string
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+type (
+	// BadStat stands in for a statement that could not be parsed.
+	BadStat struct {
+		Span
+	}
+
+	// EmptyStat is a lone ';'.
+	EmptyStat struct {
+		Span
+	}
+
+	// AssignStat is a multiple assignment, Targets = Values.
+	// Each target is an *Ident or an *IndexExpr.
+	AssignStat struct {
+		Span
+		Targets []Expr
+		Values  []Expr
+	}
+
+	// CallStat is a function call evaluated for its side effects.
+	CallStat struct {
+		Span
+		Call *CallExpr
+	}
+
+	// LabelStat is a label, ::Name::.
+	LabelStat struct {
+		Span
+		Name *Ident
+	}
+
+	// BreakStat is a break statement.
+	BreakStat struct {
+		Span
+	}
+
+	// GotoStat is a goto statement.
+	GotoStat struct {
+		Span
+		Label *Ident
+	}
+
+	// DoStat is a do ... end block.
+	DoStat struct {
+		Span
+		Body *Block
+	}
+
+	// WhileStat is a while loop.
+	WhileStat struct {
+		Span
+		Cond Expr
+		Body *Block
+	}
+
+	// RepeatStat is a repeat ... until loop.
+	// The condition is in the scope of the body's locals.
+	RepeatStat struct {
+		Span
+		Body *Block
+		Cond Expr
+	}
+
+	// IfStat is an if statement. The first clause is the 'if'
+	// and the rest are the 'elseif' clauses.
+	IfStat struct {
+		Span
+		Clauses []*IfClause
+		ElsePos lexer.Pos // position of 'else', if there is an else clause
+		Else    *Block    // nil if there is no else clause
+	}
+
+	// NumericForStat is a numeric for loop.
+	NumericForStat struct {
+		Span
+		Var   *Ident
+		Start Expr
+		Limit Expr
+		Step  Expr // nil if omitted
+		Body  *Block
+	}
+
+	// GenericForStat is a generic for loop, for Names in Exprs.
+	GenericForStat struct {
+		Span
+		Names []*Ident
+		Exprs []Expr
+		Body  *Block
+	}
+
+	// FunctionStat is a function declaration, function funcname funcbody.
+	FunctionStat struct {
+		Span
+		Name *FuncName
+		Func *FunctionExpr
+	}
+
+	// LocalFunctionStat is a local function declaration.
+	// The name is in scope in the body of the function.
+	LocalFunctionStat struct {
+		Span
+		Name *Ident
+		Func *FunctionExpr
+	}
+
+	// LocalStat declares local variables.
+	LocalStat struct {
+		Span
+		Names   []*Ident
+		Attribs []*Ident // Attribs[i] is the attribute of Names[i], or nil
+		Values  []Expr
+	}
+
+	// ReturnStat returns from the enclosing function.
+	ReturnStat struct {
+		Span
+		Values []Expr
+	}
+)
+
+// IfClause is a condition and the block it guards.
+// Its span starts at the 'if' or 'elseif' keyword.
+type IfClause struct {
+	Span
+	Cond Expr
+	Body *Block
+}
+
+// FuncName is the name in a function declaration,
+// Name {'.' Name} [':' Name].
+type FuncName struct {
+	Span
+	Path   []*Ident // the name and its fields
+	Method *Ident   // nil unless declared with ':'
+}
+
+func (*BadStat) statNode()           {}
+func (*EmptyStat) statNode()         {}
+func (*AssignStat) statNode()        {}
+func (*CallStat) statNode()          {}
+func (*LabelStat) statNode()         {}
+func (*BreakStat) statNode()         {}
+func (*GotoStat) statNode()          {}
+func (*DoStat) statNode()            {}
+func (*WhileStat) statNode()         {}
+func (*RepeatStat) statNode()        {}
+func (*IfStat) statNode()            {}
+func (*NumericForStat) statNode()    {}
+func (*GenericForStat) statNode()    {}
+func (*FunctionStat) statNode()      {}
+func (*LocalFunctionStat) statNode() {}
+func (*LocalStat) statNode()         {}
+func (*ReturnStat) statNode()        {}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+type (
+	// NilExpr is the literal nil.
+	NilExpr struct {
+		Span
+	}
+
+	// BoolExpr is the literal true or false.
+	BoolExpr struct {
+		Span
+		Value bool
+	}
+
+	// NumberExpr is a numeric literal.
+	NumberExpr struct {
+		Span
+		Raw     string  // the literal as it appeared in the source
+		IsFloat bool    // true if the literal denotes a float
+		Int     int64   // the value of an integer literal
+		Float   float64 // the value of a float literal
+	}
+
+	// StringExpr is a string literal.
+	StringExpr struct {
+		Span
+		Raw   string // the literal as it appeared in the source
+		Value string // the decoded value
+	}
+
+	// VarargExpr is the vararg expression, '...'.
+	VarargExpr struct {
+		Span
+	}
+
+	// FunctionExpr is a function constructor.
+	FunctionExpr struct {
+		Span
+		Params   []*Ident
+		IsVararg bool
+		Body     *Block
+	}
+
+	// TableExpr is a table constructor.
+	TableExpr struct {
+		Span
+		Fields []*Field
+	}
+
+	// BinaryExpr is a binary operation.
+	BinaryExpr struct {
+		Span
+		Op    BinOp
+		Left  Expr
+		Right Expr
+	}
+
+	// UnaryExpr is a unary operation.
+	UnaryExpr struct {
+		Span
+		Op      UnOp
+		Operand Expr
+	}
+
+	// ParenExpr is a parenthesized expression. The parentheses matter:
+	// they truncate a call or vararg expression to a single value.
+	ParenExpr struct {
+		Span
+		X Expr
+	}
+
+	// IndexExpr is Object[Key]. When written as Object.Name,
+	// Dot is set and Key is a *StringExpr holding the name.
+	IndexExpr struct {
+		Span
+		Object Expr
+		Key    Expr
+		Dot    bool
+	}
+
+	// CallExpr is a function call, Func(Args), or a method call,
+	// Func:Method(Args).
+	CallExpr struct {
+		Span
+		Func   Expr
+		Method *Ident // nil unless called with ':'
+		Args   []Expr
+	}
+)
+
+// FieldKind distinguishes the forms of a table constructor field.
+type FieldKind int
+
+const (
+	PositionalField FieldKind = iota // exp
+	NamedField                       // Name '=' exp
+	KeyedField                       // '[' exp ']' '=' exp
+)
+
+// Field is a field in a table constructor. Key is nil for a positional
+// field and a *StringExpr holding the name for a named field.
+type Field struct {
+	Span
+	Kind  FieldKind
+	Key   Expr
+	Value Expr
+}
+
+func (*Ident) exprNode()        {}
+func (*NilExpr) exprNode()      {}
+func (*BoolExpr) exprNode()     {}
+func (*NumberExpr) exprNode()   {}
+func (*StringExpr) exprNode()   {}
+func (*VarargExpr) exprNode()   {}
+func (*FunctionExpr) exprNode() {}
+func (*TableExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*UnaryExpr) exprNode()    {}
+func (*ParenExpr) exprNode()    {}
+func (*IndexExpr) exprNode()    {}
+func (*CallExpr) exprNode()     {}
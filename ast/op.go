@@ -0,0 +1,144 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ast
+
+// BinOp is a binary operator.
+type BinOp int
+
+const (
+	OpAdd    BinOp = iota // +
+	OpSub                 // -
+	OpMul                 // *
+	OpDiv                 // /
+	OpIDiv                // //
+	OpPow                 // ^
+	OpMod                 // %
+	OpBand                // &
+	OpBxor                // ~
+	OpBor                 // |
+	OpShr                 // >>
+	OpShl                 // <<
+	OpConcat              // ..
+	OpLT                  // <
+	OpLE                  // <=
+	OpGT                  // >
+	OpGE                  // >=
+	OpEQ                  // ==
+	OpNE                  // ~=
+	OpAnd                 // and
+	OpOr                  // or
+)
+
+var binOpNames = [...]string{
+	OpAdd:    "+",
+	OpSub:    "-",
+	OpMul:    "*",
+	OpDiv:    "/",
+	OpIDiv:   "//",
+	OpPow:    "^",
+	OpMod:    "%",
+	OpBand:   "&",
+	OpBxor:   "~",
+	OpBor:    "|",
+	OpShr:    ">>",
+	OpShl:    "<<",
+	OpConcat: "..",
+	OpLT:     "<",
+	OpLE:     "<=",
+	OpGT:     ">",
+	OpGE:     ">=",
+	OpEQ:     "==",
+	OpNE:     "~=",
+	OpAnd:    "and",
+	OpOr:     "or",
+}
+
+// String returns the Lua spelling of the operator.
+func (op BinOp) String() string {
+	if 0 <= op && int(op) < len(binOpNames) {
+		return binOpNames[op]
+	}
+	return "<unknown>"
+}
+
+// Priority returns the left and right binding power of the operator.
+// Operators that bind more tightly have higher values, and the
+// right-associative operators ('..' and '^') have a right priority
+// lower than their left.
+func (op BinOp) Priority() (left, right int) {
+	switch op {
+	case OpOr:
+		return 1, 1
+	case OpAnd:
+		return 2, 2
+	case OpLT, OpLE, OpGT, OpGE, OpEQ, OpNE:
+		return 3, 3
+	case OpBor:
+		return 4, 4
+	case OpBxor:
+		return 5, 5
+	case OpBand:
+		return 6, 6
+	case OpShr, OpShl:
+		return 7, 7
+	case OpConcat:
+		return 9, 8
+	case OpAdd, OpSub:
+		return 10, 10
+	case OpMul, OpDiv, OpIDiv, OpMod:
+		return 11, 11
+	case OpPow:
+		return 14, 13
+	}
+	return 0, 0
+}
+
+// UnaryPriority is the binding power of the unary operators.
+const UnaryPriority = 12
+
+// UnOp is a unary operator.
+type UnOp int
+
+const (
+	OpNeg  UnOp = iota // -
+	OpNot              // not
+	OpLen              // #
+	OpBnot             // ~
+)
+
+var unOpNames = [...]string{
+	OpNeg:  "-",
+	OpNot:  "not",
+	OpLen:  "#",
+	OpBnot: "~",
+}
+
+// String returns the Lua spelling of the operator.
+func (op UnOp) String() string {
+	if 0 <= op && int(op) < len(unOpNames) {
+		return unOpNames[op]
+	}
+	return "<unknown>"
+}
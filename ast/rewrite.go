@@ -0,0 +1,239 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ast
+
+import "fmt"
+
+// Rewrite traverses the tree rooted at node in depth-first order and
+// lets f replace nodes. The children of a node are rewritten before
+// the node itself; f is then called with the node and its result takes
+// the place of the node in the tree. Rewrite returns the new root.
+//
+// The replacement must fit the place of the node it replaces: a Stat
+// for a Stat, an Expr for an Expr, a *Block for a *Block, and so on.
+// Rewrite panics if it does not. In a list of statements or table
+// fields, f may return nil to remove the node from the list. The
+// nodes are updated in place.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Chunk:
+		n.Block = rewriteBlock(n.Block, f)
+	case *Block:
+		stats := n.Stats[:0]
+		for _, s := range n.Stats {
+			if s := Rewrite(s, f); s != nil {
+				stats = append(stats, mustStat(s))
+			}
+		}
+		n.Stats = stats
+	case *Ident:
+		// nothing to do
+
+	// statements
+	case *BadStat, *EmptyStat, *BreakStat:
+		// nothing to do
+	case *AssignStat:
+		rewriteExprList(n.Targets, f)
+		rewriteExprList(n.Values, f)
+	case *CallStat:
+		n.Call = mustCall(Rewrite(n.Call, f))
+	case *LabelStat:
+		n.Name = rewriteIdent(n.Name, f)
+	case *GotoStat:
+		n.Label = rewriteIdent(n.Label, f)
+	case *DoStat:
+		n.Body = rewriteBlock(n.Body, f)
+	case *WhileStat:
+		n.Cond = rewriteExpr(n.Cond, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *RepeatStat:
+		n.Body = rewriteBlock(n.Body, f)
+		n.Cond = rewriteExpr(n.Cond, f)
+	case *IfStat:
+		for i, c := range n.Clauses {
+			x := Rewrite(c, f)
+			r, ok := x.(*IfClause)
+			if !ok || r == nil {
+				panic(fmt.Sprintf("ast.Rewrite: %T is not an if clause", x))
+			}
+			n.Clauses[i] = r
+		}
+		if n.Else != nil {
+			n.Else = rewriteBlock(n.Else, f)
+		}
+	case *IfClause:
+		n.Cond = rewriteExpr(n.Cond, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *NumericForStat:
+		n.Var = rewriteIdent(n.Var, f)
+		n.Start = rewriteExpr(n.Start, f)
+		n.Limit = rewriteExpr(n.Limit, f)
+		if n.Step != nil {
+			n.Step = rewriteExpr(n.Step, f)
+		}
+		n.Body = rewriteBlock(n.Body, f)
+	case *GenericForStat:
+		rewriteIdentList(n.Names, f)
+		rewriteExprList(n.Exprs, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *FunctionStat:
+		x := Rewrite(n.Name, f)
+		r, ok := x.(*FuncName)
+		if !ok || r == nil {
+			panic(fmt.Sprintf("ast.Rewrite: %T is not a function name", x))
+		}
+		n.Name = r
+		n.Func = rewriteFunction(n.Func, f)
+	case *FuncName:
+		rewriteIdentList(n.Path, f)
+		if n.Method != nil {
+			n.Method = rewriteIdent(n.Method, f)
+		}
+	case *LocalFunctionStat:
+		n.Name = rewriteIdent(n.Name, f)
+		n.Func = rewriteFunction(n.Func, f)
+	case *LocalStat:
+		rewriteIdentList(n.Names, f)
+		for i, a := range n.Attribs {
+			if a != nil {
+				n.Attribs[i] = rewriteIdent(a, f)
+			}
+		}
+		rewriteExprList(n.Values, f)
+	case *ReturnStat:
+		rewriteExprList(n.Values, f)
+
+	// expressions
+	case *NilExpr, *BoolExpr, *NumberExpr, *StringExpr, *VarargExpr:
+		// nothing to do
+	case *FunctionExpr:
+		rewriteIdentList(n.Params, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *TableExpr:
+		fields := n.Fields[:0]
+		for _, fld := range n.Fields {
+			r := Rewrite(fld, f)
+			if r == nil {
+				continue
+			}
+			fld, ok := r.(*Field)
+			if !ok {
+				panic(fmt.Sprintf("ast.Rewrite: %T is not a table field", r))
+			}
+			fields = append(fields, fld)
+		}
+		n.Fields = fields
+	case *Field:
+		if n.Key != nil {
+			n.Key = rewriteExpr(n.Key, f)
+		}
+		n.Value = rewriteExpr(n.Value, f)
+	case *BinaryExpr:
+		n.Left = rewriteExpr(n.Left, f)
+		n.Right = rewriteExpr(n.Right, f)
+	case *UnaryExpr:
+		n.Operand = rewriteExpr(n.Operand, f)
+	case *ParenExpr:
+		n.X = rewriteExpr(n.X, f)
+	case *IndexExpr:
+		n.Object = rewriteExpr(n.Object, f)
+		n.Key = rewriteExpr(n.Key, f)
+	case *CallExpr:
+		n.Func = rewriteExpr(n.Func, f)
+		if n.Method != nil {
+			n.Method = rewriteIdent(n.Method, f)
+		}
+		rewriteExprList(n.Args, f)
+
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+func rewriteBlock(b *Block, f func(Node) Node) *Block {
+	n := Rewrite(b, f)
+	r, ok := n.(*Block)
+	if !ok || r == nil {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not a block", n))
+	}
+	return r
+}
+
+func rewriteFunction(fn *FunctionExpr, f func(Node) Node) *FunctionExpr {
+	n := Rewrite(fn, f)
+	r, ok := n.(*FunctionExpr)
+	if !ok || r == nil {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not a function", n))
+	}
+	return r
+}
+
+func rewriteIdent(id *Ident, f func(Node) Node) *Ident {
+	n := Rewrite(id, f)
+	r, ok := n.(*Ident)
+	if !ok || r == nil {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not a name", n))
+	}
+	return r
+}
+
+func rewriteIdentList(list []*Ident, f func(Node) Node) {
+	for i, id := range list {
+		list[i] = rewriteIdent(id, f)
+	}
+}
+
+func rewriteExpr(x Expr, f func(Node) Node) Expr {
+	n := Rewrite(x, f)
+	r, ok := n.(Expr)
+	if !ok || r == nil {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not an expression", n))
+	}
+	return r
+}
+
+func rewriteExprList(list []Expr, f func(Node) Node) {
+	for i, x := range list {
+		list[i] = rewriteExpr(x, f)
+	}
+}
+
+func mustStat(n Node) Stat {
+	s, ok := n.(Stat)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not a statement", n))
+	}
+	return s
+}
+
+func mustCall(n Node) *CallExpr {
+	c, ok := n.(*CallExpr)
+	if !ok || c == nil {
+		panic(fmt.Sprintf("ast.Rewrite: %T is not a call", n))
+	}
+	return c
+}
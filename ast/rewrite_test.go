@@ -0,0 +1,123 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		f    func(Node) Node
+		want string
+	}{
+		{"identity", func(n Node) Node { return n },
+			dump(sample())},
+		{"remove statements", func(n Node) Node {
+			switch n.(type) {
+			case *BreakStat, *EmptyStat:
+				return nil
+			}
+			return n
+		}, "Chunk Block AssignStat x CallExpr f 1 2 WhileStat a Block AssignStat t TableExpr Field 1 Field \"k\" 2"},
+		{"remove a statement with children", func(n Node) Node {
+			if _, ok := n.(*WhileStat); ok {
+				return nil
+			}
+			return n
+		}, "Chunk Block AssignStat x CallExpr f 1 2 AssignStat t TableExpr Field 1 Field \"k\" 2"},
+		{"remove fields", func(n Node) Node {
+			if f, ok := n.(*Field); ok && f.Kind == NamedField {
+				return nil
+			}
+			return n
+		}, "Chunk Block AssignStat x CallExpr f 1 2 WhileStat a Block BreakStat EmptyStat AssignStat t TableExpr Field 1"},
+		{"replace", func(n Node) Node {
+			switch n := n.(type) {
+			case *Ident:
+				return &Ident{Name: strings.ToUpper(n.Name)}
+			case *CallExpr:
+				return &NilExpr{}
+			case *Field:
+				return &Field{Kind: PositionalField, Value: n.Value}
+			}
+			return n
+		}, "Chunk Block AssignStat X NilExpr WhileStat A Block BreakStat EmptyStat AssignStat T TableExpr Field 1 Field 2"},
+	} {
+		if got := dump(Rewrite(sample(), tc.f)); got != tc.want {
+			t.Errorf("%s: rewrote as\n\t%s\nwant\n\t%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestRewriteOrder checks that the children of a node are rewritten
+// before the node, in the order of Walk.
+func TestRewriteOrder(t *testing.T) {
+	var list []string
+	Rewrite(sample(), func(n Node) Node {
+		list = append(list, label(n))
+		return n
+	})
+	got := strings.Join(list, " ")
+	want := "x f 1 2 CallExpr AssignStat" +
+		" a BreakStat EmptyStat Block WhileStat" +
+		" t 1 Field \"k\" 2 Field TableExpr AssignStat" +
+		" Block Chunk"
+	if got != want {
+		t.Errorf("rewrote in the order\n\t%s\nwant\n\t%s", got, want)
+	}
+}
+
+func TestRewritePanics(t *testing.T) {
+	for _, tc := range []struct {
+		old  string // the label of the node to replace
+		new  Node   // its replacement
+		want string // the panic
+	}{
+		{"BreakStat", &NilExpr{}, "ast.Rewrite: *ast.NilExpr is not a statement"},
+		{"1", &BreakStat{}, "ast.Rewrite: *ast.BreakStat is not an expression"},
+		{"1", nil, "ast.Rewrite: <nil> is not an expression"},
+		{"CallExpr", nil, "ast.Rewrite: <nil> is not an expression"},
+		{"Block", &DoStat{}, "ast.Rewrite: *ast.DoStat is not a block"},
+		{"Field", &NilExpr{}, "ast.Rewrite: *ast.NilExpr is not a table field"},
+	} {
+		got := func() (msg string) {
+			defer func() { msg = fmt.Sprint(recover()) }()
+			Rewrite(sample(), func(n Node) Node {
+				if label(n) == tc.old {
+					return tc.new
+				}
+				return n
+			})
+			return "no panic"
+		}()
+		if got != tc.want {
+			t.Errorf("replacing %s with %T: %s, want %s", tc.old, tc.new, got, tc.want)
+		}
+	}
+}
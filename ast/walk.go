@@ -0,0 +1,186 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ast
+
+import "fmt"
+
+// A Visitor's Enter method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of the node with w, followed by a call of w.Leave(node).
+type Visitor interface {
+	Enter(node Node) (w Visitor)
+	Leave(node Node)
+}
+
+// Walk traverses the tree rooted at node in depth-first order.
+// It starts by calling v.Enter(node); node must not be nil.
+// Children are visited in source order.
+func Walk(v Visitor, node Node) {
+	if v = v.Enter(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Chunk:
+		Walk(v, n.Block)
+	case *Block:
+		for _, s := range n.Stats {
+			Walk(v, s)
+		}
+	case *Ident:
+		// nothing to do
+
+	// statements
+	case *BadStat, *EmptyStat, *BreakStat:
+		// nothing to do
+	case *AssignStat:
+		walkExprList(v, n.Targets)
+		walkExprList(v, n.Values)
+	case *CallStat:
+		Walk(v, n.Call)
+	case *LabelStat:
+		Walk(v, n.Name)
+	case *GotoStat:
+		Walk(v, n.Label)
+	case *DoStat:
+		Walk(v, n.Body)
+	case *WhileStat:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+	case *RepeatStat:
+		Walk(v, n.Body)
+		Walk(v, n.Cond)
+	case *IfStat:
+		for _, c := range n.Clauses {
+			Walk(v, c)
+		}
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *IfClause:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+	case *NumericForStat:
+		Walk(v, n.Var)
+		Walk(v, n.Start)
+		Walk(v, n.Limit)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		Walk(v, n.Body)
+	case *GenericForStat:
+		walkIdentList(v, n.Names)
+		walkExprList(v, n.Exprs)
+		Walk(v, n.Body)
+	case *FunctionStat:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+	case *FuncName:
+		walkIdentList(v, n.Path)
+		if n.Method != nil {
+			Walk(v, n.Method)
+		}
+	case *LocalFunctionStat:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+	case *LocalStat:
+		for i, name := range n.Names {
+			Walk(v, name)
+			if i < len(n.Attribs) && n.Attribs[i] != nil {
+				Walk(v, n.Attribs[i])
+			}
+		}
+		walkExprList(v, n.Values)
+	case *ReturnStat:
+		walkExprList(v, n.Values)
+
+	// expressions
+	case *NilExpr, *BoolExpr, *NumberExpr, *StringExpr, *VarargExpr:
+		// nothing to do
+	case *FunctionExpr:
+		walkIdentList(v, n.Params)
+		Walk(v, n.Body)
+	case *TableExpr:
+		for _, f := range n.Fields {
+			Walk(v, f)
+		}
+	case *Field:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		Walk(v, n.Value)
+	case *BinaryExpr:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	case *UnaryExpr:
+		Walk(v, n.Operand)
+	case *ParenExpr:
+		Walk(v, n.X)
+	case *IndexExpr:
+		Walk(v, n.Object)
+		Walk(v, n.Key)
+	case *CallExpr:
+		Walk(v, n.Func)
+		if n.Method != nil {
+			Walk(v, n.Method)
+		}
+		walkExprList(v, n.Args)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Leave(node)
+}
+
+func walkIdentList(v Visitor, list []*Ident) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+func walkExprList(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Enter(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+func (f inspector) Leave(node Node) {}
+
+// Inspect traverses the tree rooted at node in depth-first order.
+// It calls f(node) for each node; if f returns true, Inspect
+// visits the children of the node.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,137 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// sample returns a new tree for
+//
+//	x = f(1, 2)
+//	while a do break; end
+//	t = {1, k = 2}
+//
+// The spans are left empty; Walk and Rewrite do not look at them.
+func sample() *Chunk {
+	id := func(name string) *Ident { return &Ident{Name: name} }
+	num := func(raw string) *NumberExpr { return &NumberExpr{Raw: raw} }
+	return &Chunk{Block: &Block{Stats: []Stat{
+		&AssignStat{
+			Targets: []Expr{id("x")},
+			Values:  []Expr{&CallExpr{Func: id("f"), Args: []Expr{num("1"), num("2")}}},
+		},
+		&WhileStat{Cond: id("a"), Body: &Block{Stats: []Stat{&BreakStat{}, &EmptyStat{}}}},
+		&AssignStat{
+			Targets: []Expr{id("t")},
+			Values: []Expr{&TableExpr{Fields: []*Field{
+				{Kind: PositionalField, Value: num("1")},
+				{Kind: NamedField, Key: &StringExpr{Raw: `"k"`, Value: "k"}, Value: num("2")},
+			}}},
+		},
+	}}}
+}
+
+// label names a node for the tests: a name or a literal as written,
+// or else the type of the node.
+func label(n Node) string {
+	switch n := n.(type) {
+	case *Ident:
+		return n.Name
+	case *NumberExpr:
+		return n.Raw
+	case *StringExpr:
+		return n.Raw
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+}
+
+// dump lists the labels of the nodes of a tree in the order of Walk.
+func dump(n Node) string {
+	var list []string
+	Inspect(n, func(n Node) bool {
+		list = append(list, label(n))
+		return true
+	})
+	return strings.Join(list, " ")
+}
+
+// recorder logs the nodes it enters and, with a slash, the ones it
+// leaves. It enters the children of a node with a new recorder for the
+// node, so that it can check that the node is left by that recorder.
+type recorder struct {
+	log   *[]string
+	node  Node              // the node whose children are visited, or nil
+	prune func(n Node) bool // reports whether to skip the children of n
+}
+
+func (r *recorder) Enter(n Node) Visitor {
+	*r.log = append(*r.log, label(n))
+	if r.prune != nil && r.prune(n) {
+		return nil
+	}
+	return &recorder{log: r.log, node: n, prune: r.prune}
+}
+
+func (r *recorder) Leave(n Node) {
+	if n != r.node {
+		*r.log = append(*r.log, "left "+label(n)+" with the visitor of "+label(r.node))
+		return
+	}
+	*r.log = append(*r.log, "/"+label(n))
+}
+
+func TestWalk(t *testing.T) {
+	for _, tc := range []struct {
+		prune string // the type of the nodes whose children are skipped
+		want  string
+	}{
+		{"", "Chunk Block" +
+			" AssignStat x /x CallExpr f /f 1 /1 2 /2 /CallExpr /AssignStat" +
+			" WhileStat a /a Block BreakStat /BreakStat EmptyStat /EmptyStat /Block /WhileStat" +
+			" AssignStat t /t TableExpr Field 1 /1 /Field Field \"k\" /\"k\" 2 /2 /Field /TableExpr /AssignStat" +
+			" /Block /Chunk"},
+		{"CallExpr", "Chunk Block" +
+			" AssignStat x /x CallExpr /AssignStat" +
+			" WhileStat a /a Block BreakStat /BreakStat EmptyStat /EmptyStat /Block /WhileStat" +
+			" AssignStat t /t TableExpr Field 1 /1 /Field Field \"k\" /\"k\" 2 /2 /Field /TableExpr /AssignStat" +
+			" /Block /Chunk"},
+		{"WhileStat", "Chunk Block" +
+			" AssignStat x /x CallExpr f /f 1 /1 2 /2 /CallExpr /AssignStat" +
+			" WhileStat" +
+			" AssignStat t /t TableExpr Field 1 /1 /Field Field \"k\" /\"k\" 2 /2 /Field /TableExpr /AssignStat" +
+			" /Block /Chunk"},
+		{"Chunk", "Chunk"},
+	} {
+		var log []string
+		r := &recorder{log: &log, prune: func(n Node) bool { return label(n) == tc.prune }}
+		Walk(r, sample())
+		if got := strings.Join(log, " "); got != tc.want {
+			t.Errorf("pruning %q: walked\n\t%s\nwant\n\t%s", tc.prune, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package check reports the compile-time errors in a Lua chunk that the
+// grammar does not catch: goto statements without a visible label or
+// that jump into the scope of a local, repeated labels, break outside
+// of a loop, '...' outside of a vararg function, unknown attributes,
+// more than one to-be-closed variable in a local declaration, and
+// assignments to const variables.
+//
+// The rules are those of the Lua 5.4 compiler, and the messages are
+// worded the same way.
+package check
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
+)
+
+// Error is a compile-time error found in a chunk.
+type Error struct {
+	Chunk string    // name of the chunk
+	Pos   lexer.Pos // position of the offending construct
+	Msg   string    // description of the error
+}
+
+// Error formats the error as "chunk:line: message".
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Chunk, e.Pos.Line, e.Msg)
+}
+
+// ErrorList is a list of errors, sorted by position.
+type ErrorList []*Error
+
+// Error returns the first error and the number of errors after it.
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	case 2:
+		return fmt.Sprintf("%s (and 1 more error)", list[0])
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// Check checks the chunk and returns the errors it finds as an ErrorList,
+// or nil if there are none.
+func Check(chunk *ast.Chunk) error {
+	c := &checker{chunk: chunk.Name}
+	// the main chunk is a vararg function
+	c.function(nil, true, chunk.Block)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Pos.Offset < c.errs[j].Pos.Offset })
+	return c.errs
+}
+
+type checker struct {
+	chunk string
+	errs  ErrorList
+	fn    *function // the function being checked
+}
+
+// function is the state of a function being checked.
+type function struct {
+	parent *function
+	vararg bool
+	block  *block   // the innermost open block
+	active []*local // the active locals, innermost last
+}
+
+// block is the state of an open block.
+type block struct {
+	parent  *block
+	loop    bool     // true if break leaves this block
+	nactive int      // number of active locals when the block was opened
+	labels  []*label // labels defined in the block so far
+	gotos   []*jump  // gotos in the block that still need a label
+}
+
+// local is a local variable.
+type local struct {
+	name   string
+	attrib string // "const", "close", or "" for a plain variable
+}
+
+type label struct {
+	name    string
+	line    int
+	nactive int // number of active locals at the label
+}
+
+// jump is a pending goto.
+type jump struct {
+	name    string
+	pos     lexer.Pos
+	nactive int // number of active locals at the goto
+}
+
+func (c *checker) errorf(pos lexer.Pos, format string, args ...interface{}) {
+	c.errs = append(c.errs, &Error{Chunk: c.chunk, Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// checkWritable reports an assignment to id if it names a const or
+// to-be-closed variable, which are read-only.
+func (c *checker) checkWritable(id *ast.Ident) {
+	if l := c.findLocal(id.Name); l != nil && l.attrib != "" {
+		c.errorf(id.Pos(), "attempt to assign to const variable '%s'", id.Name)
+	}
+}
+
+// function checks a function with the given parameters and body.
+func (c *checker) function(params []*ast.Ident, vararg bool, body *ast.Block) {
+	c.fn = &function{parent: c.fn, vararg: vararg}
+	c.openBlock(false)
+	for _, p := range params {
+		c.declare(p.Name, "")
+	}
+	c.stats(body.Stats, false)
+	c.closeBlock()
+	c.fn = c.fn.parent
+}
+
+func (c *checker) openBlock(loop bool) {
+	c.fn.block = &block{parent: c.fn.block, loop: loop, nactive: len(c.fn.active)}
+}
+
+// closeBlock closes the innermost block. Its pending gotos move to the
+// enclosing block, where a later label may still match them; they jump
+// out of the block, so the locals of the block do not count for them.
+// The gotos pending when the function's outermost block closes have no
+// label to jump to.
+func (c *checker) closeBlock() {
+	b := c.fn.block
+	c.fn.block, c.fn.active = b.parent, c.fn.active[:b.nactive]
+	if b.parent == nil {
+		for _, g := range b.gotos {
+			c.errorf(g.pos, "no visible label '%s' for <goto> at line %d", g.name, g.pos.Line)
+		}
+		return
+	}
+	for _, g := range b.gotos {
+		g.nactive = b.nactive
+		b.parent.gotos = append(b.parent.gotos, g)
+	}
+}
+
+// body checks a block nested in a statement.
+func (c *checker) body(b *ast.Block, loop bool) {
+	c.openBlock(loop)
+	c.stats(b.Stats, false)
+	c.closeBlock()
+}
+
+// declare brings a local variable into scope.
+func (c *checker) declare(name, attrib string) {
+	c.fn.active = append(c.fn.active, &local{name: name, attrib: attrib})
+}
+
+// findLocal returns the local variable with the given name that is in
+// scope, in the current function or an enclosing one, or nil if the
+// name refers to a global.
+func (c *checker) findLocal(name string) *local {
+	for fn := c.fn; fn != nil; fn = fn.parent {
+		for i := len(fn.active) - 1; i >= 0; i-- {
+			if l := fn.active[i]; l.name == name {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// findLabel returns the label with the given name that is visible in the
+// current function, or nil if there is none.
+func (c *checker) findLabel(name string) *label {
+	for b := c.fn.block; b != nil; b = b.parent {
+		for _, l := range b.labels {
+			if l.name == name {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// stats checks a list of statements. In the body of a repeat loop, the
+// condition is in the scope of the body's locals, so a label at the end
+// of the body is not at the end of their scope.
+func (c *checker) stats(list []ast.Stat, repeat bool) {
+	for i, s := range list {
+		switch s := s.(type) {
+		case *ast.LabelStat:
+			c.label(s, !repeat && onlyVoid(list[i+1:]))
+		default:
+			c.stat(s)
+		}
+	}
+}
+
+// onlyVoid reports whether the list holds nothing but labels and empty
+// statements.
+func onlyVoid(list []ast.Stat) bool {
+	for _, s := range list {
+		switch s.(type) {
+		case *ast.LabelStat, *ast.EmptyStat:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// label defines a label in the current block and resolves the pending
+// gotos that jump to it. A label at the end of its block is outside the
+// scope of the block's locals.
+func (c *checker) label(s *ast.LabelStat, last bool) {
+	name := s.Name.Name
+	if l := c.findLabel(name); l != nil {
+		c.errorf(s.Pos(), "label '%s' already defined on line %d", name, l.line)
+		return
+	}
+	b := c.fn.block
+	l := &label{name: name, line: s.Pos().Line, nactive: len(c.fn.active)}
+	if last {
+		l.nactive = b.nactive
+	}
+	b.labels = append(b.labels, l)
+
+	gotos := b.gotos[:0]
+	for _, g := range b.gotos {
+		if g.name != name {
+			gotos = append(gotos, g)
+		} else if g.nactive < l.nactive {
+			c.errorf(g.pos, "<goto %s> at line %d jumps into the scope of local '%s'", name, g.pos.Line, c.fn.active[g.nactive].name)
+		}
+	}
+	b.gotos = gotos
+}
+
+func (c *checker) stat(s ast.Stat) {
+	switch s := s.(type) {
+	case *ast.BadStat, *ast.EmptyStat:
+		// nothing to check
+	case *ast.AssignStat:
+		for _, t := range s.Targets {
+			if id, ok := t.(*ast.Ident); ok {
+				c.checkWritable(id)
+			}
+		}
+		c.exprs(s.Targets)
+		c.exprs(s.Values)
+	case *ast.CallStat:
+		c.expr(s.Call)
+	case *ast.BreakStat:
+		b := c.fn.block
+		for b != nil && !b.loop {
+			b = b.parent
+		}
+		if b == nil {
+			c.errorf(s.Pos(), "break outside a loop at line %d", s.Pos().Line)
+		}
+	case *ast.GotoStat:
+		// a visible label is behind the goto, which is always allowed
+		if c.findLabel(s.Label.Name) == nil {
+			b := c.fn.block
+			b.gotos = append(b.gotos, &jump{name: s.Label.Name, pos: s.Pos(), nactive: len(c.fn.active)})
+		}
+	case *ast.DoStat:
+		c.body(s.Body, false)
+	case *ast.WhileStat:
+		c.expr(s.Cond)
+		c.body(s.Body, true)
+	case *ast.RepeatStat:
+		c.openBlock(true)
+		c.openBlock(false)
+		c.stats(s.Body.Stats, true)
+		c.expr(s.Cond)
+		c.closeBlock()
+		c.closeBlock()
+	case *ast.IfStat:
+		for _, clause := range s.Clauses {
+			c.expr(clause.Cond)
+			c.body(clause.Body, false)
+		}
+		if s.Else != nil {
+			c.body(s.Else, false)
+		}
+	case *ast.NumericForStat:
+		c.expr(s.Start)
+		c.expr(s.Limit)
+		if s.Step != nil {
+			c.expr(s.Step)
+		}
+		c.openBlock(true)
+		c.declare(s.Var.Name, "")
+		c.body(s.Body, false)
+		c.closeBlock()
+	case *ast.GenericForStat:
+		c.exprs(s.Exprs)
+		c.openBlock(true)
+		for _, name := range s.Names {
+			c.declare(name.Name, "")
+		}
+		c.body(s.Body, false)
+		c.closeBlock()
+	case *ast.FunctionStat:
+		if len(s.Name.Path) == 1 && s.Name.Method == nil {
+			// function f() ... end assigns to f
+			c.checkWritable(s.Name.Path[0])
+		}
+		c.expr(s.Func)
+	case *ast.LocalFunctionStat:
+		c.declare(s.Name.Name, "")
+		c.expr(s.Func)
+	case *ast.LocalStat:
+		c.exprs(s.Values)
+		closing := false
+		for i, name := range s.Names {
+			attrib := ""
+			if i < len(s.Attribs) && s.Attribs[i] != nil {
+				switch attrib = s.Attribs[i].Name; attrib {
+				case "const":
+				case "close":
+					if closing {
+						c.errorf(s.Attribs[i].Pos(), "multiple to-be-closed variables in local list")
+					}
+					closing = true
+				default:
+					c.errorf(s.Attribs[i].Pos(), "unknown attribute '%s'", attrib)
+				}
+			}
+			c.declare(name.Name, attrib)
+		}
+	case *ast.ReturnStat:
+		c.exprs(s.Values)
+	case *ast.LabelStat:
+		c.label(s, false)
+	default:
+		panic(fmt.Sprintf("check: unexpected statement type %T", s))
+	}
+}
+
+func (c *checker) exprs(list []ast.Expr) {
+	for _, x := range list {
+		c.expr(x)
+	}
+}
+
+func (c *checker) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident, *ast.NilExpr, *ast.BoolExpr, *ast.NumberExpr, *ast.StringExpr:
+		// nothing to check
+	case *ast.VarargExpr:
+		if !c.fn.vararg {
+			c.errorf(x.Pos(), "cannot use '...' outside a vararg function near '...'")
+		}
+	case *ast.FunctionExpr:
+		c.function(x.Params, x.IsVararg, x.Body)
+	case *ast.TableExpr:
+		for _, f := range x.Fields {
+			if f.Key != nil {
+				c.expr(f.Key)
+			}
+			c.expr(f.Value)
+		}
+	case *ast.BinaryExpr:
+		c.expr(x.Left)
+		c.expr(x.Right)
+	case *ast.UnaryExpr:
+		c.expr(x.Operand)
+	case *ast.ParenExpr:
+		c.expr(x.X)
+	case *ast.IndexExpr:
+		c.expr(x.Object)
+		c.expr(x.Key)
+	case *ast.CallExpr:
+		c.expr(x.Func)
+		c.exprs(x.Args)
+	default:
+		panic(fmt.Sprintf("check: unexpected expression type %T", x))
+	}
+}
@@ -0,0 +1,128 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/syntax"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		errs []string
+	}{
+		// goto and labels
+		{"::top:: local x = 1 goto top", nil},
+		{"goto l; local x; ::l:: print(x)", []string{
+			"test:1: <goto l> at line 1 jumps into the scope of local 'x'"}},
+		{"goto l\nlocal x\n::l::\nprint(x)", []string{
+			"test:1: <goto l> at line 1 jumps into the scope of local 'x'"}},
+		{"do goto l; local x; ::l:: end", nil},
+		{"do goto l; local x; ::l:: ; ::m:: ; end", nil},
+		{"for i = 1, 3 do if i == 2 then goto continue end local y = i ::continue:: end", nil},
+		{"repeat goto l; local x ::l:: until x", []string{
+			"test:1: <goto l> at line 1 jumps into the scope of local 'x'"}},
+		{"goto nowhere", []string{
+			"test:1: no visible label 'nowhere' for <goto> at line 1"}},
+		{"goto l; do ::l:: end", []string{
+			"test:1: no visible label 'l' for <goto> at line 1"}},
+		{"::l::\nfunction f() goto l end", []string{
+			"test:2: no visible label 'l' for <goto> at line 2"}},
+		{"::a:: ::a::", []string{
+			"test:1: label 'a' already defined on line 1"}},
+		{"::a::\ndo ::a:: end", []string{
+			"test:2: label 'a' already defined on line 1"}},
+		{"do ::a:: end ::a::", nil},
+		{"::a:: function f() ::a:: end", nil},
+
+		// break
+		{"break", []string{
+			"test:1: break outside a loop at line 1"}},
+		{"while true do function f() break end end", []string{
+			"test:1: break outside a loop at line 1"}},
+		{"if x then\n  break\nend", []string{
+			"test:2: break outside a loop at line 2"}},
+		{"for i = 1, 2 do do break end end", nil},
+		{"for k in pairs(t) do break end", nil},
+		{"repeat if x then break end until true", nil},
+		{"while x do local function f() end break end", nil},
+
+		// '...'
+		{"return ...", nil},
+		{"function f(...) local t = {...} end", nil},
+		{"function f() return ... end", []string{
+			"test:1: cannot use '...' outside a vararg function near '...'"}},
+		{"function f(...) return function() return ... end end", []string{
+			"test:1: cannot use '...' outside a vararg function near '...'"}},
+
+		// attributes and const variables
+		{"local x <const>, y <close> = 1, nil", nil},
+		{"local x <foo> = 1", []string{
+			"test:1: unknown attribute 'foo'"}},
+		{"local a <close>, b <close> = nil, nil", []string{
+			"test:1: multiple to-be-closed variables in local list"}},
+		{"local x <const> = 1; x = 2", []string{
+			"test:1: attempt to assign to const variable 'x'"}},
+		{"local x <close> = nil; x = 1", []string{
+			"test:1: attempt to assign to const variable 'x'"}},
+		{"local x <const> = 1; x, y = 2, 3", []string{
+			"test:1: attempt to assign to const variable 'x'"}},
+		{"local x <const> = 1\nfunction f() x = 2 end", []string{
+			"test:2: attempt to assign to const variable 'x'"}},
+		{"local x <const> = 1; do local x = 2; x = 3 end", nil},
+		{"local x <const> = 1; local function f() local x; x = 2 end", nil},
+		{"local t <const> = {}; t.x = 1", nil},
+		{"local f <const> = nil; function f() end", []string{
+			"test:1: attempt to assign to const variable 'f'"}},
+		{"local t <const> = {}; function t.f() end function t:m() end", nil},
+
+		// every error is reported, in order
+		{"break\ngoto x\nfunction f() return ... end", []string{
+			"test:1: break outside a loop at line 1",
+			"test:2: no visible label 'x' for <goto> at line 2",
+			"test:3: cannot use '...' outside a vararg function near '...'"}},
+		{"local a <b> = 1\nlocal c <const> = 2\nc = 3", []string{
+			"test:1: unknown attribute 'b'",
+			"test:3: attempt to assign to const variable 'c'"}},
+	} {
+		chunk, err := syntax.ParseChunk("test", []byte(tc.src), 0)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		var errs []string
+		if err := Check(chunk); err != nil {
+			for _, e := range err.(ErrorList) {
+				errs = append(errs, e.Error())
+			}
+		}
+		if strings.Join(errs, "\n") != strings.Join(tc.errs, "\n") {
+			t.Errorf("%q: errors\n\t%s\nwant\n\t%s", tc.src, strings.Join(errs, "\n\t"), strings.Join(tc.errs, "\n\t"))
+		}
+	}
+}
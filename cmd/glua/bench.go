@@ -0,0 +1,91 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mdhender/glua/lua"
+)
+
+// engines are the engines compared by "glua bench".
+var engines = []string{"tree", "vm"}
+
+// runBench implements "glua bench", which times the scripts with each
+// engine.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: glua bench [-n count] script ...\n")
+		fs.PrintDefaults()
+	}
+	count := fs.Int("n", 1, "run each script `count` times with each engine")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || *count < 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	for _, name := range fs.Args() {
+		fp, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		tree, err := parse(name, fp)
+		fp.Close()
+		if err != nil {
+			return err
+		}
+		var times []time.Duration
+		for _, engine := range engines {
+			var total time.Duration
+			for i := 0; i < *count; i++ {
+				L := lua.NewState()
+				L.Stdout = io.Discard
+				L.Compile = compiler(engine)
+				L.Globals.Set("arg", lua.NewTable(0, 0))
+				start := time.Now()
+				fn, err := load(L, tree, engine)
+				if err == nil {
+					_, err = L.PCall(fn, nil)
+				}
+				if err != nil {
+					return fmt.Errorf("%s: %v", engine, err)
+				}
+				total += time.Since(start)
+			}
+			times = append(times, total/time.Duration(*count))
+			fmt.Printf("%s\t%s\t%v\n", name, engine, times[len(times)-1])
+		}
+		fmt.Printf("%s\tspeedup\t%.2fx\n", name, float64(times[0])/float64(times[1]))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdhender/glua/printer"
+)
+
+// runFmt implements "glua fmt", which formats Lua source files.
+// With no paths, it formats standard input. Directories are walked
+// for files ending in ".lua".
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: glua fmt [-w] [-d] [path ...]\n")
+		fs.PrintDefaults()
+	}
+	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		if *write {
+			return errors.New("cannot use -w with standard input")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return formatFile("<standard input>", src, false, *diff)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			} else if info.IsDir() || (name != path && !strings.HasSuffix(name, ".lua")) {
+				return nil
+			}
+			src, err := os.ReadFile(name)
+			if err == nil {
+				err = formatFile(name, src, *write, *diff)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("fmt: some files could not be formatted")
+	}
+	return nil
+}
+
+// formatFile formats src, read from the named file, and either writes
+// the result back to the file, prints a diff, or prints the result.
+func formatFile(name string, src []byte, write, diff bool) error {
+	res, err := printer.Format(name, src)
+	if err != nil {
+		return err
+	}
+	if !write && !diff {
+		_, err = os.Stdout.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if write {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if diff {
+		d, err := diffBytes(name, src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %v", err)
+		}
+		fmt.Printf("diff -u %s %s\n", filepath.ToSlash(filepath.Join("orig", name)), filepath.ToSlash(name))
+		os.Stdout.Write(d)
+	}
+	return nil
+}
+
+// diffBytes returns the unified diff of the two versions of the file,
+// as produced by the system's diff command.
+func diffBytes(name string, b1, b2 []byte) ([]byte, error) {
+	f1, err := writeTemp(b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTemp(b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	label := filepath.ToSlash(name)
+	data, err := exec.Command("diff", "-u", "-L", "orig/"+label, "-L", label, f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match;
+		// ignore that failure as long as we get output
+		err = nil
+	}
+	return data, err
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := os.CreateTemp("", "glua-fmt")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
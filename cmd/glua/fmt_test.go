@@ -0,0 +1,171 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	unformatted = "local   a=1\nprint( a )\n"
+	formatted   = "local a = 1\nprint(a)\n"
+)
+
+// fmtOutput runs "glua fmt" with args and returns what it printed to
+// standard output and standard error.
+func fmtOutput(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, out
+	err = runFmt(args)
+	os.Stdout, os.Stderr = stdout, stderr
+	b, rerr := os.ReadFile(out.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(b), err
+}
+
+// writeFiles creates the files in dir, making directories as needed.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFiles reports the files in dir that do not have the given contents.
+func checkFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("%s is %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.lua": unformatted})
+	out, err := fmtOutput(t, filepath.Join(dir, "a.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != formatted {
+		t.Errorf("printed %q, want %q", out, formatted)
+	}
+	checkFiles(t, dir, map[string]string{"a.lua": unformatted})
+
+	if _, err := fmtOutput(t, "-w"); err == nil {
+		t.Error("-w with standard input did not fail")
+	}
+}
+
+// TestFmtWrite checks that -w rewrites the .lua files that are not in
+// canonical form, walking directories, and prints nothing.
+func TestFmtWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.lua":     unformatted,
+		"sub/b.lua": formatted,
+		"sub/c.lua": unformatted,
+		"sub/d.txt": unformatted,
+	})
+	out, err := fmtOutput(t, "-w", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" {
+		t.Errorf("printed %q", out)
+	}
+	checkFiles(t, dir, map[string]string{
+		"a.lua":     formatted,
+		"sub/b.lua": formatted,
+		"sub/c.lua": formatted,
+		"sub/d.txt": unformatted,
+	})
+	info, err := os.Stat(filepath.Join(dir, "a.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("rewritten file has mode %v, want %v", perm, os.FileMode(0600))
+	}
+
+	writeFiles(t, dir, map[string]string{"bad.lua": "x = = 1\n"})
+	out, err = fmtOutput(t, "-w", dir)
+	if err == nil {
+		t.Error("a file with a syntax error did not fail")
+	}
+	if want := "bad.lua:1: unexpected symbol near '='\n"; !strings.HasSuffix(out, want) {
+		t.Errorf("printed %q, want it to end with %q", out, want)
+	}
+	checkFiles(t, dir, map[string]string{"bad.lua": "x = = 1\n"})
+}
+
+// TestFmtDiff checks that -d prints a diff for each file that is not in
+// canonical form and leaves the files alone.
+func TestFmtDiff(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("no diff command")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.lua": unformatted, "b.lua": formatted})
+	out, err := fmtOutput(t, "-d", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.ToSlash(filepath.Join(dir, "a.lua"))
+	for _, want := range []string{
+		"diff -u orig" + name + " " + name + "\n",
+		"\n-local   a=1\n-print( a )\n+local a = 1\n+print(a)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "b.lua") {
+		t.Errorf("diff of a file in canonical form:\n%s", out)
+	}
+	checkFiles(t, dir, map[string]string{"a.lua": unformatted, "b.lua": formatted})
+}
@@ -0,0 +1,110 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/lua"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files with what the scripts print")
+
+// TestScripts runs each script in testdata with both engines and
+// compares what it prints with the .golden file of the same name, so
+// that the engines cannot drift apart. An error that ends a script is
+// printed after its output.
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "*.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts in testdata")
+	}
+	for _, script := range scripts {
+		golden := strings.TrimSuffix(script, ".lua") + ".golden"
+		if *update {
+			out, err := runScript(script, "vm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(golden, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, engine := range engines {
+			script, engine := script, engine
+			t.Run(filepath.Base(script)+"/"+engine, func(t *testing.T) {
+				got, err := runScript(script, engine)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("glua -engine %s %s printed\n%s\nwant\n%s", engine, script, got, want)
+				}
+			})
+		}
+	}
+}
+
+// runScript runs a script with the named engine and returns what it
+// printed, followed by the error that ended it, if any. The error
+// returned is one that kept the script from running.
+func runScript(script, engine string) ([]byte, error) {
+	fp, err := os.Open(script)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	tree, err := parse(filepath.ToSlash(script), fp)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	L := lua.NewState()
+	L.Stdout, L.Stderr = &out, &out
+	L.Compile = compiler(engine)
+	L.Globals.Set("arg", lua.NewTable(0, 0))
+	fn, err := load(L, tree, engine)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := L.PCall(fn, nil); err != nil {
+		fmt.Fprintf(&out, "error: %v\n", err)
+	}
+	return out.Bytes(), nil
+}
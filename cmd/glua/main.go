@@ -0,0 +1,185 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Command glua runs Lua scripts.
+//
+// Usage:
+//
+//	glua [-engine vm|tree] [-l] [script [args]]
+//	glua fmt [-w] [-d] [path ...]
+//	glua bench [-n count] script ...
+//
+// The script is read from standard input if it is omitted or is "-".
+// The arguments after it are passed to the script as its varargs and
+// in the global table arg, where arg[0] is the script name.
+//
+// Scripts are compiled to bytecode and run by a virtual machine unless
+// -engine is "tree", which runs them by walking their syntax trees.
+// The -l flag lists the bytecode instead of running the script.
+//
+// The fmt command formats Lua source files in a canonical style.
+// By default it prints the formatted source; -w writes it back to the
+// file and -d prints a diff instead. Directories are searched for files
+// ending in ".lua", and with no paths, standard input is formatted.
+// To run a script named fmt, give its path, as in "glua ./fmt".
+//
+// The bench command runs each script with both engines, count times
+// each, discarding what the scripts print, and reports how long a run
+// took on average.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/check"
+	"github.com/mdhender/glua/interp"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/optimize"
+	"github.com/mdhender/glua/syntax"
+	"github.com/mdhender/glua/vm"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Printf("%+v\n", err)
+		os.Exit(2)
+	}
+}
+
+func run() error {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			return runFmt(os.Args[2:])
+		case "bench":
+			return runBench(os.Args[2:])
+		}
+	}
+
+	fs := flag.NewFlagSet("glua", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: glua [-engine vm|tree] [-l] [script [args]]\n")
+		fs.PrintDefaults()
+	}
+	engine := fs.String("engine", "vm", "run scripts with the bytecode `vm` or the syntax tree walker (tree)")
+	list := fs.Bool("l", false, "list the bytecode instead of running the script")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+
+	name, script := "stdin", io.Reader(os.Stdin)
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		fp, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		name, script = fs.Arg(0), fp
+	}
+
+	tree, err := parse(name, script)
+	if err != nil {
+		return err
+	}
+	if *list {
+		p, err := vm.Compile(tree)
+		if err != nil {
+			return err
+		}
+		p.Disassemble(os.Stdout)
+		return nil
+	}
+
+	L := lua.NewState()
+	L.Compile = compiler(*engine)
+	fn, err := load(L, tree, *engine)
+	if err != nil {
+		return err
+	}
+	var args []lua.Value
+	argt := lua.NewTable(0, 0)
+	argt.Set(int64(0), name)
+	if fs.NArg() > 1 {
+		for i, a := range fs.Args()[1:] {
+			args = append(args, a)
+			argt.Set(int64(i+1), a)
+		}
+	}
+	L.Globals.Set("arg", argt)
+	_, err = L.PCall(fn, args)
+	return err
+}
+
+// parse reads a chunk and prepares its syntax tree to be run.
+func parse(name string, r io.Reader) (*ast.Chunk, error) {
+	chunk, err := syntax.ParseReader(name, r)
+	if err != nil {
+		return nil, err
+	}
+	tree := syntax.Lower(chunk)
+	if err := check.Check(tree); err != nil {
+		return nil, err
+	}
+	optimize.Fold(tree)
+	return tree, nil
+}
+
+// load returns the main function of a chunk for the named engine.
+func load(L *lua.State, tree *ast.Chunk, engine string) (lua.Function, error) {
+	switch engine {
+	case "vm":
+		p, err := vm.Compile(tree)
+		if err != nil {
+			return nil, err
+		}
+		return vm.Load(L, p), nil
+	case "tree":
+		return interp.Load(L, tree), nil
+	}
+	return nil, fmt.Errorf("unknown engine %q", engine)
+}
+
+// compiler returns the compiler of the chunks that scripts load, for
+// the named engine.
+func compiler(engine string) lua.Compiler {
+	return func(L *lua.State, name string, src []byte, env lua.Value) (lua.Function, error) {
+		tree, err := parse(name, bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		if engine == "tree" {
+			return interp.LoadEnv(tree, env), nil
+		}
+		p, err := vm.Compile(tree)
+		if err != nil {
+			return nil, err
+		}
+		return vm.LoadEnv(p, env), nil
+	}
+}
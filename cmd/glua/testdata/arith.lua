@@ -0,0 +1,23 @@
+-- integer and float arithmetic, conversions and comparisons
+
+print(1 + 2, 1 + 2.0, 7 // 2, 7.0 // 2, -7 // 2, 7 % -3, -7 % 3, 7.5 % 2)
+print(2^10, 10 / 4, 3 / 0, -3 / 0, 0/0 ~= 0/0)
+print(math.maxinteger + 1 == math.mininteger, math.mininteger // -1)
+print(3 & 5, 3 | 5, 3 ~ 5, ~0, 1 << 63, 1 << 64, -1 >> 1, 2.0 | 1)
+print(0x10, 0xA.8p1, 1e2, .5, 3., 0x7fffffffffffffff, 0xffffffffffffffff)
+print(9007199254740993, 1e308 * 10, -0.0, 100000000000000)
+print("10" + 1, "0x10" * 1, "1e1" + 0, 10 .. 20, 1.5 .. "")
+print(tonumber("  12  "), tonumber("12a"), tonumber("ff", 16), tonumber("zz", 36), tonumber("8", 8))
+print(tonumber("0x1p4"), tonumber(""), tonumber("1e"), tonumber(" -0x10 "))
+print(math.type(1), math.type(1.0), math.type("1"), math.tointeger(3.0), math.tointeger(3.5))
+print(1 == 1.0, 1 < 1.5, "a" < "b", "Z" < "a", "" < "a", 2^53 == 2^53 + 1)
+print(math.floor(-3.5), math.ceil(-3.5), math.abs(math.mininteger), math.fmod(-7, 3), math.fmod(7, -3.0))
+print(math.max(1, 2.5, -1), math.min(3, 1, 2), math.ult(1, -1), math.huge, -math.huge, math.pi)
+print(string.format("%.3f", 2 / 3), 1e15, 1e16, 2^63, -2^63 == math.mininteger)
+print(pcall(function() return 1 // 0 end))
+print(pcall(function() return 1 % 0 end))
+print(pcall(function() return 1 < "2" end))
+print(pcall(function() return {} + 1 end))
+print(pcall(function() return 2^53 | 0 end))
+print(pcall(function() return 1.5 | 0 end))
+print(pcall(function() return "abc" + 0 end))
@@ -0,0 +1,38 @@
+-- long chains of operators, which must not need a register per operand
+
+local function run(name, src)
+  local f, err = load(src, "=" .. name)
+  if not f then
+    print(name, err)
+  else
+    print(name, pcall(f))
+  end
+end
+
+local function chain(operand, op, n)
+  return (operand .. " " .. op .. " "):rep(n - 1) .. operand
+end
+
+run("locals", "local a = 1 return " .. chain("a", "+", 300))
+run("globals", "x = 2 return " .. chain("x", "+", 300))
+run("fields", "local t = {x = 3} return " .. chain("t.x", "+", 300))
+run("global fields", "t = {x = 1} return " .. chain("t.x", "*", 300))
+run("mixed", "local a, b = 7, 2 return " .. chain("a - b * a // b % a", "+", 100))
+run("bitwise", "local a = 5 return " .. chain("a", "|", 300) .. ", " .. chain("a", "~", 301))
+run("power", "local a = 1 return " .. chain("a", "^", 180))
+run("power of globals", "y = 1 return " .. chain("y", "^", 180))
+run("shifts", "local a = 1 return " .. chain("a", "<<", 40) .. ", " .. chain("1", "<<", 40))
+run("calls", "local function f() return 1 end return " .. chain("f()", "+", 300))
+run("comparisons", "local a = 1 return " .. chain("(a < 2)", "==", 300))
+run("negations", "local a = 3 return " .. ("- "):rep(300) .. "a, " .. ("not "):rep(301) .. "a")
+run("field path", "local t = {} t.x = t return t" .. (".x"):rep(300) .. " == t")
+run("index path", "local t = {} t[1] = t return t" .. ("[1]"):rep(300) .. " == t")
+run("nested parens", "local a = 2 return " .. ("(a + "):rep(150) .. "a" .. (")"):rep(150))
+
+-- the operands are still evaluated left to right
+local order = {}
+local function op(v) order[#order + 1] = v return v end
+print(op(1) + op(2) * op(3) - op(4) ^ op(5), #order, order[1], order[3], order[5])
+local a = 10
+a = op(a) - a + a * op(2)
+print(a, order[6], order[7])
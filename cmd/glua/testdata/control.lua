@@ -0,0 +1,68 @@
+-- control flow, closures and varargs
+
+local function classify(n)
+  if n < 0 then return "negative" elseif n == 0 then return "zero" else return "positive" end
+end
+print(classify(-1), classify(0), classify(1))
+
+local s = 0
+for i = 10, 1, -3 do s = s + i end
+print("for", s)
+for i = 1, 0 do print("never") end
+for x = 1.0, 2.0, 0.5 do print("float for", x) end
+for i = math.maxinteger - 2, math.maxinteger do print("edge", i) end
+print(pcall(function() for i = 1, 10, 0 do end end))
+print(pcall(function() for i = "a", 2 do end end))
+
+local n = 0
+while true do n = n + 1 if n == 5 then break end end
+repeat local done = n > 7 n = n + 1 until done
+print("loops", n)
+
+for i = 1, 3 do
+  for j = 1, 3 do
+    if j == 2 then goto continue end
+    print("goto", i, j)
+    ::continue::
+  end
+end
+
+local fns = {}
+for i = 1, 3 do fns[i] = function() return i end end
+print("captured", fns[1](), fns[2](), fns[3]())
+
+local function counter()
+  local c = 0
+  return function() c = c + 1 return c end, function() return c end
+end
+local inc, get = counter()
+inc() inc()
+print("counter", get())
+
+local function va(...)
+  local a, b = ...
+  return select("#", ...), a, b, select(-1, ...), (select(2, ...))
+end
+print(va(1, nil, 3))
+print(select(2, "a", "b", "c"), (select(2, "a", "b", "c")))
+print(pcall(select, 0, 1))
+
+local function pack(...) return {n = select("#", ...), ...} end
+local t = pack(nil, nil)
+print("pack", t.n, #t)
+
+local function sum(n, acc) if n == 0 then return acc end return sum(n - 1, acc + n) end
+print("tail", sum(100000, 0))
+
+local function multi() return 1, 2, 3 end
+print(multi(), (multi()), ({multi(), multi()})[4])
+local a, b, c, d = multi()
+print(a, b, c, d)
+print(#{multi(), nil}, #{(multi())})
+
+do
+  local x <const> = 10
+  local y <close> = nil
+  print("attribs", x, y)
+end
+print(2 > 1 and "yes" or "no", nil and 1, false or nil, nil or false, not nil, 1 and 2)
@@ -0,0 +1,58 @@
+-- errors, protected calls and load
+
+print(pcall(error, "msg"))
+print(pcall(error, "msg", 0))
+print(select(2, pcall(error, {code = 1})).code)
+print(select("#", pcall(error)))
+local function lvl1() error("level one") end
+local function lvl2() error("level two", 2) end
+print(pcall(lvl1))
+print(pcall(function() lvl2() end))
+print(pcall(function() local x x.y = 1 end))
+print(pcall(function() local t = {} t.a.b = 1 end))
+print(pcall(function() undefinedfn() end))
+print(pcall(function() local s = "x" return s:nomethod() end))
+print(pcall(function() return #nil end))
+print(pcall(function() return -{} end))
+print(pcall(function() return ("x")() end))
+print(pcall(function() return 1 .. {} end))
+print(pcall(string.rep))
+print(pcall(string.sub, "x", {}))
+print(pcall(setmetatable, 1, {}))
+print(pcall(math.floor, "a"))
+print(pcall(tostring))
+print(pcall(ipairs))
+
+print(xpcall(function() error("handled") end, function(m) return "handler: " .. m end))
+print(xpcall(function(...) return ... end, print, 1, 2))
+print(xpcall(function() error("x") end, function() error("again") end))
+
+local depth = 0
+local function recurse() depth = depth + 1 return 1 + recurse() end
+local ok, err = pcall(recurse)
+print("overflow", ok, err:match("stack overflow") ~= nil, depth > 1000)
+
+print(load("return 1 +"))
+print(load("x = = 1", "=chunk"))
+print(load("return ...", "args")(4, 5))
+print(load(5))
+local parts = {"return ", "1 ", "+ 41"}
+local i = 0
+print(load(function() i = i + 1 return parts[i] end)())
+local env = {y = 7}
+print(load("return y", "env", "t", env)())
+print(load("\27Lua", "bin", "t"))
+print(pcall(load, "return 1", "x", "q"))
+print(load("break"))
+print(load("goto nowhere"))
+print(load("local x <foo> = 1"))
+print(load("local c <const> = 1; c = 2"))
+print(load("f() = 1"))
+print(load("x = 'unfinished"))
+print(load("x = [[long"))
+print(load("x = 3..4"))
+print(load("x = '\\300'"))
+print(load("::a:: ::a::"))
+print(tostring(nil), tostring(true), type(print), type(nil))
+
+error("uncaught at the end")
@@ -0,0 +1,92 @@
+-- metatables and metamethods
+
+local V = {}
+V.__index = V
+V.__name = "Vector"
+local function vec(x, y) return setmetatable({x = x, y = y}, V) end
+V.__add = function(a, b) return vec(a.x + b.x, a.y + b.y) end
+V.__unm = function(a) return vec(-a.x, -a.y) end
+V.__eq = function(a, b) return a.x == b.x and a.y == b.y end
+V.__lt = function(a, b) return a.x < b.x end
+V.__le = function(a, b) return a.x <= b.x end
+V.__len = function(a) return 2 end
+V.__concat = function(a, b)
+  local function s(v) return type(v) == "table" and "(" .. v.x .. "," .. v.y .. ")" or v end
+  return s(a) .. s(b)
+end
+V.__tostring = function(a) return "vec" .. a .. "" end
+V.__call = function(self, k) return self[k] end
+function V:norm1() return math.abs(self.x) + math.abs(self.y) end
+
+local a, b = vec(1, 2), vec(3, 4)
+print(tostring(a + b), tostring(-a), a == vec(1, 2), a ~= b, a < b, a <= b, b > a, #a)
+print(a .. "!", "v=" .. b, a("y"), a:norm1(), getmetatable(a) == V)
+
+local log = {}
+local proxy = setmetatable({}, {
+  __index = function(t, k) return k .. "?" end,
+  __newindex = function(t, k, v) rawset(t, k, v * 2) end,
+})
+proxy.x = 5
+print(proxy.x, proxy.y, rawget(proxy, "y"))
+
+local base = {greet = "hi"}
+local mid = setmetatable({}, {__index = base})
+local top = setmetatable({}, {__index = mid})
+print(top.greet, top.other)
+
+local store = {}
+local w = setmetatable({}, {__newindex = store})
+w.k = 1
+print(rawget(w, "k"), store.k)
+
+local arith = setmetatable({}, {
+  __sub = function() return "sub" end, __mul = function() return "mul" end,
+  __div = function() return "div" end, __mod = function() return "mod" end,
+  __pow = function() return "pow" end, __idiv = function() return "idiv" end,
+  __band = function() return "band" end, __bor = function() return "bor" end,
+  __bxor = function() return "bxor" end, __shl = function() return "shl" end,
+  __shr = function() return "shr" end, __bnot = function() return "bnot" end,
+})
+print(arith - 1, 1 * arith, arith / 1, arith % 1, arith ^ 1, arith // 1)
+print(arith & 1, arith | 1, arith ~ 1, arith << 1, arith >> 1, ~arith)
+
+local locked = setmetatable({}, {__metatable = "locked"})
+print(getmetatable(locked), pcall(setmetatable, locked, {}))
+print(getmetatable("abc").__index == string, ("x"):rep(3))
+
+print(pcall(function() return a + 1 end))
+print(pcall(function() local u = vec(0, 0) return u.x.y.z end))
+print(pcall(function() return {} < {} end))
+print(pcall(function() return setmetatable({}, {__index = function(t, k) error("no field " .. k) end}).f end))
+
+do
+  local order = {}
+  do
+    local x <close> = setmetatable({}, {__close = function(o, e) order[#order + 1] = "x" .. tostring(e) end})
+    local y <close> = setmetatable({}, {__close = function(o, e) order[#order + 1] = "y" .. tostring(e) end})
+  end
+  print("closed", order[1], order[2])
+  local ok, err = pcall(function()
+    local z <close> = setmetatable({}, {__close = function(o, e) order[#order + 1] = "z:" .. tostring(e) end})
+    error("boom", 0)
+  end)
+  print("closed on error", ok, err, order[3])
+  print(pcall(function() local bad <close> = {} end))
+  local function f()
+    local c <close> = setmetatable({}, {__close = function() order[#order + 1] = "ret" end})
+    return "value"
+  end
+  print(f(), order[4])
+  for i in function(s, c) if c < 2 then return c + 1 end end, nil, 0,
+      setmetatable({}, {__close = function() order[#order + 1] = "for" end}) do
+  end
+  print("for closed", order[5])
+end
+
+local finalized = false
+local function garbage() setmetatable({}, {__gc = function() finalized = true end}) end
+garbage()
+collectgarbage()
+collectgarbage()
+print("finalized", finalized)
@@ -0,0 +1,30 @@
+-- the string library
+
+print(("x"):rep(3, ","), ("abc"):upper(), ("ABC"):lower(), ("abc"):reverse(), ("abc"):len())
+print(("hello"):sub(2, -2), ("hello"):sub(-3), ("hello"):byte(1, 3), string.char(76, 117, 97))
+print(string.find("hello world", "o w"), string.find("hello", "l+"), string.find("a.b", ".", 1, true))
+print(string.match("key = value", "(%w+)%s*=%s*(%w+)"), string.match("hello", "()ll()"))
+print(string.match("  trim  ", "^%s*(.-)%s*$") .. "|", string.match("2024-01-15", "(%d+)-(%d+)-(%d+)"))
+print(string.match("[[x]]", "%[(%b[])%]"), string.match("THE (quick) fox", "%f[%a]%a+"), string.match("abc", ".-b"))
+print(string.gsub("hello world", "o", "0"), string.gsub("abc", "%w", "%0%0"), string.gsub("abc", "", "-"))
+print(string.gsub("hello world", "(%w+)", "<%1>", 1), string.gsub("$x $y", "%$(%w+)", {x = "1", y = false}))
+print(string.gsub("abc", "b", function(c) return c:upper() end), string.gsub("a,b,,c", "[^,]*", "#"))
+local words = {}
+for w in string.gmatch("one two  three", "%a+") do words[#words + 1] = w end
+print(#words, words[1], words[3])
+for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do print("pair", k, v) end
+print(string.format("%d %5.2f %-5s| %x %X %o %e %g %g", 42, 3.14159, "ab", 255, 255, 8, 12345.678, 0.0001, 1e20))
+print(string.format("%q", "a\nb\"c\0d\200"), string.format("%q", 1/3), string.format("%q", math.mininteger))
+print(string.format("%s %s %s %10.3s|", nil, true, {} ~= nil, "abcdef"), string.format("%c%c", 72, 105))
+print(string.format("%5d|%-5d|%05d|%+d|% d", 42, 42, 42, 42, 42), string.format("%a", 1.0), string.format("%%"))
+print(string.format("%i", 3.0), pcall(string.format, "%d", 3.5))
+print(pcall(string.format, "%y", 1))
+print(pcall(string.rep, "x", -1), ("x"):rep(0) == "")
+print(pcall(string.find, "a", "(%"))
+print(pcall(string.find, "a", "[a"))
+print(pcall(string.gsub, "a", "(a)", "%2"))
+print(pcall(string.match, "a", "%1"))
+print(#string.format("%099d", 1), tostring(1e100), tostring(-0.0), 2^63, math.tointeger("8"))
+print("\65\066\x43\u{44}\z
+       E", "tab\tend", 'single', [[long
+string]], [==[with ]] inside]==])
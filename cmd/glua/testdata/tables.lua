@@ -0,0 +1,37 @@
+-- tables: constructors, length, traversal and raw access
+
+local t = {10, 20, 30, x = 1, ["y z"] = 2, [1.0 + 3] = 40}
+print(#t, t[4], t[4.0], t.x, t["y z"], t[5])
+
+local keys = {}
+for k, v in pairs({1, 2, 3}) do keys[#keys + 1] = k .. "=" .. v end
+print(#keys, keys[1], keys[2], keys[3])
+
+for i, v in ipairs({"a", "b", nil, "d"}) do print("ipairs", i, v) end
+
+local big = {}
+for i = 1, 100 do big[i] = i * i end
+print(#big, big[100])
+for i = 100, 51, -1 do big[i] = nil end
+print(#big)
+
+local h = {}
+h[1] = "one" h[2] = "two" h[2^53] = "far"
+print(#h, h[2^53], next({}), rawlen({1, 2}), rawlen("abc"))
+
+local m = {a = 1, b = 2, c = 3, d = 4}
+local n = 0
+for k in pairs(m) do n = n + 1 m[k] = nil end
+print("cleared while traversing", n, next(m))
+
+print(pcall(function() local x = {} x[nil] = 1 end))
+print(pcall(function() local x = {} x[0/0] = 1 end))
+print(pcall(next, {}, "missing"))
+print(rawequal(t, t), rawequal(t, {}), rawget(t, "x"), rawset(t, "w", 5) == t, t.w)
+
+local nested = {a = {b = {c = "deep"}}}
+print(nested.a.b.c, #"", #{n = 1})
+
+local s = {}
+s[1] = 1 s[3] = 3 s[2] = 2 s[4] = 4
+print(#s, s[1] + s[2] + s[3] + s[4])
@@ -0,0 +1,56 @@
+-- tables with weak keys and values
+
+local function count(t) local n = 0 for _ in pairs(t) do n = n + 1 end return n end
+local k = setmetatable({}, {__mode = "k"})
+local keep = {}
+local function fill()
+  for i = 1, 100 do k[{}] = i end
+  k[keep] = "kept"
+  k["str"] = {}
+  k[1] = {}
+end
+fill()
+collectgarbage()
+print("weak keys", count(k), k[keep], type(k.str), type(k[1]))
+
+local v = setmetatable({}, {__mode = "v"})
+local function fillv()
+  for i = 1, 10 do v[i] = {} end
+  v.a = {} v.b = "s" v.c = keep v.f = function() end v.p = print
+  v[20] = 5
+end
+fillv()
+collectgarbage()
+print("weak values", count(v), #v, v.b, v.c == keep, v.f, v.p == print, v[20])
+
+local kv = setmetatable({}, {__mode = "kv"})
+local function fillkv() kv[{}] = 1 kv[2] = {} kv[keep] = keep end
+fillkv()
+collectgarbage()
+print("weak both", count(kv), kv[keep] == keep)
+
+-- a table made weak after it was filled, then strong again
+local s = {}
+local function fills() for i = 1, 5 do s[{}] = true end s[keep] = true end
+fills()
+setmetatable(s, {__mode = "k"})
+collectgarbage()
+print("made weak", count(s))
+setmetatable(s, nil)
+s[{}] = 1
+collectgarbage()
+print("made strong", count(s))
+
+-- traversal with collections in the middle
+local t = setmetatable({}, {__mode = "v"})
+for i = 1, 20 do t["k" .. i] = {} end
+local seen = 0
+for key, val in pairs(t) do seen = seen + 1 t[key] = nil collectgarbage() end
+print("traversal", seen <= 20, count(t))
+
+-- a closure as a weak key
+local c = setmetatable({}, {__mode = "k"})
+local function mk() local x = 0 c[function() x = x + 1 end] = 1 end
+mk()
+collectgarbage()
+print("closures", count(c))
@@ -1,3 +1,3 @@
 module github.com/mdhender/glua
 
-go 1.15
+go 1.24
@@ -0,0 +1,262 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package interp
+
+import (
+	"fmt"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/resolve"
+)
+
+// fieldsPerFlush is the number of positional fields of a table
+// constructor that are evaluated before they are stored, as in the
+// reference implementation. It matters when a keyed field sets the
+// same index as a positional one: the positional field wins unless
+// it was already stored.
+const fieldsPerFlush = 50
+
+// arithOps maps the arithmetic and bitwise binary operators to the
+// operations of package lua.
+var arithOps = [...]lua.Op{
+	ast.OpAdd:  lua.OpAdd,
+	ast.OpSub:  lua.OpSub,
+	ast.OpMul:  lua.OpMul,
+	ast.OpDiv:  lua.OpDiv,
+	ast.OpIDiv: lua.OpIDiv,
+	ast.OpPow:  lua.OpPow,
+	ast.OpMod:  lua.OpMod,
+	ast.OpBand: lua.OpBand,
+	ast.OpBxor: lua.OpBxor,
+	ast.OpBor:  lua.OpBor,
+	ast.OpShr:  lua.OpShr,
+	ast.OpShl:  lua.OpShl,
+}
+
+// eval evaluates an expression to a single value. The results of a
+// call or a vararg expression are truncated to the first one.
+func (fr *frame) eval(x ast.Expr) lua.Value {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return fr.getVar(x)
+	case *ast.NilExpr:
+		return nil
+	case *ast.BoolExpr:
+		return x.Value
+	case *ast.NumberExpr:
+		if x.IsFloat {
+			return x.Float
+		}
+		return x.Int
+	case *ast.StringExpr:
+		return x.Value
+	case *ast.VarargExpr:
+		if len(fr.varargs) == 0 {
+			return nil
+		}
+		return fr.varargs[0]
+	case *ast.FunctionExpr:
+		return fr.closure(x)
+	case *ast.TableExpr:
+		return fr.table(x)
+	case *ast.BinaryExpr:
+		return fr.binary(x)
+	case *ast.UnaryExpr:
+		v := fr.eval(x.Operand)
+		fr.cur = x
+		switch x.Op {
+		case ast.OpNeg:
+			return fr.L.Arith(lua.OpUnm, v, v)
+		case ast.OpBnot:
+			return fr.L.Arith(lua.OpBnot, v, v)
+		case ast.OpNot:
+			return !lua.Truth(v)
+		case ast.OpLen:
+			return fr.L.Len(v)
+		}
+	case *ast.ParenExpr:
+		return fr.eval(x.X)
+	case *ast.IndexExpr:
+		obj, key := fr.eval(x.Object), fr.eval(x.Key)
+		fr.cur = x
+		return fr.L.Index(obj, key)
+	case *ast.CallExpr:
+		if rets := fr.call(x); len(rets) != 0 {
+			return rets[0]
+		}
+		return nil
+	}
+	panic(fmt.Sprintf("interp: unexpected expression type %T", x))
+}
+
+// evalList evaluates a list of expressions. A call or vararg
+// expression at the end of the list contributes all its values.
+func (fr *frame) evalList(list []ast.Expr) []lua.Value {
+	if len(list) == 0 {
+		return nil
+	}
+	n := len(list) - 1
+	vals := make([]lua.Value, n, n+1)
+	for i, x := range list[:n] {
+		vals[i] = fr.eval(x)
+	}
+	return append(vals, fr.evalMulti(list[n])...)
+}
+
+// evalMulti evaluates an expression to all of its values.
+func (fr *frame) evalMulti(x ast.Expr) []lua.Value {
+	switch x := x.(type) {
+	case *ast.CallExpr:
+		return fr.call(x)
+	case *ast.VarargExpr:
+		return fr.varargs
+	}
+	return []lua.Value{fr.eval(x)}
+}
+
+// getVar returns the value of the variable a name refers to.
+func (fr *frame) getVar(id *ast.Ident) lua.Value {
+	b := fr.prog.info.Uses[id]
+	if b.Kind == resolve.Global {
+		env := fr.binding(b.Env)
+		fr.cur = globalIndex{id}
+		return fr.L.Index(env, id.Name)
+	}
+	return fr.binding(b)
+}
+
+// binding returns the value of a local or an upvalue.
+func (fr *frame) binding(b *resolve.Binding) lua.Value {
+	if b.Kind == resolve.Upvalue {
+		return fr.cl.upvals[b.Index].v
+	}
+	v := fr.slots[b.Index]
+	if b.Var.Captured {
+		return v.(*cell).v
+	}
+	return v
+}
+
+func (fr *frame) binary(x *ast.BinaryExpr) lua.Value {
+	switch x.Op {
+	case ast.OpAnd:
+		if v := fr.eval(x.Left); !lua.Truth(v) {
+			return v
+		}
+		return fr.eval(x.Right)
+	case ast.OpOr:
+		if v := fr.eval(x.Left); lua.Truth(v) {
+			return v
+		}
+		return fr.eval(x.Right)
+	}
+	a, b := fr.eval(x.Left), fr.eval(x.Right)
+	fr.cur = x
+	switch x.Op {
+	case ast.OpConcat:
+		return fr.L.Concat(a, b)
+	case ast.OpEQ:
+		return fr.L.Equal(a, b)
+	case ast.OpNE:
+		return !fr.L.Equal(a, b)
+	case ast.OpLT:
+		return fr.L.Less(a, b)
+	case ast.OpLE:
+		return fr.L.LessEqual(a, b)
+	case ast.OpGT:
+		return fr.L.Less(b, a)
+	case ast.OpGE:
+		return fr.L.LessEqual(b, a)
+	}
+	return fr.L.Arith(arithOps[x.Op], a, b)
+}
+
+// table evaluates a table constructor.
+func (fr *frame) table(x *ast.TableExpr) *lua.Table {
+	narr := 0
+	for _, f := range x.Fields {
+		if f.Kind == ast.PositionalField {
+			narr++
+		}
+	}
+	t := lua.NewTable(narr, len(x.Fields)-narr)
+	var pending []lua.Value // positional values not yet stored
+	n := int64(0)           // positional values stored
+	flush := func() {
+		for _, v := range pending {
+			n++
+			t.Set(n, v)
+		}
+		pending = pending[:0]
+	}
+	for i, f := range x.Fields {
+		switch f.Kind {
+		case ast.PositionalField:
+			if i == len(x.Fields)-1 {
+				pending = append(pending, fr.evalMulti(f.Value)...)
+				break
+			}
+			pending = append(pending, fr.eval(f.Value))
+			if len(pending) == fieldsPerFlush {
+				flush()
+			}
+		case ast.NamedField:
+			t.Set(f.Key.(*ast.StringExpr).Value, fr.eval(f.Value))
+		case ast.KeyedField:
+			key := fr.eval(f.Key)
+			val := fr.eval(f.Value)
+			fr.cur = f
+			fr.L.SetIndex(t, key, val)
+		}
+	}
+	flush()
+	return t
+}
+
+// callee evaluates the function and the arguments of a call. For a
+// method call, the function is looked up in the object, which becomes
+// the first argument.
+func (fr *frame) callee(x *ast.CallExpr) (lua.Value, []lua.Value) {
+	var fn lua.Value
+	var args []lua.Value
+	if x.Method != nil {
+		obj := fr.eval(x.Func)
+		fr.cur = methodIndex{x}
+		fn = fr.L.Index(obj, x.Method.Name)
+		args = append([]lua.Value{obj}, fr.evalList(x.Args)...)
+	} else {
+		fn = fr.eval(x.Func)
+		args = fr.evalList(x.Args)
+	}
+	fr.cur = x
+	return fn, args
+}
+
+// call makes a function call and returns its results.
+func (fr *frame) call(x *ast.CallExpr) []lua.Value {
+	fn, args := fr.callee(x)
+	return fr.L.Call(fn, args)
+}
@@ -0,0 +1,354 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package interp
+
+import (
+	"fmt"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/resolve"
+)
+
+// block runs the statements of a block and then closes the
+// to-be-closed variables declared in it.
+func (fr *frame) block(b *ast.Block) flow {
+	mark := fr.L.TBCMark()
+	fl := fr.stats(b, mark)
+	fr.closeTBC(mark, 0)
+	return fl
+}
+
+// stats runs the statements of a block. A goto to a label in the block
+// continues with the statement after the label, closing the
+// to-be-closed variables declared after the label; any other transfer
+// of control leaves the block.
+func (fr *frame) stats(b *ast.Block, mark int) flow {
+	stats := b.Stats
+	for i := 0; i < len(stats); i++ {
+		switch fl := fr.stat(stats[i]); fl {
+		case normal:
+		case jumping:
+			j, ok := fr.prog.label(b, fr.label)
+			if !ok {
+				return fl
+			}
+			if fr.L.TBCMark() > mark && j < i {
+				if level := fr.firstSlot(stats[j+1 : i+1]); level >= 0 {
+					fr.L.CloseTBC(mark, level, nil)
+				}
+			}
+			i = j
+		default:
+			return fl
+		}
+	}
+	return normal
+}
+
+// closeTBC closes the to-be-closed variables declared after mark whose
+// slots are at least level.
+func (fr *frame) closeTBC(mark, level int) {
+	if fr.L.TBCMark() > mark {
+		fr.L.CloseTBC(mark, level, nil)
+	}
+}
+
+// firstSlot returns the slot of the first local declared by the
+// statements, or -1 if they declare none.
+func (fr *frame) firstSlot(stats []ast.Stat) int {
+	for _, s := range stats {
+		switch s := s.(type) {
+		case *ast.LocalStat:
+			return fr.prog.info.Defs[s.Names[0]].Slot
+		case *ast.LocalFunctionStat:
+			return fr.prog.info.Defs[s.Name].Slot
+		}
+	}
+	return -1
+}
+
+// loop runs the body of a loop once and reports whether the loop goes
+// on, and if not, how control leaves the loop statement.
+func (fr *frame) loop(body *ast.Block) (flow, bool) {
+	return loopFlow(fr.block(body))
+}
+
+// loopFlow tells how control goes on after the body of a loop left
+// with fl.
+func loopFlow(fl flow) (flow, bool) {
+	switch fl {
+	case normal:
+		return normal, true
+	case breaking:
+		return normal, false
+	default:
+		return fl, false
+	}
+}
+
+func (fr *frame) stat(s ast.Stat) flow {
+	fr.cur = s
+	switch s := s.(type) {
+	case *ast.EmptyStat, *ast.LabelStat:
+		// nothing to do
+	case *ast.AssignStat:
+		fr.assign(s)
+	case *ast.CallStat:
+		fr.call(s.Call)
+	case *ast.BreakStat:
+		return breaking
+	case *ast.GotoStat:
+		fr.label = s.Label.Name
+		return jumping
+	case *ast.DoStat:
+		return fr.block(s.Body)
+	case *ast.WhileStat:
+		for lua.Truth(fr.eval(s.Cond)) {
+			if fl, more := fr.loop(s.Body); !more {
+				return fl
+			}
+		}
+	case *ast.RepeatStat:
+		for {
+			mark := fr.L.TBCMark()
+			fl, more := loopFlow(fr.stats(s.Body, mark))
+			// the condition sees the locals of the body, which are
+			// closed after it
+			done := !more || lua.Truth(fr.eval(s.Cond))
+			fr.closeTBC(mark, 0)
+			if !more {
+				return fl
+			}
+			if done {
+				break
+			}
+		}
+	case *ast.IfStat:
+		for _, c := range s.Clauses {
+			if lua.Truth(fr.eval(c.Cond)) {
+				return fr.block(c.Body)
+			}
+		}
+		if s.Else != nil {
+			return fr.block(s.Else)
+		}
+	case *ast.NumericForStat:
+		return fr.numericFor(s)
+	case *ast.GenericForStat:
+		return fr.genericFor(s)
+	case *ast.FunctionStat:
+		fr.functionStat(s)
+	case *ast.LocalFunctionStat:
+		// the function is in the scope of its own name
+		v := fr.prog.info.Defs[s.Name]
+		fr.define(v, nil)
+		fr.setLocal(v, fr.closure(s.Func))
+	case *ast.LocalStat:
+		vals := fr.evalList(s.Values)
+		for i, name := range s.Names {
+			var val lua.Value
+			if i < len(vals) {
+				val = vals[i]
+			}
+			v := fr.prog.info.Defs[name]
+			fr.define(v, val)
+			if v.Attrib == "close" {
+				fr.L.NewTBC(val, v.Name, v.Slot)
+			}
+		}
+	case *ast.ReturnStat:
+		fr.ret(s)
+		return returning
+	default:
+		panic(fmt.Sprintf("interp: unexpected statement type %T", s))
+	}
+	return normal
+}
+
+// assign runs a multiple assignment the way the reference
+// implementation does: the tables and keys of the targets are
+// evaluated from left to right, then the values, and the assignments
+// are made from right to left.
+func (fr *frame) assign(s *ast.AssignStat) {
+	if len(s.Targets) == 1 && len(s.Values) == 1 {
+		switch t := s.Targets[0].(type) {
+		case *ast.Ident:
+			fr.setVar(t, fr.eval(s.Values[0]))
+		case *ast.IndexExpr:
+			obj, key := fr.eval(t.Object), fr.eval(t.Key)
+			val := fr.eval(s.Values[0])
+			fr.cur = t
+			fr.L.SetIndex(obj, key, val)
+		}
+		return
+	}
+	type place struct {
+		obj, key lua.Value
+	}
+	places := make([]place, len(s.Targets))
+	for i, t := range s.Targets {
+		if t, ok := t.(*ast.IndexExpr); ok {
+			places[i] = place{fr.eval(t.Object), fr.eval(t.Key)}
+		}
+	}
+	vals := fr.evalList(s.Values)
+	for i := len(s.Targets) - 1; i >= 0; i-- {
+		var val lua.Value
+		if i < len(vals) {
+			val = vals[i]
+		}
+		switch t := s.Targets[i].(type) {
+		case *ast.Ident:
+			fr.setVar(t, val)
+		case *ast.IndexExpr:
+			fr.cur = t
+			fr.L.SetIndex(places[i].obj, places[i].key, val)
+		}
+	}
+}
+
+// setVar assigns to the variable a name refers to.
+func (fr *frame) setVar(id *ast.Ident, val lua.Value) {
+	b := fr.prog.info.Uses[id]
+	switch b.Kind {
+	case resolve.Local:
+		fr.setLocal(b.Var, val)
+	case resolve.Upvalue:
+		fr.cl.upvals[b.Index].v = val
+	case resolve.Global:
+		env := fr.binding(b.Env)
+		fr.cur = globalIndex{id}
+		fr.L.SetIndex(env, id.Name, val)
+	}
+}
+
+// setLocal assigns to a local variable of the frame.
+func (fr *frame) setLocal(v *resolve.Var, val lua.Value) {
+	if v.Captured {
+		fr.slots[v.Slot].(*cell).v = val
+		return
+	}
+	fr.slots[v.Slot] = val
+}
+
+// functionStat assigns a function to its name, which may be a field
+// of a table: function a.b.c:m() ... end.
+func (fr *frame) functionStat(s *ast.FunctionStat) {
+	cl := fr.closure(s.Func)
+	path := s.Name.Path
+	if len(path) == 1 && s.Name.Method == nil {
+		fr.setVar(path[0], cl)
+		return
+	}
+	obj := fr.eval(path[0])
+	last := s.Name.Method
+	if last == nil {
+		last, path = path[len(path)-1], path[:len(path)-1]
+	}
+	for _, id := range path[1:] {
+		fr.cur = id
+		obj = fr.L.Index(obj, id.Name)
+	}
+	fr.cur = path[len(path)-1]
+	fr.L.SetIndex(obj, last.Name, cl)
+}
+
+// numericFor runs a numeric for loop. Each iteration has a fresh copy
+// of the control variable, so closures capture the value of their
+// iteration.
+func (fr *frame) numericFor(s *ast.NumericForStat) flow {
+	start, limit := fr.eval(s.Start), fr.eval(s.Limit)
+	var step lua.Value = int64(1)
+	if s.Step != nil {
+		step = fr.eval(s.Step)
+	}
+	fr.cur = s
+	l, more := fr.L.ForPrep(start, limit, step)
+	v := fr.prog.info.Defs[s.Var]
+	for more {
+		fr.define(v, l.Value())
+		var fl flow
+		if fl, more = fr.loop(s.Body); !more {
+			return fl
+		}
+		more = l.Next()
+	}
+	return normal
+}
+
+// genericFor runs a generic for loop, calling the iterator function
+// with the state and the control value until it returns nil. The
+// closing value is closed when the loop ends.
+func (fr *frame) genericFor(s *ast.GenericForStat) flow {
+	vals := fr.evalList(s.Exprs)
+	for len(vals) < 4 {
+		vals = append(vals, nil)
+	}
+	mark := fr.L.TBCMark()
+	fr.cur = s
+	// the closing value is in the slot before the loop variables
+	fr.L.NewTBC(vals[3], "(for state)", fr.prog.info.Defs[s.Names[0]].Slot-1)
+	fl := fr.forIn(s, vals[0], vals[1], vals[2])
+	fr.closeTBC(mark, 0)
+	return fl
+}
+
+// forIn runs the iterations of a generic for loop.
+func (fr *frame) forIn(s *ast.GenericForStat, f, state, control lua.Value) flow {
+	for {
+		fr.cur = s
+		rets := fr.L.Call(f, []lua.Value{state, control})
+		if len(rets) == 0 || rets[0] == nil {
+			return normal
+		}
+		control = rets[0]
+		for i, name := range s.Names {
+			var v lua.Value
+			if i < len(rets) {
+				v = rets[i]
+			}
+			fr.define(fr.prog.info.Defs[name], v)
+		}
+		if fl, more := fr.loop(s.Body); !more {
+			return fl
+		}
+	}
+}
+
+// ret evaluates the values of a return statement. A single call that
+// is not in parentheses is a tail call; it is made by Closure.Call
+// after the frame is gone, unless a to-be-closed variable must be
+// closed after it.
+func (fr *frame) ret(s *ast.ReturnStat) {
+	if len(s.Values) == 1 && fr.L.TBCMark() == fr.tbc {
+		if call, ok := s.Values[0].(*ast.CallExpr); ok {
+			fr.tail = true
+			fr.tailFunc, fr.tailArgs = fr.callee(call)
+			return
+		}
+	}
+	fr.rets = fr.evalList(s.Values)
+}
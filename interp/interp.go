@@ -0,0 +1,279 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package interp runs Lua chunks by walking their syntax trees.
+//
+// The names in a chunk are first bound by package resolve, which lays
+// out the locals of each function in the slots of its frame. A local
+// captured by a closure lives in a cell shared by the frame and the
+// closures, so that they all see its updates.
+package interp
+
+import (
+	"fmt"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/resolve"
+)
+
+// program is a chunk loaded for evaluation.
+type program struct {
+	name   string
+	info   *resolve.Info
+	labels map[*ast.Block]map[string]int // index of each label in a block, built as needed
+}
+
+// cell holds a local variable captured by a closure.
+type cell struct {
+	v lua.Value
+}
+
+// Closure is a function written in Lua together with the cells of
+// the variables it captures.
+type Closure struct {
+	prog   *program
+	fn     *resolve.Function
+	body   *ast.Block
+	vararg bool
+	upvals []*cell
+}
+
+// Load prepares the chunk to run in L and returns its main function.
+// The chunk must have been checked by package check. Its _ENV is the
+// global environment of L.
+func Load(L *lua.State, chunk *ast.Chunk) lua.Function {
+	return LoadEnv(chunk, L.Globals)
+}
+
+// LoadEnv is like Load, but the chunk's _ENV is env.
+func LoadEnv(chunk *ast.Chunk, env lua.Value) lua.Function {
+	prog := &program{
+		name:   chunk.Name,
+		info:   resolve.Resolve(chunk),
+		labels: map[*ast.Block]map[string]int{},
+	}
+	return &Closure{
+		prog:   prog,
+		fn:     prog.info.Funcs[chunk],
+		body:   chunk.Block,
+		vararg: true,
+		upvals: []*cell{{v: env}},
+	}
+}
+
+// label returns the index of the label with the given name among the
+// statements of b.
+func (p *program) label(b *ast.Block, name string) (int, bool) {
+	m, ok := p.labels[b]
+	if !ok {
+		for i, s := range b.Stats {
+			if l, ok := s.(*ast.LabelStat); ok {
+				if m == nil {
+					m = map[string]int{}
+				}
+				m[l.Name.Name] = i
+			}
+		}
+		p.labels[b] = m
+	}
+	i, ok := m[name]
+	return i, ok
+}
+
+// Weak returns a weak reference to the closure, by which tables with
+// weak keys or values hold it.
+func (cl *Closure) Weak() lua.WeakRef {
+	return lua.NewWeakRef(cl)
+}
+
+// Call runs the function. A call in tail position replaces the
+// running function instead of nesting in it, so tail calls between
+// Lua functions use no stack.
+func (cl *Closure) Call(L *lua.State, args []lua.Value) []lua.Value {
+	ci := L.CurrentCall()
+	for {
+		fr := cl.newFrame(L, args)
+		ci.Frame = fr
+		if fr.block(cl.body) != returning || !fr.tail {
+			return fr.rets
+		}
+		next, ok := fr.tailFunc.(*Closure)
+		if !ok {
+			return L.Call(fr.tailFunc, fr.tailArgs)
+		}
+		ci.Func = next
+		cl, args = next, fr.tailArgs
+	}
+}
+
+// newFrame returns the frame for a call with the given arguments.
+func (cl *Closure) newFrame(L *lua.State, args []lua.Value) *frame {
+	fr := &frame{
+		L:     L,
+		prog:  cl.prog,
+		cl:    cl,
+		slots: make([]lua.Value, cl.fn.MaxSlots),
+		cur:   cl.body,
+		tbc:   L.TBCMark(),
+	}
+	for i, p := range cl.fn.Params {
+		var v lua.Value
+		if i < len(args) {
+			v = args[i]
+		}
+		fr.define(p, v)
+	}
+	if cl.vararg && len(args) > len(cl.fn.Params) {
+		fr.varargs = args[len(cl.fn.Params):]
+	}
+	return fr
+}
+
+// frame is the activation of a closure.
+type frame struct {
+	L       *lua.State
+	prog    *program
+	cl      *Closure
+	slots   []lua.Value // locals; a captured local's slot holds its *cell
+	varargs []lua.Value
+	tbc     int // the mark of the to-be-closed variables of the call
+
+	// cur is the node being evaluated, for error messages
+	cur ast.Node
+
+	label    string      // the target of a goto being taken
+	rets     []lua.Value // the values returned
+	tail     bool        // set if the function returns with a tail call
+	tailFunc lua.Value   // the function called in a tail call
+	tailArgs []lua.Value
+}
+
+// flow tells how control leaves a statement.
+type flow int
+
+const (
+	normal    flow = iota // on to the next statement
+	breaking              // out of the innermost loop
+	jumping               // to the label in fr.label
+	returning             // out of the function
+)
+
+// Where returns the chunk name and the line being run.
+func (fr *frame) Where() (string, int) {
+	return fr.prog.name, fr.cur.Pos().Line
+}
+
+// methodIndex is the lookup of the method in a method call, as the
+// current node of a frame.
+type methodIndex struct {
+	*ast.CallExpr
+}
+
+// globalIndex is the lookup of a global name in _ENV, as the current
+// node of a frame.
+type globalIndex struct {
+	*ast.Ident
+}
+
+// VarInfo describes operand i of the node being evaluated.
+func (fr *frame) VarInfo(i int) string {
+	switch x := fr.cur.(type) {
+	case globalIndex:
+		b := fr.prog.info.Uses[x.Ident]
+		return fmt.Sprintf(" (%s '_ENV')", b.Env.Kind)
+	case *ast.IndexExpr:
+		return fr.describe(x.Object)
+	case methodIndex:
+		return fr.describe(x.Func)
+	case *ast.CallExpr:
+		if x.Method != nil {
+			return fmt.Sprintf(" (method '%s')", x.Method.Name)
+		}
+		return fr.describe(x.Func)
+	case *ast.BinaryExpr:
+		if i == 0 {
+			return fr.describe(x.Left)
+		}
+		return fr.describe(x.Right)
+	case *ast.UnaryExpr:
+		return fr.describe(x.Operand)
+	case *ast.Ident:
+		// a name in the path of a function statement
+		return fr.describe(x)
+	case *ast.GenericForStat:
+		return " (for iterator 'for iterator')"
+	}
+	return ""
+}
+
+// describe names the variable an expression reads, as in " (local 'x')",
+// or returns "" if it does not read a named variable.
+func (fr *frame) describe(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		b, ok := fr.prog.info.Uses[x]
+		if !ok {
+			return fmt.Sprintf(" (field '%s')", x.Name)
+		}
+		return fmt.Sprintf(" (%s '%s')", b.Kind, x.Name)
+	case *ast.IndexExpr:
+		if k, ok := x.Key.(*ast.StringExpr); ok {
+			return fmt.Sprintf(" (field '%s')", k.Value)
+		}
+	case *ast.StringExpr:
+		return fmt.Sprintf(" (constant '%s')", x.Value)
+	}
+	return ""
+}
+
+// define sets a newly declared local variable.
+func (fr *frame) define(v *resolve.Var, val lua.Value) {
+	if v.Captured {
+		fr.slots[v.Slot] = &cell{v: val}
+		return
+	}
+	fr.slots[v.Slot] = val
+}
+
+// closure creates a closure of a function, capturing its upvalues
+// from the frame and the running closure.
+func (fr *frame) closure(x *ast.FunctionExpr) *Closure {
+	f := fr.prog.info.Funcs[x]
+	cl := &Closure{
+		prog:   fr.prog,
+		fn:     f,
+		body:   x.Body,
+		vararg: x.IsVararg,
+		upvals: make([]*cell, len(f.Upvalues)),
+	}
+	for i, u := range f.Upvalues {
+		if u.InStack {
+			cl.upvals[i] = fr.slots[u.Index].(*cell)
+		} else {
+			cl.upvals[i] = fr.cl.upvals[u.Index]
+		}
+	}
+	return cl
+}
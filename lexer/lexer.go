@@ -0,0 +1,509 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package lexer splits Lua 5.4 source into tokens.
+//
+// Whitespace and comments are discarded; everything else is returned
+// as a Token whose Raw field holds the source text of the token.
+// Comments and blank lines may be kept for tools that need them; see
+// KeepTrivia.
+package lexer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Lexer holds the state of the scanner.
+type Lexer struct {
+	src    []byte
+	off    int   // offset of the next unread byte
+	lines  []int // offset of the first byte of each line
+	trivia bool  // true if trivia is being attached to tokens
+}
+
+// New returns a lexer that reads from src.
+func New(src []byte) *Lexer {
+	l := &Lexer{src: src, lines: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if ch := src[i]; isNewline(ch) {
+			if i+1 < len(src) && isNewline(src[i+1]) && src[i+1] != ch {
+				i++
+			}
+			l.lines = append(l.lines, i+1)
+		}
+	}
+	return l
+}
+
+// Pos returns the position of the byte at offset off.
+func (l *Lexer) Pos(off int) Pos {
+	line := sort.Search(len(l.lines), func(i int) bool { return l.lines[i] > off })
+	return Pos{Offset: off, Line: line, Column: off - l.lines[line-1] + 1}
+}
+
+// Scan returns all of the tokens in src.
+// The last token returned is always EOF.
+func Scan(src []byte) ([]Token, error) {
+	return New(src).Tokens()
+}
+
+// SkipShebang skips the first line of the input if it starts with '#',
+// as the first line of an executable script ("#!/usr/bin/env glua") does.
+// It must be called before the first call to Next.
+func (l *Lexer) SkipShebang() {
+	if l.off != 0 || len(l.src) == 0 || l.src[0] != '#' {
+		return
+	}
+	for l.off < len(l.src) && !isNewline(l.src[l.off]) {
+		l.off++
+	}
+}
+
+// SkipLine skips the rest of the current line.
+// After an error, it lets the caller carry on with the next line.
+func (l *Lexer) SkipLine() {
+	for l.off < len(l.src) && !isNewline(l.src[l.off]) {
+		l.off++
+	}
+}
+
+// Tokens returns the remaining tokens in the input.
+// The last token returned is always EOF.
+func (l *Lexer) Tokens() ([]Token, error) {
+	var toks []Token
+	for {
+		tok, err := l.Next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.Kind == EOF {
+			return toks, nil
+		}
+	}
+}
+
+// Next returns the next token from the input.
+// Once the input is exhausted, it returns EOF on every call.
+func (l *Lexer) Next() (Token, error) {
+	if l.trivia {
+		return l.nextWithTrivia()
+	}
+	if err := l.skipSpace(); err != nil {
+		return Token{}, err
+	}
+	return l.next()
+}
+
+// next returns the token at the current offset,
+// which must not be whitespace or a comment.
+func (l *Lexer) next() (Token, error) {
+	if l.off >= len(l.src) {
+		return l.token(EOF, l.off), nil
+	}
+	start, ch := l.off, l.src[l.off]
+	switch {
+	case isAlpha(ch):
+		for l.off < len(l.src) && isAlnum(l.src[l.off]) {
+			l.off++
+		}
+		if kind, ok := keywords[string(l.src[start:l.off])]; ok {
+			return l.token(kind, start), nil
+		}
+		return l.token(NAME, start), nil
+	case isDigit(ch), ch == '.' && isDigit(l.peek(1)):
+		return l.numeral(start)
+	case ch == '"', ch == '\'':
+		return l.shortString(start)
+	case ch == '[':
+		if level := l.openLevel(l.off); level >= 0 {
+			tok, err := l.longString(start, level)
+			if err != nil {
+				return Token{}, err
+			}
+			tok.Val = longValue(tok.Raw[level+2 : len(tok.Raw)-level-2])
+			return tok, nil
+		} else if l.peek(1) == '=' {
+			return Token{}, l.errorf(start, string(l.src[start:l.off+2]), "invalid long string delimiter")
+		}
+	}
+
+	// operators and punctuation, longest match first
+	for _, op := range operators {
+		if l.hasPrefix(op.text) {
+			l.off += len(op.text)
+			return l.token(op.kind, start), nil
+		}
+	}
+	return Token{}, l.errorf(start, string(ch), "unexpected symbol")
+}
+
+var operators = []struct {
+	text string
+	kind Kind
+}{
+	{"...", DOTDOTDOT},
+	{"..", DOTDOT}, {"//", SLASHSLASH}, {">>", SHR}, {"<<", SHL},
+	{"<=", LE}, {">=", GE}, {"==", EQ}, {"~=", NE}, {"::", COLONCOLON},
+	{"+", PLUS}, {"-", MINUS}, {"*", STAR}, {"/", SLASH}, {"^", CARET},
+	{"%", PERCENT}, {"&", AMPERSAND}, {"~", TILDE}, {"|", PIPE},
+	{"<", LT}, {">", GT}, {"#", HASH}, {"=", ASSIGN},
+	{"(", LPAREN}, {")", RPAREN}, {"{", LBRACE}, {"}", RBRACE},
+	{"[", LBRACKET}, {"]", RBRACKET}, {";", SEMICOLON}, {":", COLON},
+	{",", COMMA}, {".", DOT},
+}
+
+func (l *Lexer) token(kind Kind, start int) Token {
+	return Token{Kind: kind, Raw: l.src[start:l.off], Pos: l.Pos(start), End: l.Pos(l.off)}
+}
+
+func (l *Lexer) errorf(start int, near string, format string, args ...interface{}) error {
+	return &Error{Pos: l.Pos(start), Msg: fmt.Sprintf(format, args...), Near: near}
+}
+
+func (l *Lexer) peek(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *Lexer) hasPrefix(s string) bool {
+	if len(l.src)-l.off < len(s) {
+		return false
+	}
+	return string(l.src[l.off:l.off+len(s)]) == s
+}
+
+// skipSpace skips whitespace and comments.
+func (l *Lexer) skipSpace() error {
+	for l.off < len(l.src) {
+		switch ch := l.src[l.off]; {
+		case isSpace(ch):
+			l.off++
+		case ch == '-' && l.peek(1) == '-':
+			if _, err := l.comment(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// comment scans the comment at the current offset. It reports whether
+// the comment is a long comment.
+func (l *Lexer) comment() (long bool, err error) {
+	start := l.off
+	l.off += 2
+	if level := l.openLevel(l.off); level >= 0 {
+		if _, err := l.longString(l.off, level); err != nil {
+			return false, l.errorf(start, "<eof>", "unfinished long comment (starting at line %d)", l.Pos(start).Line)
+		}
+		long = true
+	} else {
+		for l.off < len(l.src) && !isNewline(l.src[l.off]) {
+			l.off++
+		}
+	}
+	return long, nil
+}
+
+// numeral scans a numeric constant.
+// Like the reference lexer, it is greedy: it consumes every character
+// that could belong to a numeral and then checks that the result converts.
+func (l *Lexer) numeral(start int) (Token, error) {
+	expo := "Ee"
+	if l.src[l.off] == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+		expo = "Pp"
+		l.off += 2
+	}
+	for l.off < len(l.src) {
+		ch := l.src[l.off]
+		if ch == expo[0] || ch == expo[1] {
+			l.off++
+			if l.off < len(l.src) && (l.src[l.off] == '+' || l.src[l.off] == '-') {
+				l.off++
+			}
+		} else if isHexDigit(ch) || ch == '.' {
+			l.off++
+		} else {
+			break
+		}
+	}
+	if l.off < len(l.src) && isAlpha(l.src[l.off]) {
+		// a numeral touching a letter is malformed
+		l.off++
+		return Token{}, l.errorf(start, string(l.src[start:l.off]), "malformed number")
+	}
+	if _, ok := ParseNumber(l.src[start:l.off]); !ok {
+		return Token{}, l.errorf(start, string(l.src[start:l.off]), "malformed number")
+	}
+	return l.token(NUMERAL, start), nil
+}
+
+// shortString scans a single- or double-quoted string,
+// decoding escape sequences into the token's value.
+func (l *Lexer) shortString(start int) (Token, error) {
+	delim := l.src[l.off]
+	l.off++
+	var val []byte
+	for {
+		if l.off >= len(l.src) || isNewline(l.src[l.off]) {
+			return Token{}, l.errorf(start, string(l.src[start:l.off]), "unfinished string")
+		}
+		ch := l.src[l.off]
+		if ch == delim {
+			l.off++
+			tok := l.token(STRING, start)
+			tok.Val = val
+			return tok, nil
+		} else if ch != '\\' {
+			val = append(val, ch)
+			l.off++
+			continue
+		}
+
+		// escape sequence
+		esc := l.off
+		l.off++
+		if l.off >= len(l.src) {
+			continue // reported as an unfinished string
+		}
+		switch ch = l.src[l.off]; ch {
+		case 'a':
+			val, l.off = append(val, '\a'), l.off+1
+		case 'b':
+			val, l.off = append(val, '\b'), l.off+1
+		case 'f':
+			val, l.off = append(val, '\f'), l.off+1
+		case 'n':
+			val, l.off = append(val, '\n'), l.off+1
+		case 'r':
+			val, l.off = append(val, '\r'), l.off+1
+		case 't':
+			val, l.off = append(val, '\t'), l.off+1
+		case 'v':
+			val, l.off = append(val, '\v'), l.off+1
+		case '\\', '"', '\'':
+			val, l.off = append(val, ch), l.off+1
+		case '\n', '\r':
+			l.skipNewline()
+			val = append(val, '\n')
+		case 'x':
+			l.off++
+			var r byte
+			for i := 0; i < 2; i++ {
+				if l.off >= len(l.src) || !isHexDigit(l.src[l.off]) {
+					return Token{}, l.escapeError(start, esc, "hexadecimal digit expected")
+				}
+				r, l.off = r<<4+hexValue(l.src[l.off]), l.off+1
+			}
+			val = append(val, r)
+		case 'z':
+			l.off++
+			for l.off < len(l.src) && isSpace(l.src[l.off]) {
+				if isNewline(l.src[l.off]) {
+					l.skipNewline()
+				} else {
+					l.off++
+				}
+			}
+		case 'u':
+			l.off++
+			if l.off >= len(l.src) || l.src[l.off] != '{' {
+				return Token{}, l.escapeError(start, esc, "missing '{' in \\u{xxxx}")
+			}
+			l.off++
+			var r uint64
+			for n := 0; ; n++ {
+				if l.off < len(l.src) && isHexDigit(l.src[l.off]) {
+					if r = r<<4 + uint64(hexValue(l.src[l.off])); r > 0x7FFFFFFF {
+						return Token{}, l.escapeError(start, esc, "UTF-8 value too large")
+					}
+					l.off++
+				} else if n == 0 {
+					return Token{}, l.escapeError(start, esc, "hexadecimal digit expected")
+				} else {
+					break
+				}
+			}
+			if l.off >= len(l.src) || l.src[l.off] != '}' {
+				return Token{}, l.escapeError(start, esc, "missing '}' in \\u{xxxx}")
+			}
+			l.off++
+			val = append(val, utf8esc(uint32(r))...)
+		default:
+			if !isDigit(ch) {
+				return Token{}, l.escapeError(start, esc, "invalid escape sequence")
+			}
+			r := 0
+			for i := 0; i < 3 && l.off < len(l.src) && isDigit(l.src[l.off]); i++ {
+				r, l.off = r*10+int(l.src[l.off]-'0'), l.off+1
+			}
+			if r > 255 {
+				return Token{}, l.escapeError(start, esc, "decimal escape too large")
+			}
+			val = append(val, byte(r))
+		}
+	}
+}
+
+// escapeError reports a malformed escape sequence.
+// The offending text runs from the start of the string through the
+// character that exposed the problem.
+func (l *Lexer) escapeError(start, esc int, msg string) error {
+	end := l.off + 1
+	if end > len(l.src) {
+		end = len(l.src)
+	}
+	return &Error{Pos: l.Pos(esc), Msg: msg, Near: string(l.src[start:end])}
+}
+
+// utf8esc encodes r as UTF-8, allowing the extended sequences of
+// up to six bytes that cover values below 2^31.
+func utf8esc(r uint32) []byte {
+	if r < 0x80 {
+		return []byte{byte(r)}
+	}
+	var buf [6]byte
+	n := len(buf)
+	mfb := uint32(0x3f) // maximum that fits in the first byte
+	for {
+		n--
+		buf[n] = byte(0x80 | r&0x3f)
+		r >>= 6
+		mfb >>= 1
+		if r <= mfb {
+			break
+		}
+	}
+	n--
+	buf[n] = byte(^mfb<<1 | r)
+	return buf[n:]
+}
+
+// openLevel returns the level of the long bracket starting at offset at,
+// or -1 if there is not an opening long bracket there.
+func (l *Lexer) openLevel(at int) int {
+	if at >= len(l.src) || l.src[at] != '[' {
+		return -1
+	}
+	level := 0
+	for at++; at < len(l.src) && l.src[at] == '='; at++ {
+		level++
+	}
+	if at < len(l.src) && l.src[at] == '[' {
+		return level
+	}
+	return -1
+}
+
+// longString scans a long bracket of the given level starting at start.
+func (l *Lexer) longString(start, level int) (Token, error) {
+	l.off = start + level + 2
+	for l.off < len(l.src) {
+		if l.src[l.off] == ']' {
+			end := l.off + 1
+			for end < len(l.src) && l.src[end] == '=' {
+				end++
+			}
+			if end < len(l.src) && l.src[end] == ']' && end-l.off-1 == level {
+				l.off = end + 1
+				return l.token(STRING, start), nil
+			}
+			l.off = end
+			continue
+		}
+		l.off++
+	}
+	return Token{}, l.errorf(start, "<eof>", "unfinished long string (starting at line %d)", l.Pos(start).Line)
+}
+
+// longValue returns the value of the body of a long string.
+// A newline immediately following the opening bracket is dropped, and
+// every newline sequence in the body is converted to a plain '\n'.
+func longValue(body []byte) []byte {
+	val := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if !isNewline(ch) {
+			val = append(val, ch)
+			continue
+		}
+		first := i == 0
+		if i+1 < len(body) && isNewline(body[i+1]) && body[i+1] != ch {
+			i++
+		}
+		if !first {
+			val = append(val, '\n')
+		}
+	}
+	return val
+}
+
+// skipNewline skips a newline sequence; "\n\r" and "\r\n" count as one.
+func (l *Lexer) skipNewline() {
+	ch := l.src[l.off]
+	l.off++
+	if l.off < len(l.src) && isNewline(l.src[l.off]) && l.src[l.off] != ch {
+		l.off++
+	}
+}
+
+func isAlpha(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+}
+
+func isAlnum(ch byte) bool {
+	return isAlpha(ch) || isDigit(ch)
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func hexValue(ch byte) byte {
+	switch {
+	case isDigit(ch):
+		return ch - '0'
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10
+	}
+	return ch - 'A' + 10
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isNewline(ch byte) bool {
+	return ch == '\n' || ch == '\r'
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\v' || ch == '\f'
+}
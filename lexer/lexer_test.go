@@ -0,0 +1,84 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lexer
+
+import "testing"
+
+func TestStringValue(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{`"plain"`, "plain"},
+		{`'single "quoted"'`, `single "quoted"`},
+		{`"\a\b\f\n\r\t\v\\\"\'"`, "\a\b\f\n\r\t\v\\\"'"},
+		{"\"line\\\nnext\"", "line\nnext"},
+		{"\"crlf\\\r\nnext\"", "crlf\nnext"},
+		{`"\x41\x7a\xFF"`, "Az\xff"},
+		{`"\65\066\0"`, "AB\x00"},
+		{`"\0651"`, "A1"},
+		{`"\255"`, "\xff"},
+		{"\"a\\z  \n\t  b\"", "ab"},
+		{`"\u{48}\u{20AC}"`, "H€"},
+		{`"\u{7FFFFFFF}"`, "\xfd\xbf\xbf\xbf\xbf\xbf"},
+		{"[[long]]", "long"},
+		{"[[\nfirst newline skipped]]", "first newline skipped"},
+		{"[==[with ]] and ]=] inside]==]", "with ]] and ]=] inside"},
+		{"[[\r\nline\n\rend]]", "line\nend"},
+		{`[[no \n escapes]]`, `no \n escapes`},
+	} {
+		toks, err := Scan([]byte(tc.src))
+		if err != nil {
+			t.Errorf("Scan(%q): %v", tc.src, err)
+			continue
+		}
+		if toks[0].Kind != STRING || string(toks[0].Val) != tc.want {
+			t.Errorf("Scan(%q) = %v %q, want string %q", tc.src, toks[0].Kind, toks[0].Val, tc.want)
+		}
+	}
+}
+
+func TestStringError(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{`"unfinished`, `1:1: unfinished string near '"unfinished'`},
+		{"'broken\nline'", `1:1: unfinished string near ''broken'`},
+		{`"\q"`, `1:2: invalid escape sequence near '"\q'`},
+		{`"\x4"`, `1:2: hexadecimal digit expected near '"\x4"'`},
+		{`"\256"`, `1:2: decimal escape too large near '"\256"'`},
+		{`"\u48"`, `1:2: missing '{' in \u{xxxx} near '"\u4'`},
+		{`"\u{}"`, `1:2: hexadecimal digit expected near '"\u{}'`},
+		{`"\u{48"`, `1:2: missing '}' in \u{xxxx} near '"\u{48"'`},
+		{`"\u{80000000}"`, `1:2: UTF-8 value too large near '"\u{80000000'`},
+		{"[[long", `1:1: unfinished long string (starting at line 1) near '<eof>'`},
+		{"[=[mismatched]]", `1:1: unfinished long string (starting at line 1) near '<eof>'`},
+		{"[=x", `1:1: invalid long string delimiter near '[='`},
+	} {
+		_, err := Scan([]byte(tc.src))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("Scan(%q) error = %v, want %s", tc.src, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lexer
+
+import (
+	"math"
+	"strconv"
+)
+
+// Number is the value of a numeral, which is either an integer or a float.
+type Number struct {
+	IsFloat bool
+	Int     int64   // the value, if the numeral is an integer
+	Float   float64 // the value, if the numeral is a float
+}
+
+// ParseNumber converts the text of a numeral to its value, following the
+// rules of the reference implementation. Surrounding whitespace and a
+// leading sign are accepted so that the same rules can be used when
+// strings are converted to numbers at run time.
+//
+// Decimal integers that do not fit in an int64 become floats, while
+// hexadecimal integers wrap around modulo 2^64.
+func ParseNumber(s []byte) (Number, bool) {
+	if i, ok := parseInteger(s); ok {
+		return Number{Int: i}, true
+	}
+	if f, ok := parseFloat(s); ok {
+		return Number{IsFloat: true, Float: f}, true
+	}
+	return Number{}, false
+}
+
+// trimSpace removes leading and trailing whitespace.
+func trimSpace(s []byte) []byte {
+	for len(s) > 0 && isSpace(s[0]) {
+		s = s[1:]
+	}
+	for len(s) > 0 && isSpace(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// trimSign removes a leading sign and reports whether it was negative.
+func trimSign(s []byte) ([]byte, bool) {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		return s[1:], s[0] == '-'
+	}
+	return s, false
+}
+
+func isHexPrefix(s []byte) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+func parseInteger(s []byte) (int64, bool) {
+	s, neg := trimSign(trimSpace(s))
+	var a uint64
+	if isHexPrefix(s) {
+		s = s[2:]
+		if len(s) == 0 {
+			return 0, false
+		}
+		for _, ch := range s {
+			if !isHexDigit(ch) {
+				return 0, false
+			}
+			a = a<<4 + uint64(hexValue(ch))
+		}
+	} else {
+		if len(s) == 0 {
+			return 0, false
+		}
+		limit := uint64(math.MaxInt64)
+		if neg {
+			limit++
+		}
+		for _, ch := range s {
+			if !isDigit(ch) {
+				return 0, false
+			}
+			d := uint64(ch - '0')
+			if a > (limit-d)/10 {
+				return 0, false // overflow, so read it as a float
+			}
+			a = a*10 + d
+		}
+	}
+	if neg {
+		a = -a
+	}
+	return int64(a), true
+}
+
+func parseFloat(s []byte) (float64, bool) {
+	s = trimSpace(s)
+	for _, ch := range s {
+		if ch == 'n' || ch == 'N' {
+			return 0, false // reject 'inf' and 'nan'
+		}
+	}
+	body, neg := trimSign(s)
+	var f float64
+	var ok bool
+	if isHexPrefix(body) {
+		f, ok = parseHexFloat(body[2:])
+	} else {
+		f, ok = parseDecimalFloat(body)
+	}
+	if !ok {
+		return 0, false
+	}
+	if neg {
+		f = -f
+	}
+	return f, true
+}
+
+// parseDecimalFloat checks that s has the form
+// digits ['.' digits] [('e'|'E') ['+'|'-'] digits],
+// with at least one digit in the mantissa, and converts it.
+func parseDecimalFloat(s []byte) (float64, bool) {
+	i, digits := 0, 0
+	for ; i < len(s) && isDigit(s[i]); i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		for i++; i < len(s) && isDigit(s[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i == len(s) || !isDigit(s[i]) {
+			return 0, false
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	if i != len(s) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(s), 64)
+	if err != nil && err.(*strconv.NumError).Err != strconv.ErrRange {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseHexFloat converts the part of a hexadecimal numeral following
+// the "0x" prefix. The mantissa may have a fraction and the binary
+// exponent is optional, so "A", "A.8" and "1p-2" are all accepted.
+func parseHexFloat(s []byte) (float64, bool) {
+	const maxSigDigits = 30
+	var r float64
+	sigdig, nosigdig, e, hasdot := 0, 0, 0, false
+	i := 0
+	for ; i < len(s); i++ {
+		ch := s[i]
+		if ch == '.' {
+			if hasdot {
+				return 0, false
+			}
+			hasdot = true
+		} else if isHexDigit(ch) {
+			if sigdig == 0 && ch == '0' {
+				nosigdig++
+			} else if sigdig++; sigdig <= maxSigDigits {
+				r = r*16 + float64(hexValue(ch))
+			} else {
+				e++ // too many digits; ignore, but still count for exponent
+			}
+			if hasdot {
+				e--
+			}
+		} else {
+			break
+		}
+	}
+	if sigdig+nosigdig == 0 {
+		return 0, false
+	}
+	e *= 4 // each digit multiplies or divides the value by 2^4
+	if i < len(s) && (s[i] == 'p' || s[i] == 'P') {
+		i++
+		exp, neg := s[i:], false
+		if exp, neg = trimSign(exp); len(exp) == 0 {
+			return 0, false
+		}
+		i = len(s) - len(exp)
+		n := 0
+		for ; i < len(s) && isDigit(s[i]); i++ {
+			if n < 1<<20 {
+				n = n*10 + int(s[i]-'0')
+			}
+		}
+		if i < len(s) || !isDigit(exp[0]) {
+			return 0, false
+		}
+		if neg {
+			n = -n
+		}
+		e += n
+	}
+	if i != len(s) {
+		return 0, false
+	}
+	return math.Ldexp(r, e), true
+}
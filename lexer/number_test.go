@@ -0,0 +1,87 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lexer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Number
+		ok   bool
+	}{
+		{"0", Number{Int: 0}, true},
+		{"42", Number{Int: 42}, true},
+		{"  42\t\n", Number{Int: 42}, true},
+		{"-42", Number{Int: -42}, true},
+		{"+42", Number{Int: 42}, true},
+		{"9223372036854775807", Number{Int: math.MaxInt64}, true},
+		{"-9223372036854775808", Number{Int: math.MinInt64}, true},
+		{"9223372036854775808", Number{IsFloat: true, Float: 9223372036854775808}, true},
+		{"0x10", Number{Int: 16}, true},
+		{"0XfF", Number{Int: 255}, true},
+		{"0x7fffffffffffffff", Number{Int: math.MaxInt64}, true},
+		{"0xffffffffffffffff", Number{Int: -1}, true},
+		{"0x10000000000000000", Number{Int: 0}, true},
+		{"-0x10", Number{Int: -16}, true},
+		{"1.5", Number{IsFloat: true, Float: 1.5}, true},
+		{"1.", Number{IsFloat: true, Float: 1}, true},
+		{".5", Number{IsFloat: true, Float: 0.5}, true},
+		{"1e2", Number{IsFloat: true, Float: 100}, true},
+		{"1E+2", Number{IsFloat: true, Float: 100}, true},
+		{"25e-1", Number{IsFloat: true, Float: 2.5}, true},
+		{"1e400", Number{IsFloat: true, Float: math.Inf(1)}, true},
+		{"0x.8", Number{IsFloat: true, Float: 0.5}, true},
+		{"0xA.8p1", Number{IsFloat: true, Float: 21}, true},
+		{"0x1p-2", Number{IsFloat: true, Float: 0.25}, true},
+		{"0x1P4", Number{IsFloat: true, Float: 16}, true},
+		{"", Number{}, false},
+		{"   ", Number{}, false},
+		{"-", Number{}, false},
+		{".", Number{}, false},
+		{"e1", Number{}, false},
+		{"1e", Number{}, false},
+		{"1e+", Number{}, false},
+		{"0x", Number{}, false},
+		{"0x1p", Number{}, false},
+		{"0xg", Number{}, false},
+		{"1.2.3", Number{}, false},
+		{"3..4", Number{}, false},
+		{"12a", Number{}, false},
+		{"1 2", Number{}, false},
+		{"--1", Number{}, false},
+		{"inf", Number{}, false},
+		{"nan", Number{}, false},
+		{"0b101", Number{}, false},
+	} {
+		got, ok := ParseNumber([]byte(tc.in))
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("ParseNumber(%q) = %+v, %v; want %+v, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lexer
+
+import "fmt"
+
+// Kind identifies the lexical class of a token.
+type Kind int
+
+const (
+	EOF Kind = iota
+	NAME
+	NUMERAL
+	STRING
+
+	// keywords
+	AND
+	BREAK
+	DO
+	ELSE
+	ELSEIF
+	END
+	FALSE
+	FOR
+	FUNCTION
+	GOTO
+	IF
+	IN
+	LOCAL
+	NIL
+	NOT
+	OR
+	REPEAT
+	RETURN
+	THEN
+	TRUE
+	UNTIL
+	WHILE
+
+	// operators and punctuation
+	PLUS       // +
+	MINUS      // -
+	STAR       // *
+	SLASH      // /
+	SLASHSLASH // //
+	CARET      // ^
+	PERCENT    // %
+	AMPERSAND  // &
+	TILDE      // ~
+	PIPE       // |
+	SHR        // >>
+	SHL        // <<
+	DOTDOT     // ..
+	LT         // <
+	LE         // <=
+	GT         // >
+	GE         // >=
+	EQ         // ==
+	NE         // ~=
+	HASH       // #
+	ASSIGN     // =
+	LPAREN     // (
+	RPAREN     // )
+	LBRACE     // {
+	RBRACE     // }
+	LBRACKET   // [
+	RBRACKET   // ]
+	COLONCOLON // ::
+	SEMICOLON  // ;
+	COLON      // :
+	COMMA      // ,
+	DOT        // .
+	DOTDOTDOT  // ...
+)
+
+var kindNames = [...]string{
+	EOF:     "<eof>",
+	NAME:    "<name>",
+	NUMERAL: "<number>",
+	STRING:  "<string>",
+
+	AND:      "and",
+	BREAK:    "break",
+	DO:       "do",
+	ELSE:     "else",
+	ELSEIF:   "elseif",
+	END:      "end",
+	FALSE:    "false",
+	FOR:      "for",
+	FUNCTION: "function",
+	GOTO:     "goto",
+	IF:       "if",
+	IN:       "in",
+	LOCAL:    "local",
+	NIL:      "nil",
+	NOT:      "not",
+	OR:       "or",
+	REPEAT:   "repeat",
+	RETURN:   "return",
+	THEN:     "then",
+	TRUE:     "true",
+	UNTIL:    "until",
+	WHILE:    "while",
+
+	PLUS:       "+",
+	MINUS:      "-",
+	STAR:       "*",
+	SLASH:      "/",
+	SLASHSLASH: "//",
+	CARET:      "^",
+	PERCENT:    "%",
+	AMPERSAND:  "&",
+	TILDE:      "~",
+	PIPE:       "|",
+	SHR:        ">>",
+	SHL:        "<<",
+	DOTDOT:     "..",
+	LT:         "<",
+	LE:         "<=",
+	GT:         ">",
+	GE:         ">=",
+	EQ:         "==",
+	NE:         "~=",
+	HASH:       "#",
+	ASSIGN:     "=",
+	LPAREN:     "(",
+	RPAREN:     ")",
+	LBRACE:     "{",
+	RBRACE:     "}",
+	LBRACKET:   "[",
+	RBRACKET:   "]",
+	COLONCOLON: "::",
+	SEMICOLON:  ";",
+	COLON:      ":",
+	COMMA:      ",",
+	DOT:        ".",
+	DOTDOTDOT:  "...",
+}
+
+// String returns the Lua spelling of the kind, or a placeholder
+// such as <name> for the variable-text kinds.
+func (k Kind) String() string {
+	if 0 <= k && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "<unknown>"
+}
+
+// IsKeyword reports whether the kind is a reserved word.
+func (k Kind) IsKeyword() bool {
+	return AND <= k && k <= WHILE
+}
+
+var keywords = map[string]Kind{}
+
+func init() {
+	for k := AND; k <= WHILE; k++ {
+		keywords[kindNames[k]] = k
+	}
+}
+
+// Token is a single lexical token.
+type Token struct {
+	Kind Kind
+	Raw  []byte // the token's text, exactly as it appeared in the source
+	Val  []byte // for STRING tokens, the decoded value of the string
+	Pos  Pos    // position of the first byte of the token
+	End  Pos    // position just past the last byte of the token
+
+	Trivia *TokenTrivia // comments and blank lines around the token, if kept
+}
+
+// Pos is a position in the source.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // byte offset within the line, starting at 1
+}
+
+// String returns the position as "line:column".
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Error is a lexical error.
+type Error struct {
+	Pos  Pos    // where the error was detected
+	Msg  string // description of the error
+	Near string // the text of the offending token, if any
+}
+
+func (e *Error) Error() string {
+	if e.Near == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s near '%s'", e.Pos, e.Msg, e.Near)
+}
@@ -0,0 +1,170 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lexer
+
+// TriviaKind identifies the kind of a piece of trivia.
+type TriviaKind int
+
+const (
+	LineComment TriviaKind = iota // a comment running to the end of the line
+	LongComment                   // a comment in long brackets, --[[ ... ]]
+	BlankLines                    // a run of lines holding only whitespace
+)
+
+// Trivia is a comment or a run of blank lines.
+// For blank lines, Text holds the lines, including their newlines.
+type Trivia struct {
+	Kind TriviaKind
+	Text []byte
+	Pos  Pos // position of the first byte of the trivia
+	End  Pos // position just past the last byte of the trivia
+}
+
+// IsComment reports whether the trivia is a comment.
+func (t Trivia) IsComment() bool {
+	return t.Kind != BlankLines
+}
+
+// TokenTrivia is the trivia around a token.
+//
+// Trailing trivia is the comments that follow the token on its line.
+// The newline that ends that line belongs to the token as well, so
+// everything from there up to the next token is that token's leading
+// trivia.
+type TokenTrivia struct {
+	Leading  []Trivia
+	Trailing []Trivia
+}
+
+// KeepTrivia tells the lexer to attach comments and blank lines to the
+// tokens it returns, in their Trivia field. It must be called before
+// the first call to Next. Without it, trivia is skipped along with the
+// whitespace and the Trivia field is nil.
+func (l *Lexer) KeepTrivia() {
+	l.trivia = true
+}
+
+// nextWithTrivia is Next for a lexer that is keeping trivia.
+func (l *Lexer) nextWithTrivia() (Token, error) {
+	leading, err := l.leadingTrivia()
+	if err != nil {
+		return Token{}, err
+	}
+	tok, err := l.next()
+	if err != nil {
+		return Token{}, err
+	}
+	var trailing []Trivia
+	if tok.Kind != EOF {
+		if trailing, err = l.trailingTrivia(); err != nil {
+			return Token{}, err
+		}
+	}
+	if leading != nil || trailing != nil {
+		tok.Trivia = &TokenTrivia{Leading: leading, Trailing: trailing}
+	}
+	return tok, nil
+}
+
+// leadingTrivia skips whitespace and comments, returning the comments
+// and the runs of blank lines.
+func (l *Lexer) leadingTrivia() ([]Trivia, error) {
+	var list []Trivia
+	// a line is blank if it has only whitespace; the current line is
+	// blank so far only if we are at its start
+	lineStart, blankStart := -1, -1
+	if l.off == 0 || isNewline(l.src[l.off-1]) {
+		lineStart = l.off
+	}
+	flush := func() {
+		if blankStart >= 0 {
+			list = append(list, Trivia{Kind: BlankLines, Text: l.src[blankStart:lineStart], Pos: l.Pos(blankStart), End: l.Pos(lineStart)})
+			blankStart = -1
+		}
+	}
+	for l.off < len(l.src) {
+		switch ch := l.src[l.off]; {
+		case isNewline(ch):
+			l.skipNewline()
+			if lineStart >= 0 && blankStart < 0 {
+				blankStart = lineStart
+			}
+			lineStart = l.off
+		case isSpace(ch):
+			l.off++
+		case ch == '-' && l.peek(1) == '-':
+			flush()
+			t, err := l.commentTrivia()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, t)
+			lineStart = -1
+		default:
+			flush()
+			return list, nil
+		}
+	}
+	flush()
+	return list, nil
+}
+
+// trailingTrivia skips whitespace and comments up to and including the
+// end of the current line, returning the comments.
+func (l *Lexer) trailingTrivia() ([]Trivia, error) {
+	var list []Trivia
+	for l.off < len(l.src) {
+		switch ch := l.src[l.off]; {
+		case isNewline(ch):
+			l.skipNewline()
+			return list, nil
+		case isSpace(ch):
+			l.off++
+		case ch == '-' && l.peek(1) == '-':
+			t, err := l.commentTrivia()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, t)
+		default:
+			return list, nil
+		}
+	}
+	return list, nil
+}
+
+// commentTrivia scans the comment at the current offset.
+func (l *Lexer) commentTrivia() (Trivia, error) {
+	start := l.off
+	long, err := l.comment()
+	if err != nil {
+		return Trivia{}, err
+	}
+	kind := LineComment
+	if long {
+		kind = LongComment
+	}
+	return Trivia{Kind: kind, Text: l.src[start:l.off], Pos: l.Pos(start), End: l.Pos(l.off)}, nil
+}
@@ -0,0 +1,117 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+// libFunc is a function of a library.
+type libFunc struct {
+	name string
+	fn   func(L *State, args []Value) []Value
+}
+
+// newLib returns a table holding the functions of a library.
+func newLib(funcs []libFunc) *Table {
+	t := NewTable(0, len(funcs))
+	for _, f := range funcs {
+		t.Set(f.name, &GoFunction{Name: f.name, Fn: f.fn})
+	}
+	return t
+}
+
+// arg returns argument i, counted from 0, or nil if it is absent.
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// checkAny raises an error if argument n, counted from 1, is absent.
+func (L *State) checkAny(args []Value, n int) Value {
+	if n > len(args) {
+		L.ArgError(n, "value expected")
+	}
+	return args[n-1]
+}
+
+// checkNumber returns argument n, counted from 1, as a float. A
+// string that reads as a number is converted.
+func (L *State) checkNumber(args []Value, n int) float64 {
+	v, ok := ToNumber(arg(args, n-1))
+	if !ok {
+		L.typeArgError(n, "number", args)
+	}
+	f, _ := toFloat(v)
+	return f
+}
+
+// checkInteger returns argument n, counted from 1, as an integer.
+func (L *State) checkInteger(args []Value, n int) int64 {
+	v := arg(args, n-1)
+	i, ok := ToInteger(v)
+	if !ok {
+		if _, ok := ToNumber(v); ok {
+			L.ArgError(n, "number has no integer representation")
+		}
+		L.typeArgError(n, "number", args)
+	}
+	return i
+}
+
+// checkTable returns argument n, counted from 1, which must be a table.
+func (L *State) checkTable(args []Value, n int) *Table {
+	t, ok := arg(args, n-1).(*Table)
+	if !ok {
+		L.typeArgError(n, "table", args)
+	}
+	return t
+}
+
+// checkString returns argument n, counted from 1, as a string. A
+// number is converted to a string.
+func (L *State) checkString(args []Value, n int) string {
+	s, ok := toStringCoerce(arg(args, n-1))
+	if !ok {
+		L.typeArgError(n, "string", args)
+	}
+	return s
+}
+
+// optString is like checkString, but returns def if the argument is
+// nil or absent.
+func (L *State) optString(args []Value, n int, def string) string {
+	if arg(args, n-1) == nil {
+		return def
+	}
+	return L.checkString(args, n)
+}
+
+// optInteger is like checkInteger, but returns def if the argument is
+// nil or absent.
+func (L *State) optInteger(args []Value, n int, def int64) int64 {
+	if arg(args, n-1) == nil {
+		return def
+	}
+	return L.checkInteger(args, n)
+}
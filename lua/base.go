@@ -0,0 +1,597 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// openBase registers the base library in the global environment.
+func openBase(L *State) {
+	for _, f := range []libFunc{
+		{"assert", baseAssert},
+		{"collectgarbage", baseCollectgarbage},
+		{"dofile", baseDofile},
+		{"error", baseError},
+		{"getmetatable", baseGetmetatable},
+		{"ipairs", baseIpairs},
+		{"loadfile", baseLoadfile},
+		{"load", baseLoad},
+		{"pairs", basePairs},
+		{"pcall", basePcall},
+		{"print", basePrint},
+		{"warn", baseWarn},
+		{"rawequal", baseRawequal},
+		{"rawlen", baseRawlen},
+		{"rawget", baseRawget},
+		{"rawset", baseRawset},
+		{"select", baseSelect},
+		{"setmetatable", baseSetmetatable},
+		{"tonumber", baseTonumber},
+		{"tostring", baseTostring},
+		{"type", baseType},
+		{"xpcall", baseXpcall},
+	} {
+		L.Register(f.name, f.fn)
+	}
+	L.Globals.Set("next", nextFunc)
+	L.Globals.Set("_G", L.Globals)
+	L.Globals.Set("_VERSION", "Lua 5.4")
+}
+
+// baseAssert returns its arguments if the first one is true, and
+// otherwise raises an error with the second one, or with "assertion
+// failed!" if there is none.
+func baseAssert(L *State, args []Value) []Value {
+	if Truth(L.checkAny(args, 1)) {
+		return args
+	}
+	if len(args) < 2 {
+		L.Errorf("assertion failed!")
+	}
+	L.Raise(args[1])
+	return nil
+}
+
+// baseCollectgarbage controls the collector. The collector of the Go
+// runtime does the work, so the options that tune or stop it are
+// recorded but have no effect.
+func baseCollectgarbage(L *State, args []Value) []Value {
+	g := L.g
+	switch opt := L.optString(args, 1, "collect"); opt {
+	case "collect":
+		L.CollectGarbage()
+		return []Value{int64(0)}
+	case "step":
+		L.CollectGarbage()
+		return []Value{true}
+	case "count":
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return []Value{float64(m.HeapAlloc) / 1024}
+	case "stop", "restart":
+		g.gcStopped = opt == "stop"
+		return []Value{int64(0)}
+	case "isrunning":
+		return []Value{!g.gcStopped}
+	case "incremental", "generational":
+		prev := "incremental"
+		if g.gcGenerational {
+			prev = "generational"
+		}
+		g.gcGenerational = opt == "generational"
+		return []Value{prev}
+	case "setpause", "setstepmul":
+		i := 0
+		if opt == "setstepmul" {
+			i = 1
+		}
+		prev := g.gcParams[i]
+		g.gcParams[i] = L.optInteger(args, 2, 0)
+		return []Value{prev}
+	default:
+		L.ArgError(1, "invalid option '%s'", opt)
+	}
+	return nil
+}
+
+// baseDofile runs a file, or standard input if no file is named, and
+// returns what it returns. Errors propagate to the caller.
+func baseDofile(L *State, args []Value) []Value {
+	name := L.optString(args, 1, "")
+	fn, err := L.loadFile(name, "bt", L.Globals)
+	if err != nil {
+		L.Raise(err.Error())
+	}
+	return L.Call(fn, nil)
+}
+
+// baseError raises an error with its first argument. A message is
+// prefixed with the position where the error happened: at level 1,
+// the default, in the function that called error; at level 2, in the
+// function that called that one; and so on. Level 0 adds nothing.
+func baseError(L *State, args []Value) []Value {
+	level := L.optInteger(args, 2, 1)
+	v := arg(args, 0)
+	if msg, ok := v.(string); ok && level > 0 {
+		v = L.Where(int(level)) + msg
+	}
+	L.Raise(v)
+	return nil
+}
+
+// baseGetmetatable returns the metatable of its argument, or the
+// __metatable field of the metatable if it has one.
+func baseGetmetatable(L *State, args []Value) []Value {
+	mt := L.Metatable(L.checkAny(args, 1))
+	if mt == nil {
+		return []Value{nil}
+	}
+	if v := mt.GetString("__metatable"); v != nil {
+		return []Value{v}
+	}
+	return []Value{mt}
+}
+
+// baseIpairs returns an iterator over the pairs (1, t[1]), (2, t[2]),
+// ... up to the first nil value.
+func baseIpairs(L *State, args []Value) []Value {
+	return []Value{ipairsFunc, L.checkAny(args, 1), int64(0)}
+}
+
+var ipairsFunc = &GoFunction{Name: "for iterator", Fn: ipairsAux}
+
+func ipairsAux(L *State, args []Value) []Value {
+	i := L.checkInteger(args, 2) + 1
+	v := L.Index(arg(args, 0), i)
+	if v == nil {
+		return []Value{nil}
+	}
+	return []Value{i, v}
+}
+
+// baseLoadfile loads a file, or standard input if no file is named, as
+// load does a string.
+func baseLoadfile(L *State, args []Value) []Value {
+	name := L.optString(args, 1, "")
+	mode := L.optString(args, 2, "bt")
+	env := Value(L.Globals)
+	if len(args) >= 3 {
+		env = args[2]
+	}
+	fn, err := L.loadFile(name, mode, env)
+	if err != nil {
+		return []Value{nil, err.Error()}
+	}
+	return []Value{fn}
+}
+
+// loadFile loads the file name, or standard input if name is "". A
+// first line starting with '#' is skipped.
+func (L *State) loadFile(name, mode string, env Value) (Function, error) {
+	chunkname := "=stdin"
+	var src []byte
+	var err error
+	if name == "" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		chunkname = "@" + name
+		src, err = os.ReadFile(name)
+	}
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		return nil, &Error{Value: "cannot open " + chunkname[1:] + ": " + err.Error()}
+	}
+	if len(src) > 0 && src[0] == '#' {
+		// keep the newline so that the lines are counted right
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			src = src[i:]
+		} else {
+			src = nil
+		}
+	}
+	return L.load(src, chunkname, mode, env)
+}
+
+// baseLoad loads a chunk without running it, and returns its main
+// function, or nil and a message if the chunk does not compile. The
+// chunk is a string, a number, which is loaded as a string, or a
+// function that returns its pieces until it returns nil or an empty
+// string. The _ENV of the chunk is the fourth argument if there is
+// one, or else the global environment.
+func baseLoad(L *State, args []Value) []Value {
+	var src []byte
+	var chunkname string
+	switch chunk := arg(args, 0).(type) {
+	case string, int64, float64:
+		s, _ := toStringCoerce(chunk)
+		src = []byte(s)
+		chunkname = L.optString(args, 2, s)
+	default:
+		chunkname = L.optString(args, 2, "=(load)")
+		if _, ok := chunk.(Function); !ok {
+			L.typeArgError(1, "function", args)
+		}
+		var err error
+		if src, err = L.readChunk(chunk); err != nil {
+			return []Value{nil, err.(*Error).Value}
+		}
+	}
+	mode := L.optString(args, 3, "bt")
+	env := Value(L.Globals)
+	if len(args) >= 4 {
+		env = args[3]
+	}
+	fn, err := L.load(src, chunkname, mode, env)
+	if err != nil {
+		return []Value{nil, err.Error()}
+	}
+	return []Value{fn}
+}
+
+// readChunk calls the reader function of load until it returns nil
+// or an empty string, and returns the concatenation of the pieces.
+func (L *State) readChunk(reader Value) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := L.PCall(&GoFunction{Name: "load", Fn: func(L *State, _ []Value) []Value {
+		for {
+			switch piece := first(L.Call(reader, nil)).(type) {
+			case nil:
+				return nil
+			case string:
+				if piece == "" {
+					return nil
+				}
+				buf.WriteString(piece)
+			default:
+				L.Raise("reader function must return a string")
+			}
+		}
+	}}, nil)
+	return buf.Bytes(), err
+}
+
+// load compiles a chunk with the compiler of the state, after checking
+// that the mode allows it: "t" for text chunks, "b" for binary chunks,
+// which cannot be loaded.
+func (L *State) load(src []byte, chunkname, mode string, env Value) (Function, error) {
+	kind, want := "text", "t"
+	if bytes.HasPrefix(src, []byte("\x1bLua")) {
+		kind, want = "binary", "b"
+	}
+	if !strings.Contains(mode, want) {
+		return nil, &Error{Value: "attempt to load a " + kind + " chunk (mode is '" + mode + "')"}
+	}
+	if kind == "binary" {
+		return nil, &Error{Value: chunkID(chunkname) + ": binary chunks are not supported"}
+	}
+	if L.Compile == nil {
+		return nil, &Error{Value: chunkID(chunkname) + ": no compiler to load the chunk with"}
+	}
+	return L.Compile(L, chunkID(chunkname), src, env)
+}
+
+// idSize is the size of the chunk names in messages, as LUA_IDSIZE
+// less the terminating zero.
+const idSize = 59
+
+// chunkID returns the name of a chunk as messages show it: the name
+// after a leading '=' or '@', which mark names given by the host
+// program and file names, or else the start of the source itself, as
+// in [string "x = 1"].
+func chunkID(source string) string {
+	switch {
+	case strings.HasPrefix(source, "="):
+		if len(source) <= idSize+1 {
+			return source[1:]
+		}
+		return source[1 : idSize+1]
+	case strings.HasPrefix(source, "@"):
+		if len(source) <= idSize+1 {
+			return source[1:]
+		}
+		// keep the end of a long file name
+		return "..." + source[len(source)-(idSize-3):]
+	}
+	const pre, rets, pos = `[string "`, "...", `"]`
+	size := idSize - len(pre) - len(rets) - len(pos) - 1
+	nl := strings.IndexByte(source, '\n')
+	if len(source) < size && nl < 0 {
+		return pre + source + pos
+	}
+	if nl >= 0 {
+		source = source[:nl]
+	}
+	if len(source) > size {
+		source = source[:size]
+	}
+	return pre + source + rets + pos
+}
+
+// nextFunc is the function next, which pairs also returns.
+var nextFunc = &GoFunction{Name: "next", Fn: baseNext}
+
+// baseNext returns the key and the value of the field of a table that
+// follows a key in a traversal of the table, or the first field if the
+// key is nil, or nil at the end of the traversal.
+func baseNext(L *State, args []Value) []Value {
+	t := L.checkTable(args, 1)
+	k, v, ok := t.Next(arg(args, 1))
+	if !ok {
+		L.RuntimeError("invalid key to 'next'")
+	}
+	if k == nil {
+		return []Value{nil}
+	}
+	return []Value{k, v}
+}
+
+// basePairs returns next, the table and nil, to traverse all the
+// fields of a table, or the first three results of the __pairs
+// metamethod of the value called with the value.
+func basePairs(L *State, args []Value) []Value {
+	v := L.checkAny(args, 1)
+	tm := L.metaField(v, "__pairs")
+	if tm == nil {
+		return []Value{nextFunc, v, nil}
+	}
+	rets := make([]Value, 3)
+	copy(rets, L.Call(tm, []Value{v}))
+	return rets
+}
+
+// basePcall calls a function in protected mode, and returns true and
+// the results of the call, or false and the error.
+func basePcall(L *State, args []Value) []Value {
+	L.checkAny(args, 1)
+	rets, err := L.PCall(args[0], args[1:])
+	return status(rets, err)
+}
+
+// status returns the results of a protected call for pcall and xpcall.
+func status(rets []Value, err error) []Value {
+	if err != nil {
+		return []Value{false, err.(*Error).Value}
+	}
+	return append([]Value{true}, rets...)
+}
+
+// basePrint writes its arguments to standard output, separated by
+// tabs and followed by a newline.
+func basePrint(L *State, args []Value) []Value {
+	w := bufio.NewWriter(L.Stdout)
+	for i, v := range args {
+		if i > 0 {
+			w.WriteByte('\t')
+		}
+		w.WriteString(L.ToString(v))
+	}
+	w.WriteByte('\n')
+	w.Flush()
+	return nil
+}
+
+// baseWarn writes a warning made of its arguments to the standard
+// error, if warnings are on. A single argument starting with '@' is a
+// control message: "@on" and "@off" turn warnings on and off, and
+// other control messages are ignored. Warnings start off.
+func baseWarn(L *State, args []Value) []Value {
+	L.checkString(args, 1)
+	var msg strings.Builder
+	for n := range args {
+		msg.WriteString(L.checkString(args, n+1))
+	}
+	if len(args) == 1 && strings.HasPrefix(msg.String(), "@") {
+		switch msg.String() {
+		case "@on":
+			L.g.warn = true
+		case "@off":
+			L.g.warn = false
+		}
+		return nil
+	}
+	if L.g.warn {
+		w := bufio.NewWriter(L.Stderr)
+		w.WriteString("Lua warning: ")
+		w.WriteString(msg.String())
+		w.WriteByte('\n')
+		w.Flush()
+	}
+	return nil
+}
+
+// baseRawequal reports whether two values are equal without calling
+// the __eq metamethod.
+func baseRawequal(L *State, args []Value) []Value {
+	a, b := L.checkAny(args, 1), L.checkAny(args, 2)
+	return []Value{RawEqual(a, b)}
+}
+
+// baseRawlen returns the length of a table or a string without calling
+// the __len metamethod.
+func baseRawlen(L *State, args []Value) []Value {
+	switch v := arg(args, 0).(type) {
+	case *Table:
+		return []Value{v.Len()}
+	case string:
+		return []Value{int64(len(v))}
+	}
+	L.ArgError(1, "table or string expected")
+	return nil
+}
+
+// baseRawget returns t[k] without calling the __index metamethod.
+func baseRawget(L *State, args []Value) []Value {
+	t := L.checkTable(args, 1)
+	return []Value{t.Get(L.checkAny(args, 2))}
+}
+
+// baseRawset sets t[k] to v without calling the __newindex metamethod,
+// and returns t.
+func baseRawset(L *State, args []Value) []Value {
+	t := L.checkTable(args, 1)
+	k := L.checkAny(args, 2)
+	v := L.checkAny(args, 3)
+	L.checkKey(k)
+	t.Set(k, v)
+	return []Value{t}
+}
+
+// baseSelect returns its arguments after the nth, or from the end if n
+// is negative, or their number if n is the string "#".
+func baseSelect(L *State, args []Value) []Value {
+	n := int64(len(args))
+	if s, ok := arg(args, 0).(string); ok && strings.HasPrefix(s, "#") {
+		return []Value{n - 1}
+	}
+	i := L.checkInteger(args, 1)
+	if i < 0 {
+		i += n
+	} else if i > n {
+		i = n
+	}
+	if i < 1 {
+		L.ArgError(1, "index out of range")
+	}
+	return args[i:]
+}
+
+// baseSetmetatable sets the metatable of a table, or removes it if the
+// metatable is nil, and returns the table. A metatable with a
+// __metatable field cannot be changed.
+func baseSetmetatable(L *State, args []Value) []Value {
+	t, ok := arg(args, 0).(*Table)
+	if !ok {
+		L.typeArgError(1, "table", args)
+	}
+	var mt *Table
+	switch v := arg(args, 1).(type) {
+	case *Table:
+		mt = v
+	case nil:
+		if len(args) < 2 {
+			L.typeArgError(2, "nil or table", args)
+		}
+	default:
+		L.typeArgError(2, "nil or table", args)
+	}
+	if old := L.Metatable(t); old != nil && old.GetString("__metatable") != nil {
+		L.Errorf("cannot change a protected metatable")
+	}
+	L.SetMetatable(t, mt)
+	return []Value{t}
+}
+
+// baseTonumber converts a number or a string that reads as a number to
+// a number, or returns nil. With a base, the string is read as an
+// integer numeral in that base, whose letters are the digits from 10
+// on.
+func baseTonumber(L *State, args []Value) []Value {
+	if arg(args, 1) == nil {
+		v := L.checkAny(args, 1)
+		if n, ok := ToNumber(v); ok {
+			return []Value{n}
+		}
+		return []Value{nil}
+	}
+	base := L.checkInteger(args, 2)
+	s, ok := arg(args, 0).(string)
+	if !ok {
+		L.typeArgError(1, "string", args)
+	}
+	if base < 2 || base > 36 {
+		L.ArgError(2, "base out of range")
+	}
+	if n, ok := parseInt(s, base); ok {
+		return []Value{n}
+	}
+	return []Value{nil}
+}
+
+// parseInt reads an integer numeral in base, with optional surrounding
+// whitespace and sign. It wraps around on overflow.
+func parseInt(s string, base int64) (int64, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		var d int64
+		switch {
+		case '0' <= c && c <= '9':
+			d = int64(c - '0')
+		case 'a' <= c && c <= 'z':
+			d = int64(c-'a') + 10
+		case 'A' <= c && c <= 'Z':
+			d = int64(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if d >= base {
+			return 0, false
+		}
+		n = n*uint64(base) + uint64(d)
+	}
+	if neg {
+		n = -n
+	}
+	return int64(n), true
+}
+
+// baseTostring converts a value to a string, calling its __tostring
+// metamethod if it has one.
+func baseTostring(L *State, args []Value) []Value {
+	return []Value{L.ToString(L.checkAny(args, 1))}
+}
+
+// baseType returns the name of the type of a value.
+func baseType(L *State, args []Value) []Value {
+	return []Value{TypeName(L.checkAny(args, 1))}
+}
+
+// baseXpcall calls a function in protected mode, like pcall, with a
+// message handler that turns the value of an error into the one
+// xpcall returns.
+func baseXpcall(L *State, args []Value) []Value {
+	if _, ok := arg(args, 1).(Function); !ok {
+		L.typeArgError(2, "function", args)
+	}
+	rets, err := L.XPCall(args[0], args[2:], args[1])
+	return status(rets, err)
+}
@@ -0,0 +1,152 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import "math"
+
+// ForLoop is the state of a numeric for loop. The loop runs on
+// integers when the initial value and the step are integers, and on
+// floats otherwise.
+type ForLoop struct {
+	isFloat bool
+
+	// integer loop
+	i, step int64
+	count   uint64 // iterations left after the current one
+
+	// float loop
+	f, fstep, flimit float64
+}
+
+// ForPrep checks and converts the control values of a numeric for
+// loop. It reports false if the loop body must not run at all.
+//
+// An integer loop precomputes its number of iterations, so it cannot
+// overflow; a float limit is clipped to the integer range.
+func (L *State) ForPrep(init, limit, step Value) (loop ForLoop, ok bool) {
+	i, iok := init.(int64)
+	s, sok := step.(int64)
+	if iok && sok {
+		if s == 0 {
+			L.RuntimeError("'for' step is zero")
+		}
+		lim, skip := L.forLimit(i, limit, s)
+		if skip {
+			return loop, false
+		}
+		var count uint64
+		if s > 0 {
+			count = uint64(lim) - uint64(i)
+			if s != 1 {
+				count /= uint64(s)
+			}
+		} else {
+			// s+1 avoids negating the minimum integer
+			count = (uint64(i) - uint64(lim)) / (uint64(-(s + 1)) + 1)
+		}
+		return ForLoop{i: i, step: s, count: count}, true
+	}
+	flimit, ok := toFloat(limit)
+	if !ok {
+		L.RuntimeError("'for' limit must be a number")
+	}
+	fstep, ok := toFloat(step)
+	if !ok {
+		L.RuntimeError("'for' step must be a number")
+	}
+	f, ok := toFloat(init)
+	if !ok {
+		L.RuntimeError("'for' initial value must be a number")
+	}
+	if fstep == 0 {
+		L.RuntimeError("'for' step is zero")
+	}
+	if fstep > 0 && flimit < f || fstep < 0 && f < flimit {
+		return loop, false
+	}
+	return ForLoop{isFloat: true, f: f, fstep: fstep, flimit: flimit}, true
+}
+
+// forLimit converts the limit of an integer loop to an integer,
+// rounding it towards the initial value, and reports whether the
+// loop must be skipped.
+func (L *State) forLimit(init int64, limit Value, step int64) (int64, bool) {
+	var lim int64
+	switch l := limit.(type) {
+	case int64:
+		lim = l
+	case float64:
+		r := math.Floor(l)
+		if step < 0 {
+			r = math.Ceil(l)
+		}
+		var ok bool
+		if lim, ok = toInteger(r); !ok {
+			// the limit is out of the integer range, or NaN
+			if l > 0 {
+				if step < 0 {
+					return 0, true
+				}
+				lim = math.MaxInt64
+			} else {
+				if step > 0 {
+					return 0, true
+				}
+				lim = math.MinInt64
+			}
+		}
+	default:
+		L.RuntimeError("'for' limit must be a number")
+	}
+	if step > 0 {
+		return lim, init > lim
+	}
+	return lim, init < lim
+}
+
+// Value returns the current value of the control variable.
+func (l *ForLoop) Value() Value {
+	if l.isFloat {
+		return l.f
+	}
+	return l.i
+}
+
+// Next advances the loop and reports whether the body runs again.
+func (l *ForLoop) Next() bool {
+	if !l.isFloat {
+		if l.count == 0 {
+			return false
+		}
+		l.count--
+		l.i = int64(uint64(l.i) + uint64(l.step))
+		return true
+	}
+	l.f += l.fstep
+	if l.fstep > 0 {
+		return l.f <= l.flimit
+	}
+	return l.flimit <= l.f
+}
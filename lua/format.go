@@ -0,0 +1,395 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxFormat bounds the length of a conversion specification of format,
+// which allows two digits each for the width and the precision.
+const maxFormat = 32
+
+// The flags that each conversion of format accepts.
+const (
+	flagsFloat    = "-+ #0"
+	flagsHex      = "-#0"
+	flagsInt      = "-+ 0"
+	flagsUnsigned = "-0"
+	flagsChar     = "-"
+)
+
+// strFormat returns its arguments formatted by a format string, whose
+// conversions are those of C's printf, with %q for a value as a Lua
+// literal.
+func strFormat(L *State, args []Value) []Value {
+	f := L.checkString(args, 1)
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			continue
+		}
+		if i++; i < len(f) && f[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		n++
+		if n > len(args) {
+			L.ArgError(n, "no value")
+		}
+		j := i
+		for j < len(f) && strings.IndexByte(flagsFloat+"123456789.", f[j]) >= 0 {
+			j++
+		}
+		if j-i+1 >= maxFormat-10 {
+			L.Errorf("invalid format string to 'format'")
+		}
+		var conv byte
+		form := "%" + f[i:j]
+		if j < len(f) {
+			conv = f[j]
+			form += f[j : j+1]
+		}
+		i = j
+		v := args[n-1]
+		switch conv {
+		case 'c':
+			c := L.checkInteger(args, n)
+			fs := L.checkFormat(form, flagsChar, false)
+			fs.write(&b, "", "", string([]byte{byte(c)}), false)
+		case 'd', 'i', 'u', 'o', 'x', 'X':
+			c := L.checkInteger(args, n)
+			flags := flagsHex
+			switch conv {
+			case 'd', 'i':
+				flags = flagsInt
+			case 'u':
+				flags = flagsUnsigned
+			}
+			L.checkFormat(form, flags, true).formatInt(&b, conv, c)
+		case 'a', 'A':
+			fs := L.checkFormat(form, flagsFloat, true)
+			fs.formatFloat(&b, conv, L.checkNumber(args, n))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			x := L.checkNumber(args, n)
+			L.checkFormat(form, flagsFloat, true).formatFloat(&b, conv, x)
+		case 'p':
+			fs := L.checkFormat(form, flagsChar, false)
+			s := "(null)"
+			switch v.(type) {
+			case *Table, *Userdata, *Thread, Function:
+				s = fmt.Sprintf("%p", v)
+			}
+			fs.write(&b, "", "", s, false)
+		case 'q':
+			if len(form) > 2 {
+				L.Errorf("specifier '%%q' cannot have modifiers")
+			}
+			L.addLiteral(&b, v, n)
+		case 's':
+			s := L.ToString(v)
+			if len(form) == 2 {
+				b.WriteString(s)
+				break
+			}
+			if strings.IndexByte(s, 0) >= 0 {
+				L.ArgError(n, "string contains zeros")
+			}
+			fs := L.checkFormat(form, flagsChar, true)
+			if fs.prec >= 0 && fs.prec < len(s) {
+				s = s[:fs.prec]
+			}
+			fs.write(&b, "", "", s, false)
+		default:
+			L.Errorf("invalid conversion '%s' to 'format'", form)
+		}
+	}
+	return []Value{b.String()}
+}
+
+// fmtSpec is a conversion specification of format.
+type fmtSpec struct {
+	minus, plus, space, sharp, zero bool
+
+	width int
+	prec  int // -1 if absent
+}
+
+// checkFormat checks that the conversion specification form has only
+// the given flags, at most two digits of width and, if precision is
+// set, of precision, and returns it parsed.
+func (L *State) checkFormat(form, flags string, precision bool) *fmtSpec {
+	spec := strings.TrimLeft(form[1:], flags)
+	if !strings.HasPrefix(spec, "0") {
+		spec = skipDigits(spec)
+		if precision && strings.HasPrefix(spec, ".") {
+			spec = skipDigits(spec[1:])
+		}
+	}
+	if spec == "" || !isAlpha(spec[0]) {
+		L.Errorf("invalid conversion specification: '%s'", form)
+	}
+
+	fs := &fmtSpec{prec: -1}
+	i := 1
+	for ; strings.IndexByte(flagsFloat, form[i]) >= 0; i++ {
+		switch form[i] {
+		case '-':
+			fs.minus = true
+		case '+':
+			fs.plus = true
+		case ' ':
+			fs.space = true
+		case '#':
+			fs.sharp = true
+		case '0':
+			fs.zero = true
+		}
+	}
+	for ; isDigit(form[i]); i++ {
+		fs.width = fs.width*10 + int(form[i]-'0')
+	}
+	if form[i] == '.' {
+		fs.prec = 0
+		for i++; isDigit(form[i]); i++ {
+			fs.prec = fs.prec*10 + int(form[i]-'0')
+		}
+	}
+	return fs
+}
+
+// skipDigits skips up to two digits at the start of s.
+func skipDigits(s string) string {
+	for i := 0; i < 2 && s != "" && isDigit(s[0]); i++ {
+		s = s[1:]
+	}
+	return s
+}
+
+// write writes the sign, the prefix and the digits of a conversion,
+// padded to the width with spaces on the left, or on the right with
+// the flag '-'. If zero is set, the flag '0' pads with zeros between
+// the prefix and the digits instead.
+func (fs *fmtSpec) write(b *strings.Builder, sign, prefix, digits string, zero bool) {
+	pad := fs.width - len(sign) - len(prefix) - len(digits)
+	if pad <= 0 {
+		b.WriteString(sign + prefix + digits)
+		return
+	}
+	switch {
+	case fs.minus:
+		b.WriteString(sign + prefix + digits + strings.Repeat(" ", pad))
+	case fs.zero && zero:
+		b.WriteString(sign + prefix + strings.Repeat("0", pad) + digits)
+	default:
+		b.WriteString(strings.Repeat(" ", pad) + sign + prefix + digits)
+	}
+}
+
+// formatInt writes the integer n for the conversion conv. The
+// conversions other than %d and %i take n as unsigned.
+func (fs *fmtSpec) formatInt(b *strings.Builder, conv byte, n int64) {
+	var sign, prefix, digits string
+	switch conv {
+	case 'd', 'i':
+		u := uint64(n)
+		switch {
+		case n < 0:
+			sign, u = "-", -u
+		case fs.plus:
+			sign = "+"
+		case fs.space:
+			sign = " "
+		}
+		digits = strconv.FormatUint(u, 10)
+	case 'u':
+		digits = strconv.FormatUint(uint64(n), 10)
+	case 'o':
+		digits = strconv.FormatUint(uint64(n), 8)
+	case 'x', 'X':
+		digits = strconv.FormatUint(uint64(n), 16)
+		if fs.sharp && n != 0 {
+			prefix = "0x"
+		}
+		if conv == 'X' {
+			digits, prefix = strings.ToUpper(digits), strings.ToUpper(prefix)
+		}
+	}
+	if fs.prec >= 0 {
+		if fs.prec == 0 && n == 0 {
+			digits = ""
+		}
+		if len(digits) < fs.prec {
+			digits = strings.Repeat("0", fs.prec-len(digits)) + digits
+		}
+	}
+	if conv == 'o' && fs.sharp && !strings.HasPrefix(digits, "0") {
+		digits = "0" + digits
+	}
+	fs.write(b, sign, prefix, digits, fs.prec < 0)
+}
+
+// formatFloat writes the number x for the conversion conv.
+func (fs *fmtSpec) formatFloat(b *strings.Builder, conv byte, x float64) {
+	var sign, prefix, digits string
+	switch {
+	case math.Signbit(x):
+		sign, x = "-", math.Abs(x)
+	case fs.plus:
+		sign = "+"
+	case fs.space:
+		sign = " "
+	}
+	switch {
+	case math.IsInf(x, 0):
+		digits = "inf"
+	case math.IsNaN(x):
+		digits = "nan"
+	default:
+		prec := fs.prec
+		if prec < 0 && conv != 'a' && conv != 'A' {
+			prec = 6
+		}
+		switch lower(conv) {
+		case 'f':
+			digits = strconv.FormatFloat(x, 'f', prec, 64)
+			if fs.sharp && prec == 0 {
+				digits += "."
+			}
+		case 'e':
+			digits = strconv.FormatFloat(x, 'e', prec, 64)
+			if fs.sharp && prec == 0 {
+				digits = strings.Replace(digits, "e", ".e", 1)
+			}
+		case 'g':
+			digits = formatG(x, prec, fs.sharp)
+		case 'a':
+			prefix, digits = "0x", formatHex(x, prec, fs.sharp)
+		}
+	}
+	if isUpper(conv) {
+		prefix, digits = strings.ToUpper(prefix), strings.ToUpper(digits)
+	}
+	fs.write(b, sign, prefix, digits, !math.IsInf(x, 0) && !math.IsNaN(x))
+}
+
+// formatG formats x as %g does with the precision prec: in the style
+// of %e if its exponent is below -4 or not below the precision, and of
+// %f otherwise, without trailing zeros unless sharp is set.
+func formatG(x float64, prec int, sharp bool) string {
+	if prec == 0 {
+		prec = 1
+	}
+	if !sharp {
+		return strconv.FormatFloat(x, 'g', prec, 64)
+	}
+	s := strconv.FormatFloat(x, 'e', prec-1, 64)
+	exp, _ := strconv.Atoi(s[strings.IndexByte(s, 'e')+1:])
+	if exp < -4 || exp >= prec {
+		if prec == 1 {
+			s = strings.Replace(s, "e", ".e", 1)
+		}
+		return s
+	}
+	s = strconv.FormatFloat(x, 'f', prec-1-exp, 64)
+	if prec-1-exp == 0 {
+		s += "."
+	}
+	return s
+}
+
+// formatHex formats x as %a does, without the prefix 0x: a hexadecimal
+// mantissa with the precision prec, or as many digits as x needs if
+// prec is negative, and a binary exponent of as few digits as it needs.
+func formatHex(x float64, prec int, sharp bool) string {
+	s := strconv.FormatFloat(x, 'x', prec, 64)[2:]
+	p := strings.IndexByte(s, 'p')
+	exp := strings.TrimLeft(s[p+2:], "0")
+	if exp == "" {
+		exp = "0"
+	}
+	mant := s[:p]
+	if sharp && strings.IndexByte(mant, '.') < 0 {
+		mant += "."
+	}
+	return mant + s[p:p+2] + exp
+}
+
+// addLiteral writes the value v, argument n, as a literal that reads
+// back as the same value.
+func (L *State) addLiteral(b *strings.Builder, v Value, n int) {
+	switch v := v.(type) {
+	case string:
+		addQuoted(b, v)
+	case int64:
+		if v == math.MinInt64 {
+			b.WriteString("0x8000000000000000")
+		} else {
+			b.WriteString(strconv.FormatInt(v, 10))
+		}
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			b.WriteString("1e9999")
+		case math.IsInf(v, -1):
+			b.WriteString("-1e9999")
+		case math.IsNaN(v):
+			b.WriteString("(0/0)")
+		default:
+			(&fmtSpec{prec: -1}).formatFloat(b, 'a', v)
+		}
+	case nil, bool:
+		b.WriteString(tostring(v))
+	default:
+		L.ArgError(n, "value has no literal form")
+	}
+}
+
+// addQuoted writes s as a quoted string literal. Control characters are
+// written as decimal escapes, padded to three digits if a digit follows.
+func addQuoted(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\' || c == '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case isCntrl(c):
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				fmt.Fprintf(b, "\\%03d", c)
+			} else {
+				fmt.Fprintf(b, "\\%d", c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,166 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"math"
+
+	"github.com/mdhender/glua/arith"
+)
+
+// openMath registers the math library: the functions whose results
+// depend on the distinction between integers and floats, and the
+// constants of the number types.
+func openMath(L *State) {
+	m := newLib([]libFunc{
+		{"abs", mathAbs},
+		{"ceil", mathCeil},
+		{"floor", mathFloor},
+		{"fmod", mathFmod},
+		{"max", mathMax},
+		{"min", mathMin},
+		{"tointeger", mathToInteger},
+		{"type", mathType},
+		{"ult", mathUlt},
+	})
+	m.Set("huge", math.Inf(1))
+	m.Set("pi", math.Pi)
+	m.Set("maxinteger", int64(math.MaxInt64))
+	m.Set("mininteger", int64(math.MinInt64))
+	L.Globals.Set("math", m)
+}
+
+// mathAbs returns the absolute value of a number. The absolute value
+// of the minimum integer wraps around to itself.
+func mathAbs(L *State, args []Value) []Value {
+	if i, ok := arg(args, 0).(int64); ok {
+		if i < 0 {
+			i = int64(-uint64(i))
+		}
+		return []Value{i}
+	}
+	return []Value{math.Abs(L.checkNumber(args, 1))}
+}
+
+// mathFloor returns the largest integral value not above a number, as
+// an integer if it fits in one.
+func mathFloor(L *State, args []Value) []Value {
+	if i, ok := arg(args, 0).(int64); ok {
+		return []Value{i}
+	}
+	return []Value{integral(math.Floor(L.checkNumber(args, 1)))}
+}
+
+// mathCeil returns the smallest integral value not below a number, as
+// an integer if it fits in one.
+func mathCeil(L *State, args []Value) []Value {
+	if i, ok := arg(args, 0).(int64); ok {
+		return []Value{i}
+	}
+	return []Value{integral(math.Ceil(L.checkNumber(args, 1)))}
+}
+
+// integral converts an integral float to an integer if it fits in one.
+func integral(f float64) Value {
+	if i, ok := arith.FloatToInteger(f); ok {
+		return i
+	}
+	return f
+}
+
+// mathFmod returns the remainder of the division of two numbers that
+// rounds the quotient towards zero. On integers it fails if the
+// divisor is zero.
+func mathFmod(L *State, args []Value) []Value {
+	if i, ok := arg(args, 0).(int64); ok {
+		if j, ok := arg(args, 1).(int64); ok {
+			switch j {
+			case 0:
+				L.ArgError(2, "zero")
+			case -1:
+				return []Value{int64(0)} // avoid the overflow trap of MinInteger % -1
+			}
+			return []Value{i % j}
+		}
+	}
+	return []Value{math.Mod(L.checkNumber(args, 1), L.checkNumber(args, 2))}
+}
+
+// mathMax returns the largest of its arguments.
+func mathMax(L *State, args []Value) []Value {
+	return []Value{extreme(L, args, false)}
+}
+
+// mathMin returns the smallest of its arguments.
+func mathMin(L *State, args []Value) []Value {
+	return []Value{extreme(L, args, true)}
+}
+
+// extreme returns the smallest or the largest of the numbers in args.
+func extreme(L *State, args []Value, smallest bool) Value {
+	L.checkNumber(args, 1)
+	v := args[0]
+	for n := 2; n <= len(args); n++ {
+		L.checkNumber(args, n)
+		w := args[n-1]
+		a, b := v, w
+		if smallest {
+			a, b = w, v
+		}
+		if L.Less(a, b) {
+			v = w
+		}
+	}
+	return v
+}
+
+// mathToInteger converts a number with an exact integer value to an
+// integer, or returns nil.
+func mathToInteger(L *State, args []Value) []Value {
+	L.checkAny(args, 1)
+	if i, ok := ToInteger(args[0]); ok {
+		return []Value{i}
+	}
+	return []Value{nil}
+}
+
+// mathType returns "integer" or "float" for a number, or nil for any
+// other value.
+func mathType(L *State, args []Value) []Value {
+	switch L.checkAny(args, 1).(type) {
+	case int64:
+		return []Value{"integer"}
+	case float64:
+		return []Value{"float"}
+	}
+	return []Value{nil}
+}
+
+// mathUlt reports whether the first integer is below the second when
+// both are taken as unsigned.
+func mathUlt(L *State, args []Value) []Value {
+	a, b := L.checkInteger(args, 1), L.checkInteger(args, 2)
+	return []Value{uint64(a) < uint64(b)}
+}
@@ -0,0 +1,283 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// maxTagLoop bounds the chains of __index and __newindex metamethods,
+// to stop a loop of tables.
+const maxTagLoop = 2000
+
+// Metatable returns the metatable of v, or nil if it has none. Tables
+// and userdata have metatables of their own; the values of the other
+// types share one per type.
+func (L *State) Metatable(v Value) *Table {
+	switch v := v.(type) {
+	case *Table:
+		return v.meta
+	case *Userdata:
+		return v.meta
+	}
+	return L.g.metatables[TypeName(v)]
+}
+
+// SetMetatable sets the metatable of v, which for a value other than
+// a table or a userdata is the metatable of its type. It does not
+// check the __metatable field.
+//
+// If mt has a __gc field, a table or userdata is marked for
+// finalization: once it is garbage, its __gc metamethod is called
+// with it. The Go collector runs finalizers on a goroutine of its own,
+// so they are queued and called by the state on its next call.
+//
+// A table whose metatable has a __mode field containing 'k' or 'v' has
+// weak keys or values. Weak keys are not ephemerons: a value that
+// refers to its key keeps the entry alive, as the Go collector cannot
+// tell that the table holds the only path to the key.
+func (L *State) SetMetatable(v Value, mt *Table) {
+	switch v := v.(type) {
+	case *Table:
+		v.meta = mt
+		if mode := weakMode(mt); mode != v.weak {
+			v.setWeak(mode)
+			if mode != 0 {
+				L.g.weak.add(v)
+			}
+		}
+		if mt != nil && mt.GetString("__gc") != nil && !v.finalized {
+			v.finalized = true
+			runtime.SetFinalizer(v, func(t *Table) { L.g.gc.add(t) })
+		}
+	case *Userdata:
+		v.meta = mt
+		if mt != nil && mt.GetString("__gc") != nil && !v.finalized {
+			v.finalized = true
+			runtime.SetFinalizer(v, func(u *Userdata) { L.g.gc.add(u) })
+		}
+	default:
+		if L.g.metatables == nil {
+			L.g.metatables = map[string]*Table{}
+		}
+		L.g.metatables[TypeName(v)] = mt
+	}
+}
+
+// metaField returns the field event of the metatable of v, or nil.
+func (L *State) metaField(v Value, event string) Value {
+	if mt := L.Metatable(v); mt != nil {
+		return mt.GetString(event)
+	}
+	return nil
+}
+
+// objTypeName returns the name of the type of v for error messages:
+// the __name field of its metatable if v is a table or a userdata and
+// the field is a string, or else the name of its type.
+func (L *State) objTypeName(v Value) string {
+	switch v.(type) {
+	case *Table, *Userdata:
+		if name, ok := L.metaField(v, "__name").(string); ok {
+			return name
+		}
+	}
+	return TypeName(v)
+}
+
+// callMeta finds the function that calls a value that is not a
+// function: its __call metamethod, or the __call metamethod of that,
+// and so on. The value is inserted before the arguments.
+func (L *State) callMeta(fn Value, args []Value) (Function, []Value) {
+	for i := 0; ; i++ {
+		tm := L.metaField(fn, "__call")
+		if tm == nil {
+			if i > 0 {
+				L.typeError(fn, "call", -1)
+			}
+			L.typeError(fn, "call", 0)
+		}
+		args = append([]Value{fn}, args...)
+		if f, ok := tm.(Function); ok {
+			return f, args
+		}
+		fn = tm
+	}
+}
+
+// binaryMeta calls the metamethod for event of a, or if a has none, of
+// b, with a and b, and returns its first result. It reports false if
+// neither operand has the metamethod.
+func (L *State) binaryMeta(a, b Value, event string) (Value, bool) {
+	tm := L.metaField(a, event)
+	if tm == nil {
+		if tm = L.metaField(b, event); tm == nil {
+			return nil, false
+		}
+	}
+	return first(L.Call(tm, []Value{a, b})), true
+}
+
+// first returns the first of the values, or nil if there are none.
+func first(vals []Value) Value {
+	if len(vals) == 0 {
+		return nil
+	}
+	return vals[0]
+}
+
+// ----------------------------------------------------------------------------
+// To-be-closed variables
+
+// tbcVar is a to-be-closed variable in scope.
+type tbcVar struct {
+	v     Value
+	level int
+}
+
+// NewTBC declares v the value of the to-be-closed variable name. The
+// value must have a __close metamethod or be nil or false, which are
+// ignored. The level orders the variable among those of its function
+// for CloseTBC.
+func (L *State) NewTBC(v Value, name string, level int) {
+	if v == nil || v == false {
+		return
+	}
+	if L.metaField(v, "__close") == nil {
+		L.RuntimeError("variable '%s' got a non-closable value", name)
+	}
+	L.tbc = append(L.tbc, tbcVar{v: v, level: level})
+}
+
+// TBCMark returns a mark of the to-be-closed variables in scope, to
+// close those declared after it with CloseTBC.
+func (L *State) TBCMark() int {
+	return len(L.tbc)
+}
+
+// CloseTBC closes the to-be-closed variables declared after mark whose
+// level is at least level, the last declared first, by calling their
+// __close metamethods with the value and err.
+func (L *State) CloseTBC(mark, level int, err Value) {
+	for len(L.tbc) > mark {
+		v := L.tbc[len(L.tbc)-1]
+		if v.level < level {
+			break
+		}
+		L.tbc = L.tbc[:len(L.tbc)-1]
+		L.Call(L.metaField(v.v, "__close"), []Value{v.v, err})
+	}
+}
+
+// closeProtected closes the to-be-closed variables declared after mark
+// for the error e. An error raised by a __close metamethod becomes the
+// error passed to the next ones, and is returned.
+func (L *State) closeProtected(mark int, e *Error) *Error {
+	depth := L.depth
+	for len(L.tbc) > mark {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err, ok := r.(*Error)
+					if !ok {
+						panic(r)
+					}
+					L.unwind(depth)
+					e = err
+				}
+			}()
+			L.CloseTBC(mark, 0, e.Value)
+		}()
+	}
+	return e
+}
+
+// ----------------------------------------------------------------------------
+// Finalizers
+
+// finalizers is the queue of the objects whose __gc metamethods are
+// due. It is filled by the finalizers the Go runtime runs.
+type finalizers struct {
+	mu      sync.Mutex
+	pending []Value
+	n       int32 // len(pending), read without the lock
+	running bool  // set while the state calls the metamethods
+}
+
+func (f *finalizers) add(v Value) {
+	f.mu.Lock()
+	f.pending = append(f.pending, v)
+	atomic.StoreInt32(&f.n, int32(len(f.pending)))
+	f.mu.Unlock()
+}
+
+// due reports whether there are finalizers to run.
+func (f *finalizers) due() bool {
+	return atomic.LoadInt32(&f.n) != 0 && !f.running
+}
+
+func (f *finalizers) take() []Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vals := f.pending
+	f.pending = nil
+	atomic.StoreInt32(&f.n, 0)
+	return vals
+}
+
+// runFinalizers calls the __gc metamethods of the objects that were
+// found to be garbage. An error in a metamethod is ignored.
+func (L *State) runFinalizers() {
+	gc := &L.g.gc
+	gc.running = true
+	defer func() { gc.running = false }()
+	for _, v := range gc.take() {
+		if tm := L.metaField(v, "__gc"); tm != nil {
+			L.PCall(tm, []Value{v})
+		}
+	}
+}
+
+// CollectGarbage runs a full garbage collection, clears the entries of
+// the weak tables whose keys or values it found to be garbage, and then
+// calls the __gc metamethods of the objects it found to be garbage.
+func (L *State) CollectGarbage() {
+	for i := 0; i < 2; i++ {
+		// the sentinel is finalized after the collection has queued
+		// the finalizers it found; the finalizers of the first round
+		// may run after its sentinel, but they have all run by the
+		// time the sentinel of the second one has
+		done := make(chan struct{})
+		sentinel := &struct{ _ [16]byte }{}
+		runtime.SetFinalizer(sentinel, func(interface{}) { close(done) })
+		sentinel = nil
+		runtime.GC()
+		<-done
+	}
+	L.g.weak.sweep()
+	L.runFinalizers()
+}
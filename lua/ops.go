@@ -0,0 +1,512 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mdhender/glua/arith"
+	"github.com/mdhender/glua/lexer"
+)
+
+// Op is an arithmetic or bitwise operator.
+type Op int
+
+const (
+	OpAdd  Op = iota // +
+	OpSub            // -
+	OpMul            // *
+	OpMod            // %
+	OpPow            // ^
+	OpDiv            // /
+	OpIDiv           // //
+	OpBand           // &
+	OpBor            // |
+	OpBxor           // ~
+	OpShl            // <<
+	OpShr            // >>
+	OpUnm            // unary -
+	OpBnot           // unary ~
+)
+
+// events holds the names of the metamethods of the operators.
+var events = [...]string{
+	OpAdd:  "__add",
+	OpSub:  "__sub",
+	OpMul:  "__mul",
+	OpMod:  "__mod",
+	OpPow:  "__pow",
+	OpDiv:  "__div",
+	OpIDiv: "__idiv",
+	OpBand: "__band",
+	OpBor:  "__bor",
+	OpBxor: "__bxor",
+	OpShl:  "__shl",
+	OpShr:  "__shr",
+	OpUnm:  "__unm",
+	OpBnot: "__bnot",
+}
+
+// isBitwise reports whether op works on integers only.
+func (op Op) isBitwise() bool {
+	return OpBand <= op && op <= OpShr || op == OpBnot
+}
+
+// Arith returns the result of a op b. For the unary operators, b is
+// ignored; by convention it is a copy of a. Strings that read as
+// numbers are converted to numbers. If the operands are not numbers,
+// the metamethod of the operator is called.
+func (L *State) Arith(op Op, a, b Value) Value {
+	if v, ok := L.arithNumbers(op, a, b); ok {
+		return v
+	}
+	if x, y, ok := coerce(a, b); ok {
+		if v, ok := L.arithNumbers(op, x, y); ok {
+			return v
+		}
+	}
+	if v, ok := L.binaryMeta(a, b, events[op]); ok {
+		return v
+	}
+	if op.isBitwise() {
+		if isNumber(a) && isNumber(b) {
+			i := 1
+			if _, ok := toInteger(a); !ok {
+				i = 0
+			}
+			L.RuntimeError("number%s has no integer representation", L.varInfo(i))
+		}
+		// a string is blamed even if it reads as a number
+		if !isNumber(a) {
+			L.typeError(a, "perform bitwise operation on", 0)
+		}
+		L.typeError(b, "perform bitwise operation on", 1)
+	}
+	if _, ok := ToNumber(a); !ok {
+		L.typeError(a, "perform arithmetic on", 0)
+	}
+	L.typeError(b, "perform arithmetic on", 1)
+	return nil
+}
+
+// coerce converts the operands of an arithmetic operator to numbers
+// if at least one of them is a string and both read as numbers.
+func coerce(a, b Value) (x, y Value, ok bool) {
+	_, s1 := a.(string)
+	_, s2 := b.(string)
+	if !s1 && !s2 {
+		return nil, nil, false
+	}
+	x, ok1 := ToNumber(a)
+	y, ok2 := ToNumber(b)
+	return x, y, ok1 && ok2
+}
+
+// ToNumber converts v to a number if it is a number or a string that
+// reads as one, as a numeral of the language with optional surrounding
+// whitespace and sign.
+func ToNumber(v Value) (Value, bool) {
+	switch x := v.(type) {
+	case int64, float64:
+		return x, true
+	case string:
+		n, ok := lexer.ParseNumber([]byte(x))
+		if !ok {
+			return nil, false
+		}
+		if n.IsFloat {
+			return n.Float, true
+		}
+		return n.Int, true
+	}
+	return nil, false
+}
+
+// ToInteger converts v to an integer if it is a number or a string
+// that reads as one, and its value is an exact integer.
+func ToInteger(v Value) (int64, bool) {
+	n, ok := ToNumber(v)
+	if !ok {
+		return 0, false
+	}
+	return toInteger(n)
+}
+
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// arithNumbers computes a op b when both operands are numbers and
+// reports false when they are not, or when a bitwise operand has no
+// integer representation.
+func (L *State) arithNumbers(op Op, a, b Value) (Value, bool) {
+	if op.isBitwise() {
+		i, ok1 := toInteger(a)
+		j, ok2 := toInteger(b)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		switch op {
+		case OpBand:
+			return i & j, true
+		case OpBor:
+			return i | j, true
+		case OpBxor:
+			return i ^ j, true
+		case OpShl:
+			return arith.ShiftLeft(i, j), true
+		case OpShr:
+			return arith.ShiftRight(i, j), true
+		}
+		return ^i, true
+	}
+	if i, ok := a.(int64); ok && op != OpDiv && op != OpPow {
+		if j, ok := b.(int64); ok {
+			switch op {
+			case OpAdd:
+				return int64(uint64(i) + uint64(j)), true
+			case OpSub:
+				return int64(uint64(i) - uint64(j)), true
+			case OpMul:
+				return int64(uint64(i) * uint64(j)), true
+			case OpMod:
+				if j == 0 {
+					L.RuntimeError("attempt to perform 'n%%0'")
+				}
+				return arith.Mod(i, j), true
+			case OpIDiv:
+				if j == 0 {
+					L.RuntimeError("attempt to perform 'n//0'")
+				}
+				return arith.FloorDiv(i, j), true
+			}
+			return int64(-uint64(i)), true // OpUnm
+		}
+	}
+	x, ok1 := toFloat(a)
+	y, ok2 := toFloat(b)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	switch op {
+	case OpAdd:
+		return x + y, true
+	case OpSub:
+		return x - y, true
+	case OpMul:
+		return x * y, true
+	case OpMod:
+		return arith.FloatMod(x, y), true
+	case OpPow:
+		return arith.Pow(x, y), true
+	case OpDiv:
+		return x / y, true
+	case OpIDiv:
+		return arith.FloatFloorDiv(x, y), true
+	}
+	return -x, true // OpUnm
+}
+
+// toInteger converts a number with an exact integer value to an integer.
+func toInteger(v Value) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return arith.FloatToInteger(v)
+	}
+	return 0, false
+}
+
+// toFloat converts a number to a float.
+func toFloat(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Equal reports whether a == b. Two different tables or two different
+// userdata are compared by the __eq metamethod of the first, or of the
+// second if the first has none.
+func (L *State) Equal(a, b Value) bool {
+	if RawEqual(a, b) {
+		return true
+	}
+	switch a.(type) {
+	case *Table:
+		if _, ok := b.(*Table); !ok {
+			return false
+		}
+	case *Userdata:
+		if _, ok := b.(*Userdata); !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	v, _ := L.binaryMeta(a, b, "__eq")
+	return Truth(v)
+}
+
+// Less reports whether a < b.
+func (L *State) Less(a, b Value) bool {
+	if lt, ok := lessNumbers(a, b, false); ok {
+		return lt
+	}
+	if s, ok := a.(string); ok {
+		if t, ok := b.(string); ok {
+			return s < t
+		}
+	}
+	if v, ok := L.binaryMeta(a, b, "__lt"); ok {
+		return Truth(v)
+	}
+	L.orderError(a, b)
+	return false
+}
+
+// LessEqual reports whether a <= b.
+func (L *State) LessEqual(a, b Value) bool {
+	if le, ok := lessNumbers(a, b, true); ok {
+		return le
+	}
+	if s, ok := a.(string); ok {
+		if t, ok := b.(string); ok {
+			return s <= t
+		}
+	}
+	if v, ok := L.binaryMeta(a, b, "__le"); ok {
+		return Truth(v)
+	}
+	L.orderError(a, b)
+	return false
+}
+
+// lessNumbers compares two numbers exactly, whatever their subtypes.
+// It reports false if either value is not a number.
+func lessNumbers(a, b Value, orEqual bool) (lt, ok bool) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a < b || orEqual && a == b, true
+		case float64:
+			if orEqual {
+				return arith.LessEqualIntFloat(a, b), true
+			}
+			return arith.LessIntFloat(a, b), true
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			if orEqual {
+				return arith.LessEqualFloatInt(a, b), true
+			}
+			return arith.LessFloatInt(a, b), true
+		case float64:
+			return a < b || orEqual && a == b, true
+		}
+	}
+	return false, false
+}
+
+func (L *State) orderError(a, b Value) {
+	t1, t2 := L.objTypeName(a), L.objTypeName(b)
+	if t1 == t2 {
+		L.RuntimeError("attempt to compare two %s values", t1)
+	}
+	L.RuntimeError("attempt to compare %s with %s", t1, t2)
+}
+
+// Concat returns a .. b.
+func (L *State) Concat(a, b Value) Value {
+	s, ok1 := toStringCoerce(a)
+	t, ok2 := toStringCoerce(b)
+	if !ok1 || !ok2 {
+		if v, ok := L.binaryMeta(a, b, "__concat"); ok {
+			return v
+		}
+		if ok1 {
+			L.typeError(b, "concatenate", 1)
+		}
+		L.typeError(a, "concatenate", 0)
+	}
+	return s + t
+}
+
+// toStringCoerce converts a string or a number to a string, as
+// concatenation does.
+func toStringCoerce(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64, float64:
+		return tostring(v), true
+	}
+	return "", false
+}
+
+// Len returns #v. The length of a string is its number of bytes; for
+// any other value the __len metamethod takes precedence, and a table
+// without one has its border as length.
+func (L *State) Len(v Value) Value {
+	if s, ok := v.(string); ok {
+		return int64(len(s))
+	}
+	if tm := L.metaField(v, "__len"); tm != nil {
+		return first(L.Call(tm, []Value{v, v}))
+	}
+	if t, ok := v.(*Table); ok {
+		return t.Len()
+	}
+	L.typeError(v, "get length of", 0)
+	return nil
+}
+
+// Index returns obj[key]. A key absent from a table, or any key of a
+// value that is not a table, is looked up through the __index
+// metamethod: a function is called with obj and key, and any other
+// value is indexed in turn.
+func (L *State) Index(obj, key Value) Value {
+	for loop := 0; loop < maxTagLoop; loop++ {
+		var tm Value
+		if t, ok := obj.(*Table); ok {
+			v := t.Get(key)
+			if v != nil || t.meta == nil {
+				return v
+			}
+			if tm = t.meta.GetString("__index"); tm == nil {
+				return nil
+			}
+		} else if tm = L.metaField(obj, "__index"); tm == nil {
+			if loop == 0 {
+				L.typeError(obj, "index", 0)
+			}
+			L.typeError(obj, "index", -1)
+		}
+		if _, ok := tm.(Function); ok {
+			return first(L.Call(tm, []Value{obj, key}))
+		}
+		obj = tm
+	}
+	L.RuntimeError("'__index' chain too long; possibly a loop")
+	return nil
+}
+
+// SetIndex sets obj[key] to val. A key absent from a table, or any key
+// of a value that is not a table, is assigned through the __newindex
+// metamethod: a function is called with obj, key and val, and in any
+// other value the assignment is made in turn.
+func (L *State) SetIndex(obj, key, val Value) {
+	for loop := 0; loop < maxTagLoop; loop++ {
+		var tm Value
+		if t, ok := obj.(*Table); ok {
+			if t.meta == nil || t.Get(key) != nil {
+				L.checkKey(key)
+				t.Set(key, val)
+				return
+			}
+			if tm = t.meta.GetString("__newindex"); tm == nil {
+				L.checkKey(key)
+				t.Set(key, val)
+				return
+			}
+		} else if tm = L.metaField(obj, "__newindex"); tm == nil {
+			if loop == 0 {
+				L.typeError(obj, "index", 0)
+			}
+			L.typeError(obj, "index", -1)
+		}
+		if _, ok := tm.(Function); ok {
+			L.Call(tm, []Value{obj, key, val})
+			return
+		}
+		obj = tm
+	}
+	L.RuntimeError("'__newindex' chain too long; possibly a loop")
+}
+
+// checkKey raises an error for a key that cannot be stored in a table.
+func (L *State) checkKey(key Value) {
+	switch k := key.(type) {
+	case nil:
+		L.RuntimeError("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			L.RuntimeError("table index is NaN")
+		}
+	}
+}
+
+// ToString converts v to a string, as tostring does. A value with a
+// __tostring metamethod is converted by it, and a table or userdata
+// with a string __name field is named by it.
+func (L *State) ToString(v Value) string {
+	if tm := L.metaField(v, "__tostring"); tm != nil {
+		switch s := first(L.Call(tm, []Value{v})).(type) {
+		case string:
+			return s
+		case int64, float64:
+			return tostring(s)
+		}
+		L.RuntimeError("'__tostring' must return a string")
+	}
+	switch v.(type) {
+	case *Table, *Userdata:
+		if name, ok := L.metaField(v, "__name").(string); ok {
+			return fmt.Sprintf("%s: %p", name, v)
+		}
+	}
+	return tostring(v)
+}
+
+// tostring converts v to a string without calling metamethods.
+func tostring(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case int64:
+		return arith.FormatInt(v)
+	case float64:
+		return arith.FormatFloat(v)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%s: %p", TypeName(v), v)
+}
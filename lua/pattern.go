@@ -0,0 +1,449 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+// This file implements the patterns of the string library, following
+// the matcher of the reference implementation.
+
+const (
+	maxCaptures   = 32  // captures in a pattern
+	maxMatchDepth = 200 // nested calls of match, to stop runaway patterns
+
+	capUnfinished = -1 // length of a capture not yet closed
+	capPosition   = -2 // length of a position capture
+)
+
+// matchState is the state of a match of a pattern against a subject
+// string. Positions are byte offsets; -1 is a failed match.
+type matchState struct {
+	L          *State
+	src, pat   string
+	level      int // captures started
+	matchDepth int
+	capture    [maxCaptures]struct{ init, len int }
+}
+
+func newMatchState(L *State, src, pat string) *matchState {
+	return &matchState{L: L, src: src, pat: pat, matchDepth: maxMatchDepth}
+}
+
+// reset prepares the state for another match.
+func (ms *matchState) reset() {
+	ms.level = 0
+	ms.matchDepth = maxMatchDepth
+}
+
+// srcAt returns the byte at s, or 0 at the end of the subject, as the
+// terminating zero of a C string.
+func (ms *matchState) srcAt(s int) byte {
+	if s < len(ms.src) {
+		return ms.src[s]
+	}
+	return 0
+}
+
+// patAt returns the byte at p, or 0 at the end of the pattern.
+func (ms *matchState) patAt(p int) byte {
+	if p < len(ms.pat) {
+		return ms.pat[p]
+	}
+	return 0
+}
+
+// classEnd returns the position after the single character class that
+// starts at p.
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(ms.pat) {
+			ms.L.Errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if ms.patAt(p) == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(ms.pat) {
+				ms.L.Errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++ // skip escapes such as '%]'
+			}
+			if ms.patAt(p) == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+// matchClass reports whether c is in the class of the letter cl, as in
+// %a; any other character stands for itself.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch lower(cl) {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = isGraph(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isXDigit(c)
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass reports whether c is in the set [...] from p to
+// the closing bracket at ec.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.patAt(p+1) == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.patAt(p)) {
+				return sig
+			}
+		case ms.patAt(p+1) == '-' && p+2 < ec:
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+// singleMatch reports whether the byte at s matches the single
+// character class from p to ep.
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.patAt(p+1))
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the subject from s, and
+// returns the end of the match, or -1.
+func (ms *matchState) match(s, p int) int {
+	ms.matchDepth--
+	if ms.matchDepth == 0 {
+		ms.L.Errorf("pattern too complex")
+	}
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if ms.patAt(p+1) == ')' {
+				s = ms.startCapture(s, p+2, capPosition)
+			} else {
+				s = ms.startCapture(s, p+1, capUnfinished)
+			}
+			ms.matchDepth++
+			return s
+		case ')':
+			s = ms.endCapture(s, p+1)
+			ms.matchDepth++
+			return s
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s != len(ms.src) {
+					s = -1
+				}
+				ms.matchDepth++
+				return s
+			}
+		case '%':
+			switch c := ms.patAt(p + 1); {
+			case c == 'b':
+				if s = ms.matchBalance(s, p+2); s != -1 {
+					p += 4
+					continue
+				}
+				ms.matchDepth++
+				return -1
+			case c == 'f':
+				p += 2
+				if ms.patAt(p) != '[' {
+					ms.L.Errorf("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p)
+				var prev byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(ms.srcAt(s), p, ep-1) {
+					p = ep
+					continue
+				}
+				ms.matchDepth++
+				return -1
+			case isDigit(c):
+				if s = ms.matchCapture(s, c); s != -1 {
+					p += 2
+					continue
+				}
+				ms.matchDepth++
+				return -1
+			}
+		}
+		// a single character class with an optional suffix
+		ep := ms.classEnd(p)
+		suffix := ms.patAt(ep)
+		if !ms.singleMatch(s, p, ep) {
+			if suffix == '*' || suffix == '?' || suffix == '-' {
+				p = ep + 1 // accept an empty match
+				continue
+			}
+			s = -1
+			break
+		}
+		switch suffix {
+		case '?':
+			if res := ms.match(s+1, ep+1); res != -1 {
+				s = res
+				break
+			}
+			p = ep + 1
+			continue
+		case '+':
+			s = ms.maxExpand(s+1, p, ep)
+		case '*':
+			s = ms.maxExpand(s, p, ep)
+		case '-':
+			s = ms.minExpand(s, p, ep)
+		default:
+			s++
+			p = ep
+			continue
+		}
+		break
+	}
+	ms.matchDepth++
+	return s
+}
+
+// maxExpand matches as many repetitions of the class from p to ep as
+// it can while the rest of the pattern still matches.
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+// minExpand matches as few repetitions of the class from p to ep as
+// it can for the rest of the pattern to match.
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+// matchBalance matches %bxy at s, with the x and y at p.
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.L.Errorf("malformed pattern (missing arguments to '%%b')")
+	}
+	if ms.srcAt(s) != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		ms.L.Errorf("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.level-- // undo the capture
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.capture[l].len = capUnfinished // undo the capture
+	}
+	return res
+}
+
+// captureToClose returns the innermost capture still open.
+func (ms *matchState) captureToClose() int {
+	for l := ms.level - 1; l >= 0; l-- {
+		if ms.capture[l].len == capUnfinished {
+			return l
+		}
+	}
+	ms.L.Errorf("invalid pattern capture")
+	return 0
+}
+
+// matchCapture matches a back reference %1 to %9 at s.
+func (ms *matchState) matchCapture(s int, c byte) int {
+	l := ms.checkCapture(c)
+	n := ms.capture[l].len
+	init := ms.capture[l].init
+	if n >= 0 && len(ms.src)-s >= n && ms.src[init:init+n] == ms.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+func (ms *matchState) checkCapture(c byte) int {
+	l := int(c) - '1'
+	if l < 0 || l >= ms.level || ms.capture[l].len == capUnfinished {
+		ms.L.Errorf("invalid capture index %%%d", l+1)
+	}
+	return l
+}
+
+// getCapture returns capture i of a match from s to e: a string, or
+// the position of a position capture. With no captures, capture 0 is
+// the whole match.
+func (ms *matchState) getCapture(i, s, e int) Value {
+	if i >= ms.level {
+		if i != 0 {
+			ms.L.Errorf("invalid capture index %%%d", i+1)
+		}
+		return ms.src[s:e]
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		ms.L.Errorf("unfinished capture")
+	case capPosition:
+		return int64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// captures returns the captures of a match from s to e, or the whole
+// match if the pattern has no captures and s is not negative.
+func (ms *matchState) captures(s, e int) []Value {
+	n := ms.level
+	if n == 0 && s >= 0 {
+		n = 1
+	}
+	caps := make([]Value, n)
+	for i := range caps {
+		caps[i] = ms.getCapture(i, s, e)
+	}
+	return caps
+}
+
+// The character classes of the C locale.
+
+func isAlpha(c byte) bool  { return isLower(c) || isUpper(c) }
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isLower(c byte) bool  { return 'a' <= c && c <= 'z' }
+func isUpper(c byte) bool  { return 'A' <= c && c <= 'Z' }
+func isCntrl(c byte) bool  { return c < ' ' || c == 0x7f }
+func isGraph(c byte) bool  { return '!' <= c && c <= '~' }
+func isPunct(c byte) bool  { return isGraph(c) && !isAlpha(c) && !isDigit(c) }
+func isSpace(c byte) bool  { return c == ' ' || '\t' <= c && c <= '\r' }
+func isXDigit(c byte) bool { return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' }
+
+func lower(c byte) byte {
+	if isUpper(c) {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func upper(c byte) byte {
+	if isLower(c) {
+		return c - 'a' + 'A'
+	}
+	return c
+}
@@ -0,0 +1,192 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// callString calls the function name of the string library in a new
+// state.
+func callString(name string, args ...Value) ([]Value, error) {
+	L := NewState()
+	lib := L.Globals.GetString("string").(*Table)
+	return L.PCall(lib.GetString(name), args)
+}
+
+func TestPatterns(t *testing.T) {
+	vars := NewTable(0, 0)
+	vars.Set("x", int64(1))
+	vars.Set("y", false)
+	vars.Set("z", 2.5)
+	upper := &GoFunction{Name: "upper", Fn: func(L *State, args []Value) []Value {
+		return []Value{strings.ToUpper(args[0].(string))}
+	}}
+
+	for _, tc := range []struct {
+		fn   string
+		args []Value
+		want []Value
+	}{
+		// character classes
+		{"match", []Value{"abc123", "%a+"}, []Value{"abc"}},
+		{"match", []Value{"abc123", "%d+"}, []Value{"123"}},
+		{"match", []Value{"a b\tc", "%s"}, []Value{" "}},
+		{"match", []Value{"x,y", "%p"}, []Value{","}},
+		{"match", []Value{"aB", "%u"}, []Value{"B"}},
+		{"match", []Value{"aB", "%l"}, []Value{"a"}},
+		{"match", []Value{"zz_ff", "%x+"}, []Value{"ff"}},
+		{"match", []Value{"a1_", "%w+"}, []Value{"a1"}},
+		{"match", []Value{"a\x01b", "%c"}, []Value{"\x01"}},
+		{"match", []Value{"  ab", "%g+"}, []Value{"ab"}},
+		{"match", []Value{"123abc", "%D+"}, []Value{"abc"}},
+		{"match", []Value{"a.b", "%."}, []Value{"."}},
+		{"match", []Value{"a\x00b", "a.b"}, []Value{"a\x00b"}},
+
+		// sets
+		{"match", []Value{"hello", "[aeiou]+"}, []Value{"e"}},
+		{"match", []Value{"hello", "[^aeiou]+"}, []Value{"h"}},
+		{"match", []Value{"x-y", "[a-]+"}, []Value{"-"}},
+		{"match", []Value{"A9z", "[%d%u]+"}, []Value{"A9"}},
+		{"match", []Value{"]x", "[]]"}, []Value{"]"}},
+		{"match", []Value{"a^", "[%^]"}, []Value{"^"}},
+		{"match", []Value{"m", "[a-z]"}, []Value{"m"}},
+
+		// repetition and anchors
+		{"match", []Value{"aaa", "a*"}, []Value{"aaa"}},
+		{"match", []Value{"aaa", "a-"}, []Value{""}},
+		{"match", []Value{"<a><b>", "<.->"}, []Value{"<a>"}},
+		{"match", []Value{"<a><b>", "<.*>"}, []Value{"<a><b>"}},
+		{"match", []Value{"color", "colou?r"}, []Value{"color"}},
+		{"match", []Value{"bbb", "a+"}, []Value{nil}},
+		{"match", []Value{"abc", "^b"}, []Value{nil}},
+		{"match", []Value{"abc", "c$"}, []Value{"c"}},
+		{"match", []Value{"a$b", "$b"}, []Value{"$b"}},
+		{"match", []Value{"ab", "^(a)(b)$"}, []Value{"a", "b"}},
+
+		// captures
+		{"match", []Value{"hello world", "(%w+) (%w+)"}, []Value{"hello", "world"}},
+		{"match", []Value{"hello", "()ll()"}, []Value{int64(3), int64(5)}},
+		{"match", []Value{"abcabc", "(abc)%1"}, []Value{"abc"}},
+		{"match", []Value{`say "hi" now`, `(["'])(.-)%1`}, []Value{`"`, "hi"}},
+		{"match", []Value{"x-ab", "((%a)(%a))"}, []Value{"ab", "a", "b"}},
+
+		// balance and frontier
+		{"match", []Value{"f(a(b)c) d", "%b()"}, []Value{"(a(b)c)"}},
+		{"match", []Value{"(unclosed", "%b()"}, []Value{nil}},
+		{"match", []Value{"THE (quick) fox", "%f[%a]%a+"}, []Value{"THE"}},
+		{"gsub", []Value{"the cat in the hat", "%f[%w]%w+", "X"}, []Value{"X X X X X", int64(5)}},
+
+		// init and plain find
+		{"find", []Value{"hello", "l"}, []Value{int64(3), int64(3)}},
+		{"find", []Value{"hello", "l", int64(4)}, []Value{int64(4), int64(4)}},
+		{"find", []Value{"hello", "l", int64(-1)}, []Value{nil}},
+		{"find", []Value{"hello", "xyz"}, []Value{nil}},
+		{"find", []Value{"a+b", "+", int64(1), true}, []Value{int64(2), int64(2)}},
+		{"find", []Value{"abc", "", int64(4)}, []Value{int64(4), int64(3)}},
+		{"find", []Value{"abc", "", int64(5)}, []Value{nil}},
+		{"find", []Value{"key=val", "(%w+)=(%w+)"}, []Value{int64(1), int64(7), "key", "val"}},
+		{"match", []Value{"hello", ".", int64(-2)}, []Value{"l"}},
+
+		// substitutions
+		{"gsub", []Value{"hello world", "o", "0"}, []Value{"hell0 w0rld", int64(2)}},
+		{"gsub", []Value{"hello world", "o", "0", int64(1)}, []Value{"hell0 world", int64(1)}},
+		{"gsub", []Value{"abc", "%w", "%0%0"}, []Value{"aabbcc", int64(3)}},
+		{"gsub", []Value{"abc", "", "-"}, []Value{"-a-b-c-", int64(4)}},
+		{"gsub", []Value{"hello world", "(%w+) (%w+)", "%2 %1"}, []Value{"world hello", int64(1)}},
+		{"gsub", []Value{"50%", "%%", "%%%%"}, []Value{"50%%", int64(1)}},
+		{"gsub", []Value{"a,b,,c", "[^,]*", "#"}, []Value{"#,#,#,#", int64(4)}},
+		{"gsub", []Value{"$x $y $z", "%$(%w+)", vars}, []Value{"1 $y 2.5", int64(3)}},
+		{"gsub", []Value{"abc", "b", upper}, []Value{"aBc", int64(1)}},
+		{"gsub", []Value{"abc", "(a)(b)", upper}, []Value{"Ac", int64(1)}},
+	} {
+		got, err := callString(tc.fn, tc.args...)
+		if err != nil {
+			t.Errorf("string.%s%v: %v", tc.fn, tc.args, err)
+			continue
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tc.want) {
+			t.Errorf("string.%s%q = %q, want %q", tc.fn, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestGmatch(t *testing.T) {
+	for _, tc := range []struct {
+		s, pat string
+		want   string
+	}{
+		{"one two  three", "%a+", "[[one] [two] [three]]"},
+		{"a=1, b=2", "(%w+)=(%w+)", "[[a 1] [b 2]]"},
+		{"abc", "", "[[] [] [] []]"},
+		{"abc", "()", "[[1] [2] [3] [4]]"},
+		{"xyz", "%d", `[]`},
+	} {
+		L := NewState()
+		lib := L.Globals.GetString("string").(*Table)
+		iter := L.Call(lib.GetString("gmatch"), []Value{tc.s, tc.pat})[0]
+		var got [][]Value
+		for {
+			caps := L.Call(iter, nil)
+			if len(caps) == 0 || caps[0] == nil {
+				break
+			}
+			got = append(got, caps)
+		}
+		if s := fmt.Sprint(got); s != tc.want {
+			t.Errorf("string.gmatch(%q, %q) produced %s, want %s", tc.s, tc.pat, s, tc.want)
+		}
+	}
+}
+
+func TestPatternError(t *testing.T) {
+	for _, tc := range []struct {
+		fn   string
+		args []Value
+		want string
+	}{
+		{"find", []Value{"a", "%"}, "malformed pattern (ends with '%')"},
+		{"find", []Value{"a", "[a"}, "malformed pattern (missing ']')"},
+		{"find", []Value{"a", "[a%"}, "malformed pattern (missing ']')"},
+		{"find", []Value{"a", "%b"}, "malformed pattern (missing arguments to '%b')"},
+		{"find", []Value{"a", "%f"}, "missing '[' after '%f' in pattern"},
+		{"find", []Value{"a", "(a"}, "unfinished capture"},
+		{"match", []Value{"a", "a)"}, "invalid pattern capture"},
+		{"match", []Value{"a", "%1"}, "invalid capture index %1"},
+		{"match", []Value{"aa", "(a%1)"}, "invalid capture index %1"},
+		{"gsub", []Value{"a", "(a)", "%2"}, "invalid capture index %2"},
+		{"gsub", []Value{"a", "a", "%x"}, "invalid use of '%' in replacement string"},
+		{"gsub", []Value{"a", "a", true}, "bad argument #3 to 'gsub' (string/function/table expected, got boolean)"},
+		{"match", []Value{"a", strings.Repeat("(", 33) + strings.Repeat(")", 33)}, "too many captures"},
+		{"match", []Value{strings.Repeat("a", 300), strings.Repeat("a?", 300)}, "pattern too complex"},
+	} {
+		_, err := callString(tc.fn, tc.args...)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("string.%s%q error = %v, want %s", tc.fn, tc.args, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// MaxCallDepth is the number of nested calls allowed before a call
+// fails with a stack overflow.
+const MaxCallDepth = 200000
+
+// handlerDepth is the number of nested calls a message handler may make
+// beyond MaxCallDepth, to handle a stack overflow.
+const handlerDepth = 1000
+
+// State is the state of a running Lua program.
+type State struct {
+	Globals *Table    // the global environment, the value of _ENV in a main chunk
+	Stdout  io.Writer // where print writes
+	Stderr  io.Writer // where warn writes
+	Compile Compiler  // compiles the chunks of load; nil if they cannot be compiled
+
+	g     *global     // the part of the state shared by its threads
+	calls []*CallInfo // active calls; only the first depth are in use
+	depth int
+	limit int      // the depth at which a call overflows the stack
+	tbc   []tbcVar // to-be-closed variables in scope, innermost last
+}
+
+// A Compiler compiles the source of a chunk to its main function,
+// whose _ENV is env. The name of the chunk is used in messages. A
+// syntax error is returned as an error whose message is the one the
+// chunk fails to load with.
+type Compiler func(L *State, name string, src []byte, env Value) (Function, error)
+
+// global is the part of a state that its threads share.
+type global struct {
+	metatables map[string]*Table // metatables of the types other than table and userdata
+	gc         finalizers
+	weak       weakTables
+	warn       bool // set if warnings are on
+
+	// settings of collectgarbage, which the Go collector ignores
+	gcStopped      bool
+	gcGenerational bool
+	gcParams       [2]int64 // pause and step multiplier
+}
+
+// CallInfo is an active call.
+type CallInfo struct {
+	Func Function
+
+	// Frame is the activation of a Lua function, set by the evaluator
+	// when the function starts running. It is nil for a Go function.
+	Frame Frame
+}
+
+// Frame is the activation of a Lua function, which tells error
+// messages where the error happened.
+type Frame interface {
+	// Where returns the name of the chunk and the line being run.
+	Where() (chunk string, line int)
+
+	// VarInfo describes the variable that operand i of the operation
+	// being run was read from, as in " (local 'x')", or returns ""
+	// if the operand is not a named variable.
+	VarInfo(i int) string
+}
+
+// NewState returns a new state whose global environment holds the
+// base library, the math library and the string library.
+func NewState() *State {
+	L := &State{
+		Globals: NewTable(0, 0),
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		g:       &global{gcParams: [2]int64{200, 100}},
+		limit:   MaxCallDepth,
+	}
+	openBase(L)
+	openMath(L)
+	openString(L)
+	return L
+}
+
+// NewThread returns a new thread that shares the global environment
+// of L and has a call stack of its own.
+func (L *State) NewThread() *Thread {
+	return &Thread{L: &State{
+		Globals: L.Globals,
+		Stdout:  L.Stdout,
+		Stderr:  L.Stderr,
+		Compile: L.Compile,
+		g:       L.g,
+		limit:   MaxCallDepth,
+	}}
+}
+
+// Register sets the global name to the Go function fn.
+func (L *State) Register(name string, fn func(L *State, args []Value) []Value) {
+	L.Globals.Set(name, &GoFunction{Name: name, Fn: fn})
+}
+
+// Call calls fn with the arguments and returns its results. A value
+// that is not a function is called through its __call metamethod,
+// which gets the value as its first argument.
+func (L *State) Call(fn Value, args []Value) []Value {
+	f, ok := fn.(Function)
+	if !ok {
+		f, args = L.callMeta(fn, args)
+	}
+	if L.g.gc.due() {
+		L.runFinalizers()
+	}
+	if L.depth >= L.limit {
+		L.RuntimeError("stack overflow")
+	}
+	if L.depth == len(L.calls) {
+		L.calls = append(L.calls, &CallInfo{})
+	}
+	ci := L.calls[L.depth]
+	ci.Func, ci.Frame = f, nil
+	L.depth++
+	rets := f.Call(L, args)
+	L.depth--
+	ci.Func, ci.Frame = nil, nil
+	return rets
+}
+
+// PCall calls fn in protected mode: an error raised by the call is
+// returned instead of propagated. The to-be-closed variables that the
+// error leaves are closed with the error, and an error raised while
+// closing them replaces it.
+func (L *State) PCall(fn Value, args []Value) (rets []Value, err error) {
+	return L.XPCall(fn, args, nil)
+}
+
+// XPCall is like PCall, but the value of an error is passed to the
+// message handler h, if it is not nil, and replaced by what h returns.
+// The handler runs before the calls that the error left end, so it
+// can inspect them. An error in the handler is reported as an error
+// in error handling.
+func (L *State) XPCall(fn Value, args []Value, h Value) (rets []Value, err error) {
+	depth, mark := L.depth, len(L.tbc)
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			if h != nil {
+				e = L.handle(h, e)
+			}
+			L.unwind(depth)
+			rets, err = nil, L.closeProtected(mark, e)
+		}
+	}()
+	return L.Call(fn, args), nil
+}
+
+// handle calls the message handler h for the error e and returns the
+// error it turns e into.
+func (L *State) handle(h Value, e *Error) (handled *Error) {
+	depth, limit := L.depth, L.limit
+	L.limit = MaxCallDepth + handlerDepth
+	defer func() {
+		L.limit = limit
+		if r := recover(); r != nil {
+			if _, ok := r.(*Error); !ok {
+				panic(r)
+			}
+			L.unwind(depth)
+			handled = &Error{Value: "error in error handling"}
+		}
+	}()
+	return &Error{Value: first(L.Call(h, []Value{e.Value}))}
+}
+
+// unwind ends the calls above depth, which an error left.
+func (L *State) unwind(depth int) {
+	for L.depth > depth {
+		L.depth--
+		L.calls[L.depth].Func, L.calls[L.depth].Frame = nil, nil
+	}
+}
+
+// CurrentCall returns the innermost active call.
+func (L *State) CurrentCall() *CallInfo {
+	return L.calls[L.depth-1]
+}
+
+// Where returns the position of the call at the given level as
+// "chunk:line: ", or "" for a Go function. Level 0 is the current
+// call, level 1 the function that called it, and so on.
+func (L *State) Where(level int) string {
+	if level < 0 || level >= L.depth {
+		return ""
+	}
+	if fr := L.calls[L.depth-1-level].Frame; fr != nil {
+		chunk, line := fr.Where()
+		return fmt.Sprintf("%s:%d: ", chunk, line)
+	}
+	return ""
+}
+
+// Error is a Lua error. Value is the error object, which is usually
+// a message but can be any value.
+type Error struct {
+	Value Value
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	switch v := e.Value.(type) {
+	case string:
+		return v
+	case int64, float64:
+		return tostring(v)
+	}
+	return fmt.Sprintf("(error object is a %s value)", TypeName(e.Value))
+}
+
+// Raise raises an error with the given value.
+func (L *State) Raise(v Value) {
+	panic(&Error{Value: v})
+}
+
+// Errorf raises an error with a formatted message, prefixed with the
+// position of the function that called the current one. It is meant
+// for Go functions reporting errors in their arguments.
+func (L *State) Errorf(format string, args ...interface{}) {
+	L.Raise(L.Where(1) + fmt.Sprintf(format, args...))
+}
+
+// ArgError raises an error for argument n of the running Go function,
+// numbered from 1.
+func (L *State) ArgError(n int, format string, args ...interface{}) {
+	name := "?"
+	if f, ok := L.CurrentCall().Func.(*GoFunction); ok {
+		name = f.Name
+	}
+	L.Errorf("bad argument #%d to '%s' (%s)", n, name, fmt.Sprintf(format, args...))
+}
+
+// typeArgError raises an error for argument n of the running Go
+// function, which should have been a value of the type want.
+func (L *State) typeArgError(n int, want string, args []Value) {
+	got := "no value"
+	if n <= len(args) {
+		got = L.objTypeName(args[n-1])
+	}
+	L.ArgError(n, "%s expected, got %s", want, got)
+}
+
+// RuntimeError raises an error with a formatted message, prefixed with
+// the position in the current function if it is a Lua function.
+func (L *State) RuntimeError(format string, args ...interface{}) {
+	L.Raise(L.Where(0) + fmt.Sprintf(format, args...))
+}
+
+// typeError raises an error for an operation applied to a value of
+// the wrong type. The value is operand i of the current operation, or
+// if i is negative, a value the operation found on its own.
+func (L *State) typeError(v Value, op string, i int) {
+	L.RuntimeError("attempt to %s a %s value%s", op, L.objTypeName(v), L.varInfo(i))
+}
+
+// varInfo describes operand i of the current operation.
+func (L *State) varInfo(i int) string {
+	if L.depth == 0 || i < 0 {
+		return ""
+	}
+	if fr := L.CurrentCall().Frame; fr != nil {
+		return fr.VarInfo(i)
+	}
+	return ""
+}
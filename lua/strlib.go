@@ -0,0 +1,357 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"strings"
+)
+
+// maxStringSize is the length of the longest string that rep builds.
+const maxStringSize = 1<<31 - 1
+
+// openString registers the string library and makes it the __index of
+// the metatable that all strings share, so that s:rep(3) calls
+// string.rep.
+func openString(L *State) {
+	s := newLib([]libFunc{
+		{"byte", strByte},
+		{"char", strChar},
+		{"find", strFind},
+		{"format", strFormat},
+		{"gmatch", strGmatch},
+		{"gsub", strGsub},
+		{"len", strLen},
+		{"lower", strLower},
+		{"match", strMatch},
+		{"rep", strRep},
+		{"reverse", strReverse},
+		{"sub", strSub},
+		{"upper", strUpper},
+	})
+	L.Globals.Set("string", s)
+	mt := NewTable(0, 1)
+	mt.Set("__index", s)
+	L.SetMetatable("", mt)
+}
+
+// startPos converts a position given to a string function, which may
+// count from the end of a string of length n, to one counted from 1.
+// Positions before the start are clipped to 1.
+func startPos(pos int64, n int) int {
+	switch {
+	case pos > 0:
+		return int(pos)
+	case pos == 0 || pos < -int64(n):
+		return 1
+	}
+	return n + int(pos) + 1
+}
+
+// endPos is like startPos for the last position of a slice, which is
+// clipped to the string.
+func (L *State) endPos(args []Value, arg int, def int64, n int) int {
+	pos := L.optInteger(args, arg, def)
+	switch {
+	case pos > int64(n):
+		return n
+	case pos >= 0:
+		return int(pos)
+	case pos < -int64(n):
+		return 0
+	}
+	return n + int(pos) + 1
+}
+
+// strLen returns the length of a string in bytes.
+func strLen(L *State, args []Value) []Value {
+	return []Value{int64(len(L.checkString(args, 1)))}
+}
+
+// strSub returns the substring from i to j, which default to the
+// whole string.
+func strSub(L *State, args []Value) []Value {
+	s := L.checkString(args, 1)
+	i := startPos(L.optInteger(args, 2, 1), len(s))
+	j := L.endPos(args, 3, -1, len(s))
+	if i > j {
+		return []Value{""}
+	}
+	return []Value{s[i-1 : j]}
+}
+
+// strByte returns the codes of the bytes from i to j, which default to
+// the first byte.
+func strByte(L *State, args []Value) []Value {
+	s := L.checkString(args, 1)
+	i := startPos(L.optInteger(args, 2, 1), len(s))
+	j := L.endPos(args, 3, int64(i), len(s))
+	if i > j {
+		return nil
+	}
+	rets := make([]Value, 0, j-i+1)
+	for _, c := range []byte(s[i-1 : j]) {
+		rets = append(rets, int64(c))
+	}
+	return rets
+}
+
+// strChar returns the string of the bytes with the given codes.
+func strChar(L *State, args []Value) []Value {
+	b := make([]byte, len(args))
+	for i := range args {
+		c := L.checkInteger(args, i+1)
+		if uint64(c) > 255 {
+			L.ArgError(i+1, "value out of range")
+		}
+		b[i] = byte(c)
+	}
+	return []Value{string(b)}
+}
+
+// strRep returns n copies of a string, separated by sep.
+func strRep(L *State, args []Value) []Value {
+	s := L.checkString(args, 1)
+	n := L.checkInteger(args, 2)
+	sep := L.optString(args, 3, "")
+	if n <= 0 {
+		return []Value{""}
+	}
+	if l := int64(len(s) + len(sep)); l > 0 && l > (maxStringSize+int64(len(sep)))/n {
+		L.Errorf("resulting string too large")
+	}
+	if sep == "" {
+		return []Value{strings.Repeat(s, int(n))}
+	}
+	var b strings.Builder
+	b.Grow(int(n)*(len(s)+len(sep)) - len(sep))
+	for ; n > 1; n-- {
+		b.WriteString(s)
+		b.WriteString(sep)
+	}
+	b.WriteString(s)
+	return []Value{b.String()}
+}
+
+// strReverse returns a string with its bytes in reverse order.
+func strReverse(L *State, args []Value) []Value {
+	s := L.checkString(args, 1)
+	b := make([]byte, len(s))
+	for i := range b {
+		b[i] = s[len(s)-1-i]
+	}
+	return []Value{string(b)}
+}
+
+// strLower converts the ASCII letters of a string to lower case.
+func strLower(L *State, args []Value) []Value {
+	s := []byte(L.checkString(args, 1))
+	for i, c := range s {
+		s[i] = lower(c)
+	}
+	return []Value{string(s)}
+}
+
+// strUpper converts the ASCII letters of a string to upper case.
+func strUpper(L *State, args []Value) []Value {
+	s := []byte(L.checkString(args, 1))
+	for i, c := range s {
+		s[i] = upper(c)
+	}
+	return []Value{string(s)}
+}
+
+// strFind returns the positions of the first match of a pattern in a
+// string from init, followed by its captures. The search is for plain
+// text if the fourth argument is true or the pattern has no magic
+// characters.
+func strFind(L *State, args []Value) []Value {
+	return strFindAux(L, args, true)
+}
+
+// strMatch returns the captures of the first match of a pattern in a
+// string from init.
+func strMatch(L *State, args []Value) []Value {
+	return strFindAux(L, args, false)
+}
+
+func strFindAux(L *State, args []Value, find bool) []Value {
+	s := L.checkString(args, 1)
+	p := L.checkString(args, 2)
+	init := startPos(L.optInteger(args, 3, 1), len(s)) - 1
+	if init > len(s) {
+		return []Value{nil}
+	}
+	if find && (Truth(arg(args, 3)) || !strings.ContainsAny(p, "^$*+?.([%-")) {
+		if i := strings.Index(s[init:], p); i >= 0 {
+			return []Value{int64(init + i + 1), int64(init + i + len(p))}
+		}
+		return []Value{nil}
+	}
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:]
+	}
+	ms := newMatchState(L, s, p)
+	for s1 := init; ; s1++ {
+		ms.reset()
+		if e := ms.match(s1, 0); e != -1 {
+			if find {
+				return append([]Value{int64(s1 + 1), int64(e)}, ms.captures(-1, 0)...)
+			}
+			return ms.captures(s1, e)
+		}
+		if s1 >= len(s) || anchor {
+			return []Value{nil}
+		}
+	}
+}
+
+// strGmatch returns an iterator over the matches of a pattern in a
+// string from init, which returns the captures of each match in turn.
+// A match may not be empty where the previous one ended.
+func strGmatch(L *State, args []Value) []Value {
+	s := L.checkString(args, 1)
+	p := L.checkString(args, 2)
+	src := startPos(L.optInteger(args, 3, 1), len(s)) - 1
+	if src > len(s) {
+		src = len(s) + 1
+	}
+	lastMatch := -1
+	iter := func(L *State, _ []Value) []Value {
+		ms := newMatchState(L, s, p)
+		for ; src <= len(s); src++ {
+			ms.reset()
+			if e := ms.match(src, 0); e != -1 && e != lastMatch {
+				start := src
+				src, lastMatch = e, e
+				return ms.captures(start, e)
+			}
+		}
+		return []Value{nil}
+	}
+	return []Value{&GoFunction{Name: "gmatch", Fn: iter}}
+}
+
+// strGsub returns a copy of a string with the first n matches of a
+// pattern, or all of them, replaced, and the number of matches. The
+// replacement is a string, in which %0 to %9 stand for the captures, a
+// table indexed by the first capture, or a function called with the
+// captures. A false or nil replacement keeps the match.
+func strGsub(L *State, args []Value) []Value {
+	src := L.checkString(args, 1)
+	p := L.checkString(args, 2)
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case int64, float64, string, *Table, Function:
+	default:
+		L.typeArgError(3, "string/function/table", args)
+	}
+	maxN := L.optInteger(args, 4, int64(len(src))+1)
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:]
+	}
+	ms := newMatchState(L, src, p)
+	var b strings.Builder
+	s, n, lastMatch, changed := 0, int64(0), -1, false
+	for n < maxN {
+		ms.reset()
+		if e := ms.match(s, 0); e != -1 && e != lastMatch {
+			n++
+			if ms.addValue(&b, s, e, repl) {
+				changed = true
+			}
+			s, lastMatch = e, e
+		} else if s < len(src) {
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	if !changed {
+		return []Value{src, n}
+	}
+	b.WriteString(src[s:])
+	return []Value{b.String(), n}
+}
+
+// addValue writes the replacement of the match from s to e and reports
+// whether it differs from the match.
+func (ms *matchState) addValue(b *strings.Builder, s, e int, repl Value) bool {
+	L := ms.L
+	var v Value
+	switch r := repl.(type) {
+	case *Table:
+		v = L.Index(r, ms.getCapture(0, s, e))
+	case Function:
+		v = first(L.Call(r, ms.captures(s, e)))
+	default:
+		r, _ = toStringCoerce(r)
+		ms.addString(b, s, e, r.(string))
+		return true
+	}
+	if !Truth(v) {
+		b.WriteString(ms.src[s:e]) // keep the original text
+		return false
+	}
+	str, ok := toStringCoerce(v)
+	if !ok {
+		L.Errorf("invalid replacement value (a %s)", TypeName(v))
+	}
+	b.WriteString(str)
+	return true
+}
+
+// addString writes the replacement string r for the match from s to e.
+func (ms *matchState) addString(b *strings.Builder, s, e int, r string) {
+	for {
+		i := strings.IndexByte(r, '%')
+		if i < 0 {
+			break
+		}
+		b.WriteString(r[:i])
+		var c byte
+		if i+1 < len(r) {
+			c = r[i+1]
+		}
+		switch {
+		case c == '%':
+			b.WriteByte('%')
+		case c == '0':
+			b.WriteString(ms.src[s:e])
+		case isDigit(c):
+			v, _ := toStringCoerce(ms.getCapture(int(c-'1'), s, e))
+			b.WriteString(v)
+		default:
+			ms.L.Errorf("invalid use of '%%' in replacement string")
+		}
+		r = r[i+2:]
+	}
+	b.WriteString(r)
+}
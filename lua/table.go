@@ -0,0 +1,403 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/mdhender/glua/arith"
+)
+
+// Table is a Lua table, an associative array that maps any value
+// except nil and NaN to any value except nil.
+//
+// As in the reference implementation, a table has an array part,
+// which holds the fields with the keys 1 to n, and a hash part for
+// the rest. When a new key finds the hash part full, the table is
+// rebalanced: n becomes the largest power of two such that more than
+// half of the keys 1 to n are in use, and the parts are resized.
+//
+// The hash part keeps its entries in the order their keys were added,
+// so that Next can resume a traversal from any key. Setting a field
+// to nil leaves a hole that is dropped only when the table is
+// rebalanced, which happens only as new keys are added, so clearing
+// fields while traversing the table is allowed.
+//
+// A table with weak keys or values holds the objects among them by
+// weak references. An entry whose key or value has been collected
+// reads as absent, and its value is cleared when the state collects
+// garbage.
+type Table struct {
+	arr []Value // the array part: arr[i] is the field with key i+1
+
+	index map[Value]int // position in keys of each key of the hash part
+	keys  []Value
+	vals  []Value
+	holes int // entries of the hash part whose value is nil
+	hsize int // entries the hash part holds before it is rebalanced
+
+	meta      *Table
+	finalized bool  // set once the table is marked for finalization
+	weak      uint8 // weakKeys and weakValues, from the __mode of meta
+	listed    bool  // set while the table is on the list of weak tables
+}
+
+// NewTable returns an empty table with room for narr fields in its
+// array part and nrec fields in its hash part.
+func NewTable(narr, nrec int) *Table {
+	t := &Table{}
+	if narr > 0 {
+		t.arr = make([]Value, narr)
+	}
+	if nrec > 0 {
+		t.resizeHash(nrec)
+	}
+	return t
+}
+
+// normalize converts a float key with an integral value to an integer,
+// so that t[1] and t[1.0] are the same field.
+func normalize(key Value) Value {
+	if f, ok := key.(float64); ok {
+		if i, ok := arith.FloatToInteger(f); ok {
+			return i
+		}
+	}
+	return key
+}
+
+// inArray reports whether the key k belongs to the array part.
+func (t *Table) inArray(k int64) bool {
+	return uint64(k)-1 < uint64(len(t.arr))
+}
+
+// Get returns the value of t[key], without calling metamethods.
+func (t *Table) Get(key Value) Value {
+	key = normalize(key)
+	if k, ok := key.(int64); ok {
+		return t.GetInt(k)
+	}
+	if i, ok := t.index[t.key(key)]; ok {
+		return t.val(t.vals[i])
+	}
+	return nil
+}
+
+// GetInt returns the value of t[key] for an integer key.
+func (t *Table) GetInt(key int64) Value {
+	if t.inArray(key) {
+		return t.val(t.arr[key-1])
+	}
+	if i, ok := t.index[key]; ok {
+		return t.val(t.vals[i])
+	}
+	return nil
+}
+
+// GetString returns the value of t[key] for a string key.
+func (t *Table) GetString(key string) Value {
+	if i, ok := t.index[key]; ok {
+		return t.val(t.vals[i])
+	}
+	return nil
+}
+
+// key returns the form in which key is stored in the hash part: a weak
+// reference if the table has weak keys and key is an object.
+func (t *Table) key(key Value) Value {
+	if t.weak&weakKeys != 0 {
+		return weakRef(key)
+	}
+	return key
+}
+
+// val returns the value that v, as stored in the table, stands for.
+func (t *Table) val(v Value) Value {
+	if t.weak&weakValues != 0 {
+		return strongRef(v)
+	}
+	return v
+}
+
+// dead reports whether the entry of the hash part at i has a key or a
+// value that has been collected.
+func (t *Table) dead(i int) bool {
+	return t.weak != 0 && (strongRef(t.keys[i]) == nil || strongRef(t.vals[i]) == nil)
+}
+
+// Set sets t[key] to val, without calling metamethods. The key must
+// not be nil or NaN; State.SetIndex reports those as errors.
+func (t *Table) Set(key, val Value) {
+	key = normalize(key)
+	if t.weak&weakValues != 0 {
+		val = weakRef(val)
+	}
+	if k, ok := key.(int64); ok && t.inArray(k) {
+		t.arr[k-1] = val
+		return
+	}
+	key = t.key(key)
+	if i, ok := t.index[key]; ok {
+		if t.vals[i] == nil && val != nil {
+			t.holes--
+		} else if t.vals[i] != nil && val == nil {
+			t.holes++
+		}
+		t.vals[i] = val
+		return
+	}
+	if val == nil {
+		return
+	}
+	if len(t.keys) >= t.hsize {
+		t.rehash(key)
+		if k, ok := key.(int64); ok && t.inArray(k) {
+			t.arr[k-1] = val
+			return
+		}
+	}
+	t.index[key] = len(t.keys)
+	t.keys = append(t.keys, key)
+	t.vals = append(t.vals, val)
+}
+
+// maxBits bounds the sizes of the array part, 2^maxBits.
+const maxBits = 31
+
+// rehash rebalances the table before key is added to it. It counts the
+// integer keys in each range (2^(i-1), 2^i] to find the new size of
+// the array part, and sizes the hash part for the rest.
+func (t *Table) rehash(key Value) {
+	var nums [maxBits + 1]int
+	nint := 0 // integer keys that could go to the array part
+	total := 0
+	count := func(k Value) {
+		total++
+		if k, ok := k.(int64); ok && 0 < k && k <= 1<<maxBits {
+			nums[bits.Len64(uint64(k-1))]++
+			nint++
+		}
+	}
+	for i, v := range t.arr {
+		if t.val(v) != nil {
+			count(int64(i + 1))
+		}
+	}
+	for i, k := range t.keys {
+		if t.vals[i] != nil && !t.dead(i) {
+			count(k)
+		}
+	}
+	count(key)
+
+	// the largest power of two n such that more than half of the keys
+	// 1 to n are present
+	size, inArray := 0, 0
+	a := 0
+	for i, twotoi := 0, 1; i <= maxBits && nint > twotoi/2; i, twotoi = i+1, twotoi*2 {
+		a += nums[i]
+		if a > twotoi/2 {
+			size, inArray = twotoi, a
+		}
+	}
+	t.resize(size, total-inArray)
+}
+
+// resize sets the size of the array part to narr and makes room for
+// nhash entries in the hash part, moving the fields between the parts
+// as needed and dropping the holes and the dead entries of the hash
+// part.
+func (t *Table) resize(narr, nhash int) {
+	arr := t.arr
+	if narr != len(arr) {
+		t.arr = make([]Value, narr)
+		copy(t.arr, arr)
+	}
+	old := *t
+	t.resizeHash(nhash)
+	for i := narr; i < len(arr); i++ {
+		if v := arr[i]; t.val(v) != nil {
+			t.insert(int64(i+1), v)
+		}
+	}
+	for i, k := range old.keys {
+		if v := old.vals[i]; v != nil && !old.dead(i) {
+			if k, ok := k.(int64); ok && t.inArray(k) {
+				t.arr[k-1] = v
+				continue
+			}
+			t.insert(k, v)
+		}
+	}
+}
+
+// resizeHash empties the hash part, with room for n entries.
+func (t *Table) resizeHash(n int) {
+	if n > 0 {
+		n = 1 << bits.Len(uint(n-1))
+	}
+	t.index = make(map[Value]int, n)
+	t.keys = make([]Value, 0, n)
+	t.vals = make([]Value, 0, n)
+	t.holes, t.hsize = 0, n
+}
+
+// insert adds a new entry to the hash part.
+func (t *Table) insert(key, val Value) {
+	t.index[key] = len(t.keys)
+	t.keys = append(t.keys, key)
+	t.vals = append(t.vals, val)
+}
+
+// Next returns the field that follows key in a traversal of t, or the
+// first field if key is nil. The array part is traversed first, in
+// order, then the hash part. At the end of the traversal Next returns
+// a nil key. It reports false if key is not in the table.
+func (t *Table) Next(key Value) (k, v Value, ok bool) {
+	i := 0 // the position to resume from, counting the array part first
+	if key != nil {
+		key = normalize(key)
+		if k, isInt := key.(int64); isInt && t.inArray(k) {
+			i = int(k)
+		} else if j, found := t.index[t.key(key)]; found {
+			i = len(t.arr) + j + 1
+		} else {
+			return nil, nil, false
+		}
+	}
+	for ; i < len(t.arr); i++ {
+		if v := t.val(t.arr[i]); v != nil {
+			return int64(i + 1), v, true
+		}
+	}
+	for i -= len(t.arr); i < len(t.keys); i++ {
+		if t.vals[i] != nil && !t.dead(i) {
+			return strongRef(t.keys[i]), t.val(t.vals[i]), true
+		}
+	}
+	return nil, nil, true
+}
+
+// Len returns a border of t: an index n such that t[n] is not nil and
+// t[n+1] is nil, or zero if t[1] is nil. A table with holes in its
+// sequence may have several borders; Len returns any of them.
+func (t *Table) Len() int64 {
+	n := len(t.arr)
+	if n > 0 && t.val(t.arr[n-1]) == nil {
+		// there is a border in the array part; binary search for it
+		i, j := 0, n
+		for j-i > 1 {
+			m := i + (j-i)/2
+			if t.val(t.arr[m-1]) == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return int64(i)
+	}
+	if t.GetInt(int64(n)+1) == nil {
+		return int64(n)
+	}
+	return t.hashSearch(int64(n))
+}
+
+// hashSearch finds a border beyond the array part of size j, knowing
+// that t[j+1] is present. It doubles j until t[j] is absent and then
+// binary searches between the last present index and j.
+func (t *Table) hashSearch(j int64) int64 {
+	var i int64
+	if j == 0 {
+		j++
+	}
+	for {
+		i = j
+		if j <= math.MaxInt64/2 {
+			j *= 2
+		} else {
+			j = math.MaxInt64
+			if t.GetInt(j) == nil {
+				break
+			}
+			// a table built to break the search; the maximum
+			// integer is a border
+			return j
+		}
+		if t.GetInt(j) == nil {
+			break
+		}
+	}
+	for j-i > 1 {
+		m := i + (j-i)/2
+		if t.GetInt(m) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
+}
+
+// setWeak gives the table the weak mode set by a new metatable, storing
+// its entries again in the form the mode calls for.
+func (t *Table) setWeak(mode uint8) {
+	old := *t
+	t.weak = mode
+	for i, v := range old.arr {
+		if v = old.val(v); v != nil && mode&weakValues != 0 {
+			v = weakRef(v)
+		}
+		t.arr[i] = v
+	}
+	t.resizeHash(len(old.keys) - old.holes)
+	for i, k := range old.keys {
+		if v := old.vals[i]; v != nil && !old.dead(i) {
+			v = old.val(v)
+			if mode&weakValues != 0 {
+				v = weakRef(v)
+			}
+			t.insert(t.key(strongRef(k)), v)
+		}
+	}
+}
+
+// sweep clears the fields of a weak table whose keys or values have
+// been collected, so that it no longer holds on to the other half of
+// the entry. The entries stay in place as holes, so that a traversal
+// of the table can go on.
+func (t *Table) sweep() {
+	for i, v := range t.arr {
+		if v != nil && t.val(v) == nil {
+			t.arr[i] = nil
+		}
+	}
+	for i, v := range t.vals {
+		if v != nil && t.dead(i) {
+			t.vals[i] = nil
+			t.holes++
+		}
+	}
+}
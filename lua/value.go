@@ -0,0 +1,153 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package lua implements the values of Lua 5.4 and the state that the
+// evaluators share while they run a program: the global environment,
+// the stack of active calls, and the operations on values that can
+// fail at run time, such as arithmetic, indexing and calls.
+//
+// Errors are raised by panicking with an *Error, the way the reference
+// implementation uses longjmp. State.PCall recovers them.
+package lua
+
+import (
+	"fmt"
+
+	"github.com/mdhender/glua/arith"
+)
+
+// Value is a Lua value. Its dynamic type is one of
+//
+//	nil        nil
+//	bool       boolean
+//	int64      number (integer)
+//	float64    number (float)
+//	string     string
+//	*Table     table
+//	Function   function
+//	*Userdata  userdata
+//	*Thread    thread
+//
+// Numbers have two subtypes, integers and floats, which are distinct
+// values that compare equal when they denote the same number. Strings
+// are immutable sequences of bytes, not necessarily valid UTF-8.
+type Value interface{}
+
+// Function is a function value. Functions written in Lua are
+// implemented by the evaluators, and GoFunction wraps a function
+// written in Go.
+type Function interface {
+	// Call calls the function with the arguments and returns its
+	// results. The caller has already pushed a CallInfo for the call;
+	// use State.Call to call a value.
+	Call(L *State, args []Value) []Value
+}
+
+// GoFunction is a function written in Go.
+type GoFunction struct {
+	Name string // the name used in error messages
+	Fn   func(L *State, args []Value) []Value
+}
+
+// Call calls the Go function.
+func (f *GoFunction) Call(L *State, args []Value) []Value {
+	return f.Fn(L, args)
+}
+
+// Userdata is a value created by the host program to hold data of its
+// own. Lua code can only pass it around and compare it, unless its
+// metatable gives it operations; its identity is that of the pointer.
+type Userdata struct {
+	Data interface{}
+
+	meta      *Table
+	finalized bool // set once the userdata is marked for finalization
+}
+
+// Thread is a thread of execution, the type of a coroutine. A thread
+// runs on a call stack of its own, kept in its state.
+type Thread struct {
+	L *State
+}
+
+// TypeName returns the name of the type of v, as the type function
+// reports it.
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case Function:
+		return "function"
+	case *Userdata:
+		return "userdata"
+	case *Thread:
+		return "thread"
+	}
+	panic(fmt.Sprintf("lua: unexpected value type %T", v))
+}
+
+// Truth reports whether v counts as true in a condition: everything
+// but nil and false does.
+func Truth(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// RawEqual reports whether a and b are primitively equal, without
+// calling metamethods. An integer and a float are equal if they denote
+// the same number.
+func RawEqual(a, b Value) bool {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a == b
+		case float64:
+			return arith.EqualIntFloat(a, b)
+		}
+		return false
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return arith.EqualIntFloat(b, a)
+		case float64:
+			return a == b
+		}
+		return false
+	}
+	return a == b
+}
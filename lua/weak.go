@@ -0,0 +1,169 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package lua
+
+import "weak"
+
+// Weak modes of a table, set by the __mode field of its metatable.
+const (
+	weakKeys   = 1 << iota // the table has weak keys
+	weakValues             // the table has weak values
+)
+
+// A WeakRef is a weak reference to a value: it does not keep the value
+// from being collected. References to the same value are equal, so
+// they can stand for it as keys.
+type WeakRef interface {
+	// Get returns the value, or nil once it has been collected.
+	Get() Value
+}
+
+// A WeakFunction is a function that a table with weak keys or values
+// can hold weakly. The functions of this package are held weakly, and
+// those of other packages only if they implement this interface;
+// otherwise a weak table keeps them alive.
+type WeakFunction interface {
+	Function
+
+	// Weak returns a weak reference to the function.
+	Weak() WeakRef
+}
+
+// weakPtr is a weak reference to a value of type *T.
+type weakPtr[T any] struct {
+	p weak.Pointer[T]
+}
+
+// Get returns the value, or nil once it has been collected.
+func (w weakPtr[T]) Get() Value {
+	if p := w.p.Value(); p != nil {
+		return p
+	}
+	return nil
+}
+
+// NewWeakRef returns a weak reference to p, which must be a value of
+// a Lua type, for the Weak method of a WeakFunction.
+func NewWeakRef[T any](p *T) WeakRef {
+	return weakPtr[T]{weak.Make(p)}
+}
+
+// weakRef returns a weak reference to v if v is an object that can be
+// collected, and otherwise v itself. Strings are values, not objects,
+// and are never removed from weak tables.
+func weakRef(v Value) Value {
+	switch v := v.(type) {
+	case *Table:
+		return NewWeakRef(v)
+	case *Userdata:
+		return NewWeakRef(v)
+	case *Thread:
+		return NewWeakRef(v)
+	case *GoFunction:
+		return NewWeakRef(v)
+	case WeakFunction:
+		return v.Weak()
+	}
+	return v
+}
+
+// strongRef returns the value v refers to if v is a weak reference, and
+// otherwise v itself. It returns nil if the value has been collected.
+func strongRef(v Value) Value {
+	if w, ok := v.(WeakRef); ok {
+		return w.Get()
+	}
+	return v
+}
+
+// weakMode returns the weak mode that the metatable mt gives a table.
+func weakMode(mt *Table) uint8 {
+	var mode uint8
+	if mt != nil {
+		if s, ok := mt.GetString("__mode").(string); ok {
+			for i := 0; i < len(s); i++ {
+				switch s[i] {
+				case 'k':
+					mode |= weakKeys
+				case 'v':
+					mode |= weakValues
+				}
+			}
+		}
+	}
+	return mode
+}
+
+// weakTables is the list of the tables with weak keys or values, whose
+// dead entries are cleared after a collection. It holds the tables
+// weakly, too.
+type weakTables struct {
+	tables []weak.Pointer[Table]
+}
+
+// add adds t to the list, unless it is on it. When the list is full,
+// the tables that have been collected are dropped first, so that it
+// does not grow with the weak tables a program throws away.
+func (w *weakTables) add(t *Table) {
+	if t.listed {
+		return
+	}
+	if len(w.tables) == cap(w.tables) {
+		w.prune(false)
+	}
+	t.listed = true
+	w.tables = append(w.tables, weak.Make(t))
+}
+
+// sweep clears the dead entries of the tables in the list and drops
+// the tables that have been collected or are no longer weak.
+func (w *weakTables) sweep() {
+	w.prune(true)
+}
+
+// prune drops the tables that have been collected or are no longer
+// weak from the list, and clears the dead entries of the others if
+// sweep is set.
+func (w *weakTables) prune(sweep bool) {
+	live := w.tables[:0]
+	for _, p := range w.tables {
+		t := p.Value()
+		if t == nil {
+			continue
+		}
+		if t.weak == 0 {
+			t.listed = false
+			continue
+		}
+		if sweep {
+			t.sweep()
+		}
+		live = append(live, p)
+	}
+	for i := len(live); i < len(w.tables); i++ {
+		w.tables[i] = weak.Pointer[Table]{}
+	}
+	w.tables = live
+}
@@ -0,0 +1,359 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package optimize rewrites a Lua chunk into a simpler one that
+// behaves the same way.
+//
+// Fold evaluates the operators whose operands are numeric or string
+// literals and removes the branches of if statements and while loops
+// whose conditions are constants that can never be true. It follows
+// the arithmetic of Lua 5.4 exactly and leaves alone any operation
+// that would raise an error when run, such as an integer division by
+// zero or a bitwise operation on a float with a fractional part, so
+// that the error is still raised at run time.
+package optimize
+
+import (
+	"github.com/mdhender/glua/arith"
+	"github.com/mdhender/glua/ast"
+)
+
+// Fold folds the constant expressions in the chunk and prunes the
+// branches that are never taken. The chunk is modified in place.
+//
+// The chunk should have been checked first, since code in a pruned
+// branch is not there to be checked afterwards.
+func Fold(chunk *ast.Chunk) {
+	ast.Rewrite(chunk, fold)
+}
+
+// fold is called for each node after its children have been folded.
+func fold(n ast.Node) ast.Node {
+	switch n := n.(type) {
+	case *ast.ParenExpr:
+		// the parentheses around a constant do nothing
+		if _, ok := truth(n.X); ok {
+			return n.X
+		}
+	case *ast.UnaryExpr:
+		if x := foldUnary(n); x != nil {
+			return x
+		}
+	case *ast.BinaryExpr:
+		if x := foldBinary(n); x != nil {
+			return x
+		}
+	case *ast.IfStat:
+		return pruneIf(n)
+	case *ast.WhileStat:
+		if t, ok := truth(n.Cond); ok && !t {
+			return nil
+		}
+	}
+	return n
+}
+
+// truth reports whether x is a constant and, if it is, whether it
+// counts as true in a condition.
+func truth(x ast.Expr) (value, ok bool) {
+	switch x := x.(type) {
+	case *ast.NilExpr:
+		return false, true
+	case *ast.BoolExpr:
+		return x.Value, true
+	case *ast.NumberExpr, *ast.StringExpr:
+		return true, true
+	}
+	return false, false
+}
+
+// pruneIf removes the clauses of an if statement whose conditions are
+// always false. A clause whose condition is always true becomes the
+// else branch and the clauses after it are removed. An if statement
+// left with no clauses becomes its else branch, in a do block to keep
+// the scope of its locals, or disappears if there is none.
+func pruneIf(s *ast.IfStat) ast.Node {
+	clauses := s.Clauses[:0]
+	for _, c := range s.Clauses {
+		t, ok := truth(c.Cond)
+		if !ok {
+			clauses = append(clauses, c)
+			continue
+		}
+		if t {
+			s.ElsePos, s.Else = c.Pos(), c.Body
+			break
+		}
+	}
+	s.Clauses = clauses
+	if len(s.Clauses) != 0 {
+		return s
+	}
+	if s.Else == nil || len(s.Else.Stats) == 0 {
+		return nil
+	}
+	return &ast.DoStat{Span: s.Span, Body: s.Else}
+}
+
+func foldUnary(x *ast.UnaryExpr) ast.Expr {
+	switch x.Op {
+	case ast.OpNot:
+		if t, ok := truth(x.Operand); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: !t}
+		}
+	case ast.OpNeg:
+		if n, ok := x.Operand.(*ast.NumberExpr); ok {
+			if n.IsFloat {
+				return float(x.Span, -n.Float)
+			}
+			return integer(x.Span, int64(-uint64(n.Int)))
+		}
+	case ast.OpBnot:
+		if i, ok := toInteger(x.Operand); ok {
+			return integer(x.Span, ^i)
+		}
+	case ast.OpLen:
+		if s, ok := x.Operand.(*ast.StringExpr); ok {
+			return integer(x.Span, int64(len(s.Value)))
+		}
+	}
+	return nil
+}
+
+func foldBinary(x *ast.BinaryExpr) ast.Expr {
+	switch x.Op {
+	case ast.OpAdd, ast.OpSub, ast.OpMul, ast.OpDiv, ast.OpIDiv, ast.OpPow, ast.OpMod:
+		a, ok1 := x.Left.(*ast.NumberExpr)
+		b, ok2 := x.Right.(*ast.NumberExpr)
+		if ok1 && ok2 {
+			return arithmetic(x, a, b)
+		}
+	case ast.OpBand, ast.OpBor, ast.OpBxor, ast.OpShl, ast.OpShr:
+		a, ok1 := toInteger(x.Left)
+		b, ok2 := toInteger(x.Right)
+		if ok1 && ok2 {
+			return integer(x.Span, bitwise(x.Op, a, b))
+		}
+	case ast.OpConcat:
+		a, ok1 := toString(x.Left)
+		b, ok2 := toString(x.Right)
+		if ok1 && ok2 {
+			return &ast.StringExpr{Span: x.Span, Value: a + b}
+		}
+	case ast.OpEQ, ast.OpNE:
+		if eq, ok := equal(x.Left, x.Right); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: eq == (x.Op == ast.OpEQ)}
+		}
+	case ast.OpLT:
+		if lt, ok := less(x.Left, x.Right, false); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: lt}
+		}
+	case ast.OpLE:
+		if le, ok := less(x.Left, x.Right, true); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: le}
+		}
+	case ast.OpGT:
+		if gt, ok := less(x.Right, x.Left, false); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: gt}
+		}
+	case ast.OpGE:
+		if ge, ok := less(x.Right, x.Left, true); ok {
+			return &ast.BoolExpr{Span: x.Span, Value: ge}
+		}
+	}
+	return nil
+}
+
+// arithmetic folds an arithmetic operator on two numerals. The result
+// is an integer if both operands are integers, except for '/' and '^',
+// which always work on floats.
+func arithmetic(x *ast.BinaryExpr, a, b *ast.NumberExpr) ast.Expr {
+	if !a.IsFloat && !b.IsFloat {
+		i, j := a.Int, b.Int
+		switch x.Op {
+		case ast.OpAdd:
+			return integer(x.Span, int64(uint64(i)+uint64(j)))
+		case ast.OpSub:
+			return integer(x.Span, int64(uint64(i)-uint64(j)))
+		case ast.OpMul:
+			return integer(x.Span, int64(uint64(i)*uint64(j)))
+		case ast.OpIDiv:
+			if j == 0 {
+				return nil // "attempt to perform 'n//0'"
+			}
+			return integer(x.Span, arith.FloorDiv(i, j))
+		case ast.OpMod:
+			if j == 0 {
+				return nil // "attempt to perform 'n%0'"
+			}
+			return integer(x.Span, arith.Mod(i, j))
+		}
+	}
+	f, g := toFloat(a), toFloat(b)
+	switch x.Op {
+	case ast.OpAdd:
+		return float(x.Span, f+g)
+	case ast.OpSub:
+		return float(x.Span, f-g)
+	case ast.OpMul:
+		return float(x.Span, f*g)
+	case ast.OpDiv:
+		return float(x.Span, f/g)
+	case ast.OpIDiv:
+		return float(x.Span, arith.FloatFloorDiv(f, g))
+	case ast.OpMod:
+		return float(x.Span, arith.FloatMod(f, g))
+	case ast.OpPow:
+		return float(x.Span, arith.Pow(f, g))
+	}
+	return nil
+}
+
+func bitwise(op ast.BinOp, a, b int64) int64 {
+	switch op {
+	case ast.OpBand:
+		return a & b
+	case ast.OpBor:
+		return a | b
+	case ast.OpBxor:
+		return a ^ b
+	case ast.OpShl:
+		return arith.ShiftLeft(a, b)
+	}
+	return arith.ShiftRight(a, b)
+}
+
+// equal reports whether two constants are equal. Values of different
+// types are never equal, except for an integer and a float that denote
+// the same number.
+func equal(x, y ast.Expr) (eq, ok bool) {
+	if _, ok := truth(x); !ok {
+		return false, false
+	}
+	if _, ok := truth(y); !ok {
+		return false, false
+	}
+	switch x := x.(type) {
+	case *ast.NilExpr:
+		_, eq = y.(*ast.NilExpr)
+	case *ast.BoolExpr:
+		b, isBool := y.(*ast.BoolExpr)
+		eq = isBool && b.Value == x.Value
+	case *ast.StringExpr:
+		s, isString := y.(*ast.StringExpr)
+		eq = isString && s.Value == x.Value
+	case *ast.NumberExpr:
+		if n, isNumber := y.(*ast.NumberExpr); isNumber {
+			switch {
+			case !x.IsFloat && !n.IsFloat:
+				eq = x.Int == n.Int
+			case x.IsFloat && n.IsFloat:
+				eq = x.Float == n.Float
+			case x.IsFloat:
+				eq = arith.EqualIntFloat(n.Int, x.Float)
+			default:
+				eq = arith.EqualIntFloat(x.Int, n.Float)
+			}
+		}
+	}
+	return eq, true
+}
+
+// less reports whether x < y, or x <= y if orEqual is set, for two
+// numbers or two strings. Comparing other values is an error.
+func less(x, y ast.Expr, orEqual bool) (lt, ok bool) {
+	if s, ok := x.(*ast.StringExpr); ok {
+		t, ok := y.(*ast.StringExpr)
+		if !ok {
+			return false, false
+		}
+		if orEqual {
+			return s.Value <= t.Value, true
+		}
+		return s.Value < t.Value, true
+	}
+	a, ok1 := x.(*ast.NumberExpr)
+	b, ok2 := y.(*ast.NumberExpr)
+	if !ok1 || !ok2 {
+		return false, false
+	}
+	switch {
+	case !a.IsFloat && !b.IsFloat:
+		lt = a.Int < b.Int || orEqual && a.Int == b.Int
+	case a.IsFloat && b.IsFloat:
+		lt = a.Float < b.Float || orEqual && a.Float == b.Float
+	case a.IsFloat && orEqual:
+		lt = arith.LessEqualFloatInt(a.Float, b.Int)
+	case a.IsFloat:
+		lt = arith.LessFloatInt(a.Float, b.Int)
+	case orEqual:
+		lt = arith.LessEqualIntFloat(a.Int, b.Float)
+	default:
+		lt = arith.LessIntFloat(a.Int, b.Float)
+	}
+	return lt, true
+}
+
+// toInteger returns the value of a numeral that has an exact integer
+// representation, as bitwise operators require.
+func toInteger(x ast.Expr) (int64, bool) {
+	n, ok := x.(*ast.NumberExpr)
+	if !ok {
+		return 0, false
+	}
+	if n.IsFloat {
+		return arith.FloatToInteger(n.Float)
+	}
+	return n.Int, true
+}
+
+func toFloat(n *ast.NumberExpr) float64 {
+	if n.IsFloat {
+		return n.Float
+	}
+	return float64(n.Int)
+}
+
+// toString returns the value of a string literal, or the string a
+// numeral converts to when it is concatenated.
+func toString(x ast.Expr) (string, bool) {
+	switch x := x.(type) {
+	case *ast.StringExpr:
+		return x.Value, true
+	case *ast.NumberExpr:
+		if x.IsFloat {
+			return arith.FormatFloat(x.Float), true
+		}
+		return arith.FormatInt(x.Int), true
+	}
+	return "", false
+}
+
+func integer(sp ast.Span, i int64) *ast.NumberExpr {
+	return &ast.NumberExpr{Span: sp, Int: i}
+}
+
+func float(sp ast.Span, f float64) *ast.NumberExpr {
+	return &ast.NumberExpr{Span: sp, IsFloat: true, Float: f}
+}
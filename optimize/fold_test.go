@@ -0,0 +1,260 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package optimize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/check"
+	"github.com/mdhender/glua/interp"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/syntax"
+)
+
+// parse parses and checks src.
+func parse(t *testing.T, src string) *ast.Chunk {
+	t.Helper()
+	chunk, err := syntax.ParseChunk("test", []byte(src), 0)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	if err := check.Check(chunk); err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	return chunk
+}
+
+// foldExpr folds the expression x and returns the result.
+func foldExpr(t *testing.T, x string) ast.Expr {
+	t.Helper()
+	chunk := parse(t, "return "+x)
+	Fold(chunk)
+	return chunk.Block.Stats[0].(*ast.ReturnStat).Values[0]
+}
+
+// run runs the chunk and returns the values it returns.
+func run(t *testing.T, chunk *ast.Chunk) []lua.Value {
+	t.Helper()
+	L := lua.NewState()
+	rets, err := L.PCall(interp.Load(L, chunk), nil)
+	if err != nil {
+		t.Fatalf("running %s: %v", chunk.Name, err)
+	}
+	return rets
+}
+
+// literal renders a constant, showing whether a number is an integer
+// or a float; it renders any other expression as its type.
+func literal(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.NilExpr:
+		return "nil"
+	case *ast.BoolExpr:
+		return fmt.Sprint(x.Value)
+	case *ast.StringExpr:
+		return strconv.Quote(x.Value)
+	case *ast.NumberExpr:
+		if x.IsFloat {
+			return "float " + strconv.FormatFloat(x.Float, 'g', -1, 64)
+		}
+		return "int " + strconv.FormatInt(x.Int, 10)
+	}
+	return fmt.Sprintf("%T", x)
+}
+
+func TestFold(t *testing.T) {
+	for _, tc := range []struct {
+		x, want string
+	}{
+		{"1 + 2", "int 3"},
+		{"9223372036854775807 + 1", "int -9223372036854775808"},
+		{"-9223372036854775807 - 2", "int 9223372036854775807"},
+		{"1 + 2.0", "float 3"},
+		{"10 / 2", "float 5"},
+		{"2^2", "float 4"},
+		{"7 // 2", "int 3"},
+		{"7 // -2", "int -4"},
+		{"7.5 // 2", "float 3"},
+		{"7 % -2", "int -1"},
+		{"-7 % 2", "int 1"},
+		{"3 % -2.0", "float -1"},
+		{"-3 % 2.0", "float 1"},
+		{"5.5 % math", "*ast.BinaryExpr"},
+		{"1 / 0", "float +Inf"},
+		{"-1 / 0", "float -Inf"},
+		{"1 // 0.0", "float +Inf"},
+		{"-0.0", "float -0"},
+		{"0.0 * -1", "float -0"},
+		{"-0", "int 0"},
+		{"- -9223372036854775808", "float 9.223372036854776e+18"},
+		{"-(-9223372036854775807 - 1)", "int -9223372036854775808"},
+		{"3 | 1.0", "int 3"},
+		{"1 << 63", "int -9223372036854775808"},
+		{"1 << 64", "int 0"},
+		{"-1 >> 1", "int 9223372036854775807"},
+		{"1 >> -1", "int 2"},
+		{"~0", "int -1"},
+		{"~2.0", "int -3"},
+		{"#\"abc\"", "int 3"},
+		{"\"a\" .. 1", `"a1"`},
+		{"1 .. 2", `"12"`},
+		{"1.0 .. \"\"", `"1.0"`},
+		{"1 == 1.0", "true"},
+		{"\"1\" == 1", "false"},
+		{"nil ~= false", "true"},
+		{"1 < 1.5", "true"},
+		{"2^53 <= 9007199254740993", "true"},
+		{"\"a\" < \"b\"", "true"},
+		{"\"b\" >= \"a\"", "true"},
+		{"not nil", "true"},
+		{"not 0", "false"},
+		{"(1)", "int 1"},
+		{"(f())", "*ast.ParenExpr"},
+	} {
+		if got := literal(foldExpr(t, tc.x)); got != tc.want {
+			t.Errorf("%s: folded to %s, want %s", tc.x, got, tc.want)
+		}
+	}
+}
+
+// TestFoldKeepsErrors checks that an operation that raises an error
+// when run is not folded.
+func TestFoldKeepsErrors(t *testing.T) {
+	for _, x := range []string{
+		"1 // 0",
+		"1 % 0",
+		"1.5 | 0",
+		"1 & 2^63",
+		"~1.5",
+		"\"1\" + 1",
+		"-\"2\"",
+		"#1",
+		"1 < \"2\"",
+		"nil .. \"\"",
+		"true <= false",
+	} {
+		if got := literal(foldExpr(t, x)); !strings.HasPrefix(got, "*ast.") {
+			t.Errorf("%s: folded to %s, want it left alone", x, got)
+		}
+	}
+}
+
+// TestFoldLikeRunTime checks that a folded expression has the value the
+// expression has when it is run without folding.
+func TestFoldLikeRunTime(t *testing.T) {
+	for _, x := range []string{
+		"2^63 .. \"\"",
+		"-2^63 .. \"\"",
+		"1e100 .. \"\"",
+		"0.1 .. \"\"",
+		"-0.0 .. \"\"",
+		"1/0 .. \"\"",
+		"2^53 .. \"\"",
+		"100 // 1e-300 .. \"\"",
+		"9007199254740993 < 2^53",
+		"9223372036854775807 + 0.0 == 9223372036854775807",
+		"5 // 0.0 .. \"\"",
+		"-5 % (1/0) .. \"\"",
+		"2^-1074 .. \"\"",
+	} {
+		want := run(t, parse(t, "return "+x))
+		chunk := parse(t, "return "+x)
+		Fold(chunk)
+		if folded := literal(chunk.Block.Stats[0].(*ast.ReturnStat).Values[0]); strings.HasPrefix(folded, "*ast.") {
+			t.Errorf("%s: not folded", x)
+		}
+		if got := run(t, chunk); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: folded to %v, want %v", x, got, want)
+		}
+	}
+}
+
+// shape renders the kinds of the statements in a block. A do block
+// shows its body and an if statement shows its number of clauses.
+func shape(b *ast.Block) string {
+	var s []string
+	for _, stat := range b.Stats {
+		switch stat := stat.(type) {
+		case *ast.DoStat:
+			s = append(s, "do("+shape(stat.Body)+")")
+		case *ast.IfStat:
+			sh := fmt.Sprintf("if/%d", len(stat.Clauses))
+			if stat.Else != nil {
+				sh += "(else " + shape(stat.Else) + ")"
+			}
+			s = append(s, sh)
+		default:
+			s = append(s, strings.TrimPrefix(fmt.Sprintf("%T", stat), "*ast."))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestPrune(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"if false then f() end", ""},
+		{"if nil then f() else g() end", "do(CallStat)"},
+		{"if true then end", ""},
+		{"if true then f() else g() end", "do(CallStat)"},
+		{"if x then f() elseif false then g() end", "if/1"},
+		{"if false then f() elseif true then local y = 1 end", "do(LocalStat)"},
+		{"if x then f() elseif 1 then g() elseif y then h() end", "if/1(else CallStat)"},
+		{"if x then f() elseif not 1 then g() else h() end", "if/1(else CallStat)"},
+		{"if 1 > 2 then f() end g()", "CallStat"},
+		{"while false do f() end", ""},
+		{"while nil do end f()", "CallStat"},
+		{"while true do break end", "WhileStat"},
+		{"while x do if false then break end end", "WhileStat"},
+		{"repeat f() until false", "RepeatStat"},
+	} {
+		chunk := parse(t, tc.src)
+		Fold(chunk)
+		if got := shape(chunk.Block); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+// TestPruneKeepsScope checks that the locals of a branch that is always
+// taken stay local to it.
+func TestPruneKeepsScope(t *testing.T) {
+	for _, src := range []string{
+		"local a = 1 if true then local a = 2 end return a",
+		"local a = 1 if false then else local a = 2 end return a",
+		"local a = 1 if nil then elseif 1 then local a = 2 end return a",
+	} {
+		chunk := parse(t, src)
+		Fold(chunk)
+		if got := run(t, chunk); len(got) != 1 || got[0] != int64(1) {
+			t.Errorf("%q: returned %v, want [1]", src, got)
+		}
+	}
+}
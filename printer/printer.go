@@ -0,0 +1,674 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package printer formats Lua syntax trees as source code.
+//
+// The output is in a canonical form: one statement per line, blocks
+// indented with tabs, single spaces around binary operators and after
+// commas. Comments and single blank lines between statements are kept.
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
+	"github.com/mdhender/glua/syntax"
+)
+
+// Fprint formats node and writes it to w.
+// If node is an *ast.Chunk parsed with syntax.ParseTrivia, the comments
+// in its trivia are printed with it.
+//
+// A tree with an *ast.BadStat, which stands in for a statement that
+// failed to parse, cannot be printed; Fprint returns an error for it
+// and writes nothing.
+func Fprint(w io.Writer, node ast.Node) error {
+	var p printer
+	p.node(node)
+	if p.err != nil {
+		return p.err
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// Format parses src as a Lua chunk and returns it in canonical form.
+// The name identifies the chunk in error messages. A first line
+// starting with '#' is copied to the output unchanged.
+func Format(name string, src []byte) ([]byte, error) {
+	chunk, err := syntax.ParseChunk(name, src, syntax.ParseTrivia)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if len(src) != 0 && src[0] == '#' {
+		line := src
+		if i := bytes.IndexAny(src, "\r\n"); i >= 0 {
+			line = src[:i]
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := Fprint(&buf, chunk); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// printer accumulates the formatted output.
+type printer struct {
+	buf      bytes.Buffer
+	indent   int            // current indentation level
+	comments []lexer.Trivia // comments not yet printed, in source order
+	err      error          // the first statement that cannot be printed
+}
+
+// eof is a position past the end of any source.
+var eof = lexer.Pos{Offset: math.MaxInt32, Line: math.MaxInt32}
+
+func (p *printer) node(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Chunk:
+		p.comments = comments(n)
+		p.stats(n.Block.Stats, eof)
+		if p.buf.Len() != 0 {
+			p.buf.WriteByte('\n')
+		}
+	case *ast.Block:
+		p.stats(n.Stats, n.End())
+	case ast.Stat:
+		p.stat(n)
+	case ast.Expr:
+		p.expr(n)
+	default:
+		panic("printer: unexpected node type")
+	}
+}
+
+func (p *printer) print(s ...string) {
+	for _, x := range s {
+		p.buf.WriteString(x)
+	}
+}
+
+// linebreak ends the current line and indents the next one.
+func (p *printer) linebreak() {
+	p.buf.WriteByte('\n')
+	for i := 0; i < p.indent; i++ {
+		p.buf.WriteByte('\t')
+	}
+}
+
+// item starts a new line for an item that begins on source line srcLine.
+// The previous item ended on source line line, or line is 0 if this is
+// the first item in its list. A blank line between the two is kept.
+func (p *printer) item(line, srcLine int) {
+	if p.buf.Len() == 0 {
+		return
+	}
+	if line > 0 && srcLine > line+1 {
+		p.buf.WriteByte('\n')
+	}
+	p.linebreak()
+}
+
+// leading prints the pending comments that start before pos, each on
+// its own line. It returns the source line where the last one ends.
+func (p *printer) leading(pos lexer.Pos, line int) int {
+	for len(p.comments) > 0 && p.comments[0].Pos.Offset < pos.Offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.item(line, c.Pos.Line)
+		p.print(commentText(c))
+		line = c.End.Line
+	}
+	return line
+}
+
+// trailing prints, at the end of the current line, the pending comments
+// that start inside a construct ending at end or later on the same line,
+// but before next. It returns the source line where the output ends.
+func (p *printer) trailing(end, next lexer.Pos) int {
+	line, afterLine := end.Line, false
+	for len(p.comments) > 0 {
+		c := p.comments[0]
+		if c.Pos.Offset >= next.Offset || (c.Pos.Offset >= end.Offset && c.Pos.Line != end.Line) {
+			break
+		}
+		p.comments = p.comments[1:]
+		if afterLine {
+			p.linebreak()
+		} else {
+			p.print(" ")
+		}
+		p.print(commentText(c))
+		afterLine = c.Kind == lexer.LineComment
+		if c.End.Line > line {
+			line = c.End.Line
+		}
+	}
+	return line
+}
+
+// hasComments reports whether any pending comment starts within n.
+func (p *printer) hasComments(n ast.Node) bool {
+	for _, c := range p.comments {
+		if c.Pos.Offset >= n.End().Offset {
+			break
+		} else if c.Pos.Offset >= n.Pos().Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// stats prints a list of statements with the comments before limit.
+func (p *printer) stats(list []ast.Stat, limit lexer.Pos) {
+	line, first := 0, true
+	for i, s := range list {
+		if _, ok := s.(*ast.EmptyStat); ok {
+			continue
+		}
+		next := limit
+		if i+1 < len(list) {
+			next = list[i+1].Pos()
+		}
+		line = p.leading(s.Pos(), line)
+		p.item(line, s.Pos().Line)
+		if !first && startsWithParen(s) {
+			// without the semicolon, the parenthesis would be read
+			// as a call of the previous statement's last expression
+			p.print(";")
+		}
+		p.stat(s)
+		line, first = p.trailing(s.End(), next), false
+	}
+	p.leading(limit, line)
+}
+
+// block prints the body of a compound statement, one level deeper.
+// The header of the statement ended at header; comments on that line
+// are printed at the end of it.
+func (p *printer) block(b *ast.Block, header, limit lexer.Pos) {
+	next := limit
+	if len(b.Stats) != 0 {
+		next = b.Stats[0].Pos()
+	}
+	p.trailing(header, next)
+	p.indent++
+	p.stats(b.Stats, limit)
+	p.indent--
+	p.linebreak()
+}
+
+func (p *printer) stat(s ast.Stat) {
+	switch s := s.(type) {
+	case *ast.EmptyStat:
+		p.print(";")
+	case *ast.AssignStat:
+		p.exprList(s.Targets)
+		p.print(" = ")
+		p.exprList(s.Values)
+	case *ast.CallStat:
+		p.expr(s.Call)
+	case *ast.LabelStat:
+		p.print("::", s.Name.Name, "::")
+	case *ast.BreakStat:
+		p.print("break")
+	case *ast.GotoStat:
+		p.print("goto ", s.Label.Name)
+	case *ast.DoStat:
+		p.print("do")
+		p.block(s.Body, s.Pos(), s.End())
+		p.print("end")
+	case *ast.WhileStat:
+		p.print("while ")
+		p.expr(s.Cond)
+		p.print(" do")
+		p.block(s.Body, s.Cond.End(), s.End())
+		p.print("end")
+	case *ast.RepeatStat:
+		p.print("repeat")
+		p.block(s.Body, s.Pos(), s.Cond.Pos())
+		p.print("until ")
+		p.expr(s.Cond)
+	case *ast.IfStat:
+		for i, c := range s.Clauses {
+			if i == 0 {
+				p.print("if ")
+			} else {
+				p.print("elseif ")
+			}
+			p.expr(c.Cond)
+			p.print(" then")
+			limit := s.End()
+			if i+1 < len(s.Clauses) {
+				limit = s.Clauses[i+1].Pos()
+			} else if s.Else != nil {
+				limit = s.ElsePos
+			}
+			p.block(c.Body, c.Cond.End(), limit)
+		}
+		if s.Else != nil {
+			p.print("else")
+			p.block(s.Else, s.ElsePos, s.End())
+		}
+		p.print("end")
+	case *ast.NumericForStat:
+		p.print("for ", s.Var.Name, " = ")
+		p.expr(s.Start)
+		p.print(", ")
+		p.expr(s.Limit)
+		header := s.Limit.End()
+		if s.Step != nil {
+			p.print(", ")
+			p.expr(s.Step)
+			header = s.Step.End()
+		}
+		p.print(" do")
+		p.block(s.Body, header, s.End())
+		p.print("end")
+	case *ast.GenericForStat:
+		p.print("for ")
+		p.identList(s.Names)
+		p.print(" in ")
+		p.exprList(s.Exprs)
+		p.print(" do")
+		p.block(s.Body, s.Exprs[len(s.Exprs)-1].End(), s.End())
+		p.print("end")
+	case *ast.FunctionStat:
+		p.print("function ")
+		for i, name := range s.Name.Path {
+			if i > 0 {
+				p.print(".")
+			}
+			p.print(name.Name)
+		}
+		if s.Name.Method != nil {
+			p.print(":", s.Name.Method.Name)
+		}
+		p.funcBody(s.Func)
+	case *ast.LocalFunctionStat:
+		p.print("local function ", s.Name.Name)
+		p.funcBody(s.Func)
+	case *ast.LocalStat:
+		p.print("local ")
+		for i, name := range s.Names {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.print(name.Name)
+			if i < len(s.Attribs) && s.Attribs[i] != nil {
+				p.print(" <", s.Attribs[i].Name, ">")
+			}
+		}
+		if len(s.Values) != 0 {
+			p.print(" = ")
+			p.exprList(s.Values)
+		}
+	case *ast.ReturnStat:
+		p.print("return")
+		if len(s.Values) != 0 {
+			p.print(" ")
+			p.exprList(s.Values)
+		}
+	case *ast.BadStat:
+		if p.err == nil {
+			p.err = fmt.Errorf("printer: %s: statement failed to parse", s.Pos())
+		}
+	default:
+		panic("printer: unexpected statement type")
+	}
+}
+
+// funcBody prints the parameters and body of a function.
+// A function written on one line with at most one short statement
+// in its body stays on one line.
+func (p *printer) funcBody(fn *ast.FunctionExpr) {
+	p.print("(")
+	p.identList(fn.Params)
+	if fn.IsVararg {
+		if len(fn.Params) != 0 {
+			p.print(", ")
+		}
+		p.print("...")
+	}
+	p.print(")")
+
+	if fn.Pos().Line == fn.End().Line && len(fn.Body.Stats) <= 1 && !p.hasComments(fn) {
+		mark := p.buf.Len()
+		for _, s := range fn.Body.Stats {
+			p.print(" ")
+			p.stat(s)
+		}
+		p.print(" end")
+		if bytes.IndexByte(p.buf.Bytes()[mark:], '\n') < 0 {
+			return
+		}
+		p.buf.Truncate(mark)
+	}
+
+	header := fn.Pos()
+	if len(fn.Params) != 0 {
+		header = fn.Params[len(fn.Params)-1].End()
+	}
+	p.block(fn.Body, header, fn.End())
+	p.print("end")
+}
+
+func (p *printer) identList(list []*ast.Ident) {
+	for i, id := range list {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.print(id.Name)
+	}
+}
+
+func (p *printer) exprList(list []ast.Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		p.print(x.Name)
+	case *ast.NilExpr:
+		p.print("nil")
+	case *ast.BoolExpr:
+		p.print(strconv.FormatBool(x.Value))
+	case *ast.NumberExpr:
+		p.print(number(x))
+	case *ast.StringExpr:
+		if x.Raw != "" {
+			p.print(x.Raw)
+		} else {
+			p.print(quote(x.Value))
+		}
+	case *ast.VarargExpr:
+		p.print("...")
+	case *ast.FunctionExpr:
+		p.print("function")
+		p.funcBody(x)
+	case *ast.TableExpr:
+		p.table(x)
+	case *ast.BinaryExpr:
+		left, right := x.Op.Priority()
+		p.operand(x.Left, func(lp, rp int) bool { return left <= rp })
+		p.print(" ", x.Op.String(), " ")
+		// a unary operator takes its operand with it wherever it appears
+		p.operand(x.Right, func(lp, rp int) bool { return lp > right || lp == ast.UnaryPriority })
+	case *ast.UnaryExpr:
+		p.print(x.Op.String())
+		if x.Op == ast.OpNot || startsWithMinus(x.Operand) {
+			p.print(" ")
+		}
+		p.operand(x.Operand, func(lp, rp int) bool { return lp >= ast.UnaryPriority })
+	case *ast.ParenExpr:
+		p.print("(")
+		p.expr(x.X)
+		p.print(")")
+	case *ast.IndexExpr:
+		p.prefix(x.Object)
+		if key, ok := x.Key.(*ast.StringExpr); ok && x.Dot {
+			p.print(".", key.Value)
+		} else {
+			p.print("[")
+			p.expr(x.Key)
+			p.print("]")
+		}
+	case *ast.CallExpr:
+		p.prefix(x.Func)
+		if x.Method != nil {
+			p.print(":", x.Method.Name)
+		}
+		if len(x.Args) == 1 && x.Args[0].End() == x.End() {
+			// f "str" and f {fields} keep their sugar
+			switch x.Args[0].(type) {
+			case *ast.StringExpr, *ast.TableExpr:
+				p.print(" ")
+				p.expr(x.Args[0])
+				return
+			}
+		}
+		p.print("(")
+		p.exprList(x.Args)
+		p.print(")")
+	default:
+		panic("printer: unexpected expression type")
+	}
+}
+
+// operand prints an operand of an operator, in parentheses if the
+// operator would not otherwise bind it. The function ok reports whether
+// an operand whose operator has the given priorities binds as intended.
+func (p *printer) operand(x ast.Expr, ok func(left, right int) bool) {
+	paren := false
+	switch y := x.(type) {
+	case *ast.BinaryExpr:
+		paren = !ok(y.Op.Priority())
+	case *ast.UnaryExpr:
+		paren = !ok(ast.UnaryPriority, ast.UnaryPriority)
+	case *ast.NumberExpr:
+		paren = startsWithMinus(y) && !ok(ast.UnaryPriority, ast.UnaryPriority)
+	}
+	if paren {
+		p.print("(")
+		p.expr(x)
+		p.print(")")
+		return
+	}
+	p.expr(x)
+}
+
+// prefix prints the object of an index or call expression,
+// in parentheses unless it is a name, index, call or parenthesized
+// expression.
+func (p *printer) prefix(x ast.Expr) {
+	switch x.(type) {
+	case *ast.Ident, *ast.IndexExpr, *ast.CallExpr, *ast.ParenExpr:
+		p.expr(x)
+	default:
+		p.print("(")
+		p.expr(x)
+		p.print(")")
+	}
+}
+
+// table prints a table constructor. A constructor written on one line
+// stays on one line; otherwise each field is put on its own line.
+func (p *printer) table(t *ast.TableExpr) {
+	if t.Pos().Line == t.End().Line && !p.hasComments(t) {
+		p.print("{")
+		for i, f := range t.Fields {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.field(f)
+		}
+		p.print("}")
+		return
+	}
+
+	p.print("{")
+	p.indent++
+	line := 0
+	for i, f := range t.Fields {
+		next := t.End()
+		if i+1 < len(t.Fields) {
+			next = t.Fields[i+1].Pos()
+		}
+		line = p.leading(f.Pos(), line)
+		p.item(line, f.Pos().Line)
+		p.field(f)
+		p.print(",")
+		line = p.trailing(f.End(), next)
+	}
+	p.leading(t.End(), line)
+	p.indent--
+	p.linebreak()
+	p.print("}")
+}
+
+func (p *printer) field(f *ast.Field) {
+	switch f.Kind {
+	case ast.NamedField:
+		p.print(f.Key.(*ast.StringExpr).Value, " = ")
+	case ast.KeyedField:
+		p.print("[")
+		p.expr(f.Key)
+		p.print("] = ")
+	}
+	p.expr(f.Value)
+}
+
+// startsWithParen reports whether the statement begins with '('.
+func startsWithParen(s ast.Stat) bool {
+	var x ast.Expr
+	switch s := s.(type) {
+	case *ast.CallStat:
+		x = s.Call
+	case *ast.AssignStat:
+		x = s.Targets[0]
+	default:
+		return false
+	}
+	for {
+		switch y := x.(type) {
+		case *ast.CallExpr:
+			x = y.Func
+		case *ast.IndexExpr:
+			x = y.Object
+		case *ast.Ident:
+			return false
+		default:
+			return true
+		}
+	}
+}
+
+// startsWithMinus reports whether the expression is printed with
+// a leading '-', which must not follow another '-'.
+func startsWithMinus(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.UnaryExpr:
+		return x.Op == ast.OpNeg
+	case *ast.NumberExpr:
+		return strings.HasPrefix(number(x), "-")
+	}
+	return false
+}
+
+// comments returns the comments in the trivia of the chunk, in source
+// order. Where they are attached does not matter; the printer places
+// each one by its position.
+func comments(c *ast.Chunk) []lexer.Trivia {
+	var list []lexer.Trivia
+	for _, t := range c.Trivia {
+		for _, group := range [][]lexer.Trivia{t.Leading, t.Trailing, t.Inner} {
+			for _, tr := range group {
+				if tr.IsComment() {
+					list = append(list, tr)
+				}
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pos.Offset < list[j].Pos.Offset })
+	return list
+}
+
+func commentText(c lexer.Trivia) string {
+	if c.Kind == lexer.LineComment {
+		return strings.TrimRight(string(c.Text), " \t")
+	}
+	return string(c.Text)
+}
+
+// number returns the source form of a numeral. Numerals created by
+// tools rather than read from the source are formatted from their value.
+func number(x *ast.NumberExpr) string {
+	if x.Raw != "" {
+		return x.Raw
+	}
+	if !x.IsFloat {
+		if x.Int == math.MinInt64 {
+			// its decimal form would be read as a float
+			return "0x8000000000000000"
+		}
+		return strconv.FormatInt(x.Int, 10)
+	}
+	switch f := x.Float; {
+	case math.IsInf(f, 1):
+		return "1e9999"
+	case math.IsInf(f, -1):
+		return "-1e9999"
+	case math.IsNaN(f):
+		return "(0/0)"
+	}
+	s := strconv.FormatFloat(x.Float, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// quote returns s as a double-quoted Lua string literal.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if ch < ' ' || ch == 0x7f {
+				b.WriteString(`\`)
+				b.WriteString(strconv.Itoa(int(ch) + 1000)[1:])
+				continue
+			}
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
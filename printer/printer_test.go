@@ -0,0 +1,121 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package printer
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/syntax"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files with the formatted inputs")
+
+// TestGolden formats each .input file in testdata and compares the
+// result with the .golden file of the same name. The golden files are
+// in canonical form, so formatting them again must not change them.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no inputs in testdata")
+	}
+	for _, input := range inputs {
+		golden := strings.TrimSuffix(input, ".input") + ".golden"
+		src, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Format(input, src)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		if *update {
+			if err := os.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: formatted as\n%s\nwant\n%s", input, got, want)
+		}
+		again, err := Format(golden, want)
+		if err != nil {
+			t.Errorf("%s: %v", golden, err)
+		} else if !bytes.Equal(again, want) {
+			t.Errorf("%s: not idempotent, formatted again as\n%s", golden, again)
+		}
+	}
+}
+
+func TestFprint(t *testing.T) {
+	const src = "-- a comment\nx = 1 -- another\n"
+	for _, tc := range []struct {
+		mode syntax.Mode
+		want string
+	}{
+		{0, "x = 1\n"},
+		{syntax.ParseTrivia, src},
+	} {
+		chunk, err := syntax.ParseChunk("test", []byte(src), tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Fprint(&buf, chunk); err != nil {
+			t.Errorf("mode %d: %v", tc.mode, err)
+		} else if got := buf.String(); got != tc.want {
+			t.Errorf("mode %d: printed %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+
+	// a statement that failed to parse cannot be printed
+	tree, err := syntax.ParseMode("test", []byte("x = 1\ny = = 2\n"), syntax.RecoverErrors)
+	if err == nil {
+		t.Fatal("parsed a chunk with a syntax error")
+	}
+	var buf bytes.Buffer
+	if err := Fprint(&buf, syntax.Lower(tree)); err == nil {
+		t.Errorf("printed a chunk with a bad statement as %q", buf.String())
+	} else if buf.Len() != 0 {
+		t.Errorf("wrote %q before the error", buf.String())
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	if got, err := Format("test", []byte("x = = 1")); err == nil {
+		t.Errorf("formatted a syntax error as %q", got)
+	}
+}
@@ -0,0 +1,385 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package resolve binds each name in a Lua chunk to the variable it
+// refers to.
+//
+// A name refers to a local variable of the function it appears in,
+// to an upvalue, which is a local variable of an enclosing function
+// captured by a closure, or to a global, which is a field of the table
+// in _ENV. The resolver follows the scoping rules of Lua 5.4 and lays
+// out the locals of each function in slots the way its compiler does.
+package resolve
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mdhender/glua/ast"
+)
+
+// Kind tells how a name is bound.
+type Kind int
+
+const (
+	Local   Kind = iota // a local variable of the function
+	Upvalue             // a local variable of an enclosing function
+	Global              // a field of _ENV
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Local:
+		return "local"
+	case Upvalue:
+		return "upvalue"
+	case Global:
+		return "global"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Var is a local variable.
+type Var struct {
+	Name     string
+	Decl     *ast.Ident // where the variable is declared; nil for the implicit self
+	Func     *Function  // the function the variable is local to
+	Slot     int        // the variable's slot in the function's frame
+	Captured bool       // true if a closure captures the variable
+	Attrib   string     // "const", "close", or "" for a plain variable
+}
+
+// UpvalueDesc describes how a function finds one of its upvalues when
+// a closure of it is created: in a slot of the enclosing function's
+// frame, or in one of the enclosing function's own upvalues.
+type UpvalueDesc struct {
+	Name    string
+	InStack bool // true if Index is a slot of the enclosing function
+	Index   int  // the slot or upvalue index in the enclosing function
+	Var     *Var // the variable captured; nil for the _ENV of the main chunk
+}
+
+// Function holds the variables of a function.
+type Function struct {
+	Node     ast.Node // the *ast.FunctionExpr, or the *ast.Chunk for the main chunk
+	Parent   *Function
+	Params   []*Var // the parameters, starting with self for a method
+	Locals   []*Var // every local variable, in order of declaration
+	Upvalues []*UpvalueDesc
+	MaxSlots int // the number of slots the function's locals need
+}
+
+// Binding is what a name refers to.
+type Binding struct {
+	Kind Kind
+
+	// Var is the variable for a local or an upvalue.
+	// It is nil for the upvalue holding the main chunk's _ENV.
+	Var *Var
+
+	// Index is the slot of a local or the index of an upvalue
+	// in the function where the name appears.
+	Index int
+
+	// Env is the binding of _ENV for a global.
+	Env *Binding
+}
+
+// Info is the result of resolving a chunk.
+type Info struct {
+	Uses  map[*ast.Ident]*Binding // the binding of each name used as a variable
+	Defs  map[*ast.Ident]*Var     // the variable declared by each name
+	Funcs map[ast.Node]*Function  // the function of each *ast.FunctionExpr and of the *ast.Chunk
+}
+
+// Globals returns the names in the chunk that refer to globals,
+// in source order.
+func (info *Info) Globals() []*ast.Ident {
+	var list []*ast.Ident
+	for id, b := range info.Uses {
+		if b.Kind == Global {
+			list = append(list, id)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pos().Offset < list[j].Pos().Offset })
+	return list
+}
+
+// Resolve resolves the names in chunk.
+func Resolve(chunk *ast.Chunk) *Info {
+	r := &resolver{info: &Info{
+		Uses:  map[*ast.Ident]*Binding{},
+		Defs:  map[*ast.Ident]*Var{},
+		Funcs: map[ast.Node]*Function{},
+	}}
+	// the main chunk is a vararg function with a single upvalue, _ENV
+	r.openFunction(chunk)
+	r.fn.f.Upvalues = append(r.fn.f.Upvalues, &UpvalueDesc{Name: "_ENV", InStack: true})
+	r.stats(chunk.Block.Stats)
+	r.closeFunction()
+	return r.info
+}
+
+type resolver struct {
+	info *Info
+	fn   *funcState // the function being resolved
+}
+
+// funcState is the state of a function being resolved.
+type funcState struct {
+	parent *funcState
+	f      *Function
+	active []*Var // active locals, innermost last
+	nslots int    // slots in use, including those of hidden loop state
+}
+
+func (r *resolver) openFunction(node ast.Node) {
+	f := &Function{Node: node}
+	if r.fn != nil {
+		f.Parent = r.fn.f
+	}
+	r.info.Funcs[node] = f
+	r.fn = &funcState{parent: r.fn, f: f}
+}
+
+func (r *resolver) closeFunction() {
+	r.fn = r.fn.parent
+}
+
+// scope is a mark for the locals active when a block is opened.
+type scope struct {
+	nactive, nslots int
+}
+
+func (r *resolver) openScope() scope {
+	return scope{nactive: len(r.fn.active), nslots: r.fn.nslots}
+}
+
+// closeScope ends the scope of the locals declared since s was opened
+// and frees their slots.
+func (r *resolver) closeScope(s scope) {
+	r.fn.active, r.fn.nslots = r.fn.active[:s.nactive], s.nslots
+}
+
+// reserve sets aside n slots for the hidden state of a loop.
+func (r *resolver) reserve(n int) {
+	r.fn.nslots += n
+	if r.fn.nslots > r.fn.f.MaxSlots {
+		r.fn.f.MaxSlots = r.fn.nslots
+	}
+}
+
+// declare declares a local variable and brings it into scope.
+// The declaration may be nil for an implicit variable.
+func (r *resolver) declare(decl *ast.Ident, name string) *Var {
+	fs := r.fn
+	v := &Var{Name: name, Decl: decl, Func: fs.f, Slot: fs.nslots}
+	fs.f.Locals = append(fs.f.Locals, v)
+	fs.active = append(fs.active, v)
+	r.reserve(1)
+	if decl != nil {
+		r.info.Defs[decl] = v
+	}
+	return v
+}
+
+// use records the binding of a name used as a variable.
+func (r *resolver) use(id *ast.Ident) {
+	r.info.Uses[id] = r.lookup(id.Name)
+}
+
+// lookup returns the binding of name in the current function.
+func (r *resolver) lookup(name string) *Binding {
+	if b := find(r.fn, name); b != nil {
+		return b
+	}
+	return &Binding{Kind: Global, Env: r.lookup("_ENV")}
+}
+
+// find returns the binding of name as a local or upvalue of the
+// function fs, or nil if there is none and the name is a global.
+// Finding a local of an enclosing function adds an upvalue for it
+// to each function between the two.
+func find(fs *funcState, name string) *Binding {
+	for i := len(fs.active) - 1; i >= 0; i-- {
+		if v := fs.active[i]; v.Name == name {
+			return &Binding{Kind: Local, Var: v, Index: v.Slot}
+		}
+	}
+	for i, u := range fs.f.Upvalues {
+		if u.Name == name {
+			return &Binding{Kind: Upvalue, Var: u.Var, Index: i}
+		}
+	}
+	if fs.parent == nil {
+		return nil
+	}
+	b := find(fs.parent, name)
+	if b == nil {
+		return nil
+	}
+	u := &UpvalueDesc{Name: name, InStack: b.Kind == Local, Index: b.Index, Var: b.Var}
+	if b.Kind == Local {
+		b.Var.Captured = true
+	}
+	fs.f.Upvalues = append(fs.f.Upvalues, u)
+	return &Binding{Kind: Upvalue, Var: b.Var, Index: len(fs.f.Upvalues) - 1}
+}
+
+// function resolves a function. A method has an implicit first
+// parameter, self.
+func (r *resolver) function(fn *ast.FunctionExpr, method bool) {
+	r.openFunction(fn)
+	f := r.fn.f
+	if method {
+		f.Params = append(f.Params, r.declare(nil, "self"))
+	}
+	for _, p := range fn.Params {
+		f.Params = append(f.Params, r.declare(p, p.Name))
+	}
+	r.stats(fn.Body.Stats)
+	r.closeFunction()
+}
+
+// block resolves a block in a scope of its own.
+func (r *resolver) block(b *ast.Block) {
+	s := r.openScope()
+	r.stats(b.Stats)
+	r.closeScope(s)
+}
+
+func (r *resolver) stats(list []ast.Stat) {
+	for _, s := range list {
+		r.stat(s)
+	}
+}
+
+func (r *resolver) stat(s ast.Stat) {
+	switch s := s.(type) {
+	case *ast.BadStat, *ast.EmptyStat, *ast.LabelStat, *ast.BreakStat, *ast.GotoStat:
+		// no names to resolve
+	case *ast.AssignStat:
+		r.exprs(s.Targets)
+		r.exprs(s.Values)
+	case *ast.CallStat:
+		r.expr(s.Call)
+	case *ast.DoStat:
+		r.block(s.Body)
+	case *ast.WhileStat:
+		r.expr(s.Cond)
+		r.block(s.Body)
+	case *ast.RepeatStat:
+		// the condition is in the scope of the body's locals
+		sc := r.openScope()
+		r.stats(s.Body.Stats)
+		r.expr(s.Cond)
+		r.closeScope(sc)
+	case *ast.IfStat:
+		for _, c := range s.Clauses {
+			r.expr(c.Cond)
+			r.block(c.Body)
+		}
+		if s.Else != nil {
+			r.block(s.Else)
+		}
+	case *ast.NumericForStat:
+		r.expr(s.Start)
+		r.expr(s.Limit)
+		if s.Step != nil {
+			r.expr(s.Step)
+		}
+		sc := r.openScope()
+		r.reserve(3)
+		r.declare(s.Var, s.Var.Name)
+		r.block(s.Body)
+		r.closeScope(sc)
+	case *ast.GenericForStat:
+		r.exprs(s.Exprs)
+		sc := r.openScope()
+		r.reserve(4)
+		for _, name := range s.Names {
+			r.declare(name, name.Name)
+		}
+		r.block(s.Body)
+		r.closeScope(sc)
+	case *ast.FunctionStat:
+		r.use(s.Name.Path[0])
+		r.function(s.Func, s.Name.Method != nil)
+	case *ast.LocalFunctionStat:
+		// the function can refer to itself
+		r.declare(s.Name, s.Name.Name)
+		r.function(s.Func, false)
+	case *ast.LocalStat:
+		r.exprs(s.Values)
+		for i, name := range s.Names {
+			v := r.declare(name, name.Name)
+			if i < len(s.Attribs) && s.Attribs[i] != nil {
+				v.Attrib = s.Attribs[i].Name
+			}
+		}
+	case *ast.ReturnStat:
+		r.exprs(s.Values)
+	default:
+		panic(fmt.Sprintf("resolve: unexpected statement type %T", s))
+	}
+}
+
+func (r *resolver) exprs(list []ast.Expr) {
+	for _, x := range list {
+		r.expr(x)
+	}
+}
+
+func (r *resolver) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		r.use(x)
+	case *ast.NilExpr, *ast.BoolExpr, *ast.NumberExpr, *ast.StringExpr, *ast.VarargExpr:
+		// no names to resolve
+	case *ast.FunctionExpr:
+		r.function(x, false)
+	case *ast.TableExpr:
+		for _, f := range x.Fields {
+			if f.Kind == ast.KeyedField {
+				r.expr(f.Key)
+			}
+			r.expr(f.Value)
+		}
+	case *ast.BinaryExpr:
+		r.expr(x.Left)
+		r.expr(x.Right)
+	case *ast.UnaryExpr:
+		r.expr(x.Operand)
+	case *ast.ParenExpr:
+		r.expr(x.X)
+	case *ast.IndexExpr:
+		r.expr(x.Object)
+		r.expr(x.Key)
+	case *ast.CallExpr:
+		r.expr(x.Func)
+		r.exprs(x.Args)
+	default:
+		panic(fmt.Sprintf("resolve: unexpected expression type %T", x))
+	}
+}
@@ -0,0 +1,199 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package resolve
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/syntax"
+)
+
+// uses renders the binding of each name used in the chunk, in source
+// order, as name=kind/index. A global shows the binding of its _ENV.
+func uses(info *Info) string {
+	var ids []*ast.Ident
+	for id := range info.Uses {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos().Offset < ids[j].Pos().Offset })
+	var s []string
+	for _, id := range ids {
+		b := info.Uses[id]
+		if b.Kind == Global {
+			s = append(s, fmt.Sprintf("%s=global(%v/%d)", id.Name, b.Env.Kind, b.Env.Index))
+		} else {
+			s = append(s, fmt.Sprintf("%s=%v/%d", id.Name, b.Kind, b.Index))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+// defs renders the slot of each variable declared in the chunk, in
+// source order, marking the variables that closures capture with '*'.
+func defs(info *Info) string {
+	var ids []*ast.Ident
+	for id := range info.Defs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos().Offset < ids[j].Pos().Offset })
+	var s []string
+	for _, id := range ids {
+		v := info.Defs[id]
+		d := fmt.Sprintf("%s@%d", id.Name, v.Slot)
+		if v.Captured {
+			d += "*"
+		}
+		s = append(s, d)
+	}
+	return strings.Join(s, " ")
+}
+
+// upvalues renders the upvalues of each function, outermost first and
+// then in source order, as name=slot/index for one in the enclosing
+// function's frame and as name=upvalue/index for one of the enclosing
+// function's upvalues.
+func upvalues(info *Info) string {
+	var fns []*Function
+	for _, f := range info.Funcs {
+		fns = append(fns, f)
+	}
+	depth := func(f *Function) int {
+		n := 0
+		for ; f.Parent != nil; f = f.Parent {
+			n++
+		}
+		return n
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if di, dj := depth(fns[i]), depth(fns[j]); di != dj {
+			return di < dj
+		}
+		return fns[i].Node.Pos().Offset < fns[j].Node.Pos().Offset
+	})
+	var s []string
+	for _, f := range fns {
+		var ups []string
+		for _, u := range f.Upvalues {
+			where := "upvalue"
+			if u.InStack {
+				where = "slot"
+			}
+			ups = append(ups, fmt.Sprintf("%s=%s/%d", u.Name, where, u.Index))
+		}
+		s = append(s, "("+strings.Join(ups, " ")+")")
+	}
+	return strings.Join(s, " ")
+}
+
+func resolve(t *testing.T, src string) *Info {
+	t.Helper()
+	chunk, err := syntax.ParseChunk("test", []byte(src), 0)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	return Resolve(chunk)
+}
+
+func TestUses(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"local x = 1; return x, y", "x=local/0 y=global(upvalue/0)"},
+		{"local x = 1; local x = x + 1; return x", "x=local/0 x=local/1"},
+		{"local x = 1; do local x = 2 end return x", "x=local/0"},
+		{"local function f() return f end", "f=upvalue/0"},
+		{"local f = function() return f end", "f=global(upvalue/0)"},
+		{"repeat local x = 1 until x", "x=local/0"},
+		{"repeat local x = 1 until x; return x", "x=local/0 x=global(upvalue/0)"},
+		{"for i = 1, 2 do x = i end return i", "x=global(upvalue/0) i=local/3 i=global(upvalue/0)"},
+		{"local _ENV = {}; x = 1", "x=global(local/0)"},
+		{"local _ENV = {}; function f() return x end", "f=global(local/0) x=global(upvalue/0)"},
+		{"function t:m() return self end", "t=global(upvalue/0) self=local/0"},
+		{"local a; local function f() local function g() return a end end", "a=upvalue/0"},
+	} {
+		if got := uses(resolve(t, tc.src)); got != tc.want {
+			t.Errorf("%q\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"local a, b; local c", "a@0 b@1 c@2"},
+		{"do local a end local b", "a@0 b@0"},
+		{"local a; do local b; do local c end local d end local e", "a@0 b@1 c@2 d@2 e@1"},
+		// a numeric for keeps its state in three hidden slots
+		// and a generic for in four
+		{"for i = 1, 2 do local j end", "i@3 j@4"},
+		{"for k, v in t do end", "k@4 v@5"},
+		{"local x; local function f(a, b) local c end", "x@0 f@1 a@0 b@1 c@2"},
+		{"local x; function f() return x end", "x@0*"},
+		{"local x; function f() local x; return x end", "x@0 x@0"},
+	} {
+		if got := defs(resolve(t, tc.src)); got != tc.want {
+			t.Errorf("%q\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+// TestUpvalues checks the chain through which a closure reaches a
+// variable of a function further out.
+func TestUpvalues(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"return x", "(_ENV=slot/0)"},
+		{"return function() return x end", "(_ENV=slot/0) (_ENV=upvalue/0)"},
+		{"local a; return function() return a end", "(_ENV=slot/0) (a=slot/0)"},
+		{"local a, b; return function() return b, a, b end", "(_ENV=slot/0) (b=slot/1 a=slot/0)"},
+		{"local a; return function() return function() return function() return a end end end",
+			"(_ENV=slot/0) (a=slot/0) (a=upvalue/0) (a=upvalue/0)"},
+		{"local a; return function() local b; return function() return a, b, c end end",
+			"(_ENV=slot/0) (a=slot/0 _ENV=upvalue/0) (a=upvalue/0 b=slot/0 _ENV=upvalue/1)"},
+		{"local a, b; f = function() return a end; g = function() return b end",
+			"(_ENV=slot/0) (a=slot/0) (b=slot/1)"},
+	} {
+		if got := upvalues(resolve(t, tc.src)); got != tc.want {
+			t.Errorf("%q\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	info := resolve(t, "local print = print\nx = y\nfunction f(a) return a + z end")
+	var names []string
+	for _, id := range info.Globals() {
+		names = append(names, id.Name)
+	}
+	if got, want := strings.Join(names, " "), "print x y f z"; got != want {
+		t.Errorf("Globals() = %s, want %s", got, want)
+	}
+}
@@ -22,11 +22,10 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-package main
+package syntax
 
 import (
-	"bytes"
-	"fmt"
+	"github.com/mdhender/glua/lexer"
 )
 
 func (p parser) accept() (parser, *node, error) {
@@ -38,10 +37,7 @@ func (p parser) accept() (parser, *node, error) {
 
 // chunk ::= block
 func (p parser) accept_chunk() (parser, *CHUNK, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
-	pSaved, chunk := p, &CHUNK{}
+	pSaved, chunk := p, &CHUNK{name: p.chunk}
 	var err error
 
 	p, chunk.block, err = p.accept_block()
@@ -51,35 +47,49 @@ func (p parser) accept_chunk() (parser, *CHUNK, error) {
 	if chunk.block == nil {
 		return pSaved, nil, nil
 	}
+	chunk.span = spanOf(pSaved, p)
 	return p, chunk, nil
 }
 
 // block ::= {stat} [retstat]
+//
+// A block may be empty, so this never returns a nil block without an error.
+// When the parser is recovering from errors, it never returns an error:
+// a statement that fails to parse is replaced by an error statement.
 func (p parser) accept_block() (parser, *BLOCK, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
 	pSaved, block := p, &BLOCK{}
 
-	// accept {stat}
+	// accept {stat} [retstat]
 	for {
 		pp, stat, err := p.accept_stat()
+		if err == nil && stat == nil {
+			// accept retstat
+			var retstat *RETSTAT
+			if pp, retstat, err = p.accept_retstat(); err == nil {
+				if retstat != nil {
+					block.retstat, p = retstat, pp
+					break
+				}
+				if endsBlock(p.peek()) {
+					break
+				}
+				// a token that can neither start a statement nor end
+				// the block
+				err = p.expected("exp")
+			}
+		}
 		if err != nil {
-			return pSaved, nil, err
-		} else if stat == nil {
-			break
+			if !p.recovering() {
+				return pSaved, nil, err
+			}
+			// put an error statement in place of the broken one
+			pp, stat = p.recover(err)
 		}
 		block.stat = append(block.stat, stat)
 		p = pp
 	}
 
-	if pp, retstat, err := p.accept_retstat(); err != nil {
-		return pSaved, nil, err
-	} else if retstat != nil {
-		block.retstat = retstat
-		p = pp
-	}
-
+	block.span = spanOf(pSaved, p)
 	return p, block, nil
 }
 
@@ -108,112 +118,109 @@ func (p parser) accept_stat() (parser, *STAT, error) {
 	if pp, rule, err := p.accept_stat_rule1(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule1: rule}, nil
+		return pp, &STAT{span: rule.span, rule1: rule}, nil
 	}
 
-	// accept stat.rule2
-	if pp, rule, err := p.accept_stat_rule2(); err != nil {
+	// accept stat.rule2 or stat.rule3. Both start with a prefixexp, which
+	// is parsed once and then finished as the rule it turns out to start,
+	// the way the reference implementation's exprstat does. Trying each
+	// rule in turn would parse the function bodies in an assignment
+	// target twice for each level of nesting.
+	if pp, prefixexp, err := p.accept_prefixexp(); err != nil {
 		return pSaved, nil, err
-	} else if rule != nil {
-		return pp, &STAT{rule2: rule}, nil
-	}
-
-	// accept stat.rule3
-	if pp, rule, err := p.accept_stat_rule3(); err != nil {
-		return pSaved, nil, err
-	} else if rule != nil {
-		return pp, &STAT{rule3: rule}, nil
+	} else if prefixexp != nil {
+		if pp, rule := pp.accept_stat_rule3(pSaved, prefixexp); rule != nil {
+			return pp, &STAT{span: rule.span, rule3: rule}, nil
+		}
+		pp, rule, err := pp.accept_stat_rule2(pSaved, prefixexp)
+		if err != nil {
+			return pSaved, nil, err
+		}
+		return pp, &STAT{span: rule.span, rule2: rule}, nil
 	}
 
 	// accept stat.rule4
 	if pp, rule, err := p.accept_stat_rule4(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule4: rule}, nil
+		return pp, &STAT{span: rule.span, rule4: rule}, nil
 	}
 
 	// accept stat.rule5
 	if pp, rule, err := p.accept_stat_rule5(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule5: rule}, nil
+		return pp, &STAT{span: rule.span, rule5: rule}, nil
 	}
 
 	// accept stat.rule6
 	if pp, rule, err := p.accept_stat_rule6(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule6: rule}, nil
+		return pp, &STAT{span: rule.span, rule6: rule}, nil
 	}
 
 	// accept stat.rule7
 	if pp, rule, err := p.accept_stat_rule7(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule7: rule}, nil
+		return pp, &STAT{span: rule.span, rule7: rule}, nil
 	}
 
 	// accept stat.rule8
 	if pp, rule, err := p.accept_stat_rule8(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule8: rule}, nil
+		return pp, &STAT{span: rule.span, rule8: rule}, nil
 	}
 
 	// accept stat.rule9
 	if pp, rule, err := p.accept_stat_rule9(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule9: rule}, nil
+		return pp, &STAT{span: rule.span, rule9: rule}, nil
 	}
 
 	// accept stat.rule10
 	if pp, rule, err := p.accept_stat_rule10(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule10: rule}, nil
+		return pp, &STAT{span: rule.span, rule10: rule}, nil
 	}
 
 	// accept stat.rule11
 	if pp, rule, err := p.accept_stat_rule11(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule11: rule}, nil
+		return pp, &STAT{span: rule.span, rule11: rule}, nil
 	}
 
 	// accept stat.rule12
 	if pp, rule, err := p.accept_stat_rule12(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule12: rule}, nil
-	}
-
-	// accept stat.rule13
-	if pp, rule, err := p.accept_stat_rule13(); err != nil {
-		return pSaved, nil, err
-	} else if rule != nil {
-		return pp, &STAT{rule13: rule}, nil
+		return pp, &STAT{span: rule.span, rule12: rule}, nil
 	}
 
 	// accept stat.rule13
 	if pp, rule, err := p.accept_stat_rule13(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule13: rule}, nil
+		return pp, &STAT{span: rule.span, rule13: rule}, nil
 	}
 
 	// accept stat.rule14
 	if pp, rule, err := p.accept_stat_rule14(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule14: rule}, nil
+		return pp, &STAT{span: rule.span, rule14: rule}, nil
 	}
 
 	// accept stat.rule15
 	if pp, rule, err := p.accept_stat_rule15(); err != nil {
 		return pSaved, nil, err
 	} else if rule != nil {
-		return pp, &STAT{rule15: rule}, nil
+		return pp, &STAT{span: rule.span, rule15: rule}, nil
 	}
 
 	return pSaved, nil, nil
@@ -228,58 +235,57 @@ func (p parser) accept_stat_rule1() (parser, *STAT_RULE1, error) {
 
 	// accept ';'
 	var semiColon []byte
-	if p, semiColon = p.accept_Literal(';'); semiColon == nil {
+	if p, semiColon = p.accept_Literal(lexer.SEMICOLON); semiColon == nil {
 		return pSaved, nil, nil
 	}
 
-	return pSaved, &STAT_RULE1{}, nil
+	return p, &STAT_RULE1{span: spanOf(pSaved, p)}, nil
 }
 
 // stat.rule2 ::= varlist '=' explist
-func (p parser) accept_stat_rule2() (parser, *STAT_RULE2, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
-	pSaved, rule := p, &STAT_RULE2{}
+//
+// The parser is past prefixexp, the start of the varlist, which was
+// parsed from pSaved.
+func (p parser) accept_stat_rule2(pSaved parser, prefixexp *PREFIXEXP) (parser, *STAT_RULE2, error) {
+	rule := &STAT_RULE2{}
 	var err error
 
 	// accept varlist
-	if p, rule.varlist, err = p.accept_varlist(); err != nil {
+	if p, rule.varlist, err = p.accept_varlist(pSaved, prefixexp); err != nil {
 		return pSaved, nil, err
-	} else if rule.varlist == nil {
-		return pSaved, nil, nil
 	}
 	// expect '='
 	var equals []byte
-	if p, equals = p.accept_Literal('='); equals == nil {
-		return pSaved, nil, fmt.Errorf("expected '='")
+	if p, equals = p.accept_Literal(lexer.ASSIGN); equals == nil {
+		if len(rule.varlist.variables) == 1 {
+			// a lone var is an expression statement that is not a call
+			return pSaved, nil, p.errorf("syntax error")
+		}
+		return pSaved, nil, p.expectedToken(lexer.ASSIGN)
 	}
 	// accept explist
 	if p, rule.explist, err = p.accept_explist(); err != nil {
 		return pSaved, nil, err
 	} else if rule.explist == nil {
-		return pSaved, nil, fmt.Errorf("expected explist")
+		return pSaved, nil, p.expected("exp")
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
 // stat.rule3 ::= functioncall
-func (p parser) accept_stat_rule3() (parser, *STAT_RULE3, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
-	pSaved, rule := p, &STAT_RULE3{}
-	var err error
-
-	// accept functioncall
-	if p, rule.functioncall, err = p.accept_functioncall(); err != nil {
-		return pSaved, nil, err
-	} else if rule.functioncall == nil {
-		return pSaved, nil, nil
-	}
-
-	return p, rule, nil
+//
+// The parser is past prefixexp, which was parsed from pSaved. It is a
+// call statement if it is a functioncall that does not start a varlist;
+// a call is not a var, and accept_stat_rule2 reports it if it does.
+func (p parser) accept_stat_rule3(pSaved parser, prefixexp *PREFIXEXP) (parser, *STAT_RULE3) {
+	if prefixexp.functioncall == nil {
+		return pSaved, nil
+	} else if k := p.peek(); k == lexer.ASSIGN || k == lexer.COMMA {
+		return pSaved, nil
+	}
+	return p, &STAT_RULE3{span: spanOf(pSaved, p), functioncall: prefixexp.functioncall}
 }
 
 // stat.rule4 ::= label
@@ -297,6 +303,7 @@ func (p parser) accept_stat_rule4() (parser, *STAT_RULE4, error) {
 		return pSaved, nil, nil
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
@@ -309,11 +316,11 @@ func (p parser) accept_stat_rule5() (parser, *STAT_RULE5, error) {
 
 	// accept 'break'
 	var kwBreak *KEYWORD
-	if p, kwBreak = p.accept_Keyword('b', 'r', 'e', 'a', 'k'); kwBreak == nil {
+	if p, kwBreak = p.accept_Keyword(lexer.BREAK); kwBreak == nil {
 		return pSaved, nil, nil
 	}
 
-	return p, &STAT_RULE5{}, nil
+	return p, &STAT_RULE5{span: spanOf(pSaved, p)}, nil
 }
 
 // stat.rule6 ::= 'goto' Name
@@ -326,16 +333,17 @@ func (p parser) accept_stat_rule6() (parser, *STAT_RULE6, error) {
 
 	// accept 'goto'
 	var kwGoto *KEYWORD
-	if p, kwGoto = p.accept_Keyword('g', 'o', 't', 'o'); kwGoto == nil {
+	if p, kwGoto = p.accept_Keyword(lexer.GOTO); kwGoto == nil {
 		return pSaved, nil, nil
 	}
 	// expect Name
 	if p, rule.name, err = p.accept_Name(); err != nil {
 		return pSaved, nil, err
 	} else if rule.name == nil {
-		return pSaved, nil, fmt.Errorf("expected name")
+		return pSaved, nil, p.expectedToken(lexer.NAME)
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
@@ -349,21 +357,22 @@ func (p parser) accept_stat_rule7() (parser, *STAT_RULE7, error) {
 
 	// accept 'do'
 	var kwDo *KEYWORD
-	if p, kwDo = p.accept_Keyword('d', 'o'); kwDo == nil {
+	if p, kwDo = p.accept_Keyword(lexer.DO); kwDo == nil {
 		return pSaved, nil, nil
 	}
 	// expect block
 	if p, rule.block, err = p.accept_block(); err != nil {
 		return pSaved, nil, err
 	} else if rule.block == nil {
-		return pSaved, nil, fmt.Errorf("expected block")
+		return pSaved, nil, p.expected("block")
 	}
 	// expect 'end'
 	var kwEnd *KEYWORD
-	if p, kwEnd = p.accept_Keyword('e', 'n', 'd'); kwEnd == nil {
-		return pSaved, nil, nil
+	if p, kwEnd = p.accept_Keyword(lexer.END); kwEnd == nil {
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.DO, kwDo.pos)
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
@@ -377,32 +386,33 @@ func (p parser) accept_stat_rule8() (parser, *STAT_RULE8, error) {
 
 	// accept 'while'
 	var kwWhile *KEYWORD
-	if p, kwWhile = p.accept_Keyword('w', 'h', 'i', 'l', 'e'); kwWhile == nil {
+	if p, kwWhile = p.accept_Keyword(lexer.WHILE); kwWhile == nil {
 		return pSaved, nil, nil
 	}
 	// expect exp
 	if p, rule.exp, err = p.accept_exp(); err != nil {
 		return pSaved, nil, err
 	} else if rule.exp == nil {
-		return pSaved, nil, fmt.Errorf("expected exp")
+		return pSaved, nil, p.expected("exp")
 	}
 	// expect 'do'
 	var kwDo *KEYWORD
-	if p, kwDo = p.accept_Keyword('d', 'o'); kwDo == nil {
-		return pSaved, nil, fmt.Errorf("expected 'do'")
+	if p, kwDo = p.accept_Keyword(lexer.DO); kwDo == nil {
+		return pSaved, nil, p.expectedToken(lexer.DO)
 	}
 	// expect block
 	if p, rule.block, err = p.accept_block(); err != nil {
 		return pSaved, nil, err
 	} else if rule.block == nil {
-		return pSaved, nil, fmt.Errorf("expected block")
+		return pSaved, nil, p.expected("block")
 	}
 	// expect 'end'
 	var kwEnd *KEYWORD
-	if p, kwEnd = p.accept_Keyword('e', 'n', 'd'); kwEnd == nil {
-		return pSaved, nil, nil
+	if p, kwEnd = p.accept_Keyword(lexer.END); kwEnd == nil {
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.WHILE, kwWhile.pos)
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
@@ -416,27 +426,28 @@ func (p parser) accept_stat_rule9() (parser, *STAT_RULE9, error) {
 
 	// accept 'repeat'
 	var kwRepeat *KEYWORD
-	if p, kwRepeat = p.accept_Keyword('r', 'e', 'p', 'e', 'a', 't'); kwRepeat == nil {
+	if p, kwRepeat = p.accept_Keyword(lexer.REPEAT); kwRepeat == nil {
 		return pSaved, nil, nil
 	}
 	// expect block
 	if p, rule.block, err = p.accept_block(); err != nil {
 		return pSaved, nil, err
 	} else if rule.block == nil {
-		return pSaved, nil, fmt.Errorf("expected block")
+		return pSaved, nil, p.expected("block")
 	}
 	// expect 'until'
 	var kwUntil *KEYWORD
-	if p, kwUntil = p.accept_Keyword('u', 'n', 't', 'i', 'l'); kwUntil == nil {
-		return pSaved, nil, fmt.Errorf("expected 'until'")
+	if p, kwUntil = p.accept_Keyword(lexer.UNTIL); kwUntil == nil {
+		return pSaved, nil, p.expectedMatch(lexer.UNTIL, lexer.REPEAT, kwRepeat.pos)
 	}
 	// expect exp
 	if p, rule.exp, err = p.accept_exp(); err != nil {
 		return pSaved, nil, err
 	} else if rule.exp == nil {
-		return pSaved, nil, fmt.Errorf("expected exp")
+		return pSaved, nil, p.expected("exp")
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
@@ -450,7 +461,7 @@ func (p parser) accept_stat_rule10() (parser, *STAT_RULE10, error) {
 
 	// accept 'if'
 	var kwIf *KEYWORD
-	if p, kwIf = p.accept_Keyword('i', 'f'); kwIf == nil {
+	if p, kwIf = p.accept_Keyword(lexer.IF); kwIf == nil {
 		return pSaved, nil, nil
 	}
 	// expect exp
@@ -458,70 +469,137 @@ func (p parser) accept_stat_rule10() (parser, *STAT_RULE10, error) {
 	if p, exp, err = p.accept_exp(); err != nil {
 		return pSaved, nil, err
 	} else if exp == nil {
-		return pSaved, nil, fmt.Errorf("expected exp")
+		return pSaved, nil, p.expected("exp")
 	}
 	// expect 'then'
 	var kwThen *KEYWORD
-	if p, kwThen = p.accept_Keyword('t', 'h', 'e', 'n'); kwThen == nil {
-		return pSaved, nil, fmt.Errorf("expected 'then'")
+	if p, kwThen = p.accept_Keyword(lexer.THEN); kwThen == nil {
+		return pSaved, nil, p.expectedToken(lexer.THEN)
 	}
 	// expect block
 	var block *BLOCK
 	if p, block, err = p.accept_block(); err != nil {
 		return pSaved, nil, err
 	} else if block == nil {
-		return pSaved, nil, fmt.Errorf("expected block")
+		return pSaved, nil, p.expected("block")
 	}
-	rule.expblock = append(rule.expblock, &EXPBLOCK{exp: exp, block: block})
+	rule.expblock = append(rule.expblock, &EXPBLOCK{span: spanOf(pSaved, p), exp: exp, block: block})
 	// accept {'elseif' exp 'then' block}
 	for {
 		// accept 'elseif' exp 'then' block
-		pp, kwElseIf := p.accept_Keyword('e', 'l', 's', 'e', 'i', 'f')
+		pElseIf := p
+		pp, kwElseIf := p.accept_Keyword(lexer.ELSEIF)
 		if kwElseIf == nil {
 			break
 		}
 		// expect exp
-		if p, block, err = pp.accept_block(); err != nil {
+		if p, exp, err = pp.accept_exp(); err != nil {
 			return pSaved, nil, err
-		} else if block == nil {
-			return pSaved, nil, fmt.Errorf("expected block")
+		} else if exp == nil {
+			return pSaved, nil, pp.expected("exp")
 		}
 		// expect 'then'
-		if p, kwThen = p.accept_Keyword('t', 'h', 'e', 'n'); kwThen == nil {
-			return pSaved, nil, fmt.Errorf("expected 'then'")
+		if p, kwThen = p.accept_Keyword(lexer.THEN); kwThen == nil {
+			return pSaved, nil, p.expectedToken(lexer.THEN)
 		}
 		// expect block
-		if p, block, err = pp.accept_block(); err != nil {
+		if p, block, err = p.accept_block(); err != nil {
 			return pSaved, nil, err
 		} else if block == nil {
-			return pSaved, nil, fmt.Errorf("expected block")
+			return pSaved, nil, p.expected("block")
 		}
-		rule.expblock = append(rule.expblock, &EXPBLOCK{exp: exp, block: block})
+		rule.expblock = append(rule.expblock, &EXPBLOCK{span: spanOf(pElseIf, p), exp: exp, block: block})
 	}
 	// accept ['else' block]
-	if pp, kwElse := p.accept_Keyword('e', 'l', 's', 'e'); kwElse != nil {
+	if pp, kwElse := p.accept_Keyword(lexer.ELSE); kwElse != nil {
+		rule.kwElse = kwElse
 		// expect block
 		if p, rule.elseBlock, err = pp.accept_block(); err != nil {
 			return pSaved, nil, err
 		} else if rule.elseBlock == nil {
-			return pSaved, nil, fmt.Errorf("expected block")
+			return pSaved, nil, p.expected("block")
 		}
 	}
 	// expect 'end'
 	var kwEnd *KEYWORD
-	if p, kwEnd = p.accept_Keyword('e', 'n', 'd'); kwEnd == nil {
-		return pSaved, nil, fmt.Errorf("expected 'end'")
+	if p, kwEnd = p.accept_Keyword(lexer.END); kwEnd == nil {
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.IF, kwIf.pos)
 	}
 
+	rule.span = spanOf(pSaved, p)
 	return p, rule, nil
 }
 
 // stat.rule11 ::= 'for' Name '=' exp ',' exp [',' exp] 'do' block 'end'
+//
+// This rule and stat.rule12 share the prefix 'for' Name. The '=' that
+// follows is what tells them apart, so we never look further ahead.
 func (p parser) accept_stat_rule11() (parser, *STAT_RULE11, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!")
+	pSaved, rule := p, &STAT_RULE11{}
+	var err error
+
+	// accept 'for' Name '='
+	var kwFor *KEYWORD
+	if p, kwFor = p.accept_Keyword(lexer.FOR); kwFor == nil {
+		return pSaved, nil, nil
+	}
+	if p, rule.name, err = p.accept_Name(); err != nil {
+		return pSaved, nil, err
+	} else if rule.name == nil {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
+	var equals []byte
+	if p, equals = p.accept_Literal(lexer.ASSIGN); equals == nil {
+		return pSaved, nil, nil
+	}
+	// expect exp
+	if p, rule.exp1, err = p.accept_exp(); err != nil {
+		return pSaved, nil, err
+	} else if rule.exp1 == nil {
+		return pSaved, nil, p.expected("exp")
+	}
+	// expect ','
+	var comma []byte
+	if p, comma = p.accept_Literal(lexer.COMMA); comma == nil {
+		return pSaved, nil, p.expectedToken(lexer.COMMA)
+	}
+	// expect exp
+	if p, rule.exp2, err = p.accept_exp(); err != nil {
+		return pSaved, nil, err
+	} else if rule.exp2 == nil {
+		return pSaved, nil, p.expected("exp")
+	}
+	// accept [',' exp]
+	if pp, comma := p.accept_Literal(lexer.COMMA); comma != nil {
+		// expect exp
+		if p, rule.exp3, err = pp.accept_exp(); err != nil {
+			return pSaved, nil, err
+		} else if rule.exp3 == nil {
+			return pSaved, nil, pp.expected("exp")
+		}
+	}
+	// expect 'do'
+	var kwDo *KEYWORD
+	if p, kwDo = p.accept_Keyword(lexer.DO); kwDo == nil {
+		return pSaved, nil, p.expectedToken(lexer.DO)
+	}
+	// expect block
+	if p, rule.block, err = p.accept_block(); err != nil {
+		return pSaved, nil, err
+	} else if rule.block == nil {
+		return pSaved, nil, p.expected("block")
+	}
+	// expect 'end'
+	var kwEnd *KEYWORD
+	if p, kwEnd = p.accept_Keyword(lexer.END); kwEnd == nil {
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.FOR, kwFor.pos)
+	}
+
+	rule.span = spanOf(pSaved, p)
+	return p, rule, nil
 }
 
 // stat.rule12 ::= 'for' namelist 'in' explist 'do' block 'end'
@@ -529,7 +607,53 @@ func (p parser) accept_stat_rule12() (parser, *STAT_RULE12, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!")
+	pSaved, rule := p, &STAT_RULE12{}
+	var err error
+
+	// accept 'for'
+	var kwFor *KEYWORD
+	if p, kwFor = p.accept_Keyword(lexer.FOR); kwFor == nil {
+		return pSaved, nil, nil
+	}
+	// expect namelist
+	if p, rule.namelist, err = p.accept_namelist(); err != nil {
+		return pSaved, nil, err
+	} else if rule.namelist == nil {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
+	// expect 'in'
+	var kwIn *KEYWORD
+	if p, kwIn = p.accept_Keyword(lexer.IN); kwIn == nil {
+		if len(rule.namelist.names) == 1 {
+			return pSaved, nil, p.expectedToken(lexer.ASSIGN, lexer.IN)
+		}
+		return pSaved, nil, p.expectedToken(lexer.IN)
+	}
+	// expect explist
+	if p, rule.explist, err = p.accept_explist(); err != nil {
+		return pSaved, nil, err
+	} else if rule.explist == nil {
+		return pSaved, nil, p.expected("exp")
+	}
+	// expect 'do'
+	var kwDo *KEYWORD
+	if p, kwDo = p.accept_Keyword(lexer.DO); kwDo == nil {
+		return pSaved, nil, p.expectedToken(lexer.DO)
+	}
+	// expect block
+	if p, rule.block, err = p.accept_block(); err != nil {
+		return pSaved, nil, err
+	} else if rule.block == nil {
+		return pSaved, nil, p.expected("block")
+	}
+	// expect 'end'
+	var kwEnd *KEYWORD
+	if p, kwEnd = p.accept_Keyword(lexer.END); kwEnd == nil {
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.FOR, kwFor.pos)
+	}
+
+	rule.span = spanOf(pSaved, p)
+	return p, rule, nil
 }
 
 // stat.rule13 ::= 'function' funcname funcbody
@@ -537,15 +661,65 @@ func (p parser) accept_stat_rule13() (parser, *STAT_RULE13, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!")
+	pSaved, rule := p, &STAT_RULE13{}
+	var err error
+
+	// accept 'function'
+	var kwFunction *KEYWORD
+	if p, kwFunction = p.accept_Keyword(lexer.FUNCTION); kwFunction == nil {
+		return pSaved, nil, nil
+	}
+	// expect funcname
+	if p, rule.funcname, err = p.accept_funcname(); err != nil {
+		return pSaved, nil, err
+	} else if rule.funcname == nil {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
+	// expect funcbody
+	if p, rule.funcbody, err = p.accept_funcbody(); err != nil {
+		return pSaved, nil, err
+	} else if rule.funcbody == nil {
+		return pSaved, nil, p.expectedToken(lexer.LPAREN)
+	}
+
+	rule.span = spanOf(pSaved, p)
+	return p, rule, nil
 }
 
 // stat.rule14 ::= 'local' 'function' Name funcbody
+//
+// This rule and stat.rule15 share the prefix 'local'; the 'function'
+// keyword that follows is what tells them apart.
 func (p parser) accept_stat_rule14() (parser, *STAT_RULE14, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!")
+	pSaved, rule := p, &STAT_RULE14{}
+	var err error
+
+	// accept 'local' 'function'
+	var kwLocal, kwFunction *KEYWORD
+	if p, kwLocal = p.accept_Keyword(lexer.LOCAL); kwLocal == nil {
+		return pSaved, nil, nil
+	}
+	if p, kwFunction = p.accept_Keyword(lexer.FUNCTION); kwFunction == nil {
+		return pSaved, nil, nil
+	}
+	// expect Name
+	if p, rule.name, err = p.accept_Name(); err != nil {
+		return pSaved, nil, err
+	} else if rule.name == nil {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
+	// expect funcbody
+	if p, rule.funcbody, err = p.accept_funcbody(); err != nil {
+		return pSaved, nil, err
+	} else if rule.funcbody == nil {
+		return pSaved, nil, p.expectedToken(lexer.LPAREN)
+	}
+
+	rule.span = spanOf(pSaved, p)
+	return p, rule, nil
 }
 
 // stat.rule15 ::= 'local' attnamelist ['=' explist]
@@ -553,7 +727,32 @@ func (p parser) accept_stat_rule15() (parser, *STAT_RULE15, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!")
+	pSaved, rule := p, &STAT_RULE15{}
+	var err error
+
+	// accept 'local'
+	var kwLocal *KEYWORD
+	if p, kwLocal = p.accept_Keyword(lexer.LOCAL); kwLocal == nil {
+		return pSaved, nil, nil
+	}
+	// expect attnamelist
+	if p, rule.attnamelist, err = p.accept_attnamelist(); err != nil {
+		return pSaved, nil, err
+	} else if rule.attnamelist == nil {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
+	// accept ['=' explist]
+	if pp, equals := p.accept_Literal(lexer.ASSIGN); equals != nil {
+		// expect explist
+		if p, rule.explist, err = pp.accept_explist(); err != nil {
+			return pSaved, nil, err
+		} else if rule.explist == nil {
+			return pSaved, nil, pp.expected("exp")
+		}
+	}
+
+	rule.span = spanOf(pSaved, p)
+	return p, rule, nil
 }
 
 // attnamelist ::= Name attrib {',' Name attrib}
@@ -571,40 +770,40 @@ func (p parser) accept_attnamelist() (parser, *ATTNAMELIST, error) {
 	} else if name == nil {
 		return pSaved, nil, nil
 	}
-	// accept attrib
+	// accept attrib, which may be empty
 	var attrib *ATTRIB
 	if p, attrib, err = p.accept_attrib(); err != nil {
 		return pSaved, nil, err
-	} else if attrib == nil {
-		return pSaved, nil, nil
 	}
-	attnamelist.attnamelist = append(attnamelist.attnamelist, &NAMEATTRIB{name: name, attrib: attrib})
+	attnamelist.attnamelist = append(attnamelist.attnamelist, &NAMEATTRIB{span: spanOf(pSaved, p), name: name, attrib: attrib})
 	// accept {',' Name attrib}
 	for {
 		// accept ','
-		pp, comma := p.accept_Literal(',')
+		pp, comma := p.accept_Literal(lexer.COMMA)
 		if comma == nil {
 			break
 		}
 		// expect Name
+		pName := pp
 		if p, name, err = pp.accept_Name(); err != nil {
 			return pSaved, nil, err
 		} else if name == nil {
-			return pSaved, nil, fmt.Errorf("expected name")
+			return pSaved, nil, p.expectedToken(lexer.NAME)
 		}
-		// expect attrib
+		// accept attrib, which may be empty
 		if p, attrib, err = p.accept_attrib(); err != nil {
 			return pSaved, nil, err
-		} else if attrib == nil {
-			return pSaved, nil, fmt.Errorf("expected attrib")
 		}
-		attnamelist.attnamelist = append(attnamelist.attnamelist, &NAMEATTRIB{name: name, attrib: attrib})
+		attnamelist.attnamelist = append(attnamelist.attnamelist, &NAMEATTRIB{span: spanOf(pName, p), name: name, attrib: attrib})
 	}
 
+	attnamelist.span = spanOf(pSaved, p)
 	return p, attnamelist, nil
 }
 
 // attrib ::= ['<' Name '>']
+//
+// An empty attrib is returned as nil.
 func (p parser) accept_attrib() (parser, *ATTRIB, error) {
 	if eof(p) {
 		return p, nil, nil
@@ -614,21 +813,22 @@ func (p parser) accept_attrib() (parser, *ATTRIB, error) {
 
 	// accept '<'
 	var oBroket []byte
-	if p, oBroket = p.accept_Literal('<'); oBroket == nil {
+	if p, oBroket = p.accept_Literal(lexer.LT); oBroket == nil {
 		return pSaved, nil, nil
 	}
 	// expect Name
 	if p, attrib.name, err = p.accept_Name(); err != nil {
 		return pSaved, nil, err
 	} else if attrib.name == nil {
-		return pSaved, nil, fmt.Errorf("expected name")
+		return pSaved, nil, p.expectedToken(lexer.NAME)
 	}
 	// expect '>'
 	var cBroket []byte
-	if p, cBroket = p.accept_Literal(); cBroket == nil {
-		return pSaved, nil, fmt.Errorf("expected '<'")
+	if p, cBroket = p.accept_Literal(lexer.GT); cBroket == nil {
+		return pSaved, nil, p.expectedToken(lexer.GT)
 	}
 
+	attrib.span = spanOf(pSaved, p)
 	return p, attrib, nil
 }
 
@@ -642,7 +842,7 @@ func (p parser) accept_retstat() (parser, *RETSTAT, error) {
 
 	// accept 'return'
 	var kwReturn *KEYWORD
-	if p, kwReturn = p.accept_Keyword('r', 'e', 't', 'u', 'r', 'n'); kwReturn == nil {
+	if p, kwReturn = p.accept_Keyword(lexer.RETURN); kwReturn == nil {
 		return pSaved, nil, nil
 	}
 
@@ -652,8 +852,9 @@ func (p parser) accept_retstat() (parser, *RETSTAT, error) {
 	}
 
 	// accept [';']
-	p, _ = p.accept_Literal(';')
+	p, _ = p.accept_Literal(lexer.SEMICOLON)
 
+	retstat.span = spanOf(pSaved, p)
 	return p, retstat, nil
 }
 
@@ -667,20 +868,21 @@ func (p parser) accept_label() (parser, *LABEL, error) {
 
 	// accept '::
 	var colonColon []byte
-	if p, colonColon = p.accept_Literal(':', ':'); colonColon == nil {
+	if p, colonColon = p.accept_Literal(lexer.COLONCOLON); colonColon == nil {
 		return pSaved, nil, nil
 	}
 	// expect Name
 	if p, label.name, err = p.accept_Name(); err != nil {
 		return pSaved, nil, err
 	} else if label.name == nil {
-		return pSaved, nil, fmt.Errorf("expected name")
+		return pSaved, nil, p.expectedToken(lexer.NAME)
 	}
 	// expect '::
-	if p, colonColon = p.accept_Literal(':', ':'); colonColon == nil {
-		return pSaved, nil, fmt.Errorf("expected '::'")
+	if p, colonColon = p.accept_Literal(lexer.COLONCOLON); colonColon == nil {
+		return pSaved, nil, p.expectedToken(lexer.COLONCOLON)
 	}
 
+	label.span = spanOf(pSaved, p)
 	return p, label, nil
 }
 
@@ -695,12 +897,14 @@ func (p parser) accept_funcname() (parser, *FUNCNAME, error) {
 	// accept Name
 	if p, funcname.name, err = p.accept_Name(); err != nil {
 		return pSaved, nil, err
+	} else if funcname.name == nil {
+		return pSaved, nil, nil
 	}
 
 	// accept {'.' Name}
 	for {
 		// accept '.'
-		pp, dot := p.accept_Literal('.')
+		pp, dot := p.accept_Literal(lexer.DOT)
 		if dot == nil {
 			break
 		}
@@ -709,57 +913,59 @@ func (p parser) accept_funcname() (parser, *FUNCNAME, error) {
 		if p, name, err = pp.accept_Name(); err != nil {
 			return pSaved, nil, err
 		} else if name == nil {
-			return pSaved, nil, fmt.Errorf("expected name")
+			return pSaved, nil, p.expectedToken(lexer.NAME)
 		}
 		funcname.dotName = append(funcname.dotName, name)
 	}
 
 	// accept [':' Name]
-	pp, colon := p.accept_Literal(':')
+	pp, colon := p.accept_Literal(lexer.COLON)
 	if colon == nil {
+		funcname.span = spanOf(pSaved, p)
 		return p, funcname, nil
 	}
 	// expect Name
 	if p, funcname.colonName, err = pp.accept_Name(); err != nil {
 		return pSaved, nil, err
 	} else if funcname.colonName == nil {
-		return pSaved, nil, fmt.Errorf("expected name")
+		return pSaved, nil, p.expectedToken(lexer.NAME)
 	}
 
+	funcname.span = spanOf(pSaved, p)
 	return p, funcname, nil
 }
 
 // varlist ::= var {',' var}
-func (p parser) accept_varlist() (parser, *VARLIST, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
-	pSaved, varlist := p, &VARLIST{}
+//
+// The parser is past prefixexp, the first var, which was parsed from
+// pSaved.
+func (p parser) accept_varlist(pSaved parser, prefixexp *PREFIXEXP) (parser, *VARLIST, error) {
+	varlist := &VARLIST{}
 	var err error
 
-	// accept var
-	var variable *VARIABLE
-	if p, variable, err = p.accept_var(); err != nil {
-		return pSaved, nil, err
-	} else if variable == nil {
-		return pSaved, nil, nil
+	// the prefixexp must be a var
+	if prefixexp.variable == nil {
+		// a call or a parenthesized expression cannot be assigned to
+		return pSaved, nil, p.errorf("syntax error")
 	}
+	variable := prefixexp.variable
 	varlist.variables = append(varlist.variables, variable)
 
 	// accept {',' var}
 	for {
-		pp, comma := p.accept_Literal(',')
+		pp, comma := p.accept_Literal(lexer.COMMA)
 		if comma == nil {
 			break
 		}
 		if pp, variable, err = pp.accept_var(); err != nil {
 			return pSaved, nil, err
 		} else if variable == nil {
-			return pSaved, nil, fmt.Errorf("expected var")
+			return pSaved, nil, pp.expected("exp")
 		}
 		p, varlist.variables = pp, append(varlist.variables, variable)
 	}
 
+	varlist.span = spanOf(pSaved, p)
 	return p, varlist, nil
 }
 
@@ -770,7 +976,20 @@ func (p parser) accept_var() (parser, *VARIABLE, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	panic("!implemented")
+	pSaved := p
+
+	// accept prefixexp, which must turn out to be a var
+	pp, prefixexp, err := p.accept_prefixexp()
+	if err != nil {
+		return pSaved, nil, err
+	} else if prefixexp == nil {
+		return pSaved, nil, nil
+	} else if prefixexp.variable == nil {
+		// a call or a parenthesized expression cannot be assigned to
+		return pSaved, nil, pp.errorf("syntax error")
+	}
+
+	return pp, prefixexp.variable, nil
 }
 
 // namelist ::= Name {',' Name}
@@ -792,18 +1011,20 @@ func (p parser) accept_namelist() (parser, *NAMELIST, error) {
 
 	// accept {',' Name}
 	for {
-		pp, comma := p.accept_Literal(',')
-		if comma == nil {
+		pp, comma := p.accept_Literal(lexer.COMMA)
+		if comma == nil || pp.peek() == lexer.DOTDOTDOT {
+			// a trailing ',' '...' belongs to the enclosing parlist
 			break
 		}
 		if pp, name, err = pp.accept_Name(); err != nil {
 			return pSaved, nil, err
 		} else if name == nil {
-			return pSaved, nil, fmt.Errorf("expected Name")
+			return pSaved, nil, pp.expectedToken(lexer.NAME)
 		}
 		p, namelist.names = pp, append(namelist.names, name)
 	}
 
+	namelist.span = spanOf(pSaved, p)
 	return p, namelist, nil
 }
 
@@ -826,18 +1047,19 @@ func (p parser) accept_explist() (parser, *EXPLIST, error) {
 
 	// accept {',' exp}
 	for {
-		pp, comma := p.accept_Literal(',')
+		pp, comma := p.accept_Literal(lexer.COMMA)
 		if comma == nil {
 			break
 		}
 		if pp, exp, err = pp.accept_exp(); err != nil {
 			return pSaved, nil, err
 		} else if exp == nil {
-			return pSaved, nil, fmt.Errorf("expected exp")
+			return pSaved, nil, pp.expected("exp")
 		}
 		p, explist.exps = pp, append(explist.exps, exp)
 	}
 
+	explist.span = spanOf(pSaved, p)
 	return p, explist, nil
 }
 
@@ -852,7 +1074,80 @@ func (p parser) accept_explist() (parser, *EXPLIST, error) {
 //       | tableconstructor
 //       | exp binop exp
 //       | unop exp
+//
+// The grammar is ambiguous and left recursive, so we parse it by
+// precedence climbing. See accept_subexp.
 func (p parser) accept_exp() (parser, *EXP, error) {
+	return p.accept_subexp(0)
+}
+
+// subexp ::= (simpleexp | unop subexp) {binop subexp}
+//
+// Only binary operators with a left priority greater than limit are
+// accepted, which gives the tree the precedence and associativity of
+// the reference implementation.
+func (p parser) accept_subexp(limit int) (parser, *EXP, error) {
+	if eof(p) {
+		return p, nil, nil
+	}
+	pSaved := p
+	var exp *EXP
+
+	// accept unop subexp
+	if pp, unop, err := p.accept_unop(); err != nil {
+		return pSaved, nil, err
+	} else if unop != nil {
+		unopExp := &UNOP_EXP{unop: unop}
+		// expect subexp
+		if pp, unopExp.exp, err = pp.accept_subexp(unaryPriority); err != nil {
+			return pSaved, nil, err
+		} else if unopExp.exp == nil {
+			return pSaved, nil, pp.expected("exp")
+		}
+		unopExp.span = spanOf(pSaved, pp)
+		p, exp = pp, &EXP{span: unopExp.span, unopExp: unopExp}
+	} else if p, exp, err = p.accept_simpleexp(); err != nil {
+		return pSaved, nil, err
+	} else if exp == nil {
+		return pSaved, nil, nil
+	}
+
+	// accept {binop subexp}
+	for {
+		pp, binop, err := p.accept_binop()
+		if err != nil {
+			return pSaved, nil, err
+		} else if binop == nil {
+			break
+		}
+		left, right := binop.priority()
+		if left <= limit {
+			break
+		}
+		expBinopExp := &EXP_BINOP_EXP{exp1: exp, binop: binop}
+		// expect subexp
+		if pp, expBinopExp.exp2, err = pp.accept_subexp(right); err != nil {
+			return pSaved, nil, err
+		} else if expBinopExp.exp2 == nil {
+			return pSaved, nil, pp.expected("exp")
+		}
+		expBinopExp.span = spanOf(pSaved, pp)
+		p, exp = pp, &EXP{span: expBinopExp.span, expBinopExp: expBinopExp}
+	}
+
+	return p, exp, nil
+}
+
+// simpleexp ::= 'nil'
+//             | 'false'
+//             | 'true'
+//             | Numeral
+//             | LiteralString
+//             | '...'
+//             | functiondef
+//             | prefixexp
+//             | tableconstructor
+func (p parser) accept_simpleexp() (parser, *EXP, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
@@ -860,172 +1155,179 @@ func (p parser) accept_exp() (parser, *EXP, error) {
 	var err error
 
 	var literal []byte
-	if p, literal = p.accept_Literal('n', 'i', 'l'); literal != nil {
-		return p, &EXP{NIL: true}, nil
+	if p, literal = p.accept_Literal(lexer.NIL); literal != nil {
+		return p, &EXP{span: spanOf(pSaved, p), NIL: true}, nil
 	}
-	if p, literal = p.accept_Literal('f', 'a', 'l', 's', 'e'); literal != nil {
-		return p, &EXP{FALSE: true}, nil
+	if p, literal = p.accept_Literal(lexer.FALSE); literal != nil {
+		return p, &EXP{span: spanOf(pSaved, p), FALSE: true}, nil
 	}
-	if p, literal = p.accept_Literal('t', 'r', 'u', 'e'); literal != nil {
-		return p, &EXP{TRUE: true}, nil
+	if p, literal = p.accept_Literal(lexer.TRUE); literal != nil {
+		return p, &EXP{span: spanOf(pSaved, p), TRUE: true}, nil
 	}
 	if p, exp.numeral, err = p.accept_Numeral(); err != nil {
 		return pSaved, nil, err
 	} else if exp.numeral != nil {
+		exp.span = spanOf(pSaved, p)
 		return p, exp, nil
 	}
 	if p, exp.literalString, err = p.accept_LiteralString(); err != nil {
 		return pSaved, nil, err
 	} else if exp.literalString != nil {
+		exp.span = spanOf(pSaved, p)
 		return p, exp, nil
 	}
-	if p, literal = p.accept_Literal('.', '.', '.'); literal != nil {
-		return p, &EXP{dotDotDot: true}, nil
+	if p, literal = p.accept_Literal(lexer.DOTDOTDOT); literal != nil {
+		return p, &EXP{span: spanOf(pSaved, p), dotDotDot: true}, nil
 	}
 	if p, exp.functiondef, err = p.accept_functiondef(); err != nil {
 		return pSaved, nil, err
 	} else if exp.functiondef != nil {
+		exp.span = spanOf(pSaved, p)
 		return p, exp, nil
 	}
 	if p, exp.prefixexp, err = p.accept_prefixexp(); err != nil {
 		return pSaved, nil, err
 	} else if exp.prefixexp != nil {
+		exp.span = spanOf(pSaved, p)
 		return p, exp, nil
 	}
 	if p, exp.tableconstructor, err = p.accept_tableconstructor(); err != nil {
 		return pSaved, nil, err
 	} else if exp.tableconstructor != nil {
+		exp.span = spanOf(pSaved, p)
 		return p, exp, nil
 	}
 
-	// accept exp binop exp
-	if pp, exp1, err := p.accept_exp(); err != nil {
-		return pSaved, nil, err
-	} else if exp1 != nil {
-		exp.expBinopExp = &EXP_BINOP_EXP{exp1: exp1}
-		// expect binop
-		if pp, exp.expBinopExp.binop, err = p.accept_binop(); err != nil {
-			return pSaved, nil, err
-		} else if exp.expBinopExp.binop == nil {
-			return pSaved, nil, fmt.Errorf("expected binop")
-		}
-		// expect exp
-		if pp, exp.expBinopExp.exp2, err = p.accept_exp(); err != nil {
-			return pSaved, nil, err
-		} else if exp.expBinopExp.exp2 == nil {
-			return pSaved, nil, fmt.Errorf("expected exp")
-		}
-		return pp, exp, nil
-	}
-
-	// accept unop exp
-	if pp, unop, err := p.accept_unop(); err != nil {
-		return pSaved, nil, err
-	} else if unop != nil {
-		exp.unopExp = &UNOP_EXP{unop: unop}
-		// expect exp
-		if pp, exp.unopExp.exp, err = p.accept_exp(); err != nil {
-			return pSaved, nil, err
-		} else if exp.unopExp.exp == nil {
-			return pSaved, nil, fmt.Errorf("expected exp")
-		}
-		return pp, exp, nil
-	}
-
 	return pSaved, nil, nil
 }
 
 // prefixexp ::= var
 //             | functioncall
 //             | '(' exp ')'
+//
+// The rules for var and functioncall are left recursive, so we parse
+// them iteratively, the way the reference implementation does:
+//
+// prefixexp ::= primaryexp {'.' Name | '[' exp ']' | ':' Name args | args}
 func (p parser) accept_prefixexp() (parser, *PREFIXEXP, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	pSaved, prefixexp := p, &PREFIXEXP{}
-	var err error
+	pSaved := p
 
-	// accept var
-	p, prefixexp.variable, err = p.accept_var()
+	// accept primaryexp
+	pp, prefixexp, err := p.accept_primaryexp()
 	if err != nil {
 		return pSaved, nil, err
+	} else if prefixexp == nil {
+		return pSaved, nil, nil
 	}
-	if prefixexp.variable != nil {
-		return p, prefixexp, nil
+	p = pp
+
+	// accept {'.' Name | '[' exp ']' | ':' Name args | args}
+	for {
+		switch p.peek() {
+		case lexer.DOT: // '.' Name
+			variable := &VARIABLE{prefixexp: prefixexp}
+			// expect Name
+			if pp, variable.field, err = p.skiptok(1).accept_Name(); err != nil {
+				return pSaved, nil, err
+			} else if variable.field == nil {
+				return pSaved, nil, p.skiptok(1).expectedToken(lexer.NAME)
+			}
+			variable.span = spanOf(pSaved, pp)
+			p, prefixexp = pp, &PREFIXEXP{span: variable.span, variable: variable}
+		case lexer.LBRACKET: // '[' exp ']'
+			variable := &VARIABLE{prefixexp: prefixexp}
+			// expect exp
+			if pp, variable.index, err = p.skiptok(1).accept_exp(); err != nil {
+				return pSaved, nil, err
+			} else if variable.index == nil {
+				return pSaved, nil, p.skiptok(1).expected("exp")
+			}
+			// expect ']'
+			var cBracket []byte
+			if pp, cBracket = pp.accept_Literal(lexer.RBRACKET); cBracket == nil {
+				return pSaved, nil, pp.expectedToken(lexer.RBRACKET)
+			}
+			variable.span = spanOf(pSaved, pp)
+			p, prefixexp = pp, &PREFIXEXP{span: variable.span, variable: variable}
+		case lexer.COLON: // ':' Name args
+			functioncall := &FUNCTIONCALL{prefixexp: prefixexp}
+			// expect Name
+			if pp, functioncall.name, err = p.skiptok(1).accept_Name(); err != nil {
+				return pSaved, nil, err
+			} else if functioncall.name == nil {
+				return pSaved, nil, p.skiptok(1).expectedToken(lexer.NAME)
+			}
+			// expect args
+			if pp, functioncall.args, err = pp.accept_args(); err != nil {
+				return pSaved, nil, err
+			} else if functioncall.args == nil {
+				return pSaved, nil, pp.expected("function arguments")
+			}
+			functioncall.span = spanOf(pSaved, pp)
+			p, prefixexp = pp, &PREFIXEXP{span: functioncall.span, functioncall: functioncall}
+		case lexer.LPAREN, lexer.STRING, lexer.LBRACE: // args
+			functioncall := &FUNCTIONCALL{prefixexp: prefixexp}
+			// expect args
+			if pp, functioncall.args, err = p.accept_args(); err != nil {
+				return pSaved, nil, err
+			} else if functioncall.args == nil {
+				return pSaved, nil, p.expected("function arguments")
+			}
+			functioncall.span = spanOf(pSaved, pp)
+			p, prefixexp = pp, &PREFIXEXP{span: functioncall.span, functioncall: functioncall}
+		default:
+			return p, prefixexp, nil
+		}
 	}
+}
 
-	// accept functioncall
-	p, prefixexp.functioncall, err = p.accept_functioncall()
-	if err != nil {
-		return pSaved, nil, err
+// primaryexp ::= Name
+//              | '(' exp ')'
+func (p parser) accept_primaryexp() (parser, *PREFIXEXP, error) {
+	if eof(p) {
+		return p, nil, nil
 	}
-	if prefixexp.functioncall != nil {
+	pSaved, prefixexp := p, &PREFIXEXP{}
+	var err error
+
+	// accept Name
+	var name *NAME
+	if p, name, err = p.accept_Name(); err != nil {
+		return pSaved, nil, err
+	} else if name != nil {
+		prefixexp.variable = &VARIABLE{span: name.span, name: name}
+		prefixexp.span = name.span
 		return p, prefixexp, nil
 	}
 
 	// accept '(' exp ')'
 	var oParen []byte
-	p, oParen = p.accept_Literal('(')
+	p, oParen = p.accept_Literal(lexer.LPAREN)
 	if oParen == nil {
 		return pSaved, nil, nil
 	}
 	// expect exp
 	p, prefixexp.exp, err = p.accept_exp()
+	if err != nil {
+		return pSaved, nil, err
+	}
 	if prefixexp.exp == nil {
-		return pSaved, nil, fmt.Errorf("expected exp")
+		return pSaved, nil, p.expected("exp")
 	}
 	// expect ')'
 	var cParen []byte
-	p, cParen = p.accept_Literal('(')
+	p, cParen = p.accept_Literal(lexer.RPAREN)
 	if cParen == nil {
-		return pSaved, nil, fmt.Errorf("expected ')'")
+		return pSaved, nil, p.expectedMatch(lexer.RPAREN, lexer.LPAREN, pSaved.pos())
 	}
 
+	prefixexp.span = spanOf(pSaved, p)
 	return p, prefixexp, nil
 }
 
-// functioncall ::= prefixexp args
-//                | prefixexp ':' Name args
-func (p parser) accept_functioncall() (parser, *FUNCTIONCALL, error) {
-	if eof(p) {
-		return p, nil, nil
-	}
-	pSaved, functioncall := p, &FUNCTIONCALL{}
-	var err error
-
-	// accept prefixexp
-	p, functioncall.prefixexp, err = p.accept_prefixexp()
-	if err != nil {
-		return pSaved, nil, err
-	}
-	if functioncall.prefixexp == nil {
-		return pSaved, nil, nil
-	}
-
-	// accept [':' Name]
-	if pp, colon := p.accept_Literal(':'); colon != nil {
-		// expect Name
-		p, functioncall.name, err = pp.accept_Name()
-		if err != nil {
-			return pSaved, nil, err
-		}
-		if functioncall.name == nil {
-			return pSaved, nil, fmt.Errorf("expected Name")
-		}
-	}
-
-	// expect args
-	p, functioncall.args, err = p.accept_args()
-	if err != nil {
-		return pSaved, nil, err
-	}
-	if functioncall.args == nil {
-		return pSaved, nil, fmt.Errorf("expected args")
-	}
-
-	return p, functioncall, nil
-}
-
 // args ::= '(' [explist] ')'
 //        | tableconstructor
 //        | LiteralString
@@ -1041,7 +1343,7 @@ func (p parser) accept_args() (parser, *ARGS, error) {
 		return pSaved, nil, err
 	}
 	if rule1 != nil {
-		return pp, &ARGS{rule1: rule1}, nil
+		return pp, &ARGS{span: rule1.span, rule1: rule1}, nil
 	}
 
 	// accept args.rule2
@@ -1050,7 +1352,7 @@ func (p parser) accept_args() (parser, *ARGS, error) {
 		return pSaved, nil, err
 	}
 	if rule2 != nil {
-		return pp, &ARGS{rule2: rule2}, nil
+		return pp, &ARGS{span: rule2.span, rule2: rule2}, nil
 	}
 
 	// accept args.rule3
@@ -1059,7 +1361,7 @@ func (p parser) accept_args() (parser, *ARGS, error) {
 		return pSaved, nil, err
 	}
 	if rule3 != nil {
-		return pp, &ARGS{rule3: rule3}, nil
+		return pp, &ARGS{span: rule3.span, rule3: rule3}, nil
 	}
 
 	return pSaved, nil, nil
@@ -1075,7 +1377,7 @@ func (p parser) accept_args_rule1() (parser, *ARGS_RULE1, error) {
 
 	// accept '('
 	var oParen []byte
-	p, oParen = p.accept_Literal('(')
+	p, oParen = p.accept_Literal(lexer.LPAREN)
 	if oParen == nil {
 		return pSaved, nil, nil
 	}
@@ -1085,14 +1387,18 @@ func (p parser) accept_args_rule1() (parser, *ARGS_RULE1, error) {
 	if err != nil {
 		return pSaved, nil, err
 	}
+	if args.explist == nil && p.peek() != lexer.RPAREN {
+		return pSaved, nil, p.expected("exp")
+	}
 
 	// expect ')'
 	var cParen []byte
-	p, cParen = p.accept_Literal(')')
+	p, cParen = p.accept_Literal(lexer.RPAREN)
 	if cParen == nil {
-		return pSaved, nil, fmt.Errorf("expected ')'")
+		return pSaved, nil, p.expectedMatch(lexer.RPAREN, lexer.LPAREN, pSaved.pos())
 	}
 
+	args.span = spanOf(pSaved, p)
 	return p, args, nil
 }
 
@@ -1108,7 +1414,11 @@ func (p parser) accept_args_rule2() (parser, *ARGS_RULE2, error) {
 	if err != nil {
 		return pSaved, nil, err
 	}
+	if args.tableconstructor == nil {
+		return pSaved, nil, nil
+	}
 
+	args.span = spanOf(pSaved, p)
 	return p, args, nil
 }
 
@@ -1124,7 +1434,11 @@ func (p parser) accept_args_rule3() (parser, *ARGS_RULE3, error) {
 	if err != nil {
 		return pSaved, nil, err
 	}
+	if args.literalString == nil {
+		return pSaved, nil, nil
+	}
 
+	args.span = spanOf(pSaved, p)
 	return p, args, nil
 }
 
@@ -1138,7 +1452,7 @@ func (p parser) accept_functiondef() (parser, *FUNCTIONDEF, error) {
 
 	// accept 'function'
 	var function []byte
-	p, function = p.accept_Literal('f', 'u', 'n', 'c', 't', 'i', 'o', 'n')
+	p, function = p.accept_Literal(lexer.FUNCTION)
 	if function == nil {
 		return pSaved, nil, nil
 	}
@@ -1149,9 +1463,10 @@ func (p parser) accept_functiondef() (parser, *FUNCTIONDEF, error) {
 		return pSaved, nil, err
 	}
 	if functiondef.funcbody == nil {
-		return pSaved, nil, fmt.Errorf("expected funcbody")
+		return pSaved, nil, p.expected("funcbody")
 	}
 
+	functiondef.span = spanOf(pSaved, p)
 	return p, functiondef, nil
 }
 
@@ -1165,7 +1480,7 @@ func (p parser) accept_funcbody() (parser, *FUNCBODY, error) {
 
 	// accept '('
 	var oParen []byte
-	p, oParen = p.accept_Literal('(')
+	p, oParen = p.accept_Literal(lexer.LPAREN)
 	if oParen == nil {
 		return pSaved, nil, nil
 	}
@@ -1175,12 +1490,15 @@ func (p parser) accept_funcbody() (parser, *FUNCBODY, error) {
 	if err != nil {
 		return pSaved, nil, err
 	}
+	if funcbody.parlist == nil && p.peek() != lexer.RPAREN {
+		return pSaved, nil, p.expectedToken(lexer.NAME)
+	}
 
 	// expect ')'
 	var cParen []byte
-	p, cParen = p.accept_Literal(')')
+	p, cParen = p.accept_Literal(lexer.RPAREN)
 	if cParen == nil {
-		return pSaved, nil, fmt.Errorf("expected ')'")
+		return pSaved, nil, p.expectedToken(lexer.RPAREN)
 	}
 
 	// expect block
@@ -1189,16 +1507,18 @@ func (p parser) accept_funcbody() (parser, *FUNCBODY, error) {
 		return pSaved, nil, err
 	}
 	if funcbody.block == nil {
-		return pSaved, nil, fmt.Errorf("expected block")
+		return pSaved, nil, p.expected("block")
 	}
 
 	// expect 'end'
 	var end []byte
-	p, end = p.accept_Literal('e', 'n', 'd')
+	p, end = p.accept_Literal(lexer.END)
 	if end == nil {
-		return pSaved, nil, fmt.Errorf("expected 'end'")
+		return pSaved, nil, p.expectedMatch(lexer.END, lexer.FUNCTION, pSaved.pos())
 	}
-	panic("!implemented")
+
+	funcbody.span = spanOf(pSaved, p)
+	return p, funcbody, nil
 }
 
 // parlist ::= namelist [',' '...'] | '...'
@@ -1214,7 +1534,7 @@ func (p parser) accept_parlist() (parser, *PARLIST, error) {
 		return pSaved, nil, err
 	}
 	if rule1 != nil {
-		return pp, &PARLIST{rule1: rule1}, nil
+		return pp, &PARLIST{span: rule1.span, rule1: rule1}, nil
 	}
 
 	// accept parlist.rule2
@@ -1223,7 +1543,7 @@ func (p parser) accept_parlist() (parser, *PARLIST, error) {
 		return pSaved, nil, err
 	}
 	if rule2 != nil {
-		return pp, &PARLIST{rule2: rule2}, nil
+		return pp, &PARLIST{span: rule2.span, rule2: rule2}, nil
 	}
 
 	return pSaved, nil, nil
@@ -1246,16 +1566,18 @@ func (p parser) accept_parlist_rule1() (parser, *PARLIST_RULE1, error) {
 		return pSaved, nil, nil
 	}
 
-	p, parlist.comma = p.accept_Literal(',')
+	p, parlist.comma = p.accept_Literal(lexer.COMMA)
 	if parlist.comma == nil {
+		parlist.span = spanOf(pSaved, p)
 		return p, parlist, nil
 	}
 
-	p, parlist.dotDotDot = p.accept_Literal('.', '.', '.')
+	p, parlist.dotDotDot = p.accept_Literal(lexer.DOTDOTDOT)
 	if parlist.dotDotDot == nil {
-		return pSaved, nil, fmt.Errorf("expected '...'")
+		return pSaved, nil, p.expectedToken(lexer.DOTDOTDOT)
 	}
 
+	parlist.span = spanOf(pSaved, p)
 	return p, parlist, nil
 }
 
@@ -1267,11 +1589,12 @@ func (p parser) accept_parlist_rule2() (parser, *PARLIST_RULE2, error) {
 	pSaved, parlist := p, &PARLIST_RULE2{}
 
 	// accept '...'
-	p, parlist.dotDotDot = p.accept_Literal('.', '.', '.')
+	p, parlist.dotDotDot = p.accept_Literal(lexer.DOTDOTDOT)
 	if parlist.dotDotDot == nil {
 		return pSaved, nil, nil
 	}
 
+	parlist.span = spanOf(pSaved, p)
 	return p, parlist, nil
 }
 
@@ -1285,7 +1608,7 @@ func (p parser) accept_tableconstructor() (parser, *TABLECONSTRUCTOR, error) {
 
 	// accept '{'
 	var oBrace []byte
-	p, oBrace = p.accept_Literal('{')
+	p, oBrace = p.accept_Literal(lexer.LBRACE)
 	if oBrace == nil {
 		return pSaved, nil, nil
 	}
@@ -1295,14 +1618,18 @@ func (p parser) accept_tableconstructor() (parser, *TABLECONSTRUCTOR, error) {
 	if err != nil {
 		return pSaved, nil, err
 	}
+	if tableconstructor.fieldlist == nil && p.peek() != lexer.RBRACE {
+		return pSaved, nil, p.expected("exp")
+	}
 
 	// expect '}'
 	var cBrace []byte
-	p, cBrace = p.accept_Literal('}')
+	p, cBrace = p.accept_Literal(lexer.RBRACE)
 	if cBrace == nil {
-		return pSaved, nil, fmt.Errorf("expected '}'")
+		return pSaved, nil, p.expectedMatch(lexer.RBRACE, lexer.LBRACE, pSaved.pos())
 	}
 
+	tableconstructor.span = spanOf(pSaved, p)
 	return p, tableconstructor, nil
 }
 
@@ -1331,8 +1658,13 @@ func (p parser) accept_fieldlist() (parser, *FIELDLIST, error) {
 		if fieldsep == nil {
 			break
 		}
-		pp, field, _ = pp.accept_field()
-		if field == nil {
+		if pp, field, err = pp.accept_field(); err != nil {
+			return pSaved, nil, err
+		} else if field == nil {
+			if pp.peek() != lexer.RBRACE {
+				// only '}' may follow a trailing separator
+				return pSaved, nil, pp.expected("exp")
+			}
 			break
 		}
 		fieldlist.fields = append(fieldlist.fields, field)
@@ -1344,6 +1676,7 @@ func (p parser) accept_fieldlist() (parser, *FIELDLIST, error) {
 		p = pp
 	}
 
+	fieldlist.span = spanOf(pSaved, p)
 	return p, fieldlist, nil
 }
 
@@ -1363,6 +1696,7 @@ func (p parser) accept_field() (parser, *FIELD, error) {
 		return pSaved, nil, err
 	}
 	if field.rule1 != nil {
+		field.span = spanOf(pSaved, p)
 		return p, field, nil
 	}
 
@@ -1372,6 +1706,7 @@ func (p parser) accept_field() (parser, *FIELD, error) {
 		return pSaved, nil, err
 	}
 	if field.rule2 != nil {
+		field.span = spanOf(pSaved, p)
 		return p, field, nil
 	}
 
@@ -1381,7 +1716,8 @@ func (p parser) accept_field() (parser, *FIELD, error) {
 		return pSaved, nil, err
 	}
 	if field.rule3 != nil {
-		return pSaved, field, nil
+		field.span = spanOf(pSaved, p)
+		return p, field, nil
 	}
 
 	return pSaved, nil, nil
@@ -1397,7 +1733,7 @@ func (p parser) accept_field_rule1() (parser, *FIELD_RULE1, error) {
 	var err error
 
 	var oBracket []byte
-	p, oBracket = p.accept_Literal('[')
+	p, oBracket = p.accept_Literal(lexer.LBRACKET)
 	if oBracket == nil {
 		return pSaved, nil, nil
 	}
@@ -1406,19 +1742,19 @@ func (p parser) accept_field_rule1() (parser, *FIELD_RULE1, error) {
 		return pSaved, nil, err
 	}
 	if field.exp1 == nil {
-		return pSaved, nil, fmt.Errorf("expected expression")
+		return pSaved, nil, p.expected("exp")
 	}
 
 	var cBracket []byte
-	p, cBracket = p.accept_Literal('=')
+	p, cBracket = p.accept_Literal(lexer.RBRACKET)
 	if cBracket == nil {
-		return pSaved, nil, fmt.Errorf("expected ']'")
+		return pSaved, nil, p.expectedToken(lexer.RBRACKET)
 	}
 
 	var equals []byte
-	p, equals = p.accept_Literal('=')
+	p, equals = p.accept_Literal(lexer.ASSIGN)
 	if equals == nil {
-		return pSaved, nil, fmt.Errorf("expected '='")
+		return pSaved, nil, p.expectedToken(lexer.ASSIGN)
 	}
 
 	p, field.exp2, err = p.accept_exp()
@@ -1426,9 +1762,10 @@ func (p parser) accept_field_rule1() (parser, *FIELD_RULE1, error) {
 		return pSaved, nil, err
 	}
 	if field.exp2 == nil {
-		return pSaved, nil, fmt.Errorf("expected expression")
+		return pSaved, nil, p.expected("exp")
 	}
 
+	field.span = spanOf(pSaved, p)
 	return p, field, nil
 }
 
@@ -1441,17 +1778,16 @@ func (p parser) accept_field_rule2() (parser, *FIELD_RULE2, error) {
 
 	var err error
 
-	// accept Name
+	// accept Name '='
+	// Without the '=', the Name is the start of an exp.
 	p, field.name, _ = p.accept_Name()
 	if field.name == nil {
 		return pSaved, nil, nil
 	}
-
-	// expect '='
 	var equals []byte
-	p, equals = p.accept_Literal('=')
+	p, equals = p.accept_Literal(lexer.ASSIGN)
 	if equals == nil {
-		return pSaved, nil, fmt.Errorf("expected '='")
+		return pSaved, nil, nil
 	}
 
 	// expect exp
@@ -1460,8 +1796,9 @@ func (p parser) accept_field_rule2() (parser, *FIELD_RULE2, error) {
 		return pSaved, nil, err
 	}
 	if field.exp == nil {
-		return pSaved, nil, fmt.Errorf("expected expression")
+		return pSaved, nil, p.expected("exp")
 	}
+	field.span = spanOf(pSaved, p)
 	return p, field, nil
 }
 
@@ -1482,6 +1819,7 @@ func (p parser) accept_field_rule3() (parser, *FIELD_RULE3, error) {
 		return pSaved, nil, err
 	}
 
+	field.span = spanOf(pSaved, p)
 	return p, field, nil
 }
 
@@ -1490,11 +1828,12 @@ func (p parser) accept_fieldsep() (parser, *FIELDSEP, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	switch p.buf[0] {
-	case ',':
-		return p.skipch(1), &FIELDSEP{comma: true}, nil
-	case ';':
-		return p.skipch(1), &FIELDSEP{semicolon: true}, nil
+	s := spanOf(p, p.skiptok(1))
+	switch p.peek() {
+	case lexer.COMMA:
+		return p.skiptok(1), &FIELDSEP{span: s, comma: true}, nil
+	case lexer.SEMICOLON:
+		return p.skiptok(1), &FIELDSEP{span: s, semicolon: true}, nil
 	}
 	return p, nil, nil
 }
@@ -1507,63 +1846,50 @@ func (p parser) accept_binop() (parser, *BINOP, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	switch p.buf[0] {
-	case '+':
-		return p.skipch(1), &BINOP{plus: true}, nil
-	case '-':
-		return p.skipch(1), &BINOP{hyphen: true}, nil
-	case '*':
-		return p.skipch(1), &BINOP{asterisk: true}, nil
-	case '/': // '/' or '//'
-		if match := []byte{'/', '/'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{slash: true}, nil
-		}
-		return p.skipch(1), &BINOP{slashSlash: true}, nil
-	case '^':
-		return p.skipch(1), &BINOP{caret: true}, nil
-	case '%':
-		return p.skipch(1), &BINOP{percent: true}, nil
-	case '&':
-		return p.skipch(1), &BINOP{ampersand: true}, nil
-	case '~': // '~' | '~='
-		if match := []byte{'~', '='}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{tildeEqual: true}, nil
-		}
-		return p.skipch(1), &BINOP{tilde: true}, nil
-	case '|':
-		return p.skipch(1), &BINOP{pipe: true}, nil
-	case '.': // '..'
-		if match := []byte{'.', '.'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{dotDot: true}, nil
-		}
-	case '=': // '=='
-		if match := []byte{'=', '='}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{equalEqual: true}, nil
-		}
-	case '<': // '<' | '<<' | '<='
-		if match := []byte{'<', '<'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{lessThanlessThan: true}, nil
-		}
-		if match := []byte{'<', '='}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{lessThanEqual: true}, nil
-		}
-		return p.skipch(1), &BINOP{lessThan: true}, nil
-	case '>': // '>' | '>>' | '>='
-		if match := []byte{'>', '>'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{greaterThanGreaterThan: true}, nil
-		}
-		if match := []byte{'>', '='}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{greaterThanEqual: true}, nil
-		}
-		return p.skipch(1), &BINOP{greaterThan: true}, nil
-	case 'a': // 'and'
-		if match := []byte{'a', 'n', 'd'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{and: true}, nil
-		}
-	case 'o': // 'or'
-		if match := []byte{'o', 'r'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &BINOP{or: true}, nil
-		}
+	s := spanOf(p, p.skiptok(1))
+	switch p.peek() {
+	case lexer.PLUS:
+		return p.skiptok(1), &BINOP{span: s, plus: true}, nil
+	case lexer.MINUS:
+		return p.skiptok(1), &BINOP{span: s, hyphen: true}, nil
+	case lexer.STAR:
+		return p.skiptok(1), &BINOP{span: s, asterisk: true}, nil
+	case lexer.SLASH:
+		return p.skiptok(1), &BINOP{span: s, slash: true}, nil
+	case lexer.SLASHSLASH:
+		return p.skiptok(1), &BINOP{span: s, slashSlash: true}, nil
+	case lexer.CARET:
+		return p.skiptok(1), &BINOP{span: s, caret: true}, nil
+	case lexer.PERCENT:
+		return p.skiptok(1), &BINOP{span: s, percent: true}, nil
+	case lexer.AMPERSAND:
+		return p.skiptok(1), &BINOP{span: s, ampersand: true}, nil
+	case lexer.TILDE:
+		return p.skiptok(1), &BINOP{span: s, tilde: true}, nil
+	case lexer.NE:
+		return p.skiptok(1), &BINOP{span: s, tildeEqual: true}, nil
+	case lexer.PIPE:
+		return p.skiptok(1), &BINOP{span: s, pipe: true}, nil
+	case lexer.DOTDOT:
+		return p.skiptok(1), &BINOP{span: s, dotDot: true}, nil
+	case lexer.EQ:
+		return p.skiptok(1), &BINOP{span: s, equalEqual: true}, nil
+	case lexer.SHL:
+		return p.skiptok(1), &BINOP{span: s, lessThanlessThan: true}, nil
+	case lexer.LE:
+		return p.skiptok(1), &BINOP{span: s, lessThanEqual: true}, nil
+	case lexer.LT:
+		return p.skiptok(1), &BINOP{span: s, lessThan: true}, nil
+	case lexer.SHR:
+		return p.skiptok(1), &BINOP{span: s, greaterThanGreaterThan: true}, nil
+	case lexer.GE:
+		return p.skiptok(1), &BINOP{span: s, greaterThanEqual: true}, nil
+	case lexer.GT:
+		return p.skiptok(1), &BINOP{span: s, greaterThan: true}, nil
+	case lexer.AND:
+		return p.skiptok(1), &BINOP{span: s, and: true}, nil
+	case lexer.OR:
+		return p.skiptok(1), &BINOP{span: s, or: true}, nil
 	}
 	return p, nil, nil
 }
@@ -1573,18 +1899,16 @@ func (p parser) accept_unop() (parser, *UNOP, error) {
 	if eof(p) {
 		return p, nil, nil
 	}
-	pSave := p
-	switch p.buf[0] {
-	case '-':
-		return p.skipch(1), &UNOP{dash: true}, nil
-	case '#':
-		return p.skipch(1), &UNOP{hash: true}, nil
-	case 'n': // 'not'
-		if match := []byte{'n', 'o', 't'}; bytes.HasPrefix(p.buf, match) {
-			return p.skipch(len(match)), &UNOP{not: true}, nil
-		}
-	case '~':
-		return p.skipch(1), &UNOP{tilde: true}, nil
+	s := spanOf(p, p.skiptok(1))
+	switch p.peek() {
+	case lexer.MINUS:
+		return p.skiptok(1), &UNOP{span: s, dash: true}, nil
+	case lexer.HASH:
+		return p.skiptok(1), &UNOP{span: s, hash: true}, nil
+	case lexer.NOT:
+		return p.skiptok(1), &UNOP{span: s, not: true}, nil
+	case lexer.TILDE:
+		return p.skiptok(1), &UNOP{span: s, tilde: true}, nil
 	}
-	return pSave, nil, nil
+	return p, nil, nil
 }
@@ -0,0 +1,325 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdhender/glua/ast"
+)
+
+// sexpr renders a lowered node as an s-expression that shows how it
+// is grouped, as in (= (. a b) 1) for a.b = 1.
+func sexpr(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Block:
+		return "(block" + list(stats(n.Stats)) + ")"
+	case *ast.BadStat:
+		return "(bad)"
+	case *ast.EmptyStat:
+		return "(;)"
+	case *ast.AssignStat:
+		return "(=" + list(exprs(n.Targets)) + " :" + list(exprs(n.Values)) + ")"
+	case *ast.CallStat:
+		return sexpr(n.Call)
+	case *ast.LabelStat:
+		return "(label " + n.Name.Name + ")"
+	case *ast.BreakStat:
+		return "(break)"
+	case *ast.GotoStat:
+		return "(goto " + n.Label.Name + ")"
+	case *ast.DoStat:
+		return "(do " + sexpr(n.Body) + ")"
+	case *ast.WhileStat:
+		return "(while " + sexpr(n.Cond) + " " + sexpr(n.Body) + ")"
+	case *ast.RepeatStat:
+		return "(repeat " + sexpr(n.Body) + " " + sexpr(n.Cond) + ")"
+	case *ast.IfStat:
+		s := "(if"
+		for _, c := range n.Clauses {
+			s += " " + sexpr(c.Cond) + " " + sexpr(c.Body)
+		}
+		if n.Else != nil {
+			s += " else " + sexpr(n.Else)
+		}
+		return s + ")"
+	case *ast.NumericForStat:
+		s := "(for " + n.Var.Name + " " + sexpr(n.Start) + " " + sexpr(n.Limit)
+		if n.Step != nil {
+			s += " " + sexpr(n.Step)
+		}
+		return s + " " + sexpr(n.Body) + ")"
+	case *ast.GenericForStat:
+		return "(forin" + list(idents(n.Names)) + " :" + list(exprs(n.Exprs)) + " " + sexpr(n.Body) + ")"
+	case *ast.FunctionStat:
+		name := ""
+		for i, id := range n.Name.Path {
+			if i > 0 {
+				name += "."
+			}
+			name += id.Name
+		}
+		if n.Name.Method != nil {
+			name += ":" + n.Name.Method.Name
+		}
+		return "(function " + name + " " + sexpr(n.Func) + ")"
+	case *ast.LocalFunctionStat:
+		return "(local function " + n.Name.Name + " " + sexpr(n.Func) + ")"
+	case *ast.LocalStat:
+		s := "(local"
+		for i, id := range n.Names {
+			s += " " + id.Name
+			if n.Attribs[i] != nil {
+				s += "<" + n.Attribs[i].Name + ">"
+			}
+		}
+		if n.Values != nil {
+			s += " :" + list(exprs(n.Values))
+		}
+		return s + ")"
+	case *ast.ReturnStat:
+		return "(return" + list(exprs(n.Values)) + ")"
+
+	case *ast.Ident:
+		return n.Name
+	case *ast.NilExpr:
+		return "nil"
+	case *ast.BoolExpr:
+		return fmt.Sprint(n.Value)
+	case *ast.NumberExpr:
+		return n.Raw
+	case *ast.StringExpr:
+		return fmt.Sprintf("%q", n.Value)
+	case *ast.VarargExpr:
+		return "..."
+	case *ast.FunctionExpr:
+		params := idents(n.Params)
+		if n.IsVararg {
+			params = append(params, "...")
+		}
+		return "(fn (" + strings.Join(params, " ") + ") " + sexpr(n.Body) + ")"
+	case *ast.TableExpr:
+		s := "(table"
+		for _, f := range n.Fields {
+			switch f.Kind {
+			case ast.PositionalField:
+				s += " " + sexpr(f.Value)
+			case ast.NamedField:
+				s += " " + f.Key.(*ast.StringExpr).Value + "=" + sexpr(f.Value)
+			case ast.KeyedField:
+				s += " [" + sexpr(f.Key) + "]=" + sexpr(f.Value)
+			}
+		}
+		return s + ")"
+	case *ast.BinaryExpr:
+		return "(" + n.Op.String() + " " + sexpr(n.Left) + " " + sexpr(n.Right) + ")"
+	case *ast.UnaryExpr:
+		return "(" + n.Op.String() + " " + sexpr(n.Operand) + ")"
+	case *ast.ParenExpr:
+		return "(paren " + sexpr(n.X) + ")"
+	case *ast.IndexExpr:
+		if n.Dot {
+			return "(. " + sexpr(n.Object) + " " + n.Key.(*ast.StringExpr).Value + ")"
+		}
+		return "([] " + sexpr(n.Object) + " " + sexpr(n.Key) + ")"
+	case *ast.CallExpr:
+		if n.Method != nil {
+			return "(: " + sexpr(n.Func) + " " + n.Method.Name + list(exprs(n.Args)) + ")"
+		}
+		return "(call " + sexpr(n.Func) + list(exprs(n.Args)) + ")"
+	}
+	return fmt.Sprintf("(?%T)", n)
+}
+
+func stats(list []ast.Stat) []string {
+	var s []string
+	for _, stat := range list {
+		s = append(s, sexpr(stat))
+	}
+	return s
+}
+
+func exprs(list []ast.Expr) []string {
+	var s []string
+	for _, x := range list {
+		s = append(s, sexpr(x))
+	}
+	return s
+}
+
+func idents(list []*ast.Ident) []string {
+	var s []string
+	for _, id := range list {
+		s = append(s, id.Name)
+	}
+	return s
+}
+
+// list joins the elements, each preceded by a space.
+func list(elems []string) string {
+	s := ""
+	for _, e := range elems {
+		s += " " + e
+	}
+	return s
+}
+
+// parseStats parses src and renders its statements, one per line.
+func parseStats(src string) (string, error) {
+	chunk, err := ParseChunk("test", []byte(src), 0)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(stats(chunk.Block.Stats), "\n"), nil
+}
+
+// TestDeepNesting checks that the statements nested in function bodies
+// in assignment targets and calls are parsed in linear time. Parsing
+// each prefixexp more than once would double the time with each level.
+func TestDeepNesting(t *testing.T) {
+	const depth = 40
+	for _, tc := range []struct {
+		name       string
+		open, shut string
+		inner      string
+	}{
+		{"field target", "f(function() ", " end).y = 1", "x = 1"},
+		{"index target", "t[function() ", " end] = 1", "x = 1"},
+		{"call", "f(function() ", " end)", "f()"},
+	} {
+		src := strings.Repeat(tc.open, depth) + tc.inner + strings.Repeat(tc.shut, depth)
+		done := make(chan error, 1)
+		go func() {
+			_, err := Parse(tc.name, []byte(src))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: parsing %d levels deep did not finish", tc.name, depth)
+		}
+	}
+}
+
+func TestExprStat(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"x = 1", "(= x : 1)"},
+		{"a.b[c] = 1", "(= ([] (. a b) c) : 1)"},
+		{"a, b.c, d[1] = 1, 2", "(= a (. b c) ([] d 1) : 1 2)"},
+		{"obj:m(x).y(\"s\"){t}", `(call (call (. (: obj m x) y) "s") (table t))`},
+		{"f()", "(call f)"},
+		{"f{}.x = 1", "(= (. (call f (table)) x) : 1)"},
+		{"(f)()", "(call (paren f))"},
+		{"(a).b = 1", "(= (. (paren a) b) : 1)"},
+		{"f()()", "(call (call f))"},
+		{"s:m\"x\":n[[y]]", `(: (: s m "x") n "y")`},
+		{"a.b.c = f(g, ...)", "(= (. (. a b) c) : (call f g ...))"},
+		{"x = function(a, ...) return a end", "(= x : (fn (a ...) (block (return a))))"},
+	} {
+		got, err := parseStats(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%s\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestExprStatError(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"f() = 1", "test:1: syntax error near '='"},
+		{"(a) = 1", "test:1: syntax error near '='"},
+		{"f(), x = 1", "test:1: syntax error near ','"},
+		{"x, f() = 1", "test:1: syntax error near '='"},
+		{"x, (a) = 1", "test:1: syntax error near '='"},
+		{"x", "test:1: syntax error near <eof>"},
+		{"(a)", "test:1: syntax error near <eof>"},
+		{"a.b\nc = 1", "test:2: syntax error near 'c'"},
+		{"x,", "test:1: unexpected symbol near <eof>"},
+		{"x, 1 = 2", "test:1: unexpected symbol near '1'"},
+		{"x = 1 = 2", "test:1: unexpected symbol near '='"},
+		{"x =", "test:1: unexpected symbol near <eof>"},
+		{"= 1", "test:1: unexpected symbol near '='"},
+		{"1 + 2", "test:1: unexpected symbol near '1'"},
+		{"a.1 = 2", "test:1: syntax error near '.1'"},
+		{"a[1 = 2", "test:1: ']' expected near '='"},
+		{"a:m = 1", "test:1: function arguments expected near '='"},
+		{"f(1", "test:1: ')' expected near <eof>"},
+		{"(a", "test:1: ')' expected near <eof>"},
+	} {
+		_, err := Parse("test", []byte(tc.src))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%s: error = %v, want %s", tc.src, err, tc.want)
+		}
+	}
+}
+
+// TestPrecedence checks the grouping of operators by priority and
+// associativity. Both '^' and '..' are right associative, and '^' binds
+// tighter than a unary operator on its left but not one on its right.
+func TestPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"2^-3^2", "(^ 2 (- (^ 3 2)))"},
+		{"-x^2", "(- (^ x 2))"},
+		{"not a == b", "(== (not a) b)"},
+		{"1 .. 2 .. 3", "(.. 1 (.. 2 3))"},
+		{"a < b < c", "(< (< a b) c)"},
+		{"1 + 2 * 3", "(+ 1 (* 2 3))"},
+		{"1 - 2 - 3", "(- (- 1 2) 3)"},
+		{"(1 - 2) * 3", "(* (paren (- 1 2)) 3)"},
+		{"a or b and c", "(or a (and b c))"},
+		{"a and b or c and d", "(or (and a b) (and c d))"},
+		{"a == b and c ~= d", "(and (== a b) (~= c d))"},
+		{"a .. b == c", "(== (.. a b) c)"},
+		{"1 + 2 .. 3", "(.. (+ 1 2) 3)"},
+		{"a | b ~ c & d", "(| a (~ b (& c d)))"},
+		{"a & b << 1", "(& a (<< b 1))"},
+		{"1 << 2 + 3", "(<< 1 (+ 2 3))"},
+		{"a < b | c", "(< a (| b c))"},
+		{"#t + 1", "(+ (# t) 1)"},
+		{"- - x", "(- (- x))"},
+		{"~x ~ y", "(~ (~ x) y)"},
+		{"a // b % c * d / e", "(/ (* (% (// a b) c) d) e)"},
+	} {
+		got, err := parseStats("return " + tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+		} else if want := "(return " + tc.want + ")"; got != want {
+			t.Errorf("%s\ngot  %s\nwant %s", tc.src, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mdhender/glua/lexer"
+)
+
+// SyntaxError reports a problem found while parsing a chunk.
+type SyntaxError struct {
+	Chunk    string    // name of the chunk being parsed
+	Pos      lexer.Pos // position of the offending token
+	Expected []string  // what the parser would have accepted, if known
+	Near     string    // text of the offending token, or <eof>
+	Msg      string    // description of the problem, if not just Expected
+}
+
+// Error formats the error the way the reference implementation does,
+// as "chunk:line: message near 'token'".
+func (e *SyntaxError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = strings.Join(e.Expected, " or ") + " expected"
+	}
+	switch e.Near {
+	case "":
+		return fmt.Sprintf("%s:%d: %s", e.Chunk, e.Pos.Line, msg)
+	case "<eof>":
+		return fmt.Sprintf("%s:%d: %s near %s", e.Chunk, e.Pos.Line, msg, e.Near)
+	}
+	return fmt.Sprintf("%s:%d: %s near '%s'", e.Chunk, e.Pos.Line, msg, e.Near)
+}
+
+// ErrorList is the list of errors found while parsing a chunk with
+// RecoverErrors, in the order they were found.
+type ErrorList []*SyntaxError
+
+// Error returns the first error and the number of errors after it.
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	case 2:
+		return fmt.Sprintf("%s (and 1 more error)", list[0])
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// Sort sorts the list by position. Errors at the same position keep
+// their order.
+func (list ErrorList) Sort() {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Pos.Offset < list[j].Pos.Offset })
+}
+
+// near returns the text of the next token for an error message.
+func (p parser) near() string {
+	if eof(p) {
+		return "<eof>"
+	}
+	return string(p.toks[0].Raw)
+}
+
+// errorf returns a SyntaxError positioned at the next token.
+func (p parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Chunk: p.chunk, Pos: p.pos(), Near: p.near(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// expected returns a SyntaxError reporting that the grammar rule
+// what was expected at the next token.
+func (p parser) expected(what string) error {
+	err := &SyntaxError{Chunk: p.chunk, Pos: p.pos(), Expected: []string{what}, Near: p.near()}
+	if what == "exp" {
+		// the reference implementation reports this as an unexpected symbol
+		err.Msg = "unexpected symbol"
+	}
+	return err
+}
+
+// expectedToken returns a SyntaxError reporting that one of the given
+// tokens was expected at the next token.
+func (p parser) expectedToken(kinds ...lexer.Kind) error {
+	err := &SyntaxError{Chunk: p.chunk, Pos: p.pos(), Near: p.near()}
+	for _, kind := range kinds {
+		switch kind {
+		case lexer.NAME, lexer.NUMERAL, lexer.STRING, lexer.EOF:
+			err.Expected = append(err.Expected, kind.String())
+		default:
+			err.Expected = append(err.Expected, "'"+kind.String()+"'")
+		}
+	}
+	return err
+}
+
+// expectedMatch returns a SyntaxError reporting that the token what was
+// expected to close the construct that the token who opened at pos.
+func (p parser) expectedMatch(what, who lexer.Kind, pos lexer.Pos) error {
+	err := p.expectedToken(what).(*SyntaxError)
+	if pos.Line != p.pos().Line {
+		err.Msg = fmt.Sprintf("'%s' expected (to close '%s' at line %d)", what, who, pos.Line)
+	}
+	return err
+}
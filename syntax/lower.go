@@ -0,0 +1,361 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"github.com/mdhender/glua/ast"
+)
+
+// Lower converts the parse tree of a chunk to an abstract syntax tree.
+//
+// The parse tree mirrors the grammar, with a nullable field for each
+// alternative of a rule. The abstract syntax tree replaces those with
+// a concrete node type per statement and expression.
+func Lower(chunk *CHUNK) *ast.Chunk {
+	c := &ast.Chunk{Span: chunk.lower(), Name: chunk.name, Block: chunk.block.lower()}
+	if chunk.trivia != nil {
+		attachTrivia(c, chunk.trivia)
+	}
+	return c
+}
+
+// ParseChunk parses src as a Lua chunk and returns its abstract syntax tree.
+func ParseChunk(name string, src []byte, mode Mode) (*ast.Chunk, error) {
+	chunk, err := ParseMode(name, src, mode)
+	if err != nil {
+		return nil, err
+	}
+	return Lower(chunk), nil
+}
+
+func (s span) lower() ast.Span {
+	return ast.Span{From: s.pos, To: s.end}
+}
+
+func (b *BLOCK) lower() *ast.Block {
+	block := &ast.Block{Span: b.span.lower()}
+	for _, stat := range b.stat {
+		block.Stats = append(block.Stats, stat.lower())
+	}
+	if b.retstat != nil {
+		block.Stats = append(block.Stats, &ast.ReturnStat{Span: b.retstat.span.lower(), Values: b.retstat.explist.lower()})
+	}
+	return block
+}
+
+func (s *STAT) lower() ast.Stat {
+	sp := s.span.lower()
+	switch {
+	case s.bad != nil:
+		return &ast.BadStat{Span: sp}
+	case s.rule1 != nil:
+		return &ast.EmptyStat{Span: sp}
+	case s.rule2 != nil:
+		stat := &ast.AssignStat{Span: sp, Values: s.rule2.explist.lower()}
+		for _, v := range s.rule2.varlist.variables {
+			stat.Targets = append(stat.Targets, v.lower())
+		}
+		return stat
+	case s.rule3 != nil:
+		return &ast.CallStat{Span: sp, Call: s.rule3.functioncall.lower()}
+	case s.rule4 != nil:
+		return &ast.LabelStat{Span: sp, Name: s.rule4.label.name.lower()}
+	case s.rule5 != nil:
+		return &ast.BreakStat{Span: sp}
+	case s.rule6 != nil:
+		return &ast.GotoStat{Span: sp, Label: s.rule6.name.lower()}
+	case s.rule7 != nil:
+		return &ast.DoStat{Span: sp, Body: s.rule7.block.lower()}
+	case s.rule8 != nil:
+		return &ast.WhileStat{Span: sp, Cond: s.rule8.exp.lower(), Body: s.rule8.block.lower()}
+	case s.rule9 != nil:
+		return &ast.RepeatStat{Span: sp, Body: s.rule9.block.lower(), Cond: s.rule9.exp.lower()}
+	case s.rule10 != nil:
+		stat := &ast.IfStat{Span: sp}
+		for _, eb := range s.rule10.expblock {
+			clause := &ast.IfClause{Span: eb.span.lower(), Cond: eb.exp.lower(), Body: eb.block.lower()}
+			stat.Clauses = append(stat.Clauses, clause)
+		}
+		if s.rule10.elseBlock != nil {
+			stat.ElsePos = s.rule10.kwElse.pos
+			stat.Else = s.rule10.elseBlock.lower()
+		}
+		return stat
+	case s.rule11 != nil:
+		stat := &ast.NumericForStat{
+			Span:  sp,
+			Var:   s.rule11.name.lower(),
+			Start: s.rule11.exp1.lower(),
+			Limit: s.rule11.exp2.lower(),
+			Body:  s.rule11.block.lower(),
+		}
+		if s.rule11.exp3 != nil {
+			stat.Step = s.rule11.exp3.lower()
+		}
+		return stat
+	case s.rule12 != nil:
+		return &ast.GenericForStat{
+			Span:  sp,
+			Names: s.rule12.namelist.lower(),
+			Exprs: s.rule12.explist.lower(),
+			Body:  s.rule12.block.lower(),
+		}
+	case s.rule13 != nil:
+		return &ast.FunctionStat{Span: sp, Name: s.rule13.funcname.lower(), Func: s.rule13.funcbody.lower(sp)}
+	case s.rule14 != nil:
+		return &ast.LocalFunctionStat{Span: sp, Name: s.rule14.name.lower(), Func: s.rule14.funcbody.lower(sp)}
+	case s.rule15 != nil:
+		stat := &ast.LocalStat{Span: sp, Values: s.rule15.explist.lower()}
+		for _, na := range s.rule15.attnamelist.attnamelist {
+			stat.Names = append(stat.Names, na.name.lower())
+			if na.attrib != nil {
+				stat.Attribs = append(stat.Attribs, na.attrib.name.lower())
+			} else {
+				stat.Attribs = append(stat.Attribs, nil)
+			}
+		}
+		return stat
+	}
+	panic("unknown stat")
+}
+
+func (n *NAME) lower() *ast.Ident {
+	return &ast.Ident{Span: n.span.lower(), Name: string(n.val)}
+}
+
+func (n *NAMELIST) lower() []*ast.Ident {
+	var names []*ast.Ident
+	for _, name := range n.names {
+		names = append(names, name.lower())
+	}
+	return names
+}
+
+func (f *FUNCNAME) lower() *ast.FuncName {
+	funcname := &ast.FuncName{Span: f.span.lower(), Path: []*ast.Ident{f.name.lower()}}
+	for _, name := range f.dotName {
+		funcname.Path = append(funcname.Path, name.lower())
+	}
+	if f.colonName != nil {
+		funcname.Method = f.colonName.lower()
+	}
+	return funcname
+}
+
+// lower returns the list of expressions, which is empty if e is nil.
+func (e *EXPLIST) lower() []ast.Expr {
+	if e == nil {
+		return nil
+	}
+	var exps []ast.Expr
+	for _, exp := range e.exps {
+		exps = append(exps, exp.lower())
+	}
+	return exps
+}
+
+func (e *EXP) lower() ast.Expr {
+	sp := e.span.lower()
+	switch {
+	case e.NIL:
+		return &ast.NilExpr{Span: sp}
+	case e.FALSE:
+		return &ast.BoolExpr{Span: sp, Value: false}
+	case e.TRUE:
+		return &ast.BoolExpr{Span: sp, Value: true}
+	case e.dotDotDot:
+		return &ast.VarargExpr{Span: sp}
+	case e.numeral != nil:
+		return e.numeral.lower()
+	case e.literalString != nil:
+		return e.literalString.lower()
+	case e.functiondef != nil:
+		return e.functiondef.funcbody.lower(sp)
+	case e.prefixexp != nil:
+		return e.prefixexp.lower()
+	case e.tableconstructor != nil:
+		return e.tableconstructor.lower()
+	case e.expBinopExp != nil:
+		return &ast.BinaryExpr{
+			Span:  sp,
+			Op:    e.expBinopExp.binop.op(),
+			Left:  e.expBinopExp.exp1.lower(),
+			Right: e.expBinopExp.exp2.lower(),
+		}
+	case e.unopExp != nil:
+		return &ast.UnaryExpr{Span: sp, Op: e.unopExp.unop.op(), Operand: e.unopExp.exp.lower()}
+	}
+	panic("unknown exp")
+}
+
+func (n *NUMERAL) lower() *ast.NumberExpr {
+	return &ast.NumberExpr{
+		Span:    n.span.lower(),
+		Raw:     string(n.val),
+		IsFloat: n.isFloat,
+		Int:     n.integer,
+		Float:   n.float,
+	}
+}
+
+func (l *LITERALSTRING) lower() *ast.StringExpr {
+	return &ast.StringExpr{Span: l.span.lower(), Raw: string(l.raw), Value: string(l.val)}
+}
+
+// lower returns the function whose body this is. The span is that of
+// the enclosing construct, which includes the 'function' keyword.
+func (f *FUNCBODY) lower(sp ast.Span) *ast.FunctionExpr {
+	fn := &ast.FunctionExpr{Span: sp, Body: f.block.lower()}
+	if f.parlist != nil {
+		if rule := f.parlist.rule1; rule != nil {
+			fn.Params = rule.namelist.lower()
+			fn.IsVararg = rule.dotDotDot != nil
+		} else {
+			fn.IsVararg = true
+		}
+	}
+	return fn
+}
+
+func (p *PREFIXEXP) lower() ast.Expr {
+	switch {
+	case p.variable != nil:
+		return p.variable.lower()
+	case p.functioncall != nil:
+		return p.functioncall.lower()
+	}
+	return &ast.ParenExpr{Span: p.span.lower(), X: p.exp.lower()}
+}
+
+// lower returns an *ast.Ident or an *ast.IndexExpr.
+func (v *VARIABLE) lower() ast.Expr {
+	switch {
+	case v.name != nil:
+		return v.name.lower()
+	case v.field != nil:
+		key := &ast.StringExpr{Span: v.field.span.lower(), Raw: string(v.field.val), Value: string(v.field.val)}
+		return &ast.IndexExpr{Span: v.span.lower(), Object: v.prefixexp.lower(), Key: key, Dot: true}
+	}
+	return &ast.IndexExpr{Span: v.span.lower(), Object: v.prefixexp.lower(), Key: v.index.lower()}
+}
+
+func (f *FUNCTIONCALL) lower() *ast.CallExpr {
+	call := &ast.CallExpr{Span: f.span.lower(), Func: f.prefixexp.lower()}
+	if f.name != nil {
+		call.Method = f.name.lower()
+	}
+	switch args := f.args; {
+	case args.rule1 != nil:
+		call.Args = args.rule1.explist.lower()
+	case args.rule2 != nil:
+		call.Args = []ast.Expr{args.rule2.tableconstructor.lower()}
+	case args.rule3 != nil:
+		call.Args = []ast.Expr{args.rule3.literalString.lower()}
+	}
+	return call
+}
+
+func (t *TABLECONSTRUCTOR) lower() *ast.TableExpr {
+	table := &ast.TableExpr{Span: t.span.lower()}
+	if t.fieldlist == nil {
+		return table
+	}
+	for _, f := range t.fieldlist.fields {
+		field := &ast.Field{Span: f.span.lower()}
+		switch {
+		case f.rule1 != nil:
+			field.Kind, field.Key, field.Value = ast.KeyedField, f.rule1.exp1.lower(), f.rule1.exp2.lower()
+		case f.rule2 != nil:
+			key := &ast.StringExpr{Span: f.rule2.name.span.lower(), Raw: string(f.rule2.name.val), Value: string(f.rule2.name.val)}
+			field.Kind, field.Key, field.Value = ast.NamedField, key, f.rule2.exp.lower()
+		default:
+			field.Kind, field.Value = ast.PositionalField, f.rule3.exp.lower()
+		}
+		table.Fields = append(table.Fields, field)
+	}
+	return table
+}
+
+func (b *BINOP) op() ast.BinOp {
+	switch {
+	case b.plus:
+		return ast.OpAdd
+	case b.hyphen:
+		return ast.OpSub
+	case b.asterisk:
+		return ast.OpMul
+	case b.slash:
+		return ast.OpDiv
+	case b.slashSlash:
+		return ast.OpIDiv
+	case b.caret:
+		return ast.OpPow
+	case b.percent:
+		return ast.OpMod
+	case b.ampersand:
+		return ast.OpBand
+	case b.tilde:
+		return ast.OpBxor
+	case b.pipe:
+		return ast.OpBor
+	case b.greaterThanGreaterThan:
+		return ast.OpShr
+	case b.lessThanlessThan:
+		return ast.OpShl
+	case b.dotDot:
+		return ast.OpConcat
+	case b.lessThan:
+		return ast.OpLT
+	case b.lessThanEqual:
+		return ast.OpLE
+	case b.greaterThan:
+		return ast.OpGT
+	case b.greaterThanEqual:
+		return ast.OpGE
+	case b.equalEqual:
+		return ast.OpEQ
+	case b.tildeEqual:
+		return ast.OpNE
+	case b.and:
+		return ast.OpAnd
+	case b.or:
+		return ast.OpOr
+	}
+	panic("unknown binop")
+}
+
+func (u *UNOP) op() ast.UnOp {
+	switch {
+	case u.dash:
+		return ast.OpNeg
+	case u.not:
+		return ast.OpNot
+	case u.hash:
+		return ast.OpLen
+	case u.tilde:
+		return ast.OpBnot
+	}
+	panic("unknown unop")
+}
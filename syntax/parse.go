@@ -0,0 +1,174 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package syntax parses Lua 5.4 source code.
+//
+// The parse tree follows the grammar in the reference manual: each
+// nonterminal has a struct named after it (CHUNK, BLOCK, STAT, ...)
+// with a field for each of its alternatives.
+package syntax
+
+import (
+	"io"
+
+	"github.com/mdhender/glua/lexer"
+)
+
+// Parse parses src as a Lua chunk and returns its parse tree.
+// The name identifies the chunk in error messages.
+//
+// The entire input must form a chunk; anything left over is reported
+// as an error. A first line starting with '#' is ignored, so scripts
+// may begin with "#!/usr/bin/env glua".
+func Parse(name string, src []byte) (*CHUNK, error) {
+	return ParseMode(name, src, 0)
+}
+
+// A Mode is a set of flags that control optional parser behavior.
+type Mode uint
+
+const (
+	// ParseTrivia keeps the comments and blank lines in the source
+	// and attaches them to the nearest token or node. Without it,
+	// they are discarded by the lexer along with the whitespace.
+	ParseTrivia Mode = 1 << iota
+
+	// RecoverErrors keeps parsing after a syntax error. A statement
+	// that fails to parse is replaced by an error statement and the
+	// parser resumes at the next keyword that starts a statement or
+	// ends a block.
+	RecoverErrors
+)
+
+// ParseMode is like Parse, but the mode controls optional behavior.
+func ParseMode(name string, src []byte, mode Mode) (*CHUNK, error) {
+	return Config{Mode: mode}.Parse(name, src)
+}
+
+// DefaultMaxErrors is the number of errors the parser recovers from
+// before it gives up, unless configured otherwise.
+const DefaultMaxErrors = 10
+
+// Config controls the parser.
+type Config struct {
+	Mode Mode
+
+	// MaxErrors is the most errors collected when parsing with
+	// RecoverErrors. If it is zero, DefaultMaxErrors is used;
+	// if it is negative, there is no limit.
+	MaxErrors int
+}
+
+// Parse parses src as a Lua chunk, as the package-level Parse does,
+// with the behavior set by the configuration.
+//
+// With RecoverErrors, Parse returns a chunk even if src has errors.
+// The error, if any, is an ErrorList.
+func (cfg Config) Parse(name string, src []byte) (*CHUNK, error) {
+	l := lexer.New(src)
+	if cfg.Mode&ParseTrivia != 0 {
+		l.KeepTrivia()
+	}
+	maxErrors := 0
+	if cfg.Mode&RecoverErrors != 0 {
+		if maxErrors = cfg.MaxErrors; maxErrors == 0 {
+			maxErrors = DefaultMaxErrors
+		} else if maxErrors < 0 {
+			maxErrors = -1
+		}
+	}
+	p, lexErr := newParser(name, l, maxErrors)
+	if _, ok := lexErr.(*SyntaxError); lexErr != nil && !ok {
+		return nil, lexErr
+	}
+	toks := p.toks
+	p, chunk, err := p.accept_chunk()
+	if err != nil {
+		return nil, firstError(err, lexErr)
+	}
+	for !eof(p) {
+		err := p.expectedToken(lexer.EOF)
+		if !p.recovering() {
+			return nil, firstError(err, lexErr)
+		}
+		if p.tooManyErrors() {
+			break
+		}
+		// a stray token such as an 'end' without a match; skip it and
+		// carry on with the statements that follow it
+		var stat *STAT
+		p, stat = p.recover(err)
+		if chunk.block.retstat != nil {
+			// nothing may follow a return statement
+			p = p.skiptok(len(p.toks))
+			continue
+		}
+		var block *BLOCK
+		if p, block, err = p.accept_block(); err != nil {
+			return nil, err
+		}
+		chunk.block.stat = append(chunk.block.stat, stat)
+		chunk.block.stat = append(chunk.block.stat, block.stat...)
+		chunk.block.retstat = block.retstat
+		chunk.block.end, chunk.end = block.end, block.end
+	}
+	if lexErr != nil {
+		return nil, lexErr
+	}
+	if cfg.Mode&ParseTrivia != 0 {
+		for _, tok := range toks {
+			if tok.Trivia != nil {
+				chunk.trivia = append(chunk.trivia, tok)
+			}
+		}
+	}
+	if list := p.errs.list; len(list) != 0 {
+		list.Sort()
+		return chunk, list
+	}
+	return chunk, nil
+}
+
+// firstError returns the error that the reference implementation, which
+// reads each token only when the parser needs it, reports first: err,
+// found by the parser, unless it is at or after lexErr, the lexical error
+// that ended the input.
+func firstError(err, lexErr error) error {
+	if lexErr == nil {
+		return err
+	}
+	if se, ok := err.(*SyntaxError); ok && se.Pos.Offset < lexErr.(*SyntaxError).Pos.Offset {
+		return err
+	}
+	return lexErr
+}
+
+// ParseReader reads all of r and parses it as a Lua chunk.
+func ParseReader(name string, r io.Reader) (*CHUNK, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(name, src)
+}
@@ -0,0 +1,296 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mdhender/glua/lexer"
+)
+
+// TestTokenBoundaries checks that a keyword or operator is only
+// recognized as a whole token, never as the start of a longer name,
+// and that comments separate tokens the way whitespace does.
+func TestTokenBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"x = nilly", "(= x : nilly)"},
+		{"x = nil", "(= x : nil)"},
+		{"if android then end", "(if android (block))"},
+		{"x = a and b", "(= x : (and a b))"},
+		{"x = notx or ort", "(= x : (or notx ort))"},
+		{"x = not_ and true_", "(= x : (and not_ true_))"},
+		{"local function_, end_ = 1", "(local function_ end_ : 1)"},
+		{"x = t.end_.do2", "(= x : (. (. t end_) do2))"},
+		{"x = a--comment\ny = b", "(= x : a)\n(= y : b)"},
+		{"x = a--[[ long\ncomment ]]+ b", "(= x : (+ a b))"},
+		{"x = a--[==[ ]] ]==]b = 1", "(= x : a)\n(= b : 1)"},
+		{"x = a..b", "(= x : (.. a b))"},
+		{"x = 1 .. 2", "(= x : (.. 1 2))"},
+		{"x = a//b", "(= x : (// a b))"},
+		{"x = a~=b", "(= x : (~= a b))"},
+		{"x = a<=b>>c", "(= x : (<= a (>> b c)))"},
+		{"::top:: goto top", "(label top)\n(goto top)"},
+		{"f(...)", "(call f ...)"},
+	} {
+		got, err := parseStats(tc.src)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%q\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+// TestSyntaxError checks the position, expected tokens and offending
+// token recorded in an error, and the message they make.
+func TestSyntaxError(t *testing.T) {
+	for _, tc := range []struct {
+		src      string
+		pos      lexer.Pos
+		expected []string
+		near     string
+		msg      string
+	}{
+		{"x = 1\n  y = = 2", lexer.Pos{Offset: 12, Line: 2, Column: 7}, []string{"exp"}, "=",
+			"test:2: unexpected symbol near '='"},
+		{"for i do end", lexer.Pos{Offset: 6, Line: 1, Column: 7}, []string{"'='", "'in'"}, "do",
+			"test:1: '=' or 'in' expected near 'do'"},
+		{"local function", lexer.Pos{Offset: 14, Line: 1, Column: 15}, []string{"<name>"}, "<eof>",
+			"test:1: <name> expected near <eof>"},
+		{"if x then\n\nf()", lexer.Pos{Offset: 14, Line: 3, Column: 4}, []string{"'end'"}, "<eof>",
+			"test:3: 'end' expected (to close 'if' at line 1) near <eof>"},
+		{"x = \"abc", lexer.Pos{Offset: 4, Line: 1, Column: 5}, nil, "\"abc",
+			"test:1: unfinished string near '\"abc'"},
+		{"x = 1..2", lexer.Pos{Offset: 4, Line: 1, Column: 5}, nil, "1..2",
+			"test:1: malformed number near '1..2'"},
+	} {
+		_, err := Parse("test", []byte(tc.src))
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: error %v is a %T, want a *SyntaxError", tc.src, err, err)
+			continue
+		}
+		if se.Chunk != "test" || se.Pos != tc.pos || !reflect.DeepEqual(se.Expected, tc.expected) || se.Near != tc.near {
+			t.Errorf("%q: got %q at %+v, expected %q, near %q\nwant %q at %+v, expected %q, near %q", tc.src,
+				se.Chunk, se.Pos, se.Expected, se.Near, "test", tc.pos, tc.expected, tc.near)
+		}
+		if se.Error() != tc.msg {
+			t.Errorf("%q: error = %q, want %q", tc.src, se.Error(), tc.msg)
+		}
+	}
+}
+
+// TestSyntaxErrorMessage checks that errors read the way the reference
+// implementation words them.
+func TestSyntaxErrorMessage(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"f(", "test:1: unexpected symbol near <eof>"},
+		{"f(1,", "test:1: unexpected symbol near <eof>"},
+		{"f(1\n, 2", "test:2: ')' expected (to close '(' at line 1) near <eof>"},
+		{"x = (1\n+ 2", "test:2: ')' expected (to close '(' at line 1) near <eof>"},
+		{"x = {", "test:1: unexpected symbol near <eof>"},
+		{"x = {1,", "test:1: unexpected symbol near <eof>"},
+		{"x = {1 2}", "test:1: '}' expected near '2'"},
+		{"x = {1,\n2", "test:2: '}' expected (to close '{' at line 1) near <eof>"},
+		{"x = {[1] 2}", "test:1: '=' expected near '2'"},
+		{"function f(", "test:1: <name> expected near <eof>"},
+		{"function f(1) end", "test:1: <name> expected near '1'"},
+		{"function f(a, 1) end", "test:1: <name> expected near '1'"},
+		{"function f(..., a) end", "test:1: ')' expected near ','"},
+		{"function f() return 1", "test:1: 'end' expected near <eof>"},
+		{"function f()\nreturn 1", "test:2: 'end' expected (to close 'function' at line 1) near <eof>"},
+		{"while x do", "test:1: 'end' expected near <eof>"},
+		{"while x", "test:1: 'do' expected near <eof>"},
+		{"repeat\nx = 1", "test:2: 'until' expected (to close 'repeat' at line 1) near <eof>"},
+		{"if x then else elseif", "test:1: 'end' expected near 'elseif'"},
+		{"goto 1", "test:1: <name> expected near '1'"},
+		{"::a", "test:1: '::' expected near <eof>"},
+		{"return 1;;", "test:1: <eof> expected near ';'"},
+		{"x = 1 +", "test:1: unexpected symbol near <eof>"},
+		{"x = [[", "test:1: unfinished long string (starting at line 1) near <eof>"},
+
+		// a lexical error is only reported when the parser reaches it
+		{"x = = 1\ny = \"abc", "test:1: unexpected symbol near '='"},
+		{"x = 1\ny = \"abc", "test:2: unfinished string near '\"abc'"},
+		{"if x then\ny = \"abc", "test:2: unfinished string near '\"abc'"},
+		{"x = 1\n#!", "test:2: unexpected symbol near '#'"},
+	} {
+		_, err := Parse("test", []byte(tc.src))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: error = %v, want %s", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestForFunctionLocal(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"for i = 1, 10 do end", "(for i 1 10 (block))"},
+		{"for i = 1, 10, 2 do x = i end", "(for i 1 10 2 (block (= x : i)))"},
+		{"for k in f do end", "(forin k : f (block))"},
+		{"for k, v in pairs(t) do end", "(forin k v : (call pairs t) (block))"},
+		{"for k in a, b, c do end", "(forin k : a b c (block))"},
+		{"function f() end", "(function f (fn () (block)))"},
+		{"function a.b.c:m(x, ...) end", "(function a.b.c:m (fn (x ...) (block)))"},
+		{"local function f(...) return ... end", "(local function f (fn (...) (block (return ...))))"},
+		{"local x", "(local x)"},
+		{"local x, y = 1", "(local x y : 1)"},
+		{"local x <const> = 1", "(local x<const> : 1)"},
+		{"local a <const>, b <close>, c = 1, 2", "(local a<const> b<close> c : 1 2)"},
+
+		// attributes other than const and close are rejected by package check
+		{"local x <foo> = 1", "(local x<foo> : 1)"},
+	} {
+		got, err := parseStats(tc.src)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%q\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+
+	// a long name list is read once to tell the two for loops apart
+	names := make([]string, 200)
+	for i := range names {
+		names[i] = "v"
+	}
+	namelist := strings.Join(names, ", ")
+	if got, err := parseStats("for " + namelist + " in t do end"); err != nil {
+		t.Errorf("generic for with %d names: %v", len(names), err)
+	} else if want := "(forin " + strings.Join(names, " ") + " : t (block))"; got != want {
+		t.Errorf("generic for with %d names: got %s", len(names), got)
+	}
+	if _, err := Parse("test", []byte("for "+namelist+" = 1, 2 do end")); err == nil || err.Error() != "test:1: 'in' expected near '='" {
+		t.Errorf("numeric for with %d names: error = %v", len(names), err)
+	}
+}
+
+func TestForFunctionLocalError(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"for do end", "test:1: <name> expected near 'do'"},
+		{"for i do end", "test:1: '=' or 'in' expected near 'do'"},
+		{"for i, j = 1, 2 do end", "test:1: 'in' expected near '='"},
+		{"for i = 1 do end", "test:1: ',' expected near 'do'"},
+		{"for i = 1, 2 end", "test:1: 'do' expected near 'end'"},
+		{"for i in do end", "test:1: unexpected symbol near 'do'"},
+		{"for i in t\nx = 1", "test:2: 'do' expected near 'x'"},
+		{"function () end", "test:1: <name> expected near '('"},
+		{"function f:m.n() end", "test:1: '(' expected near '.'"},
+		{"local function a.b() end", "test:1: '(' expected near '.'"},
+		{"local 1", "test:1: <name> expected near '1'"},
+		{"local x <const", "test:1: '>' expected near <eof>"},
+		{"local x <> = 1", "test:1: <name> expected near '>'"},
+		{"local x = ", "test:1: unexpected symbol near <eof>"},
+	} {
+		_, err := Parse("test", []byte(tc.src))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: error = %v, want %s", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestParseWholeInput(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"", ""},
+		{"x = 1", ""},
+		{"x = 1 )", "test:1: unexpected symbol near ')'"},
+		{"x = 1\nend", "test:2: <eof> expected near 'end'"},
+		{"do end until", "test:1: <eof> expected near 'until'"},
+		{"return 1 x = 2", "test:1: <eof> expected near 'x'"},
+		{"return; return", "test:1: <eof> expected near 'return'"},
+		{"x = 1 else", "test:1: <eof> expected near 'else'"},
+
+		// a first line starting with '#' is skipped, but still counted
+		{"#!/usr/bin/env glua\nx = 1", ""},
+		{"#!/usr/bin/env glua", ""},
+		{"# x = = 1\nx = 1", ""},
+		{"#!/usr/bin/env glua\nx = = 1", "test:2: unexpected symbol near '='"},
+		{"x = 1\n#!/usr/bin/env glua", "test:2: unexpected symbol near '#'"},
+		{" #!/usr/bin/env glua", "test:1: unexpected symbol near '#'"},
+	} {
+		_, err := Parse("test", []byte(tc.src))
+		if got := errString(err); got != tc.want {
+			t.Errorf("%q: error = %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	chunk, err := ParseReader("test", strings.NewReader("#!/usr/bin/env glua\nx = 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(chunk.block.stat); n != 1 {
+		t.Errorf("got %d statements, want 1", n)
+	}
+
+	_, err = ParseReader("test", strings.NewReader("x = 1 end"))
+	if got, want := errString(err), "test:1: <eof> expected near 'end'"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+
+	// an error reading the input is returned as is
+	readErr := errors.New("read failed")
+	if _, err := ParseReader("test", iotest.ErrReader(readErr)); err != readErr {
+		t.Errorf("error = %v, want %v", err, readErr)
+	}
+}
+
+func TestParseChunk(t *testing.T) {
+	chunk, err := ParseChunk("test", []byte("#!/usr/bin/env glua\nlocal x = 1\nreturn x\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Name != "test" {
+		t.Errorf("chunk name = %q, want %q", chunk.Name, "test")
+	}
+	if got, want := strings.Join(stats(chunk.Block.Stats), "\n"), "(local x : 1)\n(return x)"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if pos := chunk.Block.Stats[0].Pos(); pos.Line != 2 || pos.Column != 1 {
+		t.Errorf("first statement at %v, want 2:1", pos)
+	}
+}
+
+// errString returns the text of err, or "" if it is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
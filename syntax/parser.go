@@ -22,19 +22,26 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-package main
+package syntax
 
 import (
 	"bytes"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
 )
 
 //	chunk ::= block
 type CHUNK struct {
-	block *BLOCK
+	span
+	name   string        // name of the chunk, for error messages
+	block  *BLOCK
+	trivia []lexer.Token // tokens with trivia; nil unless parsed with ParseTrivia
 }
 
 //	block ::= {stat} [retstat]
 type BLOCK struct {
+	span
 	stat    []*STAT
 	retstat *RETSTAT
 }
@@ -55,6 +62,7 @@ type BLOCK struct {
 //		 local function Name funcbody |
 //		 local attnamelist [‘=’ explist]
 type STAT struct {
+	span
 	rule1  *STAT_RULE1
 	rule2  *STAT_RULE2
 	rule3  *STAT_RULE3
@@ -70,90 +78,116 @@ type STAT struct {
 	rule13 *STAT_RULE13
 	rule14 *STAT_RULE14
 	rule15 *STAT_RULE15
+	bad    *SyntaxError // set instead of a rule for a statement that failed to parse
+}
+type STAT_RULE1 struct {
+	span
 }
-type STAT_RULE1 struct{}
 type STAT_RULE2 struct {
+	span
 	varlist *VARLIST
 	explist *EXPLIST
 }
 type STAT_RULE3 struct {
+	span
 	functioncall *FUNCTIONCALL
 }
 type STAT_RULE4 struct {
+	span
 	label *LABEL
 }
-type STAT_RULE5 struct{}
+type STAT_RULE5 struct {
+	span
+}
 type STAT_RULE6 struct {
+	span
 	name *NAME
 }
 type STAT_RULE7 struct {
+	span
 	block *BLOCK
 }
 type STAT_RULE8 struct {
+	span
 	exp   *EXP
 	block *BLOCK
 }
 type STAT_RULE9 struct {
+	span
 	block *BLOCK
 	exp   *EXP
 }
 type STAT_RULE10 struct {
+	span
 	expblock  []*EXPBLOCK
+	kwElse    *KEYWORD
 	elseBlock *BLOCK
 }
 type EXPBLOCK struct {
+	span
 	exp   *EXP
 	block *BLOCK
 }
 type STAT_RULE11 struct {
+	span
 	name             *NAME
 	exp1, exp2, exp3 *EXP
 	block            *BLOCK
 }
 type STAT_RULE12 struct {
+	span
 	namelist *NAMELIST
 	explist  *EXPLIST
 	block    *BLOCK
 }
 type STAT_RULE13 struct {
+	span
 	funcname *FUNCNAME
 	funcbody *FUNCBODY
 }
 type STAT_RULE14 struct {
+	span
 	name     *NAME
 	funcbody *FUNCBODY
 }
 type STAT_RULE15 struct {
+	span
 	attnamelist *ATTNAMELIST
 	explist     *EXPLIST
 }
 
 //	attnamelist ::=  Name attrib {‘,’ Name attrib}
 type ATTNAMELIST struct {
+	span
 	attnamelist []*NAMEATTRIB
 }
 type NAMEATTRIB struct {
+	span
 	name   *NAME
 	attrib *ATTRIB
 }
 
 //	attrib ::= [‘<’ Name ‘>’]
 type ATTRIB struct {
+	span
 	name *NAME
 }
 
 //	retstat ::= return [explist] [‘;’]
 type RETSTAT struct {
+	span
 	explist *EXPLIST
 }
 
 //	label ::= ‘::’ Name ‘::’
 type LABEL struct {
+	span
 	name *NAME
 }
 
 //	funcname ::= Name {‘.’ Name} [‘:’ Name]
 type FUNCNAME struct {
+	span
 	name      *NAME
 	dotName   []*NAME
 	colonName *NAME
@@ -161,24 +195,34 @@ type FUNCNAME struct {
 
 //	varlist ::= var {‘,’ var}
 type VARLIST struct {
+	span
 	variables []*VARIABLE
 }
 
 //	var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
-type VARIABLE struct{}
+type VARIABLE struct {
+	span
+	name      *NAME      // Name
+	prefixexp *PREFIXEXP // the table being indexed, for the other two forms
+	index     *EXP       // prefixexp '[' exp ']'
+	field     *NAME      // prefixexp '.' Name
+}
 
 //	namelist ::= Name {‘,’ Name}
 type NAMELIST struct {
+	span
 	names []*NAME
 }
 
 //	explist ::= exp {‘,’ exp}
 type EXPLIST struct {
+	span
 	exps []*EXP
 }
 
 //	exp ::=  nil | false | true | Numeral | LiteralString | ‘...’ | functiondef | prefixexp | tableconstructor | exp binop exp | unop exp
 type EXP struct {
+	span
 	NIL, FALSE, TRUE, dotDotDot bool
 	literal                     []byte
 	literalString               *LITERALSTRING
@@ -191,18 +235,21 @@ type EXP struct {
 }
 
 type EXP_BINOP_EXP struct {
+	span
 	exp1  *EXP
 	binop *BINOP
 	exp2  *EXP
 }
 
 type UNOP_EXP struct {
+	span
 	unop *UNOP
 	exp  *EXP
 }
 
 // prefixexp ::= var | functioncall | ‘(’ exp ‘)’
 type PREFIXEXP struct {
+	span
 	variable     *VARIABLE
 	functioncall *FUNCTIONCALL
 	exp          *EXP
@@ -210,6 +257,7 @@ type PREFIXEXP struct {
 
 //	functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
 type FUNCTIONCALL struct {
+	span
 	prefixexp *PREFIXEXP
 	name      *NAME
 	args      *ARGS
@@ -217,75 +265,91 @@ type FUNCTIONCALL struct {
 
 //	args ::=  ‘(’ [explist] ‘)’ | tableconstructor | LiteralString
 type ARGS struct {
+	span
 	rule1 *ARGS_RULE1
 	rule2 *ARGS_RULE2
 	rule3 *ARGS_RULE3
 }
 type ARGS_RULE1 struct {
+	span
 	explist *EXPLIST
 }
 type ARGS_RULE2 struct {
+	span
 	tableconstructor *TABLECONSTRUCTOR
 }
 type ARGS_RULE3 struct {
+	span
 	literalString *LITERALSTRING
 }
 
 //	functiondef ::= function funcbody
 type FUNCTIONDEF struct {
+	span
 	funcbody *FUNCBODY
 }
 
 //	funcbody ::= ‘(’ [parlist] ‘)’ block end
 type FUNCBODY struct {
+	span
 	parlist *PARLIST
 	block   *BLOCK
 }
 
 //	parlist ::= namelist [‘,’ ‘...’] | ‘...’
 type PARLIST struct {
+	span
 	rule1 *PARLIST_RULE1
 	rule2 *PARLIST_RULE2
 }
 type PARLIST_RULE1 struct {
+	span
 	namelist  *NAMELIST
 	comma     []byte
 	dotDotDot []byte
 }
 type PARLIST_RULE2 struct {
+	span
 	dotDotDot []byte
 }
 
 //	tableconstructor ::= ‘{’ [fieldlist] ‘}’
 type TABLECONSTRUCTOR struct {
+	span
 	fieldlist *FIELDLIST
 }
 
 //	fieldlist ::= field {fieldsep field} [fieldsep]
 type FIELDLIST struct {
+	span
 	fields []*FIELD
 }
 
 //	field ::= ‘[’ exp ‘]’ ‘=’ exp | Name ‘=’ exp | exp
 type FIELD struct {
+	span
 	rule1 *FIELD_RULE1
 	rule2 *FIELD_RULE2
 	rule3 *FIELD_RULE3
 }
 type FIELD_RULE1 struct {
+	span
 	exp1 *EXP
 	exp2 *EXP
 }
 type FIELD_RULE2 struct {
+	span
 	name *NAME
 	exp  *EXP
 }
 type FIELD_RULE3 struct {
+	span
 	exp *EXP
 }
 
 // fieldsep ::= ‘,’ | ‘;’
 type FIELDSEP struct {
+	span
 	comma     bool
 	semicolon bool
 }
@@ -295,6 +359,7 @@ type FIELDSEP struct {
 //		 ‘<’ | ‘<=’ | ‘>’ | ‘>=’ | ‘==’ | ‘~=’ |
 //		 and | or
 type BINOP struct {
+	span
 	plus                   bool
 	hyphen                 bool
 	asterisk               bool
@@ -318,36 +383,125 @@ type BINOP struct {
 	or                     bool
 }
 
+// priority returns the left and right binding power of the operator.
+func (b *BINOP) priority() (left, right int) {
+	return b.op().Priority()
+}
+
+// unaryPriority is the binding power of the unary operators.
+const unaryPriority = ast.UnaryPriority
+
 // unop ::= ‘-’ | not | ‘#’ | ‘~’
 type UNOP struct {
+	span
 	dash  bool
 	not   bool
 	hash  bool
 	tilde bool
 }
 
+// span records the extent of a node in the source.
+type span struct {
+	pos lexer.Pos // position of the first token of the node
+	end lexer.Pos // position just past the last token of the node
+}
+
+// Pos returns the position of the first token of the node.
+func (s span) Pos() lexer.Pos {
+	return s.pos
+}
+
+// End returns the position just past the last token of the node.
+func (s span) End() lexer.Pos {
+	return s.end
+}
+
 type parser struct {
-	buf []byte
+	chunk     string         // name of the chunk, for error messages
+	toks      []lexer.Token  // remaining input, always terminated by an EOF token
+	maxErrors int            // most errors to recover from; 0 if not recovering, -1 for no limit
+	errs      *errorSet      // errors recovered from so far, shared by every copy of the parser
+}
+
+// newParser returns a parser positioned at the first token read by l.
+// A first line starting with '#' is skipped.
+//
+// If maxErrors is not zero, the parser recovers from errors. A lexical
+// error is recorded and the rest of its line is skipped. Otherwise the
+// input ends at the first lexical error, which is returned along with
+// the parser for the tokens before it.
+func newParser(chunk string, l *lexer.Lexer, maxErrors int) (parser, error) {
+	p := parser{chunk: chunk, maxErrors: maxErrors, errs: &errorSet{at: map[int]bool{}}}
+	l.SkipShebang()
+	for {
+		tok, err := l.Next()
+		if err != nil {
+			le, ok := err.(*lexer.Error)
+			if !ok {
+				return parser{}, err
+			}
+			err := &SyntaxError{Chunk: chunk, Pos: le.Pos, Msg: le.Msg, Near: le.Near}
+			if !p.recovering() {
+				p.toks = append(p.toks, lexer.Token{Kind: lexer.EOF, Pos: le.Pos, End: le.Pos})
+				return p, err
+			}
+			p.record(err)
+			if p.tooManyErrors() {
+				p.toks = append(p.toks, lexer.Token{Kind: lexer.EOF, Pos: le.Pos, End: le.Pos})
+				return p, nil
+			}
+			l.SkipLine()
+			continue
+		}
+		p.toks = append(p.toks, tok)
+		if tok.Kind == lexer.EOF {
+			return p, nil
+		}
+	}
+}
+
+// pos returns the position of the next token.
+func (p parser) pos() lexer.Pos {
+	return p.toks[0].Pos
+}
+
+// spanOf returns the span covering the tokens consumed between from and to.
+// If no tokens were consumed, the span is empty and sits at the next token.
+func spanOf(from, to parser) span {
+	n := len(from.toks) - len(to.toks)
+	if n <= 0 {
+		return span{pos: from.pos(), end: from.pos()}
+	}
+	return span{pos: from.pos(), end: from.toks[n-1].End}
 }
 
 func eof(p parser) bool {
-	return len(p.buf) == 0
+	return len(p.toks) == 0 || p.toks[0].Kind == lexer.EOF
+}
+
+// peek returns the kind of the next token.
+func (p parser) peek() lexer.Kind {
+	if eof(p) {
+		return lexer.EOF
+	}
+	return p.toks[0].Kind
 }
 
-func (p parser) skipch(n int) parser {
+func (p parser) skiptok(n int) parser {
 	if eof(p) {
 		return p
 	}
-	if n > len(p.buf) {
-		n = len(p.buf)
+	if n > len(p.toks)-1 {
+		n = len(p.toks) - 1
 	}
-	p.buf = p.buf[n:]
+	p.toks = p.toks[n:]
 	return p
 }
 
 type node struct{}
 
 type KEYWORD struct {
+	span
 	val []byte
 }
 
@@ -357,62 +511,86 @@ func bdup(src []byte) []byte {
 	return dst
 }
 
-func (p parser) accept_Keyword(b ...byte) (parser, *KEYWORD) {
-	if eof(p) || !bytes.HasPrefix(p.buf, b) {
+func (p parser) accept_Keyword(kind lexer.Kind) (parser, *KEYWORD) {
+	if eof(p) || p.toks[0].Kind != kind {
 		return p, nil
 	}
-	pSaved, keyword := p, &KEYWORD{val: bdup(p.buf[len(b):])}
-	// skip the keyword
-	p.buf = p.buf[len(b):]
-	// the next byte must be a keyword terminator
-	if eof(p) {
-		return pSaved, nil
-	}
-	switch p.buf[0] {
-	case ' ', '\t', '\r', '\n',
-		'(', ')', '{', '}', '<', '>', '[', ']',
-		'.', ':', ';', '~', '=', '%', '/', '#', '*',
-		'\'', '"':
-		return p.skipch(1), keyword
-	}
-	return pSaved, nil
+	return p.skiptok(1), &KEYWORD{span: spanOf(p, p.skiptok(1)), val: bdup(p.toks[0].Raw)}
 }
 
-func (p parser) accept_Literal(b ...byte) (parser, []byte) {
-	if eof(p) || !bytes.HasPrefix(p.buf, b) {
+func (p parser) accept_Literal(kind lexer.Kind) (parser, []byte) {
+	if eof(p) || p.toks[0].Kind != kind {
 		return p, nil
 	}
-	p.buf = p.buf[len(b):]
-	return p, b
+	return p.skiptok(1), p.toks[0].Raw
 }
 
 type LITERALSTRING struct {
-	level int
-	val   []byte
+	span
+	level int    // level of the long bracket, or -1 for a quoted string
+	val   []byte // the decoded value of the string
+	raw   []byte // the string as it appeared in the source
 }
 
 // LiteralString is a terminal
 func (p parser) accept_LiteralString() (parser, *LITERALSTRING, error) {
-	panic("!implemented")
+	if eof(p) || p.toks[0].Kind != lexer.STRING {
+		return p, nil, nil
+	}
+	tok := p.toks[0]
+	literalString := &LITERALSTRING{span: spanOf(p, p.skiptok(1)), level: -1, val: bdup(tok.Val), raw: bdup(tok.Raw)}
+	if tok.Raw[0] == '[' {
+		literalString.level = bytes.IndexByte(tok.Raw[1:], '[')
+	}
+	return p.skiptok(1), literalString, nil
 }
 
 type NAME struct {
+	span
 	val []byte
 }
 
 // Name is a terminal
 func (p parser) accept_Name() (parser, *NAME, error) {
-	if eof(p) {
+	if eof(p) || p.toks[0].Kind != lexer.NAME {
 		return p, nil, nil
 	}
-	panic("!implemented")
+	return p.skiptok(1), &NAME{span: spanOf(p, p.skiptok(1)), val: bdup(p.toks[0].Raw)}, nil
 }
 
 type NUMERAL struct {
-	val []byte
+	span
+	val     []byte  // the numeral as it appeared in the source
+	isFloat bool    // true if the numeral denotes a float
+	integer int64   // the value of an integer numeral
+	float   float64 // the value of a float numeral
+}
+
+// IsFloat reports whether the numeral denotes a float rather than an integer.
+func (n *NUMERAL) IsFloat() bool {
+	return n.isFloat
+}
+
+// Int returns the value of an integer numeral.
+func (n *NUMERAL) Int() int64 {
+	return n.integer
+}
+
+// Float returns the value of a float numeral.
+func (n *NUMERAL) Float() float64 {
+	return n.float
 }
 
 // Numeral is a terminal
 func (p parser) accept_Numeral() (parser, *NUMERAL, error) {
-	panic("!implemented")
+	if eof(p) || p.toks[0].Kind != lexer.NUMERAL {
+		return p, nil, nil
+	}
+	tok := p.toks[0]
+	num, ok := lexer.ParseNumber(tok.Raw)
+	if !ok {
+		return p, nil, p.errorf("malformed number")
+	}
+	numeral := &NUMERAL{span: spanOf(p, p.skiptok(1)), val: bdup(tok.Raw), isFloat: num.IsFloat, integer: num.Int, float: num.Float}
+	return p.skiptok(1), numeral, nil
 }
@@ -0,0 +1,167 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"github.com/mdhender/glua/lexer"
+)
+
+// recovering reports whether the parser recovers from errors.
+func (p parser) recovering() bool {
+	return p.maxErrors != 0
+}
+
+// tooManyErrors reports whether the parser has recovered from as many
+// errors as it is allowed to.
+func (p parser) tooManyErrors() bool {
+	return p.maxErrors > 0 && len(p.errs.list) >= p.maxErrors
+}
+
+// record adds err to the errors recovered from. The list is shared by
+// every copy of the parser, so an error found in a nested block is kept
+// even if the statement around the block fails to parse later. Recovery
+// may parse such a block again; an error at a position that already has
+// one is dropped.
+func (p parser) record(err *SyntaxError) {
+	if !p.errs.at[err.Pos.Offset] {
+		p.errs.at[err.Pos.Offset] = true
+		p.errs.list = append(p.errs.list, err)
+	}
+}
+
+// errorSet is the errors recorded by a parser and the offsets they are at.
+type errorSet struct {
+	list ErrorList
+	at   map[int]bool
+}
+
+// recover records err, which was found while parsing the statement that
+// starts at the next token, and skips past that statement. It returns
+// an error statement to stand in for it.
+//
+// Once the parser has recovered from too many errors, it skips the rest
+// of the input instead, and records no more errors.
+func (p parser) recover(err error) (parser, *STAT) {
+	se, ok := err.(*SyntaxError)
+	if !ok {
+		se = p.errorf("%v", err).(*SyntaxError)
+	}
+	pSaved := p
+	if !p.tooManyErrors() {
+		p.record(se)
+	}
+	if p.tooManyErrors() {
+		p = p.skiptok(len(p.toks))
+	} else {
+		p = p.sync(se.Pos)
+	}
+	return p, &STAT{span: spanOf(pSaved, p), bad: se}
+}
+
+// sync skips the tokens of a statement that failed to parse at pos,
+// stopping at a keyword that starts a statement or ends the enclosing
+// block. A name that begins a line after pos is taken to start a
+// statement, too, as is a name right after a stray 'end' or 'until'.
+// It skips at least one token, so that the parser makes progress.
+//
+// Keywords that open a nested block are matched with the keywords that
+// close it, so that a broken 'if' or 'function' is skipped as a whole.
+// A 'function' opens a block only if its header is complete; otherwise
+// there is no body to skip, and the statements that follow are kept.
+func (p parser) sync(pos lexer.Pos) parser {
+	depth, line, stray := 0, 0, false
+	for first := true; !eof(p); first = false {
+		tok := p.toks[0]
+		if !first && depth == 0 {
+			if startsStat(tok.Kind) || endsBlock(tok.Kind) {
+				break
+			} else if tok.Kind == lexer.NAME && (stray || tok.Pos.Offset > pos.Offset && tok.Pos.Line > line) {
+				break
+			}
+		}
+		p, line, stray = p.skiptok(1), tok.End.Line, false
+		switch tok.Kind {
+		case lexer.DO, lexer.IF, lexer.REPEAT:
+			depth++
+		case lexer.FUNCTION:
+			if opensBody(p.toks) {
+				depth++
+			}
+		case lexer.END, lexer.UNTIL:
+			if depth == 0 {
+				stray = true
+			} else if depth--; depth == 0 {
+				return p
+			}
+		}
+	}
+	return p
+}
+
+// opensBody reports whether the tokens after a 'function' keyword start
+// with a complete header, [funcname] '(' [parlist] ')', which a body
+// closed by 'end' follows.
+func opensBody(toks []lexer.Token) bool {
+	i := 0
+	if i < len(toks) && toks[i].Kind == lexer.NAME {
+		i++
+		for i+1 < len(toks) && (toks[i].Kind == lexer.DOT || toks[i].Kind == lexer.COLON) && toks[i+1].Kind == lexer.NAME {
+			i += 2
+		}
+	}
+	if i >= len(toks) || toks[i].Kind != lexer.LPAREN {
+		return false
+	}
+	for i++; i < len(toks); i++ {
+		switch toks[i].Kind {
+		case lexer.NAME, lexer.DOTDOTDOT, lexer.COMMA:
+		case lexer.RPAREN:
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// startsStat reports whether a token of the given kind starts a statement
+// that can be recognized without looking further.
+func startsStat(kind lexer.Kind) bool {
+	switch kind {
+	case lexer.BREAK, lexer.DO, lexer.FOR, lexer.FUNCTION, lexer.GOTO, lexer.IF,
+		lexer.LOCAL, lexer.REPEAT, lexer.RETURN, lexer.WHILE, lexer.COLONCOLON:
+		return true
+	}
+	return false
+}
+
+// endsBlock reports whether a token of the given kind ends a block.
+func endsBlock(kind lexer.Kind) bool {
+	switch kind {
+	case lexer.EOF, lexer.ELSE, lexer.ELSEIF, lexer.END, lexer.UNTIL:
+		return true
+	}
+	return false
+}
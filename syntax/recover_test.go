@@ -0,0 +1,153 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoverErrors(t *testing.T) {
+	const broken = "x = = 1\nlocal = 2\nif then end\nprint(1)"
+	for _, tc := range []struct {
+		src       string
+		maxErrors int
+		errs      []string // the ErrorList, in order
+		stats     string   // the lowered statements, one per line
+	}{
+		{broken, 1, []string{
+			"test:1: unexpected symbol near '='",
+		}, "(bad)"},
+		{broken, 2, []string{
+			"test:1: unexpected symbol near '='",
+			"test:2: <name> expected near '='",
+		}, "(bad)\n(bad)"},
+		{broken, 3, []string{
+			"test:1: unexpected symbol near '='",
+			"test:2: <name> expected near '='",
+			"test:3: unexpected symbol near 'then'",
+		}, "(bad)\n(bad)\n(bad)"},
+		{broken, -1, []string{
+			"test:1: unexpected symbol near '='",
+			"test:2: <name> expected near '='",
+			"test:3: unexpected symbol near 'then'",
+		}, "(bad)\n(bad)\n(bad)\n(call print 1)"},
+		{broken, 0, []string{
+			"test:1: unexpected symbol near '='",
+			"test:2: <name> expected near '='",
+			"test:3: unexpected symbol near 'then'",
+		}, "(bad)\n(bad)\n(bad)\n(call print 1)"},
+
+		// a stray 'end' or 'until' is skipped along with what follows it
+		// on the line, up to a name that can start a statement
+		{"x = 1 end y = 2 end z = = 3", 1, []string{
+			"test:1: <eof> expected near 'end'",
+		}, "(= x : 1)\n(bad)"},
+		{"x = 1 end y = 2 end z = = 3", -1, []string{
+			"test:1: <eof> expected near 'end'",
+			"test:1: <eof> expected near 'end'",
+			"test:1: unexpected symbol near '='",
+		}, "(= x : 1)\n(bad)\n(= y : 2)\n(bad)\n(bad)"},
+		{"x = 1 until\ny = 2", -1, []string{
+			"test:1: <eof> expected near 'until'",
+		}, "(= x : 1)\n(bad)\n(= y : 2)"},
+		{"return 1 x\nreturn 2", -1, []string{
+			"test:1: <eof> expected near 'x'",
+		}, "(return 1)"},
+
+		// an error in a nested block is kept when the block is broken, too,
+		// and counts toward the limit
+		{"local function f() x = = 1 end\ny = 2", -1, []string{
+			"test:1: unexpected symbol near '='",
+		}, "(local function f (fn () (block (bad))))\n(= y : 2)"},
+		{"local function f() x = = 1 end\ny = 2", 1, []string{
+			"test:1: unexpected symbol near '='",
+		}, "(bad)"},
+		{"repeat x = until y\nprint(1)", 1, []string{
+			"test:1: unexpected symbol near 'until'",
+		}, "(bad)"},
+		{"repeat x = = 1\n", -1, []string{
+			"test:1: unexpected symbol near '='",
+			"test:2: 'until' expected (to close 'repeat' at line 1) near <eof>",
+		}, "(bad)"},
+
+		// a function header that is not complete opens no body to skip
+		{"function (\ny = 3", -1, []string{
+			"test:1: <name> expected near '('",
+		}, "(bad)\n(= y : 3)"},
+
+		// lexical errors are recovered from, too
+		{"x = 1 @ 2\ny = 2", -1, []string{
+			"test:1: unexpected symbol near '@'",
+		}, "(= x : 1)\n(= y : 2)"},
+	} {
+		chunk, err := Config{Mode: RecoverErrors, MaxErrors: tc.maxErrors}.Parse("test", []byte(tc.src))
+		if chunk == nil {
+			t.Errorf("%q, max %d: no chunk, error %v", tc.src, tc.maxErrors, err)
+			continue
+		}
+		list, _ := err.(ErrorList)
+		var errs []string
+		for _, e := range list {
+			errs = append(errs, e.Error())
+		}
+		if strings.Join(errs, "\n") != strings.Join(tc.errs, "\n") {
+			t.Errorf("%q, max %d: errors\n\t%s\nwant\n\t%s", tc.src, tc.maxErrors,
+				strings.Join(errs, "\n\t"), strings.Join(tc.errs, "\n\t"))
+		}
+		if got := strings.Join(stats(Lower(chunk).Block.Stats), "\n"); got != tc.stats {
+			t.Errorf("%q, max %d: statements\n%s\nwant\n%s", tc.src, tc.maxErrors, got, tc.stats)
+		}
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"x = 1", ""},
+		{"x = = 1", "test:1: unexpected symbol near '='"},
+		{"x = = 1\ny = = 2", "test:1: unexpected symbol near '=' (and 1 more error)"},
+		{"x = = 1\ny = = 2\nz = = 3", "test:1: unexpected symbol near '=' (and 2 more errors)"},
+	} {
+		_, err := ParseMode("test", []byte(tc.src), RecoverErrors)
+		got := ""
+		if err != nil {
+			if _, ok := err.(ErrorList); !ok {
+				t.Errorf("%q: error is a %T, want an ErrorList", tc.src, err)
+			}
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Errorf("%q: error = %q, want %q", tc.src, got, tc.want)
+		}
+	}
+
+	// without RecoverErrors, parsing stops at the first error
+	chunk, err := Parse("test", []byte("x = = 1\ny = = 2"))
+	if _, ok := err.(*SyntaxError); chunk != nil || !ok {
+		t.Errorf("Parse returned %v, %v; want no chunk and a *SyntaxError", chunk, err)
+	}
+}
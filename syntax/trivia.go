@@ -0,0 +1,133 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"math"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
+)
+
+// attachTrivia attaches the trivia of the tokens to the nodes of c.
+//
+// The leading trivia of a token goes to the outermost node that starts
+// with the token and its trailing trivia to the outermost node that
+// ends with it. Blocks are skipped in favor of their statements. When
+// there is no such node, the trivia is inner trivia of the innermost
+// node containing the token. The leading trivia of the final EOF token
+// is trailing trivia of the chunk itself.
+func attachTrivia(c *ast.Chunk, toks []lexer.Token) {
+	a := &attacher{
+		starts: map[int]ast.Node{},
+		ends:   map[int]ast.Node{},
+		toks:   toks,
+		inner:  make([]ast.Node, len(toks)),
+		depth:  make([]int, len(toks)),
+		open:   []ast.Node{c},
+	}
+	ast.Walk(a, c.Block)
+	a.place(math.MaxInt)
+
+	c.Trivia = map[ast.Node]*ast.Trivia{}
+	trivia := func(n ast.Node) *ast.Trivia {
+		t, ok := c.Trivia[n]
+		if !ok {
+			t = &ast.Trivia{}
+			c.Trivia[n] = t
+		}
+		return t
+	}
+	for i, tok := range toks {
+		if leading := tok.Trivia.Leading; leading != nil {
+			if tok.Kind == lexer.EOF {
+				trivia(c).Trailing = append(trivia(c).Trailing, leading...)
+			} else if n, ok := a.starts[tok.Pos.Offset]; ok {
+				trivia(n).Leading = append(trivia(n).Leading, leading...)
+			} else {
+				trivia(a.inner[i]).Inner = append(trivia(a.inner[i]).Inner, leading...)
+			}
+		}
+		if trailing := tok.Trivia.Trailing; trailing != nil {
+			if n, ok := a.ends[tok.End.Offset]; ok {
+				trivia(n).Trailing = append(trivia(n).Trailing, trailing...)
+			} else {
+				trivia(a.inner[i]).Inner = append(trivia(a.inner[i]).Inner, trailing...)
+			}
+		}
+	}
+}
+
+// attacher finds, in a single walk of the tree, the outermost nodes that
+// start and end at each offset and the innermost node containing each
+// token, which is the deepest one, or the first of the deepest.
+//
+// Walk visits children in source order, so the tokens before a node that
+// were not placed in an earlier one are in the innermost node still open.
+// The one exception is the function of a function statement, which has
+// the span of the whole statement and so overlaps the name before it.
+type attacher struct {
+	starts, ends map[int]ast.Node
+	toks         []lexer.Token // the tokens to place, in source order
+	inner        []ast.Node    // inner[i] is the innermost node containing toks[i]
+	depth        []int         // depth[i] is the depth of inner[i]
+	placed       int           // the number of tokens placed so far
+	open         []ast.Node    // the nodes entered but not yet left, innermost last
+}
+
+func (a *attacher) Enter(n ast.Node) ast.Visitor {
+	a.place(n.Pos().Offset)
+	// claim the tokens already placed in n from shallower nodes
+	d := len(a.open)
+	for i := a.placed - 1; i >= 0 && a.toks[i].Pos.Offset >= n.Pos().Offset; i-- {
+		if a.depth[i] < d {
+			a.inner[i], a.depth[i] = n, d
+		}
+	}
+	if _, ok := n.(*ast.Block); !ok {
+		// parents are entered before their children
+		if _, ok := a.starts[n.Pos().Offset]; !ok {
+			a.starts[n.Pos().Offset] = n
+		}
+		if _, ok := a.ends[n.End().Offset]; !ok {
+			a.ends[n.End().Offset] = n
+		}
+	}
+	a.open = append(a.open, n)
+	return a
+}
+
+func (a *attacher) Leave(n ast.Node) {
+	a.place(n.End().Offset)
+	a.open = a.open[:len(a.open)-1]
+}
+
+// place puts the tokens that start before offset off and have not been
+// placed yet in the innermost open node.
+func (a *attacher) place(off int) {
+	for ; a.placed < len(a.toks) && a.toks[a.placed].Pos.Offset < off; a.placed++ {
+		a.inner[a.placed], a.depth[a.placed] = a.open[len(a.open)-1], len(a.open)-1
+	}
+}
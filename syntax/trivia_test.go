@@ -0,0 +1,181 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package syntax
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
+)
+
+// triviaOf renders the trivia attached to each node of the chunk, in
+// source order, one node per line.
+func triviaOf(c *ast.Chunk) string {
+	var nodes []ast.Node
+	for n := range c.Trivia {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Pos().Offset != nodes[j].Pos().Offset {
+			return nodes[i].Pos().Offset < nodes[j].Pos().Offset
+		}
+		// the outer of two nodes that start together ends later
+		return nodes[i].End().Offset > nodes[j].End().Offset
+	})
+	render := func(list []lexer.Trivia) string {
+		var s []string
+		for _, t := range list {
+			if t.IsComment() {
+				s = append(s, string(t.Text))
+			} else {
+				s = append(s, fmt.Sprintf("<%d blank>", strings.Count(string(t.Text), "\n")))
+			}
+		}
+		return strings.Join(s, " ")
+	}
+	var lines []string
+	for _, n := range nodes {
+		t := c.Trivia[n]
+		line := fmt.Sprintf("%s@%d:", strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."), n.Pos().Line)
+		if t.Leading != nil {
+			line += " leading " + render(t.Leading) + ";"
+		}
+		if t.Trailing != nil {
+			line += " trailing " + render(t.Trailing) + ";"
+		}
+		if t.Inner != nil {
+			line += " inner " + render(t.Inner) + ";"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTrivia(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"x = 1", ""},
+		{"-- head\n\nlocal x = 1 -- one\n", "LocalStat@3: leading -- head <1 blank>; trailing -- one;"},
+		{"x = 1\n\n\ny = 2", "AssignStat@4: leading <2 blank>;"},
+		{"x = 1\n-- tail\n", "Chunk@1: trailing -- tail;"},
+		{"x = --[[ a ]] 1", "AssignStat@1: inner --[[ a ]];"},
+		{"x =\n--[[ a ]] 1", "NumberExpr@2: leading --[[ a ]];"},
+		{"x = 1 --[[ a ]] + 2", "NumberExpr@1: trailing --[[ a ]];"},
+		{"if x then -- then\n  y()\nend --[[ end ]]",
+			"IfStat@1: trailing --[[ end ]];\nIfClause@1: inner -- then;"},
+		{"f(a, -- comma\n  b)", "CallExpr@1: inner -- comma;"},
+		{"local t = { -- open\n  1,\n}", "TableExpr@1: inner -- open;"},
+		{"do\n  -- only a comment\nend", "DoStat@1: inner -- only a comment;"},
+		{"function f() -- header\nend", "FunctionExpr@1: inner -- header;"},
+	} {
+		c, err := ParseChunk("test", []byte(tc.src), ParseTrivia)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if got := triviaOf(c); got != tc.want {
+			t.Errorf("%q\ngot\n%s\nwant\n%s", tc.src, got, tc.want)
+		}
+	}
+}
+
+// TestTriviaOff checks that trivia is not kept unless asked for.
+func TestTriviaOff(t *testing.T) {
+	c, err := ParseChunk("test", []byte("-- comment\nx = 1 -- comment\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Trivia != nil {
+		t.Errorf("trivia kept without ParseTrivia: %s", triviaOf(c))
+	}
+}
+
+// TestTriviaInnermost checks that the single walk done by attachTrivia
+// finds the innermost node containing each token: the deepest one, or
+// the first of the deepest in the order of Walk.
+func TestTriviaInnermost(t *testing.T) {
+	const src = `
+local t = {1, [2] = 3, x = {y = f(a, b)}}
+function t.m:n(a, ...)
+	for i = 1, #a, -1 do
+		if a[i] then return ... elseif not a then break else goto l end
+	end
+	::l::
+	repeat local z = (a + b) * c .. d until z
+	return function(...) return {...}, t:m(1)("s"){} end
+end
+`
+	// put a comment after every token
+	toks, err := lexer.Scan([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, tok := range toks {
+		b.Write(tok.Raw)
+		b.WriteString(" --[[c]] ")
+	}
+	chunk, err := Config{Mode: ParseTrivia}.Parse("test", []byte(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Lower(chunk)
+
+	a := &attacher{starts: map[int]ast.Node{}, ends: map[int]ast.Node{}, toks: chunk.trivia,
+		inner: make([]ast.Node, len(chunk.trivia)), depth: make([]int, len(chunk.trivia)), open: []ast.Node{c}}
+	ast.Walk(a, c.Block)
+	a.place(len(b.String()) + 1)
+	for i, tok := range chunk.trivia {
+		want, wantDepth := ast.Node(c), 0
+		ast.Walk(&depthFinder{pos: tok.Pos, found: &want, foundDepth: &wantDepth, depth: 1}, c.Block)
+		if a.inner[i] != want {
+			t.Errorf("token %q at %v: innermost node is %T at %v, want %T at %v",
+				tok.Raw, tok.Pos, a.inner[i], a.inner[i].Pos(), want, want.Pos())
+		}
+	}
+}
+
+// depthFinder finds the deepest node containing pos, or the first of the
+// deepest, by looking at every node.
+type depthFinder struct {
+	pos        lexer.Pos
+	found      *ast.Node
+	foundDepth *int
+	depth      int
+}
+
+func (f *depthFinder) Enter(n ast.Node) ast.Visitor {
+	if n.Pos().Offset <= f.pos.Offset && f.pos.Offset < n.End().Offset && f.depth > *f.foundDepth {
+		*f.found, *f.foundDepth = n, f.depth
+	}
+	return &depthFinder{pos: f.pos, found: f.found, foundDepth: f.foundDepth, depth: f.depth + 1}
+}
+
+func (f *depthFinder) Leave(n ast.Node) {}
@@ -0,0 +1,812 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/lexer"
+	"github.com/mdhender/glua/lua"
+	"github.com/mdhender/glua/resolve"
+)
+
+// Limits of the compiler, which are those of the reference
+// implementation.
+const (
+	maxRegs   = MaxArgA // registers a function may use
+	maxLocals = 200     // active locals in a function
+	maxUpvals = MaxArgB // upvalues of a function
+
+	// fieldsPerFlush is the number of positional fields of a table
+	// constructor stored by each SETLIST.
+	fieldsPerFlush = 50
+)
+
+// noJump marks the end of a list of jumps to patch.
+const noJump = -1
+
+// Error is a limit of the compiler exceeded by a chunk.
+type Error struct {
+	Chunk string    // name of the chunk
+	Pos   lexer.Pos // position of the construct that needs too much
+	Msg   string
+}
+
+// Error formats the error as "chunk:line: message".
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Chunk, e.Pos.Line, e.Msg)
+}
+
+// Compile compiles a chunk to the prototype of its main function.
+// The chunk must have been checked by package check.
+func Compile(chunk *ast.Chunk) (p *Proto, err error) {
+	c := &compiler{chunk: chunk.Name, info: resolve.Resolve(chunk)}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, e
+		}
+	}()
+	fs := c.open(nil, c.info.Funcs[chunk], chunk)
+	fs.p.IsVararg = true
+	fs.p.Upvalues = []UpvalDesc{{Name: "_ENV", InStack: true}}
+	fs.stats(chunk.Block.Stats)
+	fs.line = chunk.End().Line
+	return fs.close(), nil
+}
+
+// compiler holds the state shared by the functions of a chunk.
+type compiler struct {
+	chunk string
+	info  *resolve.Info
+}
+
+// funcState is the state of a function being compiled.
+type funcState struct {
+	c      *compiler
+	parent *funcState
+	f      *resolve.Function
+	p      *Proto
+	bl     *blockState // the innermost block
+
+	actvars []activeVar // the active locals, innermost last
+	nactvar int         // registers held by active locals and hidden loop state
+	freereg int         // the first free register
+
+	constants map[interface{}]int // index of each constant
+	line      int                 // the source line of the code being emitted
+}
+
+// activeVar is an active local variable.
+type activeVar struct {
+	v      *resolve.Var
+	locvar int // index in Proto.LocVars
+}
+
+// floatKey is the key of a float constant in funcState.constants,
+// which tells 0.0 from -0.0 and lets NaN be found again.
+type floatKey uint64
+
+// open starts compiling a function.
+func (c *compiler) open(parent *funcState, f *resolve.Function, node ast.Node) *funcState {
+	fs := &funcState{
+		c:         c,
+		parent:    parent,
+		f:         f,
+		p:         &Proto{Source: c.chunk, MaxStack: 2},
+		constants: map[interface{}]int{},
+		line:      node.Pos().Line,
+	}
+	if parent != nil {
+		fs.p.LineDefined = node.Pos().Line
+	}
+	fs.p.LastLine = node.End().Line
+	for _, u := range f.Upvalues {
+		fs.p.Upvalues = append(fs.p.Upvalues, UpvalDesc{Name: u.Name, InStack: u.InStack, Index: u.Index})
+	}
+	if len(fs.p.Upvalues) > maxUpvals {
+		fs.errorf(node.Pos(), "too many upvalues (limit is %d)", maxUpvals)
+	}
+	fs.enterBlock(false)
+	return fs
+}
+
+// close finishes the function with a final return.
+func (fs *funcState) close() *Proto {
+	fs.emit(createABC(RETURN0, 0, 0, 0, false))
+	fs.leaveBlock()
+	return fs.p
+}
+
+func (fs *funcState) errorf(pos lexer.Pos, format string, args ...interface{}) {
+	panic(&Error{Chunk: fs.c.chunk, Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// ----------------------------------------------------------------------------
+// Code generation
+
+// pc returns the index of the next instruction.
+func (fs *funcState) pc() int {
+	return len(fs.p.Code)
+}
+
+// emit appends an instruction and returns its index.
+func (fs *funcState) emit(i Instruction) int {
+	fs.p.Code = append(fs.p.Code, i)
+	fs.p.Lines = append(fs.p.Lines, fs.line)
+	return len(fs.p.Code) - 1
+}
+
+func (fs *funcState) emitABC(op Opcode, a, b, c int) int {
+	return fs.emit(createABC(op, a, b, c, false))
+}
+
+func (fs *funcState) emitABCk(op Opcode, a, b, c int, k bool) int {
+	return fs.emit(createABC(op, a, b, c, k))
+}
+
+// emitJump emits a jump to be patched later and returns it as a list.
+func (fs *funcState) emitJump() []int {
+	return []int{fs.emit(createSJ(JMP, 0))}
+}
+
+// patch makes the jumps in the list go to target.
+func (fs *funcState) patch(list []int, target int) {
+	for _, pc := range list {
+		fs.p.Code[pc] = fs.p.Code[pc].setSJ(target - (pc + 1))
+	}
+}
+
+// patchHere makes the jumps in the list go to the next instruction.
+func (fs *funcState) patchHere(list []int) {
+	fs.patch(list, fs.pc())
+}
+
+// jumpTo emits a jump to target.
+func (fs *funcState) jumpTo(target int) {
+	fs.patch(fs.emitJump(), target)
+}
+
+// reserve sets aside n registers above the ones in use.
+func (fs *funcState) reserve(n int) {
+	fs.freereg += n
+	if fs.freereg > fs.p.MaxStack {
+		if fs.freereg > maxRegs {
+			fs.errorf(lexer.Pos{Line: fs.line}, "function or expression needs too many registers")
+		}
+		fs.p.MaxStack = fs.freereg
+	}
+}
+
+// constant returns the index of a constant, adding it if needed.
+func (fs *funcState) constant(v lua.Value) int {
+	var key interface{} = v
+	if f, ok := v.(float64); ok {
+		key = floatKey(math.Float64bits(f))
+	}
+	if i, ok := fs.constants[key]; ok {
+		return i
+	}
+	i := len(fs.p.Constants)
+	fs.p.Constants = append(fs.p.Constants, v)
+	fs.constants[key] = i
+	return i
+}
+
+// loadConstant emits code to load constant index i into register r.
+func (fs *funcState) loadConstant(r, i int) {
+	if i <= MaxArgBx {
+		fs.emit(createABx(LOADK, r, i))
+		return
+	}
+	fs.emit(createABx(LOADKX, r, 0))
+	fs.emit(createAx(EXTRAARG, i))
+}
+
+// ----------------------------------------------------------------------------
+// Blocks and scopes
+
+// blockState is a block being compiled.
+type blockState struct {
+	prev      *blockState
+	nactvar   int  // registers held by locals when the block started
+	nvars     int  // active locals when the block started
+	isLoop    bool // breaks jump out of this block
+	insideTBC bool // in the scope of a to-be-closed variable
+	breaks    []int
+	labels    []label
+	gotos     []pendingGoto // gotos waiting for a label later in the function
+}
+
+// label is a label visible in a block.
+type label struct {
+	name    string
+	pc      int
+	nactvar int
+}
+
+// pendingGoto is a forward goto whose label is not yet known.
+type pendingGoto struct {
+	name     string
+	pc       int // the jump
+	captured int // the highest register of a local to close active at the goto, or -1
+}
+
+func (fs *funcState) enterBlock(isLoop bool) {
+	bl := &blockState{prev: fs.bl, nactvar: fs.nactvar, nvars: len(fs.actvars), isLoop: isLoop}
+	bl.insideTBC = fs.bl != nil && fs.bl.insideTBC
+	fs.bl = bl
+}
+
+// leaveBlock ends the scope of the locals declared in the block,
+// closing the upvalues of those that were captured and the
+// to-be-closed variables.
+func (fs *funcState) leaveBlock() {
+	bl := fs.bl
+	if bl.prev != nil && fs.closeFrom(bl.nactvar) >= 0 {
+		fs.emitABC(CLOSE, bl.nactvar, 0, 0)
+	}
+	fs.removeVars(bl.nvars)
+	fs.nactvar, fs.freereg = bl.nactvar, bl.nactvar
+	if bl.isLoop {
+		fs.patchHere(bl.breaks)
+	}
+	fs.bl = bl.prev
+	if fs.bl != nil {
+		fs.bl.gotos = append(fs.bl.gotos, bl.gotos...)
+	}
+}
+
+// closeFrom returns the highest register at or above level that holds
+// a local captured by a closure or a to-be-closed variable, or -1 if
+// there is none. Leaving the scope of such a local needs a CLOSE.
+func (fs *funcState) closeFrom(level int) int {
+	for i := len(fs.actvars) - 1; i >= 0; i-- {
+		v := fs.actvars[i].v
+		if v.Slot < level {
+			break
+		}
+		if v.Captured || v.Attrib == "close" {
+			return v.Slot
+		}
+	}
+	return -1
+}
+
+// activate brings a declared local into scope. Its register is the
+// one the resolver assigned, which must be the next one.
+func (fs *funcState) activate(v *resolve.Var) {
+	if v.Slot != fs.nactvar {
+		panic(fmt.Sprintf("vm: local %s in register %d, expected %d", v.Name, v.Slot, fs.nactvar))
+	}
+	if len(fs.actvars) >= maxLocals {
+		pos := lexer.Pos{Line: fs.line}
+		if v.Decl != nil {
+			pos = v.Decl.Pos()
+		}
+		fs.errorf(pos, "too many local variables (limit is %d)", maxLocals)
+	}
+	fs.p.LocVars = append(fs.p.LocVars, LocVar{Name: v.Name, Reg: v.Slot, StartPC: fs.pc()})
+	fs.actvars = append(fs.actvars, activeVar{v: v, locvar: len(fs.p.LocVars) - 1})
+	fs.nactvar++
+	if fs.freereg < fs.nactvar {
+		fs.reserve(fs.nactvar - fs.freereg)
+	}
+}
+
+// removeVars ends the scope of the locals after the first n.
+func (fs *funcState) removeVars(n int) {
+	for _, av := range fs.actvars[n:] {
+		fs.p.LocVars[av.locvar].EndPC = fs.pc()
+	}
+	fs.actvars = fs.actvars[:n]
+}
+
+// hidden sets aside n registers for the hidden state of a loop, as
+// locals named "(for state)".
+func (fs *funcState) hidden(n int) {
+	for i := 0; i < n; i++ {
+		fs.activate(&resolve.Var{Name: "(for state)", Slot: fs.nactvar})
+	}
+}
+
+// toBeClosed marks the local in register r as a to-be-closed variable
+// of the current block.
+func (fs *funcState) toBeClosed(r int) {
+	fs.emitABC(TBC, r, 0, 0)
+	fs.bl.insideTBC = true
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+func (fs *funcState) stats(list []ast.Stat) {
+	for _, s := range list {
+		fs.stat(s)
+		fs.freereg = fs.nactvar
+	}
+}
+
+// block compiles a block in a scope of its own.
+func (fs *funcState) block(b *ast.Block) {
+	fs.enterBlock(false)
+	fs.stats(b.Stats)
+	fs.leaveBlock()
+}
+
+func (fs *funcState) stat(s ast.Stat) {
+	fs.line = s.Pos().Line
+	switch s := s.(type) {
+	case *ast.EmptyStat:
+		// nothing to do
+	case *ast.AssignStat:
+		fs.assign(s)
+	case *ast.CallStat:
+		fs.call(s.Call, 0)
+	case *ast.LabelStat:
+		fs.label(s)
+	case *ast.BreakStat:
+		fs.breakStat()
+	case *ast.GotoStat:
+		fs.gotoStat(s)
+	case *ast.DoStat:
+		fs.block(s.Body)
+	case *ast.WhileStat:
+		fs.whileStat(s)
+	case *ast.RepeatStat:
+		fs.repeatStat(s)
+	case *ast.IfStat:
+		fs.ifStat(s)
+	case *ast.NumericForStat:
+		fs.numericFor(s)
+	case *ast.GenericForStat:
+		fs.genericFor(s)
+	case *ast.FunctionStat:
+		fs.functionStat(s)
+	case *ast.LocalFunctionStat:
+		v := fs.c.info.Defs[s.Name]
+		fs.activate(v)
+		fs.closure(s.Func, v.Slot)
+	case *ast.LocalStat:
+		fs.explist(s.Values, len(s.Names))
+		for _, name := range s.Names {
+			fs.activate(fs.c.info.Defs[name])
+		}
+		for _, name := range s.Names {
+			if v := fs.c.info.Defs[name]; v.Attrib == "close" {
+				fs.toBeClosed(v.Slot)
+			}
+		}
+	case *ast.ReturnStat:
+		fs.ret(s)
+	default:
+		panic(fmt.Sprintf("vm: unexpected statement type %T", s))
+	}
+}
+
+// label defines a label. Pending gotos to it are patched, and if one
+// of them leaves the scope of a captured local, the label starts with
+// a CLOSE.
+func (fs *funcState) label(s *ast.LabelStat) {
+	bl := fs.bl
+	l := label{name: s.Name.Name, pc: fs.pc(), nactvar: fs.nactvar}
+	needClose := false
+	gotos := bl.gotos[:0]
+	var matched []int
+	for _, g := range bl.gotos {
+		if g.name != l.name {
+			gotos = append(gotos, g)
+			continue
+		}
+		matched = append(matched, g.pc)
+		if g.captured >= l.nactvar {
+			needClose = true
+		}
+	}
+	bl.gotos = gotos
+	if needClose {
+		fs.emitABC(CLOSE, l.nactvar, 0, 0)
+	}
+	fs.patch(matched, l.pc)
+	bl.labels = append(bl.labels, l)
+}
+
+func (fs *funcState) gotoStat(s *ast.GotoStat) {
+	name := s.Label.Name
+	for bl := fs.bl; bl != nil; bl = bl.prev {
+		for _, l := range bl.labels {
+			if l.name == name {
+				// a backward jump
+				if fs.closeFrom(l.nactvar) >= 0 {
+					fs.emitABC(CLOSE, l.nactvar, 0, 0)
+				}
+				fs.jumpTo(l.pc)
+				return
+			}
+		}
+	}
+	fs.bl.gotos = append(fs.bl.gotos, pendingGoto{
+		name:     name,
+		pc:       fs.emitJump()[0],
+		captured: fs.closeFrom(0),
+	})
+}
+
+func (fs *funcState) breakStat() {
+	bl := fs.bl
+	for !bl.isLoop {
+		bl = bl.prev
+	}
+	if fs.closeFrom(bl.nactvar) >= 0 {
+		fs.emitABC(CLOSE, bl.nactvar, 0, 0)
+	}
+	bl.breaks = append(bl.breaks, fs.emitJump()...)
+}
+
+func (fs *funcState) whileStat(s *ast.WhileStat) {
+	start := fs.pc()
+	exit := fs.condJump(s.Cond, false)
+	fs.enterBlock(true)
+	fs.block(s.Body)
+	fs.jumpTo(start)
+	fs.patchHere(exit)
+	fs.leaveBlock()
+}
+
+func (fs *funcState) repeatStat(s *ast.RepeatStat) {
+	start := fs.pc()
+	fs.enterBlock(true)
+	fs.enterBlock(false)
+	fs.stats(s.Body.Stats)
+	fs.line = s.Cond.Pos().Line
+	again := fs.condJump(s.Cond, false)
+	level := fs.bl.nactvar
+	captured := fs.closeFrom(level) >= 0
+	fs.leaveBlock()
+	if captured {
+		// the upvalues must be closed before the next iteration too
+		exit := fs.emitJump()
+		fs.patchHere(again)
+		fs.emitABC(CLOSE, level, 0, 0)
+		again = fs.emitJump()
+		fs.patchHere(exit)
+	}
+	fs.patch(again, start)
+	fs.leaveBlock()
+}
+
+func (fs *funcState) ifStat(s *ast.IfStat) {
+	var escapes []int
+	for i, c := range s.Clauses {
+		fs.line = c.Pos().Line
+		next := fs.condJump(c.Cond, false)
+		fs.block(c.Body)
+		if i < len(s.Clauses)-1 || s.Else != nil {
+			escapes = append(escapes, fs.emitJump()...)
+		}
+		fs.patchHere(next)
+	}
+	if s.Else != nil {
+		fs.block(s.Else)
+	}
+	fs.patchHere(escapes)
+}
+
+// numericFor compiles a numeric for loop. The loop keeps its state in
+// three hidden registers followed by the control variable.
+func (fs *funcState) numericFor(s *ast.NumericForStat) {
+	base := fs.freereg
+	fs.enterBlock(true)
+	fs.exp2nextreg(s.Start)
+	fs.exp2nextreg(s.Limit)
+	if s.Step != nil {
+		fs.exp2nextreg(s.Step)
+	} else {
+		fs.reserve(1)
+		fs.emit(createAsBx(LOADI, base+2, 1))
+	}
+	fs.hidden(3)
+	fs.line = s.Pos().Line
+	prep := fs.emit(createABx(FORPREP, base, 0))
+	fs.enterBlock(false)
+	fs.activate(fs.c.info.Defs[s.Var])
+	fs.stats(s.Body.Stats)
+	fs.leaveBlock()
+	fs.line = s.Pos().Line
+	loop := fs.pc()
+	fs.emit(createABx(FORLOOP, base, loopOffset(fs, loop, prep)))
+	fs.p.Code[prep] = fs.p.Code[prep].setBx(loop - (prep + 1))
+	fs.leaveBlock()
+}
+
+// loopOffset returns the backward offset of a loop instruction at pc
+// that jumps to the instruction after prep.
+func loopOffset(fs *funcState, pc, prep int) int {
+	off := pc - prep
+	if off > MaxArgBx {
+		fs.errorf(lexer.Pos{Line: fs.line}, "control structure too long")
+	}
+	return off
+}
+
+// genericFor compiles a generic for loop. The loop keeps the iterator
+// function, the state, the control value and the closing value in
+// four hidden registers followed by the loop variables. The closing
+// value is a to-be-closed variable, which TFORPREP declares.
+func (fs *funcState) genericFor(s *ast.GenericForStat) {
+	base := fs.freereg
+	fs.enterBlock(true)
+	fs.explist(s.Exprs, 4)
+	fs.hidden(3)
+	fs.activate(&resolve.Var{Name: "(for state)", Slot: fs.nactvar, Attrib: "close"})
+	fs.bl.insideTBC = true
+	fs.line = s.Pos().Line
+	prep := fs.emit(createABx(TFORPREP, base, 0))
+	fs.enterBlock(false)
+	for _, name := range s.Names {
+		fs.activate(fs.c.info.Defs[name])
+	}
+	fs.stats(s.Body.Stats)
+	fs.leaveBlock()
+	fs.line = s.Pos().Line
+	fs.p.Code[prep] = fs.p.Code[prep].setBx(fs.pc() - (prep + 1))
+	fs.emitABC(TFORCALL, base, 0, len(s.Names))
+	fs.emit(createABx(TFORLOOP, base, loopOffset(fs, fs.pc(), prep)))
+	fs.leaveBlock()
+}
+
+// functionStat assigns a function to its name, which may be a field
+// of a table: function a.b.c:m() ... end.
+func (fs *funcState) functionStat(s *ast.FunctionStat) {
+	path := s.Name.Path
+	if len(path) == 1 && s.Name.Method == nil {
+		fs.storeFunction(path[0], s.Func)
+		return
+	}
+	last := s.Name.Method
+	if last == nil {
+		last, path = path[len(path)-1], path[:len(path)-1]
+	}
+	t := fs.exp2anyreg(path[0])
+	for _, id := range path[1:] {
+		r := fs.freereg
+		if t >= fs.nactvar {
+			r = t // reuse the temporary
+		} else {
+			fs.reserve(1)
+		}
+		fs.line = id.Pos().Line
+		fs.index(t, &ast.StringExpr{Span: id.Span, Value: id.Name}, r)
+		t = r
+	}
+	f := fs.freereg
+	fs.reserve(1)
+	fs.closure(s.Func, f)
+	fs.line = s.Pos().Line
+	fs.storeField(t, last.Name, f, false)
+}
+
+// storeFunction assigns a function to a variable.
+func (fs *funcState) storeFunction(id *ast.Ident, fn *ast.FunctionExpr) {
+	b := fs.c.info.Uses[id]
+	if b.Kind == resolve.Local {
+		fs.closure(fn, b.Index)
+		return
+	}
+	r := fs.freereg
+	fs.reserve(1)
+	fs.closure(fn, r)
+	fs.storeVar(id, r, false)
+}
+
+// ret compiles a return statement. A single call that is not in
+// parentheses becomes a tail call, unless a to-be-closed variable
+// must be closed after it.
+func (fs *funcState) ret(s *ast.ReturnStat) {
+	switch len(s.Values) {
+	case 0:
+		fs.emitABC(RETURN0, 0, 0, 0)
+		return
+	case 1:
+		switch x := s.Values[0].(type) {
+		case *ast.CallExpr:
+			if fs.bl.insideTBC {
+				break
+			}
+			base := fs.call(x, -1)
+			i := &fs.p.Code[len(fs.p.Code)-1]
+			*i = createABC(TAILCALL, base, i.B(), 0, false)
+			fs.emitABC(RETURN, base, 0, 0)
+			return
+		case *ast.VarargExpr:
+		default:
+			r := fs.exp2anyreg(x)
+			fs.emitABC(RETURN1, r, 0, 0)
+			return
+		}
+	}
+	base := fs.freereg
+	n, open := fs.explist(s.Values, -1)
+	b := n + 1
+	if open {
+		b = 0
+	}
+	fs.line = s.Pos().Line
+	fs.emitABC(RETURN, base, b, 0)
+}
+
+// assign compiles an assignment. The tables and keys of the targets
+// are evaluated from left to right, then the values, and the stores
+// are made from right to left.
+func (fs *funcState) assign(s *ast.AssignStat) {
+	if len(s.Targets) == 1 && len(s.Values) == 1 {
+		fs.assignOne(s.Targets[0], s.Values[0])
+		return
+	}
+	// registers of the local targets, whose old values must be used
+	// for the tables and keys of the other targets
+	assigned := map[int]bool{}
+	for _, t := range s.Targets {
+		if id, ok := t.(*ast.Ident); ok {
+			if b := fs.c.info.Uses[id]; b.Kind == resolve.Local {
+				assigned[b.Index] = true
+			}
+		}
+	}
+	safe := func(r int) int {
+		if r < fs.nactvar && assigned[r] {
+			t := fs.freereg
+			fs.reserve(1)
+			fs.emitABC(MOVE, t, r, 0)
+			return t
+		}
+		return r
+	}
+	places := make([]place, len(s.Targets))
+	for i, t := range s.Targets {
+		if x, ok := t.(*ast.IndexExpr); ok {
+			p := place{t: safe(fs.exp2anyreg(x.Object))}
+			p.key, p.keyKind = fs.keyOperand(x.Key)
+			if p.keyKind == keyReg {
+				p.key = safe(p.key)
+			}
+			places[i] = p
+		}
+	}
+	base := fs.freereg
+	fs.explist(s.Values, len(s.Targets))
+	for i := len(s.Targets) - 1; i >= 0; i-- {
+		fs.line = s.Targets[i].Pos().Line
+		switch t := s.Targets[i].(type) {
+		case *ast.Ident:
+			fs.storeVar(t, base+i, false)
+		case *ast.IndexExpr:
+			fs.storeIndex(places[i], base+i, false)
+		}
+	}
+}
+
+// assignOne compiles an assignment of one value to one target.
+func (fs *funcState) assignOne(target, value ast.Expr) {
+	switch t := target.(type) {
+	case *ast.Ident:
+		if b := fs.c.info.Uses[t]; b.Kind == resolve.Local {
+			fs.exp2reg(value, b.Index)
+			return
+		}
+		v, isK := fs.exp2rk(value)
+		fs.line = t.Pos().Line
+		fs.storeVar(t, v, isK)
+	case *ast.IndexExpr:
+		p := place{t: fs.exp2anyreg(t.Object)}
+		p.key, p.keyKind = fs.keyOperand(t.Key)
+		v, isK := fs.exp2rk(value)
+		fs.line = t.Pos().Line
+		fs.storeIndex(p, v, isK)
+	}
+}
+
+// A place is the table and key of an indexed assignment target.
+type place struct {
+	t       int
+	key     int
+	keyKind keyKind
+}
+
+// keyKind tells how an index operand is encoded.
+type keyKind int
+
+const (
+	keyReg   keyKind = iota // a register
+	keyField                // a string constant
+	keyInt                  // a small non-negative integer
+)
+
+// keyOperand compiles an index key to an operand of the instructions
+// that index tables.
+func (fs *funcState) keyOperand(key ast.Expr) (int, keyKind) {
+	switch k := key.(type) {
+	case *ast.StringExpr:
+		if i := fs.constant(k.Value); i <= MaxArgB {
+			return i, keyField
+		}
+	case *ast.NumberExpr:
+		if !k.IsFloat && 0 <= k.Int && k.Int <= MaxArgB {
+			return int(k.Int), keyInt
+		}
+	}
+	return fs.exp2anyreg(key), keyReg
+}
+
+// storeIndex stores RK(v) in t[key].
+func (fs *funcState) storeIndex(p place, v int, isK bool) {
+	switch p.keyKind {
+	case keyField:
+		fs.emitABCk(SETFIELD, p.t, p.key, v, isK)
+	case keyInt:
+		fs.emitABCk(SETI, p.t, p.key, v, isK)
+	default:
+		fs.emitABCk(SETTABLE, p.t, p.key, v, isK)
+	}
+}
+
+// storeField stores RK(v) in t[name].
+func (fs *funcState) storeField(t int, name string, v int, isK bool) {
+	key, kind := fs.keyOperand(&ast.StringExpr{Value: name})
+	fs.storeIndex(place{t: t, key: key, keyKind: kind}, v, isK)
+}
+
+// storeVar stores RK(v) in the variable a name refers to.
+func (fs *funcState) storeVar(id *ast.Ident, v int, isK bool) {
+	b := fs.c.info.Uses[id]
+	if isK && b.Kind != resolve.Global {
+		r := fs.freereg
+		fs.reserve(1)
+		fs.loadConstant(r, v)
+		v, isK = r, false
+	}
+	switch b.Kind {
+	case resolve.Local:
+		if v != b.Index {
+			fs.emitABC(MOVE, b.Index, v, 0)
+		}
+	case resolve.Upvalue:
+		fs.emitABC(SETUPVAL, v, b.Index, 0)
+	case resolve.Global:
+		env := b.Env
+		if env.Kind == resolve.Upvalue {
+			if k := fs.constant(id.Name); k <= MaxArgB {
+				fs.emitABCk(SETTABUP, env.Index, k, v, isK)
+				return
+			}
+		}
+		fs.storeField(fs.envReg(env), id.Name, v, isK)
+	}
+}
@@ -0,0 +1,142 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vm
+
+import "fmt"
+
+// VarInfo describes operand i of the instruction being run, following
+// the register it was read from back to the instruction that set it.
+func (fr *frame) VarInfo(i int) string {
+	p := fr.cl.p
+	pc := fr.pc
+	in := p.Code[pc]
+	a, b, c := in.A(), in.B(), in.C()
+	switch in.Op() {
+	case GETTABUP:
+		return fmt.Sprintf(" (upvalue '%s')", p.Upvalues[b].Name)
+	case SETTABUP:
+		return fmt.Sprintf(" (upvalue '%s')", p.Upvalues[a].Name)
+	case GETTABLE, GETI, GETFIELD, SELF, UNM, BNOT, LEN,
+		ADDI, ADDK, SUBK, MULK, MODK, POWK, DIVK, IDIVK, BANDK, BORK, BXORK, SHRI:
+		if i == 0 {
+			return p.objName(pc, b)
+		}
+	case SHLI:
+		if i == 1 {
+			return p.objName(pc, b)
+		}
+	case ADD, SUB, MUL, MOD, POW, DIV, IDIV, BAND, BOR, BXOR, SHL, SHR:
+		if i == 0 {
+			return p.objName(pc, b)
+		}
+		return p.objName(pc, c)
+	case SETTABLE, SETI, SETFIELD, CALL, TAILCALL:
+		return p.objName(pc, a)
+	case CONCAT:
+		return p.objName(pc, fr.cat+i)
+	case TFORCALL:
+		return " (for iterator 'for iterator')"
+	}
+	return ""
+}
+
+// objName names the value in register reg at pc, as in " (local 'x')",
+// or returns "" if it did not come from a named variable.
+func (p *Proto) objName(pc, reg int) string {
+	if name := p.localName(reg, pc); name != "" {
+		return fmt.Sprintf(" (local '%s')", name)
+	}
+	setpc := p.findSetReg(pc, reg)
+	if setpc < 0 {
+		return ""
+	}
+	in := p.Code[setpc]
+	switch in.Op() {
+	case MOVE:
+		if b := in.B(); b < in.A() {
+			return p.objName(setpc, b)
+		}
+	case GETTABUP:
+		key := p.Constants[in.C()].(string)
+		if p.Upvalues[in.B()].Name == "_ENV" {
+			return fmt.Sprintf(" (global '%s')", key)
+		}
+		return fmt.Sprintf(" (field '%s')", key)
+	case GETFIELD:
+		key := p.Constants[in.C()].(string)
+		if p.localName(in.B(), setpc) == "_ENV" {
+			return fmt.Sprintf(" (global '%s')", key)
+		}
+		return fmt.Sprintf(" (field '%s')", key)
+	case GETUPVAL:
+		return fmt.Sprintf(" (upvalue '%s')", p.Upvalues[in.B()].Name)
+	case LOADK:
+		if s, ok := p.Constants[in.Bx()].(string); ok {
+			return fmt.Sprintf(" (constant '%s')", s)
+		}
+	case SELF:
+		if in.K() {
+			return fmt.Sprintf(" (method '%s')", p.Constants[in.C()])
+		}
+	}
+	return ""
+}
+
+// findSetReg returns the last instruction before lastpc that set
+// register reg, or -1 if it cannot be known because a jump leads past
+// that instruction.
+func (p *Proto) findSetReg(lastpc, reg int) int {
+	setreg, jmptarget := -1, 0
+	for pc := 0; pc < lastpc; pc++ {
+		in := p.Code[pc]
+		a := in.A()
+		change := false
+		switch in.Op() {
+		case LOADNIL:
+			change = a <= reg && reg <= a+in.B()
+		case TFORCALL:
+			change = reg >= a+2
+		case CALL, TAILCALL:
+			change = reg >= a
+		case JMP:
+			if dest := pc + 1 + in.SJ(); dest <= lastpc && dest > jmptarget {
+				jmptarget = dest
+			}
+		case SETUPVAL, SETTABUP, SETTABLE, SETI, SETFIELD, CLOSE, TBC,
+			EQ, LT, LE, EQK, EQI, LTI, LEI, GTI, GEI, TEST,
+			RETURN, RETURN0, RETURN1, TFORPREP, TFORLOOP, SETLIST, EXTRAARG:
+			// these do not set R[A]
+		default:
+			change = reg == a
+		}
+		if change {
+			setreg = pc
+			if pc < jmptarget {
+				setreg = -1
+			}
+		}
+	}
+	return setreg
+}
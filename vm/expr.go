@@ -0,0 +1,686 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mdhender/glua/ast"
+	"github.com/mdhender/glua/resolve"
+)
+
+// The range of the signed immediate operands sB and sC.
+const (
+	minImm = -offsetSC
+	maxImm = MaxArgC - offsetSC
+)
+
+// The range of the signed operand sBx of LOADI and LOADF.
+const (
+	minSBx = -offsetSBx
+	maxSBx = MaxArgBx - offsetSBx
+)
+
+// exp2reg compiles an expression and puts its value in register r.
+// The temporaries the expression needs are released afterwards.
+func (fs *funcState) exp2reg(e ast.Expr, r int) {
+	save := fs.freereg
+	fs.discharge(e, r)
+	fs.freereg = save
+}
+
+// exp2nextreg puts the value of an expression in a new register.
+func (fs *funcState) exp2nextreg(e ast.Expr) int {
+	fs.reserve(1)
+	r := fs.freereg - 1
+	fs.exp2reg(e, r)
+	return r
+}
+
+// exp2anyreg puts the value of an expression in a register and returns
+// it. A local variable is left in its own register.
+func (fs *funcState) exp2anyreg(e ast.Expr) int {
+	if r, ok := fs.localReg(e); ok {
+		return r
+	}
+	return fs.exp2nextreg(e)
+}
+
+// exp2opreg puts the value of the first operand of an operation whose
+// result goes to register r in a register and returns it. If r is the
+// last temporary, the operand is computed in r itself, so that a chain
+// such as a + b + c needs two registers rather than one per operand:
+// the operands that follow are computed above r and cannot clobber it.
+func (fs *funcState) exp2opreg(e ast.Expr, r int) int {
+	if reg, ok := fs.localReg(e); ok {
+		return reg
+	}
+	if r >= fs.nactvar && r == fs.freereg-1 {
+		fs.exp2reg(e, r)
+		return r
+	}
+	return fs.exp2nextreg(e)
+}
+
+// exp2rk returns the operand for an expression that may be a constant:
+// the index of the constant and true, or a register and false.
+func (fs *funcState) exp2rk(e ast.Expr) (int, bool) {
+	if v, ok := literal(e); ok {
+		if k := fs.constant(v); k <= MaxArgC {
+			return k, true
+		}
+	}
+	return fs.exp2anyreg(e), false
+}
+
+// localReg returns the register of a local variable.
+func (fs *funcState) localReg(e ast.Expr) (int, bool) {
+	if id, ok := e.(*ast.Ident); ok {
+		if b := fs.c.info.Uses[id]; b.Kind == resolve.Local {
+			return b.Index, true
+		}
+	}
+	return 0, false
+}
+
+// envReg returns a register holding the _ENV a global is found in.
+func (fs *funcState) envReg(env *resolve.Binding) int {
+	if env.Kind == resolve.Local {
+		return env.Index
+	}
+	r := fs.freereg
+	fs.reserve(1)
+	fs.emitABC(GETUPVAL, r, env.Index, 0)
+	return r
+}
+
+// literal returns the value of a literal.
+func literal(e ast.Expr) (interface{}, bool) {
+	switch e := e.(type) {
+	case *ast.NilExpr:
+		return nil, true
+	case *ast.BoolExpr:
+		return e.Value, true
+	case *ast.NumberExpr:
+		if e.IsFloat {
+			return e.Float, true
+		}
+		return e.Int, true
+	case *ast.StringExpr:
+		return e.Value, true
+	}
+	return nil, false
+}
+
+// numeral returns the value of a numeric literal.
+func numeral(e ast.Expr) (interface{}, bool) {
+	if n, ok := e.(*ast.NumberExpr); ok {
+		if n.IsFloat {
+			return n.Float, true
+		}
+		return n.Int, true
+	}
+	return nil, false
+}
+
+// immediate returns the value of an integer literal that fits in a
+// signed immediate operand.
+func immediate(e ast.Expr) (int, bool) {
+	if n, ok := e.(*ast.NumberExpr); ok && !n.IsFloat && minImm <= n.Int && n.Int <= maxImm {
+		return int(n.Int), true
+	}
+	return 0, false
+}
+
+// discharge emits the code that puts the value of an expression in
+// register r. Expressions built in consecutive registers, such as
+// calls, are built in place when r is the last register reserved and
+// moved to r otherwise.
+func (fs *funcState) discharge(e ast.Expr, r int) {
+	switch e := e.(type) {
+	case *ast.NilExpr:
+		fs.emitABC(LOADNIL, r, 0, 0)
+	case *ast.BoolExpr:
+		if e.Value {
+			fs.emitABC(LOADTRUE, r, 0, 0)
+		} else {
+			fs.emitABC(LOADFALSE, r, 0, 0)
+		}
+	case *ast.NumberExpr:
+		switch {
+		case !e.IsFloat && minSBx <= e.Int && e.Int <= maxSBx:
+			fs.emit(createAsBx(LOADI, r, int(e.Int)))
+		case e.IsFloat && e.Float == math.Trunc(e.Float) && minSBx <= e.Float && e.Float <= maxSBx &&
+			!(e.Float == 0 && math.Signbit(e.Float)):
+			fs.emit(createAsBx(LOADF, r, int(e.Float)))
+		default:
+			v, _ := numeral(e)
+			fs.loadConstant(r, fs.constant(v))
+		}
+	case *ast.StringExpr:
+		fs.loadConstant(r, fs.constant(e.Value))
+	case *ast.VarargExpr:
+		fs.emitABC(VARARG, r, 0, 2)
+	case *ast.Ident:
+		fs.variable(e, r)
+	case *ast.ParenExpr:
+		fs.discharge(e.X, r)
+	case *ast.FunctionExpr:
+		fs.closure(e, r)
+	case *ast.IndexExpr:
+		t := fs.exp2opreg(e.Object, r)
+		fs.line = e.Pos().Line
+		fs.index(t, e.Key, r)
+	case *ast.CallExpr:
+		fs.inPlace(r, func() { fs.call(e, 1) })
+	case *ast.TableExpr:
+		fs.inPlace(r, func() { fs.table(e) })
+	case *ast.UnaryExpr:
+		fs.unary(e, r)
+	case *ast.BinaryExpr:
+		fs.binary(e, r)
+	default:
+		panic(fmt.Sprintf("vm: unexpected expression type %T", e))
+	}
+}
+
+// inPlace runs build, which leaves a value in the first free register,
+// and puts the value in register r.
+func (fs *funcState) inPlace(r int, build func()) {
+	if r == fs.freereg-1 && r >= fs.nactvar {
+		fs.freereg = r
+		build()
+		return
+	}
+	t := fs.freereg
+	build()
+	fs.emitABC(MOVE, r, t, 0)
+}
+
+// variable loads the value of a variable into register r.
+func (fs *funcState) variable(id *ast.Ident, r int) {
+	b := fs.c.info.Uses[id]
+	fs.line = id.Pos().Line
+	switch b.Kind {
+	case resolve.Local:
+		if b.Index != r {
+			fs.emitABC(MOVE, r, b.Index, 0)
+		}
+	case resolve.Upvalue:
+		fs.emitABC(GETUPVAL, r, b.Index, 0)
+	case resolve.Global:
+		env := b.Env
+		if env.Kind == resolve.Upvalue {
+			if k := fs.constant(id.Name); k <= MaxArgC {
+				fs.emitABC(GETTABUP, r, env.Index, k)
+				return
+			}
+		}
+		t := fs.envReg(env)
+		fs.index(t, &ast.StringExpr{Span: id.Span, Value: id.Name}, r)
+	}
+}
+
+// index loads R[t][key] into register r.
+func (fs *funcState) index(t int, key ast.Expr, r int) {
+	line := fs.line
+	k, kind := fs.keyOperand(key)
+	fs.line = line
+	switch kind {
+	case keyField:
+		fs.emitABC(GETFIELD, r, t, k)
+	case keyInt:
+		fs.emitABC(GETI, r, t, k)
+	default:
+		fs.emitABC(GETTABLE, r, t, k)
+	}
+}
+
+var unaryOps = [...]Opcode{
+	ast.OpNeg:  UNM,
+	ast.OpNot:  NOT,
+	ast.OpLen:  LEN,
+	ast.OpBnot: BNOT,
+}
+
+func (fs *funcState) unary(e *ast.UnaryExpr, r int) {
+	x := fs.exp2opreg(e.Operand, r)
+	fs.line = e.Pos().Line
+	fs.emitABC(unaryOps[e.Op], r, x, 0)
+}
+
+// binaryOps maps the arithmetic and bitwise operators to the opcodes
+// that take two registers and to those that take a constant.
+var binaryOps = [...]struct{ rr, rk Opcode }{
+	ast.OpAdd:  {ADD, ADDK},
+	ast.OpSub:  {SUB, SUBK},
+	ast.OpMul:  {MUL, MULK},
+	ast.OpDiv:  {DIV, DIVK},
+	ast.OpIDiv: {IDIV, IDIVK},
+	ast.OpPow:  {POW, POWK},
+	ast.OpMod:  {MOD, MODK},
+	ast.OpBand: {BAND, BANDK},
+	ast.OpBxor: {BXOR, BXORK},
+	ast.OpBor:  {BOR, BORK},
+	ast.OpShr:  {SHR, 0},
+	ast.OpShl:  {SHL, 0},
+}
+
+func (fs *funcState) binary(e *ast.BinaryExpr, r int) {
+	switch e.Op {
+	case ast.OpAnd, ast.OpOr:
+		fs.andOr(e, r)
+		return
+	case ast.OpConcat:
+		fs.inPlace(r, func() { fs.concat(e) })
+		return
+	case ast.OpEQ, ast.OpNE, ast.OpLT, ast.OpLE, ast.OpGT, ast.OpGE:
+		top := r >= fs.nactvar && r == fs.freereg-1
+		if top {
+			// the comparison is done with its operands before r is
+			// set, so they can be computed in r
+			fs.freereg = r
+		}
+		t := fs.condJump(e, true)
+		if top {
+			fs.freereg = r + 1
+		}
+		fs.emitABC(LFALSESKIP, r, 0, 0)
+		fs.patchHere(t)
+		fs.emitABC(LOADTRUE, r, 0, 0)
+		return
+	}
+
+	// The operands are never swapped, so that a metamethod sees
+	// them in the order they were written.
+	if e.Op == ast.OpShl {
+		if imm, ok := immediate(e.Left); ok {
+			b := fs.exp2opreg(e.Right, r)
+			fs.line = e.Pos().Line
+			fs.emitABC(SHLI, r, b, imm+offsetSC)
+			return
+		}
+	}
+	b := fs.exp2opreg(e.Left, r)
+	if imm, ok := immediate(e.Right); ok && (e.Op == ast.OpAdd || e.Op == ast.OpShr) {
+		fs.line = e.Pos().Line
+		op := ADDI
+		if e.Op == ast.OpShr {
+			op = SHRI
+		}
+		fs.emitABC(op, r, b, imm+offsetSC)
+		return
+	}
+	ops := binaryOps[e.Op]
+	if v, ok := numeral(e.Right); ok && ops.rk != 0 {
+		_, isInt := v.(int64)
+		if isInt || ops.rk < BANDK {
+			if k := fs.constant(v); k <= MaxArgC {
+				fs.line = e.Pos().Line
+				fs.emitABC(ops.rk, r, b, k)
+				return
+			}
+		}
+	}
+	var c int
+	if b == r {
+		c = fs.exp2anyreg(e.Right)
+	} else {
+		// the left operand is a local, which leaves r free for the
+		// right one, so that a ^ b ^ c needs no register per operand
+		c = fs.exp2opreg(e.Right, r)
+	}
+	fs.line = e.Pos().Line
+	fs.emitABC(ops.rr, r, b, c)
+}
+
+// andOr compiles 'a and b' and 'a or b' as values. The result is a
+// if it decides the outcome and b otherwise.
+func (fs *funcState) andOr(e *ast.BinaryExpr, r int) {
+	if r < fs.nactvar {
+		// b may read the variable that r holds
+		fs.inPlace(r, func() {
+			fs.reserve(1)
+			fs.andOr(e, fs.freereg-1)
+		})
+		return
+	}
+	fs.exp2reg(e.Left, r)
+	fs.emitABCk(TEST, r, 0, 0, e.Op == ast.OpOr)
+	skip := fs.emitJump()
+	fs.exp2reg(e.Right, r)
+	fs.patchHere(skip)
+}
+
+// concat compiles a chain of concatenations into consecutive registers
+// from the first free one and leaves the result in the first.
+func (fs *funcState) concat(e *ast.BinaryExpr) {
+	base := fs.freereg
+	var x ast.Expr = e
+	for {
+		b, ok := x.(*ast.BinaryExpr)
+		if !ok || b.Op != ast.OpConcat {
+			break
+		}
+		fs.exp2nextreg(b.Left)
+		x = b.Right
+	}
+	fs.exp2nextreg(x)
+	fs.line = e.Pos().Line
+	fs.emitABC(CONCAT, base, fs.freereg-base, 0)
+	fs.freereg = base + 1
+}
+
+// condJump compiles a condition and returns the jumps taken when its
+// truth is jumpWhen. Otherwise the code falls through.
+func (fs *funcState) condJump(e ast.Expr, jumpWhen bool) []int {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		return fs.condJump(x.X, jumpWhen)
+	case *ast.NilExpr, *ast.BoolExpr, *ast.NumberExpr, *ast.StringExpr:
+		v, _ := literal(x)
+		if truth := v != nil && v != false; truth == jumpWhen {
+			return fs.emitJump()
+		}
+		return nil
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpNot {
+			return fs.condJump(x.Operand, !jumpWhen)
+		}
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case ast.OpAnd, ast.OpOr:
+			if (x.Op == ast.OpAnd) != jumpWhen {
+				// either operand decides: false for and, true for or
+				t := fs.condJump(x.Left, jumpWhen)
+				return append(t, fs.condJump(x.Right, jumpWhen)...)
+			}
+			skip := fs.condJump(x.Left, !jumpWhen)
+			t := fs.condJump(x.Right, jumpWhen)
+			fs.patchHere(skip)
+			return t
+		case ast.OpEQ, ast.OpNE, ast.OpLT, ast.OpLE, ast.OpGT, ast.OpGE:
+			fs.compare(x, jumpWhen)
+			return fs.emitJump()
+		}
+	}
+	save := fs.freereg
+	r := fs.exp2anyreg(e)
+	fs.freereg = save
+	fs.emitABCk(TEST, r, 0, 0, jumpWhen)
+	return fs.emitJump()
+}
+
+// The opcodes that compare a register with an immediate, for an
+// immediate on the right and on the left of the operator.
+var (
+	immRight = map[ast.BinOp]Opcode{ast.OpLT: LTI, ast.OpLE: LEI, ast.OpGT: GTI, ast.OpGE: GEI}
+	immLeft  = map[ast.BinOp]Opcode{ast.OpLT: GTI, ast.OpLE: GEI, ast.OpGT: LTI, ast.OpGE: LEI}
+)
+
+// compare emits the test of a comparison whose outcome is jumpWhen.
+func (fs *funcState) compare(e *ast.BinaryExpr, jumpWhen bool) {
+	save := fs.freereg
+	defer func() { fs.freereg = save }()
+	op := e.Op
+	if op == ast.OpNE {
+		op, jumpWhen = ast.OpEQ, !jumpWhen
+	}
+	left, right := e.Left, e.Right
+	if op == ast.OpEQ {
+		if _, ok := literal(left); ok {
+			if _, ok := literal(right); !ok {
+				left, right = right, left
+			}
+		}
+		a := fs.exp2anyreg(left)
+		fs.line = e.Pos().Line
+		if imm, ok := immediate(right); ok {
+			fs.emitABCk(EQI, a, imm+offsetSC, 0, jumpWhen)
+			return
+		}
+		if v, ok := literal(right); ok {
+			if k := fs.constant(v); k <= MaxArgB {
+				fs.emitABCk(EQK, a, k, 0, jumpWhen)
+				return
+			}
+		}
+		b := fs.exp2anyreg(right)
+		fs.line = e.Pos().Line
+		fs.emitABCk(EQ, a, b, 0, jumpWhen)
+		return
+	}
+
+	// an immediate on the left is compared from the other side
+	if imm, ok := immediate(left); ok {
+		b := fs.exp2anyreg(right)
+		fs.line = e.Pos().Line
+		fs.emitABCk(immLeft[op], b, imm+offsetSC, 0, jumpWhen)
+		return
+	}
+	a := fs.exp2anyreg(left)
+	if imm, ok := immediate(right); ok {
+		fs.line = e.Pos().Line
+		fs.emitABCk(immRight[op], a, imm+offsetSC, 0, jumpWhen)
+		return
+	}
+	b := fs.exp2anyreg(right)
+	fs.line = e.Pos().Line
+	switch op {
+	case ast.OpLT:
+		fs.emitABCk(LT, a, b, 0, jumpWhen)
+	case ast.OpLE:
+		fs.emitABCk(LE, a, b, 0, jumpWhen)
+	case ast.OpGT:
+		fs.emitABCk(LT, b, a, 0, jumpWhen)
+	case ast.OpGE:
+		fs.emitABCk(LE, b, a, 0, jumpWhen)
+	}
+}
+
+// call compiles a call with its function in the first free register,
+// followed by the arguments. It keeps nresults results there, or all
+// of them if nresults is -1, and returns the register of the first.
+func (fs *funcState) call(x *ast.CallExpr, nresults int) int {
+	base := fs.freereg
+	nargs := 1
+	if x.Method != nil {
+		obj := fs.exp2anyreg(x.Func)
+		fs.freereg = base
+		fs.reserve(2)
+		key, isK := fs.exp2rk(&ast.StringExpr{Span: x.Method.Span, Value: x.Method.Name})
+		fs.line = x.Pos().Line
+		fs.emitABCk(SELF, base, obj, key, isK)
+		fs.freereg = base + 2
+		nargs++
+	} else {
+		fs.exp2nextreg(x.Func)
+	}
+	n, open := fs.explist(x.Args, -1)
+	b := nargs + n
+	if open {
+		b = 0
+	}
+	fs.line = x.Pos().Line
+	fs.emitABC(CALL, base, b, nresults+1)
+	fs.freereg = base
+	if nresults > 0 {
+		fs.reserve(nresults)
+	}
+	return base
+}
+
+// explist compiles a list of expressions into consecutive registers
+// from the first free one, adjusted to want values. With want -1, a
+// call or vararg expression at the end leaves all its values, and
+// open is set; n counts the values before it.
+func (fs *funcState) explist(list []ast.Expr, want int) (n int, open bool) {
+	base := fs.freereg
+	for i, e := range list {
+		if i == len(list)-1 && isMulti(e) {
+			nresults := -1
+			if want >= 0 {
+				nresults = want - i
+				if nresults < 0 {
+					nresults = 0
+				}
+			}
+			fs.multi(e, nresults)
+			if nresults < 0 {
+				return i, true
+			}
+			break
+		}
+		fs.exp2nextreg(e)
+	}
+	if want < 0 {
+		return fs.freereg - base, false
+	}
+	if have := fs.freereg - base; have < want {
+		fs.line = fs.lineOf(list)
+		fs.emitABC(LOADNIL, fs.freereg, want-have-1, 0)
+		fs.reserve(want - have)
+	}
+	fs.freereg = base + want
+	return want, false
+}
+
+// lineOf returns the line of the last expression of a list, if any.
+func (fs *funcState) lineOf(list []ast.Expr) int {
+	if len(list) == 0 {
+		return fs.line
+	}
+	return list[len(list)-1].Pos().Line
+}
+
+// isMulti reports whether an expression can have several values.
+func isMulti(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.CallExpr, *ast.VarargExpr:
+		return true
+	}
+	return false
+}
+
+// multi compiles a call or vararg expression into registers from the
+// first free one, keeping nresults values, or all of them if nresults
+// is -1.
+func (fs *funcState) multi(e ast.Expr, nresults int) {
+	switch e := e.(type) {
+	case *ast.CallExpr:
+		fs.call(e, nresults)
+	case *ast.VarargExpr:
+		fs.line = e.Pos().Line
+		fs.emitABC(VARARG, fs.freereg, 0, nresults+1)
+		if nresults > 0 {
+			fs.reserve(nresults)
+		}
+	}
+}
+
+// table compiles a table constructor into the first free register.
+// Positional fields are stored fieldsPerFlush at a time by SETLIST.
+func (fs *funcState) table(e *ast.TableExpr) {
+	t := fs.freereg
+	fs.reserve(1)
+	narray, nhash := 0, 0
+	for _, f := range e.Fields {
+		if f.Kind == ast.PositionalField {
+			narray++
+		} else {
+			nhash++
+		}
+	}
+	if nhash > MaxArgB {
+		nhash = MaxArgB
+	}
+	fs.line = e.Pos().Line
+	fs.emitABCk(NEWTABLE, t, nhash, narray&MaxArgC, narray > MaxArgC)
+	fs.emit(createAx(EXTRAARG, narray>>sizeC))
+
+	stored, pending := 0, 0
+	for i, f := range e.Fields {
+		switch f.Kind {
+		case ast.PositionalField:
+			if i == len(e.Fields)-1 && isMulti(f.Value) {
+				fs.multi(f.Value, -1)
+				fs.setList(t, stored, -1)
+				pending = 0
+				continue
+			}
+			fs.exp2nextreg(f.Value)
+			pending++
+			if pending == fieldsPerFlush {
+				fs.setList(t, stored, pending)
+				stored += pending
+				pending = 0
+			}
+		default:
+			save := fs.freereg
+			key, kind := fs.keyOperand(f.Key)
+			v, isK := fs.exp2rk(f.Value)
+			fs.line = f.Pos().Line
+			fs.storeIndex(place{t: t, key: key, keyKind: kind}, v, isK)
+			fs.freereg = save
+		}
+	}
+	if pending > 0 {
+		fs.setList(t, stored, pending)
+	}
+	fs.freereg = t + 1
+}
+
+// setList stores n values from the registers after t in the table,
+// starting at index stored+1. With n -1 the values run up to the top.
+func (fs *funcState) setList(t, stored, n int) {
+	b := n
+	if n < 0 {
+		b = 0
+	}
+	if stored <= MaxArgC {
+		fs.emitABC(SETLIST, t, b, stored)
+	} else {
+		fs.emitABCk(SETLIST, t, b, stored&MaxArgC, true)
+		fs.emit(createAx(EXTRAARG, stored>>sizeC))
+	}
+	fs.freereg = t + 1
+}
+
+// closure compiles a function and creates a closure of it in register r.
+func (fs *funcState) closure(e *ast.FunctionExpr, r int) {
+	f := fs.c.info.Funcs[e]
+	child := fs.c.open(fs, f, e)
+	child.p.NumParams = len(f.Params)
+	child.p.IsVararg = e.IsVararg
+	for _, v := range f.Params {
+		child.activate(v)
+	}
+	child.stats(e.Body.Stats)
+	child.line = e.End().Line
+	fs.p.Protos = append(fs.p.Protos, child.close())
+	fs.line = e.Pos().Line
+	fs.emit(createABx(CLOSURE, r, len(fs.p.Protos)-1))
+}
@@ -0,0 +1,302 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vm
+
+import "fmt"
+
+// Instruction is a VM instruction. Its layout follows Lua 5.4: the
+// opcode is in the low 7 bits and the arguments are in one of these
+// formats, from the most significant bit to the least:
+//
+//	iABC   C(8) | B(8) | k(1) | A(8) | Op(7)
+//	iABx   Bx(17)            | A(8) | Op(7)
+//	iAsBx  sBx(17)           | A(8) | Op(7)
+//	iAx    Ax(25)                   | Op(7)
+//	isJ    sJ(25)                   | Op(7)
+//
+// Signed arguments are stored in excess-K: the value is the unsigned
+// field minus half its range.
+type Instruction uint32
+
+const (
+	sizeOp = 7
+	sizeA  = 8
+	sizeB  = 8
+	sizeC  = 8
+	sizeBx = sizeC + sizeB + 1
+	sizeAx = sizeBx + sizeA
+	sizeSJ = sizeBx + sizeA
+
+	posA  = sizeOp
+	posK  = posA + sizeA
+	posB  = posK + 1
+	posC  = posB + sizeB
+	posBx = posK
+	posAx = posA
+	posSJ = posA
+
+	// MaxArgA and the others are the largest values of the arguments.
+	MaxArgA  = 1<<sizeA - 1
+	MaxArgB  = 1<<sizeB - 1
+	MaxArgC  = 1<<sizeC - 1
+	MaxArgBx = 1<<sizeBx - 1
+	MaxArgAx = 1<<sizeAx - 1
+	MaxArgSJ = 1<<sizeSJ - 1
+
+	offsetSBx = MaxArgBx >> 1
+	offsetSJ  = MaxArgSJ >> 1
+	offsetSC  = MaxArgC >> 1
+)
+
+// Opcode is the operation of an instruction.
+type Opcode uint8
+
+// The opcodes. R[x] is a register, K[x] a constant, RK(x) is K[x] if
+// the k bit is set and R[x] otherwise, and sB and sC are signed
+// immediate operands. A test skips the next instruction, which is
+// usually a jump, when its condition does not hold.
+const (
+	MOVE       Opcode = iota // A B      R[A] := R[B]
+	LOADI                    // A sBx    R[A] := sBx
+	LOADF                    // A sBx    R[A] := float(sBx)
+	LOADK                    // A Bx     R[A] := K[Bx]
+	LOADKX                   // A        R[A] := K[extra arg]
+	LOADFALSE                // A        R[A] := false
+	LFALSESKIP               // A        R[A] := false; pc++
+	LOADTRUE                 // A        R[A] := true
+	LOADNIL                  // A B      R[A], ..., R[A+B] := nil
+	GETUPVAL                 // A B      R[A] := UpValue[B]
+	SETUPVAL                 // A B      UpValue[B] := R[A]
+	GETTABUP                 // A B C    R[A] := UpValue[B][K[C]:string]
+	GETTABLE                 // A B C    R[A] := R[B][R[C]]
+	GETI                     // A B C    R[A] := R[B][C]
+	GETFIELD                 // A B C    R[A] := R[B][K[C]:string]
+	SETTABUP                 // A B C    UpValue[A][K[B]:string] := RK(C)
+	SETTABLE                 // A B C    R[A][R[B]] := RK(C)
+	SETI                     // A B C    R[A][B] := RK(C)
+	SETFIELD                 // A B C    R[A][K[B]:string] := RK(C)
+	NEWTABLE                 // A B C k  R[A] := {}
+	SELF                     // A B C    R[A+1] := R[B]; R[A] := R[B][RK(C):string]
+	ADDI                     // A B sC   R[A] := R[B] + sC
+	ADDK                     // A B C    R[A] := R[B] + K[C]:number
+	SUBK                     // A B C    R[A] := R[B] - K[C]:number
+	MULK                     // A B C    R[A] := R[B] * K[C]:number
+	MODK                     // A B C    R[A] := R[B] % K[C]:number
+	POWK                     // A B C    R[A] := R[B] ^ K[C]:number
+	DIVK                     // A B C    R[A] := R[B] / K[C]:number
+	IDIVK                    // A B C    R[A] := R[B] // K[C]:number
+	BANDK                    // A B C    R[A] := R[B] & K[C]:integer
+	BORK                     // A B C    R[A] := R[B] | K[C]:integer
+	BXORK                    // A B C    R[A] := R[B] ~ K[C]:integer
+	SHRI                     // A B sC   R[A] := R[B] >> sC
+	SHLI                     // A B sC   R[A] := sC << R[B]
+	ADD                      // A B C    R[A] := R[B] + R[C]
+	SUB                      // A B C    R[A] := R[B] - R[C]
+	MUL                      // A B C    R[A] := R[B] * R[C]
+	MOD                      // A B C    R[A] := R[B] % R[C]
+	POW                      // A B C    R[A] := R[B] ^ R[C]
+	DIV                      // A B C    R[A] := R[B] / R[C]
+	IDIV                     // A B C    R[A] := R[B] // R[C]
+	BAND                     // A B C    R[A] := R[B] & R[C]
+	BOR                      // A B C    R[A] := R[B] | R[C]
+	BXOR                     // A B C    R[A] := R[B] ~ R[C]
+	SHL                      // A B C    R[A] := R[B] << R[C]
+	SHR                      // A B C    R[A] := R[B] >> R[C]
+	UNM                      // A B      R[A] := -R[B]
+	BNOT                     // A B      R[A] := ~R[B]
+	NOT                      // A B      R[A] := not R[B]
+	LEN                      // A B      R[A] := #R[B]
+	CONCAT                   // A B      R[A] := R[A].. ... ..R[A + B - 1]
+	CLOSE                    // A        close all upvalues >= R[A]
+	TBC                      // A        mark variable A "to be closed"
+	JMP                      // sJ       pc += sJ
+	EQ                       // A B k    if ((R[A] == R[B]) ~= k) then pc++
+	LT                       // A B k    if ((R[A] <  R[B]) ~= k) then pc++
+	LE                       // A B k    if ((R[A] <= R[B]) ~= k) then pc++
+	EQK                      // A B k    if ((R[A] == K[B]) ~= k) then pc++
+	EQI                      // A sB k   if ((R[A] == sB) ~= k) then pc++
+	LTI                      // A sB k   if ((R[A] < sB) ~= k) then pc++
+	LEI                      // A sB k   if ((R[A] <= sB) ~= k) then pc++
+	GTI                      // A sB k   if ((R[A] > sB) ~= k) then pc++
+	GEI                      // A sB k   if ((R[A] >= sB) ~= k) then pc++
+	TEST                     // A k      if (not R[A] == k) then pc++
+	TESTSET                  // A B k    if (not R[B] == k) then pc++ else R[A] := R[B]
+	CALL                     // A B C    R[A], ... ,R[A+C-2] := R[A](R[A+1], ... ,R[A+B-1])
+	TAILCALL                 // A B      return R[A](R[A+1], ... ,R[A+B-1])
+	RETURN                   // A B      return R[A], ... ,R[A+B-2]
+	RETURN0                  //          return
+	RETURN1                  // A        return R[A]
+	FORLOOP                  // A Bx     update counters; if loop continues then pc -= Bx
+	FORPREP                  // A Bx     check values and prepare counters; if not to run then pc += Bx+1
+	TFORPREP                 // A Bx     create upvalue for R[A + 3]; pc += Bx
+	TFORCALL                 // A C      R[A+4], ... ,R[A+3+C] := R[A](R[A+1], R[A+2])
+	TFORLOOP                 // A Bx     if R[A+4] ~= nil then { R[A+2] := R[A+4]; pc -= Bx }
+	SETLIST                  // A B C k  R[A][C+i] := R[A+i], 1 <= i <= B
+	CLOSURE                  // A Bx     R[A] := closure(KPROTO[Bx])
+	VARARG                   // A C      R[A], R[A+1], ..., R[A+C-2] = vararg
+	EXTRAARG                 // Ax       extra (larger) argument for previous opcode
+
+	numOpcodes
+)
+
+// In CALL, TAILCALL, RETURN and SETLIST, a B of zero means the values
+// run up to the top set by the previous instruction, a CALL or VARARG
+// with a C of zero, which leaves all its results. In NEWTABLE and
+// SETLIST a set k bit means an EXTRAARG follows with the high part of
+// C.
+
+var opNames = [...]string{
+	"MOVE", "LOADI", "LOADF", "LOADK", "LOADKX", "LOADFALSE", "LFALSESKIP",
+	"LOADTRUE", "LOADNIL", "GETUPVAL", "SETUPVAL", "GETTABUP", "GETTABLE",
+	"GETI", "GETFIELD", "SETTABUP", "SETTABLE", "SETI", "SETFIELD",
+	"NEWTABLE", "SELF", "ADDI", "ADDK", "SUBK", "MULK", "MODK", "POWK",
+	"DIVK", "IDIVK", "BANDK", "BORK", "BXORK", "SHRI", "SHLI", "ADD", "SUB",
+	"MUL", "MOD", "POW", "DIV", "IDIV", "BAND", "BOR", "BXOR", "SHL", "SHR",
+	"UNM", "BNOT", "NOT", "LEN", "CONCAT", "CLOSE", "TBC", "JMP", "EQ", "LT",
+	"LE", "EQK", "EQI", "LTI", "LEI", "GTI", "GEI", "TEST", "TESTSET",
+	"CALL", "TAILCALL", "RETURN", "RETURN0", "RETURN1", "FORLOOP", "FORPREP",
+	"TFORPREP", "TFORCALL", "TFORLOOP", "SETLIST", "CLOSURE", "VARARG",
+	"EXTRAARG",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Opcode(%d)", int(op))
+}
+
+// Instruction constructors.
+
+func createABC(op Opcode, a, b, c int, k bool) Instruction {
+	i := Instruction(op) | Instruction(a)<<posA | Instruction(b)<<posB | Instruction(c)<<posC
+	if k {
+		i |= 1 << posK
+	}
+	return i
+}
+
+func createABx(op Opcode, a, bx int) Instruction {
+	return Instruction(op) | Instruction(a)<<posA | Instruction(bx)<<posBx
+}
+
+func createAsBx(op Opcode, a, sbx int) Instruction {
+	return createABx(op, a, sbx+offsetSBx)
+}
+
+func createAx(op Opcode, ax int) Instruction {
+	return Instruction(op) | Instruction(ax)<<posAx
+}
+
+func createSJ(op Opcode, sj int) Instruction {
+	return Instruction(op) | Instruction(sj+offsetSJ)<<posSJ
+}
+
+// Argument accessors.
+
+// Op returns the opcode of the instruction.
+func (i Instruction) Op() Opcode { return Opcode(i & (1<<sizeOp - 1)) }
+
+// A returns argument A.
+func (i Instruction) A() int { return int(i >> posA & MaxArgA) }
+
+// B returns argument B.
+func (i Instruction) B() int { return int(i >> posB & MaxArgB) }
+
+// C returns argument C.
+func (i Instruction) C() int { return int(i >> posC & MaxArgC) }
+
+// K reports whether the k bit is set.
+func (i Instruction) K() bool { return i&(1<<posK) != 0 }
+
+// Bx returns argument Bx.
+func (i Instruction) Bx() int { return int(i >> posBx & MaxArgBx) }
+
+// SBx returns the signed argument sBx.
+func (i Instruction) SBx() int { return i.Bx() - offsetSBx }
+
+// Ax returns argument Ax.
+func (i Instruction) Ax() int { return int(i >> posAx & MaxArgAx) }
+
+// SJ returns the signed jump offset sJ.
+func (i Instruction) SJ() int { return int(i>>posSJ&MaxArgSJ) - offsetSJ }
+
+// SB returns B as a signed immediate.
+func (i Instruction) SB() int { return i.B() - offsetSC }
+
+// SC returns C as a signed immediate.
+func (i Instruction) SC() int { return i.C() - offsetSC }
+
+// setA returns the instruction with argument A replaced.
+func (i Instruction) setA(a int) Instruction {
+	return i&^(MaxArgA<<posA) | Instruction(a)<<posA
+}
+
+// setSJ returns the jump with its offset replaced.
+func (i Instruction) setSJ(sj int) Instruction {
+	return i&^(MaxArgSJ<<posSJ) | Instruction(sj+offsetSJ)<<posSJ
+}
+
+// setBx returns the instruction with argument Bx replaced.
+func (i Instruction) setBx(bx int) Instruction {
+	return i&^(MaxArgBx<<posBx) | Instruction(bx)<<posBx
+}
+
+// String disassembles the instruction.
+func (i Instruction) String() string {
+	op := i.Op()
+	switch op {
+	case LOADI, LOADF:
+		return fmt.Sprintf("%-9s %d %d", op, i.A(), i.SBx())
+	case LOADK, FORLOOP, FORPREP, TFORPREP, TFORLOOP, CLOSURE:
+		return fmt.Sprintf("%-9s %d %d", op, i.A(), i.Bx())
+	case LOADKX, LOADFALSE, LFALSESKIP, LOADTRUE, CLOSE, TBC, RETURN1:
+		return fmt.Sprintf("%-9s %d", op, i.A())
+	case RETURN0:
+		return op.String()
+	case JMP:
+		return fmt.Sprintf("%-9s %d", op, i.SJ())
+	case EXTRAARG:
+		return fmt.Sprintf("%-9s %d", op, i.Ax())
+	case ADDI, SHRI, SHLI:
+		return fmt.Sprintf("%-9s %d %d %d", op, i.A(), i.B(), i.SC())
+	case EQI, LTI, LEI, GTI, GEI:
+		return fmt.Sprintf("%-9s %d %d %d", op, i.A(), i.SB(), k(i))
+	case EQ, LT, LE, EQK, TEST:
+		return fmt.Sprintf("%-9s %d %d %d", op, i.A(), i.B(), k(i))
+	}
+	s := fmt.Sprintf("%-9s %d %d %d", op, i.A(), i.B(), i.C())
+	if i.K() {
+		s += "k"
+	}
+	return s
+}
+
+func k(i Instruction) int {
+	if i.K() {
+		return 1
+	}
+	return 0
+}
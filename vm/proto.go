@@ -0,0 +1,159 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mdhender/glua/arith"
+	"github.com/mdhender/glua/lua"
+)
+
+// Proto is a compiled function: its code and everything the code
+// refers to. The closures of a function share its prototype.
+type Proto struct {
+	Source      string // name of the chunk
+	LineDefined int    // line where the function starts; 0 for a main chunk
+	LastLine    int    // line where the function ends
+	NumParams   int
+	IsVararg    bool
+	MaxStack    int // registers the function needs
+
+	Code      []Instruction
+	Lines     []int // source line of each instruction
+	Constants []lua.Value
+	Upvalues  []UpvalDesc
+	Protos    []*Proto // functions defined in this one
+	LocVars   []LocVar // debug information about the locals
+}
+
+// UpvalDesc describes how a closure finds an upvalue when it is
+// created: in a register of the enclosing function, or in one of the
+// enclosing function's upvalues.
+type UpvalDesc struct {
+	Name    string
+	InStack bool
+	Index   int
+}
+
+// LocVar is a local variable, active while the code between StartPC
+// and EndPC runs.
+type LocVar struct {
+	Name           string
+	Reg            int
+	StartPC, EndPC int
+}
+
+// localName returns the name of the local in register reg at pc, or ""
+// if the register does not hold an active local.
+func (p *Proto) localName(reg, pc int) string {
+	name := ""
+	for _, v := range p.LocVars {
+		if v.StartPC > pc {
+			break
+		}
+		if v.Reg == reg && pc < v.EndPC {
+			name = v.Name
+		}
+	}
+	return name
+}
+
+// Disassemble writes a listing of the function and the functions
+// defined in it, in the style of luac -l.
+func (p *Proto) Disassemble(w io.Writer) {
+	kind := "function"
+	if p.LineDefined == 0 {
+		kind = "main"
+	}
+	fmt.Fprintf(w, "\n%s <%s:%d,%d> (%d instructions)\n", kind, p.Source, p.LineDefined, p.LastLine, len(p.Code))
+	vararg := ""
+	if p.IsVararg {
+		vararg = "+"
+	}
+	fmt.Fprintf(w, "%d%s params, %d slots, %d upvalues, %d locals, %d constants, %d functions\n",
+		p.NumParams, vararg, p.MaxStack, len(p.Upvalues), len(p.LocVars), len(p.Constants), len(p.Protos))
+	for pc, i := range p.Code {
+		fmt.Fprintf(w, "\t%d\t[%d]\t%s%s\n", pc+1, p.Lines[pc], i, p.comment(pc, i))
+	}
+	fmt.Fprintf(w, "constants (%d):\n", len(p.Constants))
+	for i, k := range p.Constants {
+		fmt.Fprintf(w, "\t%d\t%s\n", i, constant(k))
+	}
+	fmt.Fprintf(w, "locals (%d):\n", len(p.LocVars))
+	for i, v := range p.LocVars {
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, v.Name, v.StartPC+1, v.EndPC+1)
+	}
+	fmt.Fprintf(w, "upvalues (%d):\n", len(p.Upvalues))
+	for i, u := range p.Upvalues {
+		fmt.Fprintf(w, "\t%d\t%s\t%v\t%d\n", i, u.Name, u.InStack, u.Index)
+	}
+	for _, f := range p.Protos {
+		f.Disassemble(w)
+	}
+}
+
+// comment explains the operands of an instruction in a listing.
+func (p *Proto) comment(pc int, i Instruction) string {
+	switch i.Op() {
+	case LOADK:
+		return "\t; " + constant(p.Constants[i.Bx()])
+	case GETTABUP:
+		return fmt.Sprintf("\t; %s %s", p.Upvalues[i.B()].Name, constant(p.Constants[i.C()]))
+	case SETTABUP:
+		return fmt.Sprintf("\t; %s %s", p.Upvalues[i.A()].Name, constant(p.Constants[i.B()]))
+	case GETUPVAL, SETUPVAL:
+		return "\t; " + p.Upvalues[i.B()].Name
+	case GETFIELD, ADDK, SUBK, MULK, MODK, POWK, DIVK, IDIVK, BANDK, BORK, BXORK:
+		return "\t; " + constant(p.Constants[i.C()])
+	case SETFIELD, EQK:
+		return "\t; " + constant(p.Constants[i.B()])
+	case SELF:
+		if i.K() {
+			return "\t; " + constant(p.Constants[i.C()])
+		}
+	case JMP:
+		return fmt.Sprintf("\t; to %d", pc+i.SJ()+2)
+	case FORLOOP, TFORLOOP:
+		return fmt.Sprintf("\t; to %d", pc-i.Bx()+2)
+	case FORPREP:
+		return fmt.Sprintf("\t; exit to %d", pc+i.Bx()+3)
+	case TFORPREP:
+		return fmt.Sprintf("\t; to %d", pc+i.Bx()+2)
+	}
+	return ""
+}
+
+// constant formats a constant for a listing.
+func constant(v lua.Value) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case float64:
+		return arith.FormatFloat(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,583 @@
+// GLUA - a Lua-like interpreter
+//
+// MIT License
+//
+// Copyright (c) 2021 Michael D Henderson
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package vm compiles Lua chunks to bytecode and runs it.
+//
+// The instruction set follows the register-based one of Lua 5.4. A
+// function is compiled to a Proto holding its code, constants, upvalue
+// descriptors and the prototypes of the functions nested in it. The
+// names are bound by package resolve, whose slots become the registers
+// of the locals. Metamethods are handled by the operations of package
+// lua, so there are no MMBIN instructions.
+package vm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mdhender/glua/lua"
+)
+
+// Closure is a function compiled to bytecode together with the
+// upvalues it captures.
+type Closure struct {
+	p      *Proto
+	upvals []*upvalue
+}
+
+// upvalue is a variable captured by a closure. While the function that
+// declares the variable runs, the upvalue is open and the variable
+// lives in a register of its frame; when the variable goes out of
+// scope, the upvalue is closed and keeps the value itself.
+type upvalue struct {
+	fr  *frame // the frame holding the variable; nil once closed
+	idx int    // the register of the variable in fr
+	v   lua.Value
+}
+
+func (u *upvalue) get() lua.Value {
+	if u.fr != nil {
+		return u.fr.regs[u.idx]
+	}
+	return u.v
+}
+
+func (u *upvalue) set(v lua.Value) {
+	if u.fr != nil {
+		u.fr.regs[u.idx] = v
+		return
+	}
+	u.v = v
+}
+
+// Load returns the main function of a compiled chunk, ready to run in
+// L. Its _ENV is the global environment of L.
+func Load(L *lua.State, p *Proto) lua.Function {
+	return LoadEnv(p, L.Globals)
+}
+
+// LoadEnv is like Load, but the chunk's _ENV is env.
+func LoadEnv(p *Proto, env lua.Value) lua.Function {
+	return &Closure{p: p, upvals: []*upvalue{{v: env}}}
+}
+
+// frame is the activation of a closure.
+type frame struct {
+	cl      *Closure
+	regs    []lua.Value
+	varargs []lua.Value
+	top     int // the end of the values left by a CALL or VARARG with C = 0
+	pc      int // the instruction being run
+	cat     int // the left operand of the pair being concatenated
+	open    []*upvalue
+	tbc     int // the mark of the to-be-closed variables of the call
+
+	tail     bool      // set if the function returns with a tail call
+	tailFunc lua.Value // the function called in a tail call
+	tailArgs []lua.Value
+}
+
+// Weak returns a weak reference to the closure, by which tables with
+// weak keys or values hold it.
+func (cl *Closure) Weak() lua.WeakRef {
+	return lua.NewWeakRef(cl)
+}
+
+// Call runs the function. A call in tail position replaces the
+// running function instead of nesting in it, so tail calls between
+// Lua functions use no stack.
+func (cl *Closure) Call(L *lua.State, args []lua.Value) []lua.Value {
+	ci := L.CurrentCall()
+	for {
+		fr := cl.newFrame(args)
+		ci.Frame = fr
+		rets := fr.run(L)
+		if !fr.tail {
+			ci.Frame = nil
+			fr.free()
+			return rets
+		}
+		next, ok := fr.tailFunc.(*Closure)
+		if !ok {
+			return L.Call(fr.tailFunc, fr.tailArgs)
+		}
+		args = fr.tailArgs
+		ci.Func, ci.Frame = next, nil
+		fr.free()
+		cl = next
+	}
+}
+
+// framePool keeps the frames of returned calls for reuse, so that a
+// call does not have to allocate its registers.
+var framePool = sync.Pool{New: func() interface{} { return new(frame) }}
+
+// free returns a frame that is no longer in use to the pool. Its
+// upvalues must have been closed.
+func (fr *frame) free() {
+	regs := fr.regs
+	for i := range regs {
+		regs[i] = nil
+	}
+	*fr = frame{regs: regs[:0]}
+	framePool.Put(fr)
+}
+
+// newFrame returns the frame for a call with the given arguments.
+func (cl *Closure) newFrame(args []lua.Value) *frame {
+	p := cl.p
+	fr := framePool.Get().(*frame)
+	fr.cl = cl
+	if cap(fr.regs) >= p.MaxStack {
+		fr.regs = fr.regs[:p.MaxStack]
+	} else {
+		fr.regs = make([]lua.Value, p.MaxStack)
+	}
+	copy(fr.regs[:p.NumParams], args)
+	if p.IsVararg && len(args) > p.NumParams {
+		fr.varargs = args[p.NumParams:]
+	}
+	return fr
+}
+
+// grow makes room for n registers.
+func (fr *frame) grow(n int) {
+	if n > len(fr.regs) {
+		regs := make([]lua.Value, n+len(fr.regs)/2)
+		copy(regs, fr.regs)
+		fr.regs = regs
+	}
+}
+
+// upvalue returns the open upvalue for register idx, creating it if
+// needed.
+func (fr *frame) upvalue(idx int) *upvalue {
+	for _, u := range fr.open {
+		if u.idx == idx {
+			return u
+		}
+	}
+	u := &upvalue{fr: fr, idx: idx}
+	fr.open = append(fr.open, u)
+	return u
+}
+
+// close closes the open upvalues of the registers from level up.
+func (fr *frame) close(level int) {
+	open := fr.open[:0]
+	for _, u := range fr.open {
+		if u.idx < level {
+			open = append(open, u)
+			continue
+		}
+		u.v, u.fr = fr.regs[u.idx], nil
+	}
+	for i := len(open); i < len(fr.open); i++ {
+		fr.open[i] = nil
+	}
+	fr.open = open
+}
+
+// leave closes the upvalues and the to-be-closed variables of a
+// returning call.
+func (fr *frame) leave(L *lua.State) {
+	fr.close(0)
+	if L.TBCMark() > fr.tbc {
+		L.CloseTBC(fr.tbc, 0, nil)
+	}
+}
+
+// Where returns the chunk name and the line being run.
+func (fr *frame) Where() (string, int) {
+	p := fr.cl.p
+	return p.Source, p.Lines[fr.pc]
+}
+
+// values returns a copy of the registers from a up to b, or up to the
+// top if b is negative.
+func (fr *frame) values(a, b int) []lua.Value {
+	if b < 0 {
+		b = fr.top
+	}
+	if b <= a {
+		return nil
+	}
+	vals := make([]lua.Value, b-a)
+	copy(vals, fr.regs[a:b])
+	return vals
+}
+
+// results stores the values returned by a call from register a on. It
+// keeps n of them, or all of them and sets the top if n is negative.
+func (fr *frame) results(a int, rets []lua.Value, n int) {
+	if n < 0 {
+		fr.grow(a + len(rets))
+		copy(fr.regs[a:], rets)
+		fr.top = a + len(rets)
+		return
+	}
+	r := fr.regs[a : a+n]
+	m := copy(r, rets)
+	for i := m; i < n; i++ {
+		r[i] = nil
+	}
+}
+
+// The lua operators of the arithmetic opcodes.
+var arithOps = [...]lua.Op{
+	ADD - ADD:  lua.OpAdd,
+	SUB - ADD:  lua.OpSub,
+	MUL - ADD:  lua.OpMul,
+	MOD - ADD:  lua.OpMod,
+	POW - ADD:  lua.OpPow,
+	DIV - ADD:  lua.OpDiv,
+	IDIV - ADD: lua.OpIDiv,
+	BAND - ADD: lua.OpBand,
+	BOR - ADD:  lua.OpBor,
+	BXOR - ADD: lua.OpBxor,
+	SHL - ADD:  lua.OpShl,
+	SHR - ADD:  lua.OpShr,
+}
+
+// The lua operators of the opcodes with a constant operand.
+var arithKOps = [...]lua.Op{
+	ADDK - ADDK:  lua.OpAdd,
+	SUBK - ADDK:  lua.OpSub,
+	MULK - ADDK:  lua.OpMul,
+	MODK - ADDK:  lua.OpMod,
+	POWK - ADDK:  lua.OpPow,
+	DIVK - ADDK:  lua.OpDiv,
+	IDIVK - ADDK: lua.OpIDiv,
+	BANDK - ADDK: lua.OpBand,
+	BORK - ADDK:  lua.OpBor,
+	BXORK - ADDK: lua.OpBxor,
+}
+
+// arithmetic computes a op b, with fast paths for the common cases.
+func arithmetic(L *lua.State, op lua.Op, a, b lua.Value) lua.Value {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			switch op {
+			case lua.OpAdd:
+				return x + y
+			case lua.OpSub:
+				return x - y
+			case lua.OpMul:
+				return x * y
+			}
+		}
+	} else if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch op {
+			case lua.OpAdd:
+				return x + y
+			case lua.OpSub:
+				return x - y
+			case lua.OpMul:
+				return x * y
+			case lua.OpDiv:
+				return x / y
+			}
+		}
+	}
+	return L.Arith(op, a, b)
+}
+
+// less reports whether a < b, with a fast path for integers.
+func less(L *lua.State, a, b lua.Value) bool {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return x < y
+		}
+	}
+	return L.Less(a, b)
+}
+
+// lessEqual reports whether a <= b, with a fast path for integers.
+func lessEqual(L *lua.State, a, b lua.Value) bool {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return x <= y
+		}
+	}
+	return L.LessEqual(a, b)
+}
+
+// run runs the function until it returns, and returns its results.
+// For a tail call it sets tail, tailFunc and tailArgs instead.
+func (fr *frame) run(L *lua.State) []lua.Value {
+	cl := fr.cl
+	p := cl.p
+	code, k := p.Code, p.Constants
+	R := fr.regs
+	fr.tbc = L.TBCMark()
+	for pc := 0; ; pc++ {
+		i := code[pc]
+		fr.pc = pc
+		a := i.A()
+		switch i.Op() {
+		case MOVE:
+			R[a] = R[i.B()]
+		case LOADI:
+			R[a] = int64(i.SBx())
+		case LOADF:
+			R[a] = float64(i.SBx())
+		case LOADK:
+			R[a] = k[i.Bx()]
+		case LOADKX:
+			pc++
+			R[a] = k[code[pc].Ax()]
+		case LOADFALSE:
+			R[a] = false
+		case LFALSESKIP:
+			R[a] = false
+			pc++
+		case LOADTRUE:
+			R[a] = true
+		case LOADNIL:
+			for j := a; j <= a+i.B(); j++ {
+				R[j] = nil
+			}
+		case GETUPVAL:
+			R[a] = cl.upvals[i.B()].get()
+		case SETUPVAL:
+			cl.upvals[i.B()].set(R[a])
+		case GETTABUP:
+			R[a] = L.Index(cl.upvals[i.B()].get(), k[i.C()])
+		case GETTABLE:
+			R[a] = L.Index(R[i.B()], R[i.C()])
+		case GETI:
+			R[a] = L.Index(R[i.B()], int64(i.C()))
+		case GETFIELD:
+			R[a] = L.Index(R[i.B()], k[i.C()])
+		case SETTABUP:
+			L.SetIndex(cl.upvals[a].get(), k[i.B()], rk(R, k, i))
+		case SETTABLE:
+			L.SetIndex(R[a], R[i.B()], rk(R, k, i))
+		case SETI:
+			L.SetIndex(R[a], int64(i.B()), rk(R, k, i))
+		case SETFIELD:
+			L.SetIndex(R[a], k[i.B()], rk(R, k, i))
+		case NEWTABLE:
+			n := i.C()
+			if i.K() {
+				n += code[pc+1].Ax() * (MaxArgC + 1)
+			}
+			pc++
+			R[a] = lua.NewTable(n, i.B())
+		case SELF:
+			obj := R[i.B()]
+			R[a+1] = obj
+			R[a] = L.Index(obj, rk(R, k, i))
+		case ADDI:
+			R[a] = arithmetic(L, lua.OpAdd, R[i.B()], int64(i.SC()))
+		case ADDK, SUBK, MULK, MODK, POWK, DIVK, IDIVK, BANDK, BORK, BXORK:
+			R[a] = arithmetic(L, arithKOps[i.Op()-ADDK], R[i.B()], k[i.C()])
+		case SHRI:
+			R[a] = L.Arith(lua.OpShr, R[i.B()], int64(i.SC()))
+		case SHLI:
+			R[a] = L.Arith(lua.OpShl, int64(i.SC()), R[i.B()])
+		case ADD, SUB, MUL, MOD, POW, DIV, IDIV, BAND, BOR, BXOR, SHL, SHR:
+			R[a] = arithmetic(L, arithOps[i.Op()-ADD], R[i.B()], R[i.C()])
+		case UNM:
+			v := R[i.B()]
+			if n, ok := v.(int64); ok {
+				R[a] = -n
+			} else {
+				R[a] = L.Arith(lua.OpUnm, v, v)
+			}
+		case BNOT:
+			v := R[i.B()]
+			R[a] = L.Arith(lua.OpBnot, v, v)
+		case NOT:
+			R[a] = !lua.Truth(R[i.B()])
+		case LEN:
+			R[a] = L.Len(R[i.B()])
+		case CONCAT:
+			n := i.B()
+			v := R[a+n-1]
+			for j := a + n - 2; j >= a; j-- {
+				fr.cat = j
+				v = L.Concat(R[j], v)
+			}
+			R[a] = v
+		case CLOSE:
+			fr.close(a)
+			L.CloseTBC(fr.tbc, a, nil)
+		case TBC:
+			L.NewTBC(R[a], p.localName(a, pc), a)
+		case JMP:
+			pc += i.SJ()
+		case EQ:
+			if L.Equal(R[a], R[i.B()]) != i.K() {
+				pc++
+			}
+		case LT:
+			if less(L, R[a], R[i.B()]) != i.K() {
+				pc++
+			}
+		case LE:
+			if lessEqual(L, R[a], R[i.B()]) != i.K() {
+				pc++
+			}
+		case EQK:
+			if lua.RawEqual(R[a], k[i.B()]) != i.K() {
+				pc++
+			}
+		case EQI:
+			if lua.RawEqual(R[a], int64(i.SB())) != i.K() {
+				pc++
+			}
+		case LTI:
+			if less(L, R[a], int64(i.SB())) != i.K() {
+				pc++
+			}
+		case LEI:
+			if lessEqual(L, R[a], int64(i.SB())) != i.K() {
+				pc++
+			}
+		case GTI:
+			if less(L, int64(i.SB()), R[a]) != i.K() {
+				pc++
+			}
+		case GEI:
+			if lessEqual(L, int64(i.SB()), R[a]) != i.K() {
+				pc++
+			}
+		case TEST:
+			if lua.Truth(R[a]) != i.K() {
+				pc++
+			}
+		case TESTSET:
+			if v := R[i.B()]; lua.Truth(v) != i.K() {
+				pc++
+			} else {
+				R[a] = v
+			}
+		case CALL:
+			rets := L.Call(R[a], fr.args(a, i.B()))
+			fr.results(a, rets, i.C()-1)
+			R = fr.regs
+		case TAILCALL:
+			fr.tail, fr.tailFunc, fr.tailArgs = true, R[a], fr.args(a, i.B())
+			fr.close(0)
+			return nil
+		case RETURN:
+			b := -1
+			if i.B() != 0 {
+				b = a + i.B() - 1
+			}
+			rets := fr.values(a, b)
+			fr.leave(L)
+			return rets
+		case RETURN0:
+			fr.leave(L)
+			return nil
+		case RETURN1:
+			v := R[a]
+			fr.leave(L)
+			return []lua.Value{v}
+		case FORLOOP:
+			if l := R[a].(*lua.ForLoop); l.Next() {
+				R[a+3] = l.Value()
+				pc -= i.Bx()
+			}
+		case FORPREP:
+			l, ok := L.ForPrep(R[a], R[a+1], R[a+2])
+			if !ok {
+				pc += i.Bx() + 1
+				break
+			}
+			R[a], R[a+3] = &l, l.Value()
+		case TFORPREP:
+			L.NewTBC(R[a+3], "(for state)", a+3)
+			pc += i.Bx()
+		case TFORCALL:
+			rets := L.Call(R[a], []lua.Value{R[a+1], R[a+2]})
+			fr.results(a+4, rets, i.C())
+		case TFORLOOP:
+			if v := R[a+4]; v != nil {
+				R[a+2] = v
+				pc -= i.Bx()
+			}
+		case SETLIST:
+			n := i.B()
+			if n == 0 {
+				n = fr.top - a - 1
+			}
+			last := i.C()
+			if i.K() {
+				pc++
+				last += code[pc].Ax() * (MaxArgC + 1)
+			}
+			t := R[a].(*lua.Table)
+			for j := 1; j <= n; j++ {
+				t.Set(int64(last+j), R[a+j])
+			}
+		case CLOSURE:
+			R[a] = fr.closure(p.Protos[i.Bx()])
+		case VARARG:
+			n := i.C() - 1
+			if n < 0 {
+				fr.results(a, fr.varargs, -1)
+				R = fr.regs
+			} else {
+				fr.results(a, fr.varargs, n)
+			}
+		default:
+			panic(fmt.Sprintf("vm: unexpected opcode %v", i.Op()))
+		}
+	}
+}
+
+// rk returns the operand C of an instruction that may be a constant.
+func rk(R, k []lua.Value, i Instruction) lua.Value {
+	if i.K() {
+		return k[i.C()]
+	}
+	return R[i.C()]
+}
+
+// args returns the arguments of a call to the function in register a
+// with operand B.
+func (fr *frame) args(a, b int) []lua.Value {
+	if b == 0 {
+		return fr.values(a+1, -1)
+	}
+	return fr.values(a+1, a+b)
+}
+
+// closure creates a closure of a function, capturing its upvalues
+// from the frame and the running closure.
+func (fr *frame) closure(p *Proto) *Closure {
+	cl := &Closure{p: p, upvals: make([]*upvalue, len(p.Upvalues))}
+	for i, u := range p.Upvalues {
+		if u.InStack {
+			cl.upvals[i] = fr.upvalue(u.Index)
+		} else {
+			cl.upvals[i] = fr.cl.upvals[u.Index]
+		}
+	}
+	return cl
+}